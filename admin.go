@@ -0,0 +1,52 @@
+package main
+
+import (
+	"avazon-api/config"
+	"avazon-api/models"
+	"avazon-api/services"
+	"fmt"
+	"log"
+	"os"
+)
+
+const adminUsage = `usage: avazon-api [flags] admin <command>
+
+commands:
+  promote USER_ID     give the admin role to a user (who signed in once), e.g. the first admin
+  demote USER_ID      take the admin role back
+  list                list the admins`
+
+// runAdmin manages the admins from the command line. The changes are audited with the actor "cli".
+func runAdmin(cfg config.DatabaseConfig, args []string) {
+	if len(args) == 0 {
+		fmt.Println(adminUsage)
+		os.Exit(2)
+	}
+	admin := services.NewAdminService(initDB(cfg))
+	switch command, args := args[0], args[1:]; command {
+	case "promote", "demote":
+		if len(args) != 1 {
+			log.Fatalf("usage: admin %s USER_ID", command)
+		}
+		role := models.UserRoleAdmin
+		if command == "demote" {
+			role = models.UserRoleUser
+		}
+		user, err := admin.SetRole(services.ActorCLI, args[0], role)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s (%s) is %s\n", user.ID, user.Email, role)
+	case "list":
+		admins, err := admin.GetAdmins()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, user := range admins {
+			fmt.Printf("%-40s %s\n", user.ID, user.Email)
+		}
+	default:
+		fmt.Println(adminUsage)
+		os.Exit(2)
+	}
+}
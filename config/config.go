@@ -0,0 +1,333 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is every setting the server reads at startup.
+//
+// Each field is named after its environment variable (`env` tag) and can also be set
+//   - in a JSON config file (-config or CONFIG_FILE), keyed by the same names: {"OPENAI_MODEL": "gpt-4o"}
+//   - with a command line flag: OPENAI_MODEL -> -openai-model
+//
+// Precedence, lowest first: default, file, environment, flag.
+type Config struct {
+	Server     ServerConfig
+	Database   DatabaseConfig
+	Auth       AuthConfig
+	Storage    StorageConfig
+	OpenAI     OpenAIConfig
+	Anthropic  AnthropicConfig
+	Assistants AssistantsConfig
+	Providers  ProvidersConfig
+	Keys       ProviderKeys
+	Jobs       JobsConfig
+	Redis      RedisConfig
+	WebData    WebDataSessionConfig
+	Sessions   AvatarSessionConfig
+	Events     EventsConfig
+	Usage      UsageConfig
+	Credits    CreditsConfig
+	RateLimit  RateLimitConfig
+}
+
+type ServerConfig struct {
+	Addr           string   `env:"SERVER_ADDR" default:":8080"`
+	AllowedOrigins []string `env:"ALLOWED_ORIGINS" default:"http://localhost:8081,http://localhost:5173,https://gid.cast-ing.kr,https://staging.d9xje8vs9f8su.amplifyapp.com,https://avazon.cast-ing.kr" usage:"comma separated, used by CORS and the websocket upgrader"`
+	TrustedProxies []string `env:"TRUSTED_PROXIES" usage:"comma separated IPs or CIDRs of the reverse proxies whose X-Forwarded-For gives the client IP (rate limits by IP); none by default"`
+}
+
+type DatabaseConfig struct {
+	Driver          string        `env:"DB_DRIVER" default:"sqlite" usage:"sqlite or postgres"`
+	DSN             string        `env:"DB_DSN" usage:"file path for sqlite, connection string for postgres"`
+	MaxOpenConns    int           `env:"DB_MAX_OPEN_CONNS"`
+	MaxIdleConns    int           `env:"DB_MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `env:"DB_CONN_MAX_LIFETIME"`
+	AutoMigrate     bool          `env:"DB_AUTO_MIGRATE" usage:"apply pending migrations on startup"`
+}
+
+type AuthConfig struct {
+	PrivateKeyFile string `env:"JWT_PRIVATE_KEY_FILE" default:"private_key.pem"`
+	PublicKeyFile  string `env:"JWT_PUBLIC_KEY_FILE" default:"public_key.pem"`
+	DWEnvironment  string `env:"DW_ENVIRONMENT" required:"true" usage:"Dynamic wallet environment id"`
+	DWLiveKey      string `env:"DW_LIVE_KEY" required:"true" usage:"Dynamic wallet API key"`
+	// Dynamic wallet JWT keys and claims
+	DWJWKSURL     string        `env:"DW_JWKS_URL" usage:"JWKS of the Dynamic wallet keys, by default the one of DW_ENVIRONMENT"`
+	DWJWKSFile    string        `env:"DW_JWKS_FILE" usage:"JWKS file read instead of DW_JWKS_URL (offline tests)"`
+	DWJWKSRefresh time.Duration `env:"DW_JWKS_REFRESH" default:"1h" usage:"how often the JWKS is fetched again"`
+	DWJWKSMaxAge  time.Duration `env:"DW_JWKS_MAX_AGE" default:"24h" usage:"the cached keys are used for this long after the last successful fetch, while the JWKS is unreachable"`
+	DWIssuer      string        `env:"DW_ISSUER" usage:"iss of the Dynamic wallet JWTs, by default app.dynamicauth.com/DW_ENVIRONMENT"`
+	DWAudiences   []string      `env:"DW_AUDIENCES" usage:"comma separated aud accepted in the Dynamic wallet JWTs (the app origins), by default ALLOWED_ORIGINS"`
+	// sessions (POST /users/session)
+	AccessTokenTTL   time.Duration `env:"ACCESS_TOKEN_TTL" default:"15m" usage:"lifetime of the access tokens issued by POST /users/session"`
+	RefreshTokenTTL  time.Duration `env:"REFRESH_TOKEN_TTL" default:"720h" usage:"a session not refreshed for this long ends"`
+	RevocationPoll   time.Duration `env:"SESSION_REVOCATION_POLL" default:"10s" usage:"how often the revoked sessions are read, so a logout reaches every replica"`
+	AcceptDynamicJWT bool          `env:"ACCEPT_DYNAMIC_JWT" default:"true" usage:"also accept Dynamic wallet JWTs as access tokens, until every client exchanges them at POST /users/session"`
+}
+
+// JWKSURL -> DW_JWKS_URL, or the JWKS of DW_ENVIRONMENT
+func (c AuthConfig) JWKSURL() string {
+	if c.DWJWKSURL != "" {
+		return c.DWJWKSURL
+	}
+	return "https://app.dynamic.xyz/api/v0/sdk/" + c.DWEnvironment + "/.well-known/jwks"
+}
+
+// Issuer -> DW_ISSUER, or the issuer of DW_ENVIRONMENT
+func (c AuthConfig) Issuer() string {
+	if c.DWIssuer != "" {
+		return c.DWIssuer
+	}
+	return "app.dynamicauth.com/" + c.DWEnvironment
+}
+
+type StorageConfig struct {
+	Driver    string `env:"STORAGE_DRIVER" default:"s3" usage:"s3, local or memory"`
+	S3Bucket  string `env:"S3_BUCKET" default:"avazon"`
+	S3Region  string `env:"S3_REGION" default:"us-west-1"`
+	LocalDir  string `env:"STORAGE_LOCAL_DIR" default:"./uploads" usage:"directory of the local storage"`
+	Route     string `env:"STORAGE_ROUTE" default:"/files" usage:"route serving local and memory storage files"`
+	PublicURL string `env:"STORAGE_PUBLIC_URL" default:"http://localhost:8080/files" usage:"public URL of STORAGE_ROUTE"`
+}
+
+type JobsConfig struct {
+	Concurrency        []string      `env:"JOB_CONCURRENCY" default:"video=2,avatar_chat_video=2,music=2" usage:"comma separated job_type=N caps, e.g. video=2,music=1"`
+	DefaultConcurrency int           `env:"JOB_DEFAULT_CONCURRENCY" default:"4" usage:"cap of the job types missing from JOB_CONCURRENCY"`
+	MaxAttempts        int           `env:"JOB_MAX_ATTEMPTS" default:"3"`
+	RetryBackoff       time.Duration `env:"JOB_RETRY_BACKOFF" default:"10s" usage:"delay before the first retry, doubled on every attempt"`
+	PollInterval       time.Duration `env:"JOB_POLL_INTERVAL" default:"1s"`
+	StaleAfter         time.Duration `env:"JOB_STALE_AFTER" default:"2m" usage:"a running job without heartbeat for this long is picked up again"`
+}
+
+// ConcurrencyByType parses JOB_CONCURRENCY
+func (c JobsConfig) ConcurrencyByType() (map[string]int, error) {
+	caps := make(map[string]int, len(c.Concurrency))
+	for _, item := range c.Concurrency {
+		jobType, raw, ok := strings.Cut(item, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if !ok || err != nil || n <= 0 {
+			return nil, fmt.Errorf("JOB_CONCURRENCY: invalid entry %q, expected job_type=N", item)
+		}
+		caps[strings.TrimSpace(jobType)] = n
+	}
+	return caps, nil
+}
+
+type UsageConfig struct {
+	Prices []string `env:"USAGE_PRICES" usage:"comma separated provider/operation=USD overrides of the default prices, per unit (image, request, character, second) or input:output per 1M chat tokens, e.g. openai/chat=2.5:10,runway/video=0.05"`
+}
+
+// UsagePrice is the price of an operation: per unit, or per 1M input and output tokens for chats
+type UsagePrice struct {
+	PerUnit          float64
+	PerMillionInput  float64
+	PerMillionOutput float64
+}
+
+// PricesByKey parses USAGE_PRICES, keyed provider/operation
+func (c UsageConfig) PricesByKey() (map[string]UsagePrice, error) {
+	prices := make(map[string]UsagePrice, len(c.Prices))
+	for _, item := range c.Prices {
+		key, raw, ok := strings.Cut(item, "=")
+		key = strings.TrimSpace(key)
+		provider, operation, keyOK := strings.Cut(key, "/")
+		if !ok || !keyOK || provider == "" || operation == "" {
+			return nil, fmt.Errorf("USAGE_PRICES: invalid entry %q, expected provider/operation=price or provider/operation=input:output", item)
+		}
+		var price UsagePrice
+		input, output, isChat := strings.Cut(strings.TrimSpace(raw), ":")
+		var err error
+		if isChat {
+			price.PerMillionInput, err = strconv.ParseFloat(input, 64)
+			if err == nil {
+				price.PerMillionOutput, err = strconv.ParseFloat(output, 64)
+			}
+		} else {
+			price.PerUnit, err = strconv.ParseFloat(input, 64)
+		}
+		if err != nil || price.PerUnit < 0 || price.PerMillionInput < 0 || price.PerMillionOutput < 0 {
+			return nil, fmt.Errorf("USAGE_PRICES: invalid price in %q", item)
+		}
+		prices[key] = price
+	}
+	return prices, nil
+}
+
+type CreditsConfig struct {
+	Prices      []string `env:"CREDIT_PRICES" default:"avatar_image=1,avatar_voice=1,video_image=1,video=10,music_image=1,music=5,image_remix=2" usage:"comma separated job_type=credits, the job types missing are free"`
+	SignupGrant int      `env:"CREDITS_SIGNUP_GRANT" default:"20" usage:"credits of a new user"`
+}
+
+// PricesByJobType parses CREDIT_PRICES
+func (c CreditsConfig) PricesByJobType() (map[string]int64, error) {
+	prices := make(map[string]int64, len(c.Prices))
+	for _, item := range c.Prices {
+		jobType, raw, ok := strings.Cut(item, "=")
+		n, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if !ok || err != nil || n < 0 {
+			return nil, fmt.Errorf("CREDIT_PRICES: invalid entry %q, expected job_type=N", item)
+		}
+		prices[strings.TrimSpace(jobType)] = n
+	}
+	return prices, nil
+}
+
+type RateLimitConfig struct {
+	Store  string   `env:"RATE_LIMIT_STORE" default:"memory" usage:"memory or redis"`
+	Limits []string `env:"RATE_LIMITS" default:"public=120/1m,user=300/1m,creation=20/1m" usage:"comma separated group=N/period token buckets (N requests, refilled over period); public is limited by IP, user and creation (the requests starting paid work) by user; a group missing is not limited"`
+}
+
+// RateLimit is a token bucket of Requests, refilled over Period
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// LimitsByGroup parses RATE_LIMITS
+func (c RateLimitConfig) LimitsByGroup() (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit, len(c.Limits))
+	for _, item := range c.Limits {
+		group, raw, ok := strings.Cut(item, "=")
+		limit, limitOK := parseRateLimit(raw)
+		if !ok || !limitOK {
+			return nil, fmt.Errorf("RATE_LIMITS: invalid entry %q, expected group=N/period, e.g. user=300/1m", item)
+		}
+		limits[strings.TrimSpace(group)] = limit
+	}
+	return limits, nil
+}
+
+// parseRateLimit parses N/period
+func parseRateLimit(raw string) (RateLimit, bool) {
+	requests, period, ok := strings.Cut(strings.TrimSpace(raw), "/")
+	n, err := strconv.Atoi(requests)
+	d, durationErr := time.ParseDuration(period)
+	if !ok || err != nil || durationErr != nil || n <= 0 || d <= 0 {
+		return RateLimit{}, false
+	}
+	return RateLimit{Requests: n, Period: d}, true
+}
+
+type RedisConfig struct {
+	URL string `env:"REDIS_URL" usage:"e.g. redis://localhost:6379/0, needed by the redis stores"`
+}
+
+type WebDataSessionConfig struct {
+	Store       string        `env:"WEB_SESSION_STORE" default:"memory" usage:"memory or redis"`
+	TokenTTL    time.Duration `env:"WEB_TOKEN_TTL" default:"2m" usage:"token keys expire this long after they are put"`
+	DataIdleTTL time.Duration `env:"WEB_DATA_IDLE_TTL" default:"30m" usage:"data sessions expire after this long without being read or written"`
+}
+
+type EventsConfig struct {
+	Bus string `env:"EVENT_BUS" default:"memory" usage:"memory or redis; with redis, clients also get the events of jobs run by other replicas"`
+}
+
+type AvatarSessionConfig struct {
+	IdleTTL            time.Duration `env:"AVATAR_SESSION_IDLE_TTL" default:"30m" usage:"avatar creation sessions without chats for this long are closed"`
+	MaxSessionsPerUser int           `env:"AVATAR_SESSIONS_PER_USER" default:"3" usage:"concurrent avatar creation sessions of one user"`
+	MaxSocketsPerUser  int           `env:"AVATAR_SOCKETS_PER_USER" default:"5" usage:"concurrent avatar creation websockets of one user"`
+	SocketMaxMessage   int           `env:"AVATAR_SOCKET_MAX_MESSAGE" default:"8192" usage:"bytes of an avatar creation websocket message, a bigger one closes the socket"`
+	SocketMessages     string        `env:"AVATAR_SOCKET_MESSAGES" default:"10/1m" usage:"message budget of one avatar creation websocket, N/period"`
+	SocketPongWait     time.Duration `env:"AVATAR_SOCKET_PONG_WAIT" default:"60s" usage:"an avatar creation websocket silent for this long (not even answering pings) is closed"`
+	SocketMaxRejected  int           `env:"AVATAR_SOCKET_MAX_REJECTED" default:"5" usage:"messages in a row rejected (over budget, or while the assistant is answering) before the socket is closed"`
+}
+
+// MessageBudget parses AVATAR_SOCKET_MESSAGES
+func (c AvatarSessionConfig) MessageBudget() (RateLimit, error) {
+	limit, ok := parseRateLimit(c.SocketMessages)
+	if !ok {
+		return limit, fmt.Errorf("AVATAR_SOCKET_MESSAGES: invalid value %q, expected N/period, e.g. 10/1m", c.SocketMessages)
+	}
+	return limit, nil
+}
+
+type OpenAIConfig struct {
+	Model   string `env:"OPENAI_MODEL" default:"gpt-4o" usage:"chat model used by the assistants"`
+	BaseURL string `env:"OPENAI_BASE_URL" default:"https://api.openai.com/v1" usage:"chat completions API of the openai assistants, any OpenAI compatible server works (vLLM, Ollama, ...)"`
+}
+
+type AnthropicConfig struct {
+	Model     string `env:"ANTHROPIC_MODEL" default:"claude-sonnet-4-5" usage:"chat model used by the anthropic assistants"`
+	BaseURL   string `env:"ANTHROPIC_BASE_URL" default:"https://api.anthropic.com"`
+	MaxTokens int    `env:"ANTHROPIC_MAX_TOKENS" default:"4096" usage:"longest answer of the anthropic assistants"`
+}
+
+const (
+	VendorOpenAI    = "openai"
+	VendorAnthropic = "anthropic"
+)
+
+type AssistantsConfig struct {
+	Vendor string   `env:"ASSISTANT_VENDOR" default:"openai" usage:"vendor of the assistants: openai or anthropic"`
+	Agents []string `env:"AGENT_ASSISTANTS" usage:"comma separated agent=vendor[:model] overrides, e.g. music_create=anthropic:claude-haiku-4-5,avatar_introduce=openai:gpt-4o-mini"`
+}
+
+// AssistantChoice is the vendor and model of an agent. An empty model is the vendor's default (OPENAI_MODEL, ANTHROPIC_MODEL).
+type AssistantChoice struct {
+	Vendor string
+	Model  string
+}
+
+// ByAgent parses AGENT_ASSISTANTS
+func (c AssistantsConfig) ByAgent() (map[string]AssistantChoice, error) {
+	choices := make(map[string]AssistantChoice, len(c.Agents))
+	for _, item := range c.Agents {
+		agent, raw, ok := strings.Cut(item, "=")
+		vendor, model, _ := strings.Cut(strings.TrimSpace(raw), ":")
+		if !ok || strings.TrimSpace(agent) == "" || (vendor != VendorOpenAI && vendor != VendorAnthropic) {
+			return nil, fmt.Errorf("AGENT_ASSISTANTS: invalid entry %q, expected agent=openai[:model] or agent=anthropic[:model]", item)
+		}
+		choices[strings.TrimSpace(agent)] = AssistantChoice{Vendor: vendor, Model: strings.TrimSpace(model)}
+	}
+	return choices, nil
+}
+
+// UsesVendor tells whether any agent is configured with vendor
+func (c AssistantsConfig) UsesVendor(vendor string) bool {
+	if c.Vendor == vendor {
+		return true
+	}
+	choices, _ := c.ByAgent()
+	for _, choice := range choices {
+		if choice.Vendor == vendor {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	ProvidersReal = "real"
+	ProvidersFake = "fake"
+)
+
+type ProvidersConfig struct {
+	Mode             string        `env:"PROVIDERS" default:"real" usage:"real, or fake to run offline without any provider keys"`
+	FakeDelay        time.Duration `env:"FAKE_PROVIDER_DELAY" default:"2s" usage:"simulated latency of the fake providers"`
+	FakeVideoFixture string        `env:"FAKE_VIDEO_FIXTURE" usage:"file returned by the fake video producer"`
+	FakeMusicFixture string        `env:"FAKE_MUSIC_FIXTURE" usage:"file returned by the fake music producer"`
+	// fallback chains, tried in order (ignored with PROVIDERS=fake)
+	ImagePainters  []string `env:"IMAGE_PAINTERS" default:"openart,openai" usage:"painters of the avatar images, video thumbnails and remixes: openart, openai"`
+	AlbumPainters  []string `env:"ALBUM_PAINTERS" default:"openai,openart" usage:"painters of the music album art: openart, openai"`
+	VoiceActors    []string `env:"VOICE_ACTORS" default:"elevenlabs" usage:"voice actors: elevenlabs"`
+	MusicProducers []string `env:"MUSIC_PRODUCERS" default:"jenai" usage:"music producers: jenai"`
+	// retries and circuit breakers of the provider calls
+	MaxRetries       int           `env:"PROVIDER_MAX_RETRIES" default:"3" usage:"retries of a call answering 429 or 5xx"`
+	RetryBackoff     time.Duration `env:"PROVIDER_RETRY_BACKOFF" default:"500ms" usage:"delay before the first retry, doubled on every retry, with jitter"`
+	MaxRetryWait     time.Duration `env:"PROVIDER_MAX_RETRY_WAIT" default:"30s" usage:"longest wait before a retry; a longer Retry-After fails at once"`
+	BreakerThreshold int           `env:"PROVIDER_BREAKER_THRESHOLD" default:"5" usage:"consecutive failures (5xx, network errors) opening the circuit breaker of a provider"`
+	BreakerCooldown  time.Duration `env:"PROVIDER_BREAKER_COOLDOWN" default:"30s" usage:"an open circuit breaker lets a trial call through after this long"`
+}
+
+// required:"real" means required unless PROVIDERS=fake
+type ProviderKeys struct {
+	OpenAI     string `env:"OPENAI_API_KEY" required:"real"`
+	OpenArt    string `env:"OPENART_API_KEY" required:"real"`
+	ElevenLabs string `env:"ELEVENLABS_API_KEY" required:"real"`
+	Runway     string `env:"RUNWAY_API_KEY" required:"real"`
+	JENAI      string `env:"JENAI_API_KEY" required:"real"`
+	Anthropic  string `env:"ANTHROPIC_API_KEY" usage:"required when an assistant uses anthropic"`
+}
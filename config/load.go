@@ -0,0 +1,295 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// field is one leaf setting of Config, found by walking the struct.
+type field struct {
+	env      string
+	def      string
+	usage    string
+	required string // "true", or the PROVIDERS mode it is required in
+	value    reflect.Value
+}
+
+func (f *field) flagName() string {
+	return strings.ReplaceAll(strings.ToLower(f.env), "_", "-")
+}
+
+func (f *field) set(raw string) error {
+	switch f.value.Interface().(type) {
+	case string:
+		f.value.SetString(raw)
+	case []string:
+		var list []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		f.value.Set(reflect.ValueOf(list))
+	case time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%s: invalid duration %q", f.env, raw)
+		}
+		f.value.SetInt(int64(d))
+	case int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%s: invalid number %q", f.env, raw)
+		}
+		f.value.SetInt(int64(n))
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%s: invalid boolean %q", f.env, raw)
+		}
+		f.value.SetBool(b)
+	default:
+		return fmt.Errorf("%s: unsupported type %s", f.env, f.value.Type())
+	}
+	return nil
+}
+
+func (f *field) isZero() bool {
+	return f.value.IsZero() || (f.value.Kind() == reflect.String && strings.TrimSpace(f.value.String()) == "")
+}
+
+func collectFields(v reflect.Value, fields []*field) []*field {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if env, ok := sf.Tag.Lookup("env"); ok {
+			fields = append(fields, &field{
+				env:      env,
+				def:      sf.Tag.Get("default"),
+				usage:    sf.Tag.Get("usage"),
+				required: sf.Tag.Get("required"),
+				value:    v.Field(i),
+			})
+		} else if sf.Type.Kind() == reflect.Struct {
+			fields = collectFields(v.Field(i), fields)
+		}
+	}
+	return fields
+}
+
+// flagValue remembers the raw flag value so flags can be applied last.
+type flagValue struct {
+	raw    *string
+	isBool bool
+}
+
+func (v flagValue) String() string {
+	if v.raw == nil {
+		return ""
+	}
+	return *v.raw
+}
+func (v flagValue) Set(s string) error { *v.raw = s; return nil }
+func (v flagValue) IsBoolFlag() bool   { return v.isBool }
+
+// Load builds the Config from defaults, the config file, the environment and the flags in args.
+// The arguments left after the flags (e.g. a subcommand) are returned as well.
+// Load only fails on malformed input; call Validate to check that the required values are present.
+func Load(args []string) (*Config, []string, error) {
+	cfg := &Config{}
+	fields := collectFields(reflect.ValueOf(cfg).Elem(), nil)
+
+	fs := flag.NewFlagSet("avazon-api", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "JSON config file (env CONFIG_FILE)")
+	flagValues := make(map[string]*string, len(fields))
+	for _, f := range fields {
+		raw := new(string)
+		flagValues[f.env] = raw
+		usage := f.env
+		if f.usage != "" {
+			usage += ": " + f.usage
+		}
+		_, isBool := f.value.Interface().(bool)
+		fs.Var(flagValue{raw: raw, isBool: isBool}, f.flagName(), usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	var errs []error
+	for _, f := range fields {
+		if f.def != "" {
+			errs = append(errs, f.set(f.def))
+		}
+	}
+
+	if *configFile != "" {
+		values, err := readFile(*configFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, f := range fields {
+			if raw, ok := values[f.env]; ok {
+				errs = append(errs, f.set(raw))
+				delete(values, f.env)
+			}
+		}
+		for key := range values {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %s", *configFile, key))
+		}
+	}
+
+	for _, f := range fields {
+		if raw, ok := os.LookupEnv(f.env); ok {
+			errs = append(errs, f.set(raw))
+		}
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+	for _, f := range fields {
+		if set[f.flagName()] {
+			errs = append(errs, f.set(*flagValues[f.env]))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// readFile reads a flat JSON object keyed by env names. Lists may be JSON arrays.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config file: %w", err)
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("could not parse config file %s: %w", path, err)
+	}
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		switch v := value.(type) {
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		case string:
+			values[key] = v
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+// Validate reports every missing required value at once.
+func (cfg *Config) Validate() error {
+	var errs []error
+	for _, f := range collectFields(reflect.ValueOf(cfg).Elem(), nil) {
+		required := f.required == "true" || (f.required != "" && f.required == cfg.Providers.Mode)
+		if required && f.isZero() {
+			errs = append(errs, fmt.Errorf("%s is not set", f.env))
+		}
+	}
+	if cfg.Providers.Mode != ProvidersReal && cfg.Providers.Mode != ProvidersFake {
+		errs = append(errs, fmt.Errorf("PROVIDERS must be %q or %q, got %q", ProvidersReal, ProvidersFake, cfg.Providers.Mode))
+	}
+	if len(cfg.Providers.ImagePainters) == 0 || len(cfg.Providers.AlbumPainters) == 0 || len(cfg.Providers.VoiceActors) == 0 || len(cfg.Providers.MusicProducers) == 0 {
+		errs = append(errs, errors.New("IMAGE_PAINTERS, ALBUM_PAINTERS, VOICE_ACTORS and MUSIC_PRODUCERS must not be empty"))
+	}
+	if cfg.Providers.MaxRetries < 0 || cfg.Providers.RetryBackoff < 0 || cfg.Providers.MaxRetryWait < 0 || cfg.Providers.BreakerThreshold <= 0 || cfg.Providers.BreakerCooldown <= 0 {
+		errs = append(errs, errors.New("PROVIDER_MAX_RETRIES, PROVIDER_RETRY_BACKOFF and PROVIDER_MAX_RETRY_WAIT must not be negative, PROVIDER_BREAKER_THRESHOLD and PROVIDER_BREAKER_COOLDOWN must be positive"))
+	}
+	if cfg.Assistants.Vendor != VendorOpenAI && cfg.Assistants.Vendor != VendorAnthropic {
+		errs = append(errs, fmt.Errorf("ASSISTANT_VENDOR must be %q or %q, got %q", VendorOpenAI, VendorAnthropic, cfg.Assistants.Vendor))
+	}
+	if _, err := cfg.Assistants.ByAgent(); err != nil {
+		errs = append(errs, err)
+	} else if cfg.Providers.Mode == ProvidersReal && cfg.Keys.Anthropic == "" && cfg.Assistants.UsesVendor(VendorAnthropic) {
+		errs = append(errs, errors.New("ANTHROPIC_API_KEY is not set (needed by the anthropic assistants)"))
+	}
+	if cfg.Anthropic.MaxTokens <= 0 {
+		errs = append(errs, errors.New("ANTHROPIC_MAX_TOKENS must be positive"))
+	}
+	switch cfg.Storage.Driver {
+	case "s3", "local", "memory":
+	default:
+		errs = append(errs, fmt.Errorf("STORAGE_DRIVER must be s3, local or memory, got %q", cfg.Storage.Driver))
+	}
+	if len(cfg.Server.AllowedOrigins) == 0 {
+		errs = append(errs, errors.New("ALLOWED_ORIGINS is empty"))
+	}
+	switch cfg.WebData.Store {
+	case "memory":
+	case "redis":
+		if cfg.Redis.URL == "" {
+			errs = append(errs, errors.New("REDIS_URL is not set (needed by WEB_SESSION_STORE=redis)"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("WEB_SESSION_STORE must be memory or redis, got %q", cfg.WebData.Store))
+	}
+	switch cfg.RateLimit.Store {
+	case "memory":
+	case "redis":
+		if cfg.Redis.URL == "" {
+			errs = append(errs, errors.New("REDIS_URL is not set (needed by RATE_LIMIT_STORE=redis)"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_STORE must be memory or redis, got %q", cfg.RateLimit.Store))
+	}
+	switch cfg.Events.Bus {
+	case "memory":
+	case "redis":
+		if cfg.Redis.URL == "" {
+			errs = append(errs, errors.New("REDIS_URL is not set (needed by EVENT_BUS=redis)"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("EVENT_BUS must be memory or redis, got %q", cfg.Events.Bus))
+	}
+	if _, err := cfg.Jobs.ConcurrencyByType(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := cfg.Usage.PricesByKey(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := cfg.Credits.PricesByJobType(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := cfg.RateLimit.LimitsByGroup(); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Auth.AccessTokenTTL <= 0 || cfg.Auth.RefreshTokenTTL <= 0 || cfg.Auth.RevocationPoll <= 0 {
+		errs = append(errs, errors.New("ACCESS_TOKEN_TTL, REFRESH_TOKEN_TTL and SESSION_REVOCATION_POLL must be positive"))
+	}
+	if cfg.Auth.DWJWKSRefresh <= 0 || cfg.Auth.DWJWKSMaxAge < cfg.Auth.DWJWKSRefresh {
+		errs = append(errs, errors.New("DW_JWKS_REFRESH must be positive and DW_JWKS_MAX_AGE at least DW_JWKS_REFRESH"))
+	}
+	if cfg.Credits.SignupGrant < 0 {
+		errs = append(errs, errors.New("CREDITS_SIGNUP_GRANT must not be negative"))
+	}
+	if cfg.Jobs.DefaultConcurrency <= 0 || cfg.Jobs.MaxAttempts <= 0 || cfg.Jobs.PollInterval <= 0 || cfg.Jobs.StaleAfter <= 0 {
+		errs = append(errs, errors.New("JOB_DEFAULT_CONCURRENCY, JOB_MAX_ATTEMPTS, JOB_POLL_INTERVAL and JOB_STALE_AFTER must be positive"))
+	}
+	if cfg.Sessions.IdleTTL <= 0 || cfg.Sessions.MaxSessionsPerUser <= 0 || cfg.Sessions.MaxSocketsPerUser <= 0 {
+		errs = append(errs, errors.New("AVATAR_SESSION_IDLE_TTL, AVATAR_SESSIONS_PER_USER and AVATAR_SOCKETS_PER_USER must be positive"))
+	}
+	if _, err := cfg.Sessions.MessageBudget(); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Sessions.SocketMaxMessage <= 0 || cfg.Sessions.SocketPongWait <= 0 || cfg.Sessions.SocketMaxRejected <= 0 {
+		errs = append(errs, errors.New("AVATAR_SOCKET_MAX_MESSAGE, AVATAR_SOCKET_PONG_WAIT and AVATAR_SOCKET_MAX_REJECTED must be positive"))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"avazon-api/controllers/errs"
+	"avazon-api/models"
+	"avazon-api/services"
+	"avazon-api/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AdminController struct {
+	admin *services.AdminService
+}
+
+func NewAdminController(admin *services.AdminService) *AdminController {
+	return &AdminController{admin: admin}
+}
+
+func (ctrl *AdminController) setRole(c *gin.Context, role models.UserRole) {
+	actorID, ok := utils.GetUserID(c)
+	if !ok {
+		HandleError(c, errs.ErrUnauthorized)
+		return
+	}
+	user, err := ctrl.admin.SetRole(actorID, c.Param("user_id"), role)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// POST /system/users/:user_id/promote
+func (ctrl *AdminController) PromoteUser(c *gin.Context) {
+	ctrl.setRole(c, models.UserRoleAdmin)
+}
+
+// POST /system/users/:user_id/demote
+func (ctrl *AdminController) DemoteUser(c *gin.Context) {
+	ctrl.setRole(c, models.UserRoleUser)
+}
+
+// GET /system/users/admins
+func (ctrl *AdminController) GetAdmins(c *gin.Context) {
+	admins, err := ctrl.admin.GetAdmins()
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, admins)
+}
+
+// GET /system/audit
+// query-params: actor_id, target_id, action, page, limit (the latest first)
+func (ctrl *AdminController) GetAuditLog(c *gin.Context) {
+	page, limit := GetPagingParams(c)
+	filter := services.AuditFilter{ActorID: c.Query("actor_id"), TargetID: c.Query("target_id"), Action: c.Query("action")}
+	entries, err := ctrl.admin.GetAuditLog(filter, page, limit)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
@@ -33,7 +33,7 @@ func (ctrl *AvatarContentCreationController) StartMusicCreation(c *gin.Context)
 		return
 	}
 
-	musicCreation, err := ctrl.AvatarContentCreationService.CreateAvatarMusicImage(userID, c.Param("avatar_id"), request)
+	musicCreation, err := ctrl.AvatarContentCreationService.CreateAvatarMusicImage(c.Request.Context(), userID, c.Param("avatar_id"), request)
 	if err != nil {
 		HandleError(c, err)
 		return
@@ -108,6 +108,23 @@ func (ctrl *AvatarContentCreationController) ConfirmAvatarMusic(c *gin.Context)
 	c.JSON(http.StatusOK, music)
 }
 
+// stops the album image or music being produced
+func (ctrl *AvatarContentCreationController) CancelMusicCreation(c *gin.Context) {
+	userID, ok := utils.GetUserID(c)
+	if !ok {
+		HandleError(c, errs.ErrUnauthorized)
+		return
+	}
+
+	musicCreation, err := ctrl.AvatarContentCreationService.CancelAvatarMusic(userID, c.Param("creation_id"))
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, musicCreation)
+}
+
 // ========== Video Creation ==========
 
 func (ctrl *AvatarContentCreationController) StartVideoImageCreation(c *gin.Context) {
@@ -245,3 +262,20 @@ func (ctrl *AvatarContentCreationController) ConfirmAvatarVideo(c *gin.Context)
 
 	c.JSON(http.StatusOK, video)
 }
+
+// stops the thumbnail or video being rendered
+func (ctrl *AvatarContentCreationController) CancelVideoCreation(c *gin.Context) {
+	userID, ok := utils.GetUserID(c)
+	if !ok {
+		HandleError(c, errs.ErrUnauthorized)
+		return
+	}
+
+	videoCreation, err := ctrl.AvatarContentCreationService.CancelAvatarVideo(userID, c.Param("creation_id"))
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, videoCreation)
+}
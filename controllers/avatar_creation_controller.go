@@ -4,15 +4,19 @@ import (
 	"avazon-api/controllers/errs"
 	"avazon-api/dto"
 	"avazon-api/middleware"
-	"avazon-api/models"
 	"avazon-api/services"
+	"avazon-api/tools"
 	"avazon-api/utils"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
-	"strings"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -20,29 +24,24 @@ import (
 
 type AvatarCreationController struct {
 	AvatarCreationService *services.AvatarCreateService
+	upgrader              websocket.Upgrader
+	socketCfg             AvatarSocketConfig
 }
 
-func NewAvatarCreationController(avatarCreationService *services.AvatarCreateService) *AvatarCreationController {
-	return &AvatarCreationController{AvatarCreationService: avatarCreationService}
+// AvatarSocketConfig limits what one avatar creation websocket may do
+type AvatarSocketConfig struct {
+	MaxMessageSize int64                // bytes; a bigger message closes the socket (1009)
+	MessageBudget  middleware.RateLimit // messages of one socket
+	PongWait       time.Duration        // a socket silent for this long is closed; it is pinged every 9/10 of it
+	MaxRejected    int                  // messages in a row rejected (over budget or busy) before the socket is closed (1008)
 }
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		allowedOrigins := []string{
-			"http://localhost:8081",
-			"https://gid.cast-ing.kr",
-			"https://staging.d9xje8vs9f8su.amplifyapp.com",
-			"https://avazon.cast-ing.kr",
-		}
-
-		origin := r.Header.Get("Origin")
-		for _, o := range allowedOrigins {
-			if o == origin {
-				return true
-			}
-		}
-		return false
-	},
+func NewAvatarCreationController(avatarCreationService *services.AvatarCreateService, allowedOrigins []string, socketCfg AvatarSocketConfig) *AvatarCreationController {
+	return &AvatarCreationController{
+		AvatarCreationService: avatarCreationService,
+		upgrader:              NewUpgrader(allowedOrigins),
+		socketCfg:             socketCfg,
+	}
 }
 
 // First of all, create a new avatar creation session
@@ -85,6 +84,68 @@ func (ctrl *AvatarCreationController) GetOneSession(c *gin.Context) {
 	c.JSON(http.StatusOK, avatarCreation)
 }
 
+// GET /avatar/create/:creation_id/chats?object_type=&cursor=&size=&include_tools=
+func (ctrl *AvatarCreationController) GetSessionChats(c *gin.Context) {
+	avatarCreationID := c.Param("creation_id")
+	userID, ok := utils.GetUserID(c)
+	if !ok {
+		HandleError(c, errs.ErrUnauthorized)
+		return
+	}
+	objectType, cursor, size, withTools, err := getChatPageParams(c, false)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	chats, nextCursor, err := ctrl.AvatarCreationService.GetMyCreateSessionChat(userID, avatarCreationID, objectType, cursor, size, withTools)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, dto.CursorPageResponse{Items: chats, NextCursor: nextCursor})
+}
+
+// GET /system/avatar-creations/:creation_id/chats (admin, for support)
+// same as GetSessionChats, without the ownership check. Tool calls are included by default.
+func (ctrl *AvatarCreationController) GetSessionChatsAdmin(c *gin.Context) {
+	avatarCreationID := c.Param("creation_id")
+	objectType, cursor, size, withTools, err := getChatPageParams(c, true)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	chats, nextCursor, err := ctrl.AvatarCreationService.GetCreateSessionChat(avatarCreationID, objectType, cursor, size, withTools)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, dto.CursorPageResponse{Items: chats, NextCursor: nextCursor})
+}
+
+// size defaults to 20 (max 100), include_tools to defaultWithTools
+func getChatPageParams(c *gin.Context, defaultWithTools bool) (objectType string, cursor int, size int, withTools bool, err error) {
+	withTools = defaultWithTools
+	objectType = c.Query("object_type")
+	if raw := c.Query("cursor"); raw != "" {
+		if cursor, err = strconv.Atoi(raw); err != nil || cursor < 0 {
+			return "", 0, 0, false, errs.ErrBadRequest
+		}
+	}
+	size = 20
+	if raw := c.Query("size"); raw != "" {
+		if size, err = strconv.Atoi(raw); err != nil || size <= 0 {
+			return "", 0, 0, false, errs.ErrBadRequest
+		}
+	}
+	size = min(size, 100)
+	if raw := c.Query("include_tools"); raw != "" {
+		if withTools, err = strconv.ParseBool(raw); err != nil {
+			return "", 0, 0, false, errs.ErrBadRequest
+		}
+	}
+	return objectType, cursor, size, withTools, nil
+}
+
 func (ctrl *AvatarCreationController) CreateAvatarImage(c *gin.Context) {
 	creationID := c.Param("creation_id")
 	userID, ok := utils.GetUserID(c)
@@ -117,7 +178,7 @@ func (ctrl *AvatarCreationController) CreateAvatarCharacter(c *gin.Context) {
 		HandleError(c, err)
 		return
 	}
-	err := ctrl.AvatarCreationService.CreateCharacterByRequest(userID, creationID, req.Summary)
+	err := ctrl.AvatarCreationService.CreateCharacterByRequest(c.Request.Context(), userID, creationID, req.Summary)
 	if err != nil {
 		HandleError(c, err)
 		return
@@ -145,6 +206,37 @@ func (ctrl *AvatarCreationController) CreateAvatarVoice(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Voice creation started"})
 }
 
+// cancelPart answers the cancel endpoints of the image, character and voice creations
+func (ctrl *AvatarCreationController) cancelPart(c *gin.Context, cancel func(userID string, creationID string, partID int) error) {
+	userID, ok := utils.GetUserID(c)
+	if !ok {
+		HandleError(c, errs.ErrUnauthorized)
+		return
+	}
+	partID, err := strconv.Atoi(c.Param("part_id"))
+	if err != nil {
+		HandleError(c, errs.ErrBadRequest, "invalid part_id")
+		return
+	}
+	if err := cancel(userID, c.Param("creation_id"), partID); err != nil {
+		HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Creation cancelled"})
+}
+
+func (ctrl *AvatarCreationController) CancelAvatarImage(c *gin.Context) {
+	ctrl.cancelPart(c, ctrl.AvatarCreationService.CancelImage)
+}
+
+func (ctrl *AvatarCreationController) CancelAvatarCharacter(c *gin.Context) {
+	ctrl.cancelPart(c, ctrl.AvatarCreationService.CancelCharacter)
+}
+
+func (ctrl *AvatarCreationController) CancelAvatarVoice(c *gin.Context) {
+	ctrl.cancelPart(c, ctrl.AvatarCreationService.CancelVoice)
+}
+
 func (ctrl *AvatarCreationController) CreateAvatar(c *gin.Context) {
 	avatarCreationID := c.Param("creation_id")
 	userID, ok := utils.GetUserID(c)
@@ -178,7 +270,7 @@ type AvatarCreateRequest struct {
 
 type AvatarCreateResponse struct {
 	ObjectType string `json:"object_type"` // image, character, voice, all
-	Event      string `json:"event"`       // chat, chunk, creation, close, error
+	Event      string `json:"event"`       // history, chat, chunk, creation, close, error, busy
 	Content    string `json:"content"`
 }
 
@@ -192,12 +284,18 @@ func (ctrl *AvatarCreationController) EnterSession(c *gin.Context) {
 	// 	return
 	// }
 
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	conn, err := ctrl.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Println("WebSocket Upgrade Error:", err)
 		return
 	}
 	defer conn.Close()
+	// a silent client is dropped once the deadline passes: messages and pongs push it back
+	conn.SetReadLimit(ctrl.socketCfg.MaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(ctrl.socketCfg.PongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(ctrl.socketCfg.PongWait))
+	})
 
 	accessTokenBody := &struct {
 		AccessToken string `json:"access_token"`
@@ -212,15 +310,38 @@ func (ctrl *AvatarCreationController) EnterSession(c *gin.Context) {
 		return
 	}
 
-	session, err := ctrl.AvatarCreationService.EnterSession(userID, avatarCreationID)
-	if err != nil {
+	socket := &sessionSocket{conn: conn}
+	session, err := ctrl.AvatarCreationService.EnterSession(userID, avatarCreationID, socket)
+	if errors.Is(err, errs.ErrTooManySessions) || errors.Is(err, errs.ErrTooManySockets) {
+		log.Println("Session limit reached for user:", userID)
+		socket.Close(err)
+		return
+	} else if err != nil {
 		log.Println("Wrong session ID or unauthorized access")
-		conn.WriteMessage(websocket.TextMessage, []byte("Invalid State"))
+		socket.WriteMessage(websocket.TextMessage, []byte("Invalid State"))
 		conn.Close()
 		return
 	}
+	defer ctrl.AvatarCreationService.LeaveSession(session, socket)
+	// the assistant replies streaming to this socket stop when it goes away
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	go keepAlive(ctx, conn, ctrl.socketCfg.PongWait*9/10)
 	log.Println("Starting session for avatar creation ID:", avatarCreationID)
 
+	// replay the transcript, so a reconnecting client can restore its chat view
+	history, err := session.History()
+	if err != nil {
+		log.Println("Error loading chat history:", err)
+		return
+	}
+	jsonHistory, err := json.Marshal(history)
+	if err != nil {
+		log.Println("Error marshalling chat history to JSON:", err)
+		return
+	}
+	socket.WriteJSON(AvatarCreateResponse{Event: "history", ObjectType: "all", Content: string(jsonHistory)})
+
 	// message := map[string]string{"status": "OK"}
 	// jsonMessage, _ := json.Marshal(message)
 	// conn.WriteMessage(websocket.TextMessage, jsonMessage)
@@ -229,33 +350,72 @@ func (ctrl *AvatarCreationController) EnterSession(c *gin.Context) {
 	clientMessageChan := make(chan AvatarCreateRequest)
 	closeChan := make(chan struct{})
 	go func() {
-		defer close(clientMessageChan)
 		defer close(closeChan)
 		for {
 			var req AvatarCreateRequest
+			// a message over MaxMessageSize fails here, after gorilla closed the socket with 1009
 			err := conn.ReadJSON(&req)
 			if err != nil {
 				log.Println("Error reading message:", err)
-				closeChan <- struct{}{}
 				return
 			}
-			clientMessageChan <- req
+			conn.SetReadDeadline(time.Now().Add(ctrl.socketCfg.PongWait))
+			select {
+			case clientMessageChan <- req:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 
-	imageChatMu := sync.Mutex{}
-	characterChatMu := sync.Mutex{}
-	voiceChatMu := sync.Mutex{}
+	budget := middleware.NewTokenBucket(ctrl.socketCfg.MessageBudget)
+	rejected := 0 // messages in a row
+	// reject tells the client why its message is dropped, or closes the socket if it keeps sending them (returns false)
+	reject := func(event string, objectType string, reason string) bool {
+		rejected++
+		if rejected >= ctrl.socketCfg.MaxRejected {
+			log.Printf("Closing socket of user %s: %d messages rejected in a row", userID, rejected)
+			socket.Close(errs.ErrTooManyMessages)
+			return false
+		}
+		socket.WriteJSON(AvatarCreateResponse{Event: event, ObjectType: objectType, Content: reason})
+		return true
+	}
+	// one answer at a time per assistant: a chat sent meanwhile gets a "busy" event instead of waiting
+	chatMus := map[string]*sync.Mutex{"image": {}, "character": {}, "voice": {}}
 	for {
 		select {
 		case req := <-clientMessageChan:
+			if req.Event != "close" {
+				if ok, retryAfter := budget.Take(); !ok {
+					reason := fmt.Sprintf("%s, retry in %.0fs", errs.ErrTooManyMessages.Message, math.Ceil(retryAfter.Seconds()))
+					if !reject("error", req.ObjectType, reason) {
+						return
+					}
+					continue
+				}
+			}
+			session.Touch()
 			if req.Event == "chat" {
 				objectType := req.ObjectType
+				chatMu, ok := chatMus[objectType]
+				if !ok {
+					log.Println("Invalid object type:", objectType)
+					continue
+				}
+				if !chatMu.TryLock() {
+					if !reject("busy", objectType, errs.ErrAssistantBusy.Message) {
+						return
+					}
+					continue
+				}
+				rejected = 0
 				// save user chat
 				if req.Content != "" {
 					chat, err := ctrl.AvatarCreationService.SaveChat(avatarCreationID, "user", objectType, req.Content)
 					if err != nil {
 						log.Println("Error saving chat:", err)
+						chatMu.Unlock()
 						return
 					}
 					jsonChat, err := json.Marshal(chat)
@@ -263,204 +423,210 @@ func (ctrl *AvatarCreationController) EnterSession(c *gin.Context) {
 						log.Println("Error marshalling chat to JSON:", err)
 						return
 					}
-					conn.WriteJSON(AvatarCreateResponse{Event: "chat", ObjectType: objectType, Content: string(jsonChat)})
+					socket.WriteJSON(AvatarCreateResponse{Event: "chat", ObjectType: objectType, Content: string(jsonChat)})
 				}
 
-				var outputChan chan string
-				var doneChan chan string
-				var errorChan chan error
+				var events <-chan tools.AssistantEvent
 				switch objectType {
 				case "image":
-					imageChatMu.Lock()
-					outputChan, doneChan, errorChan = session.HandleImageChat(req.Content)
+					events = session.HandleImageChat(ctx, req.Content)
 				case "character":
-					characterChatMu.Lock()
-					outputChan, doneChan, errorChan = session.HandleCharacterChat(req.Content)
+					events = session.HandleCharacterChat(ctx, req.Content)
 				case "voice":
-					voiceChatMu.Lock()
-					outputChan, doneChan, errorChan = session.HandleVoiceChat(req.Content)
-				default:
-					log.Println("Invalid object type:", objectType)
-					continue
+					events = session.HandleVoiceChat(ctx, req.Content)
 				}
 				// handle chat response
 				go func() {
-					switch objectType {
-					case "image":
-						defer imageChatMu.Unlock()
-					case "character":
-						defer characterChatMu.Unlock()
-					case "voice":
-						defer voiceChatMu.Unlock()
-					}
+					defer chatMu.Unlock()
 
-					functionName := ""
+					// the tool calls of the first answer are run, then the assistant answers their results.
+					// The calls of that second answer are not run again.
 					functionHandled := false
-					toolCallId := ""
-					for {
-						select {
-						case output := <-outputChan:
-							if strings.HasPrefix(output, "function:") {
-								// Handle function call
-								// You can add your logic here to process the function call
-								log.Println("Function call detected:", output)
+					for events != nil {
+						var results []tools.ToolResult
+						for event := range events {
+							switch event.Type {
+							case tools.AE_TextDelta:
+								socket.WriteJSON(AvatarCreateResponse{Event: "chunk", ObjectType: objectType, Content: event.Text})
+							case tools.AE_ToolCallStart:
+								log.Println("Function call detected:", event.ToolCall.Name)
+							case tools.AE_ToolCallComplete:
 								if functionHandled {
-									log.Println("Function call already exists:", functionName)
+									log.Println("Function call already handled:", event.ToolCall.Name)
 									continue
 								}
-								fCallJsonStr := strings.TrimPrefix(output, "function:")
-								fCallJson := map[string]string{}
-								err := json.Unmarshal([]byte(fCallJsonStr), &fCallJson)
-								if err != nil {
-									log.Println("Error unmarshalling function call:", err)
-									continue
+								if result, ok := runAvatarTool(socket, session, objectType, event.ToolCall); ok {
+									results = append(results, tools.ToolResult{Call: event.ToolCall, Content: result})
 								}
-								functionName = fCallJson["name"]
-								toolCallId = fCallJson["id"]
-							} else {
-								conn.WriteJSON(AvatarCreateResponse{Event: "chunk", ObjectType: objectType, Content: output})
-							}
-						case message := <-doneChan:
-							nonJsonStr, jsonStrList := utils.SeparateTextAndJSON(message)
-
-							// handle function call
-							if functionName != "" && len(jsonStrList) > 0 {
-								if !session.CanCreateNow(objectType) {
-									continue // pass this turn
-								}
-
-								switch functionName {
-								case "create_avatar_image":
-									var summary string
-									var result map[string]interface{}
-									if err := json.Unmarshal([]byte(jsonStrList[0]), &result); err != nil {
-										log.Println("Error parsing message to JSON:", message, err)
-										errorChan <- err
-										continue
-									}
-									summary = result["summary"].(string)
-									imageChan, err := session.CreateImage(summary)
-									if err != nil {
-										log.Println("Error creating image:", err)
-										conn.WriteJSON(AvatarCreateResponse{Event: "error", ObjectType: objectType, Content: err.Error()})
-										return
-									}
-									// handle image creation
-									go func() {
-										for image := range imageChan {
-											imageJson, err := json.Marshal(image)
-											if err != nil {
-												log.Println("Error marshalling image to JSON:", err)
-												return
-											}
-											conn.WriteJSON(AvatarCreateResponse{Event: "creation", ObjectType: objectType, Content: string(imageJson)})
-											if image.Status == models.AC_Completed || image.Status == models.AC_Failed {
-												return
-											}
-										}
-									}()
-								case "create_avatar_character":
-									characterChan, err := session.CreateCharacter()
-									if err != nil {
-										log.Println("Error creating character:", err)
-										return
-									}
-									// handle character creation
-									go func() {
-										for character := range characterChan {
-											characterJson, err := json.Marshal(character)
-											if err != nil {
-												log.Println("Error marshalling character to JSON:", err)
-												return
-											}
-											conn.WriteJSON(AvatarCreateResponse{Event: "creation", ObjectType: objectType, Content: string(characterJson)})
-											if character.Status == models.AC_Completed || character.Status == models.AC_Failed {
-												return
-											}
-										}
-									}()
-								case "create_avatar_voice":
-									var summary string
-									var gender string
-									var accentStrength string
-									var age string
-									var accent string
-									var result map[string]interface{}
-									if err := json.Unmarshal([]byte(jsonStrList[0]), &result); err != nil {
-										log.Println("Error parsing message to JSON:", message, err)
-										return
-									}
-									summary = result["summary"].(string)
-									gender = result["gender"].(string)
-									accentStrength = fmt.Sprintf("%f", result["accent_strength"].(float64))
-									age = result["age"].(string)
-									accent = result["accent"].(string)
-									voiceChan, err := session.CreateVoice(summary, gender, accentStrength, age, accent)
-									if err != nil {
-										log.Println("Error creating voice:", err)
-										errorChan <- err
-										continue
-									}
-									// handle voice creation
-									go func() {
-										for voice := range voiceChan {
-											voiceJson, err := json.Marshal(voice)
-											if err != nil {
-												log.Println("Error marshalling voice to JSON:", err)
-												errorChan <- err
-												return
-											}
-											conn.WriteJSON(AvatarCreateResponse{Event: "creation", ObjectType: objectType, Content: string(voiceJson)})
-											if voice.Status == models.AC_Completed || voice.Status == models.AC_Failed {
-												return
-											}
-										}
-									}()
-								} // end of switch functionName
-								outputChan, doneChan, errorChan = session.ResponseAfterToolCalled(objectType, "creation started", functionName, jsonStrList[0], toolCallId)
-								if outputChan == nil || doneChan == nil || errorChan == nil {
-									log.Println("Error creating output channel:", err)
-									errorChan <- err
+							case tools.AE_Finish:
+								if event.Content == "" { // handle chat response
 									continue
 								}
-								functionName = "" // reset function name (if not reset, it will be used again here)
-								toolCallId = ""
-								functionHandled = true
-							}
-							if nonJsonStr != "" { // handle chat response
-								chat, err := ctrl.AvatarCreationService.SaveChat(avatarCreationID, "assistant", objectType, nonJsonStr)
+								chat, err := ctrl.AvatarCreationService.SaveChat(avatarCreationID, "assistant", objectType, event.Content)
 								if err != nil {
 									log.Println("Error saving chat:", err)
-									errorChan <- err
 									continue
 								}
 								jsonChat, err := json.Marshal(chat)
 								if err != nil {
 									log.Println("Error marshalling chat to JSON:", err)
-									errorChan <- err
 									continue
 								}
-								conn.WriteJSON(AvatarCreateResponse{Event: "chat", ObjectType: objectType, Content: string(jsonChat)})
-							}
-						case err := <-errorChan:
-							if err != nil {
-								conn.WriteJSON(AvatarCreateResponse{Event: "error", ObjectType: objectType, Content: err.Error()})
+								socket.WriteJSON(AvatarCreateResponse{Event: "chat", ObjectType: objectType, Content: string(jsonChat)})
+							case tools.AE_Error:
+								socket.WriteJSON(AvatarCreateResponse{Event: "error", ObjectType: objectType, Content: event.Err.Error()})
 							}
-							return
+						}
+						events = nil
+						if len(results) > 0 {
+							events = session.ResponseAfterToolCalled(ctx, objectType, results)
+							functionHandled = true
 						}
 					}
 				}()
 			} else if req.Event == "confirm" {
+				rejected = 0
 				session.Confirm()
 			} else if req.Event == "close" {
-				ctrl.AvatarCreationService.CloseSession(userID, avatarCreationID)
-				conn.WriteJSON(AvatarCreateResponse{ObjectType: "all", Event: "close", Content: "session closed"})
+				socket.WriteJSON(AvatarCreateResponse{ObjectType: "all", Event: "close", Content: "session closed"})
+				ctrl.AvatarCreationService.CloseSession(userID, avatarCreationID) // also closes the other sockets of the session
 				return
 			}
 		case <-closeChan:
-			ctrl.AvatarCreationService.CloseSession(userID, avatarCreationID)
-			conn.WriteJSON(AvatarCreateResponse{ObjectType: "all", Event: "close", Content: "session closed"})
+			// the socket is gone (or closed by the idle reaper); the session stays until it expires
 			return
 		}
 	}
 }
+
+// sessionSocket lets the avatar creation service close the websocket (idle reaper, session limits).
+// Its writes are serialized: the read loop, the chat answers and the creation relays all write to it.
+type sessionSocket struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+}
+
+func (s *sessionSocket) WriteJSON(v interface{}) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.conn.WriteJSON(v)
+}
+
+func (s *sessionSocket) WriteMessage(messageType int, data []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.conn.WriteMessage(messageType, data)
+}
+
+func (s *sessionSocket) Close(reason error) {
+	code := websocket.CloseNormalClosure
+	switch {
+	case errors.Is(reason, errs.ErrSessionExpired):
+		code = websocket.CloseGoingAway
+	case errors.Is(reason, errs.ErrTooManySessions), errors.Is(reason, errs.ErrTooManySockets), errors.Is(reason, errs.ErrTooManyMessages):
+		code = websocket.ClosePolicyViolation
+	}
+	// WriteControl may be called concurrently with the other writers
+	message := websocket.FormatCloseMessage(code, reason.Error())
+	if err := s.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second)); err != nil {
+		log.Println("Error writing close message:", err)
+	}
+	s.conn.Close()
+}
+
+// keepAlive pings conn until ctx is done; the pongs push the read deadline back
+func keepAlive(ctx context.Context, conn *websocket.Conn, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// runAvatarTool starts the creation a tool call of the assistant asks for, and streams its progress to socket.
+// ok is false when nothing was started: the call is left unanswered.
+func runAvatarTool(socket *sessionSocket, session *services.AvatarCreateSession, objectType string, call tools.ToolCall) (result string, ok bool) {
+	if !session.CanCreateNow(objectType) {
+		return "", false // pass this turn
+	}
+	var arguments struct {
+		Summary        string  `json:"summary"`
+		Gender         string  `json:"gender"`
+		AccentStrength float64 `json:"accent_strength"`
+		Age            string  `json:"age"`
+		Accent         string  `json:"accent"`
+	}
+	if err := json.Unmarshal([]byte(call.Arguments), &arguments); err != nil {
+		log.Println("Error parsing tool call arguments:", call.Arguments, err)
+		socket.WriteJSON(AvatarCreateResponse{Event: "error", ObjectType: objectType, Content: err.Error()})
+		return "", false
+	}
+
+	switch call.Name {
+	case "create_avatar_image":
+		imageChan, err := session.CreateImage(arguments.Summary)
+		if err != nil {
+			log.Println("Error creating image:", err)
+			socket.WriteJSON(AvatarCreateResponse{Event: "error", ObjectType: objectType, Content: err.Error()})
+			return "", false
+		}
+		// handle image creation, until the creation is over (completed, failed or cancelled) and closes the channel
+		go func() {
+			for image := range imageChan {
+				imageJson, err := json.Marshal(image)
+				if err != nil {
+					log.Println("Error marshalling image to JSON:", err)
+					continue
+				}
+				socket.WriteJSON(AvatarCreateResponse{Event: "creation", ObjectType: objectType, Content: string(imageJson)})
+			}
+		}()
+	case "create_avatar_character":
+		characterChan, err := session.CreateCharacter()
+		if err != nil {
+			log.Println("Error creating character:", err)
+			return "", false
+		}
+		// handle character creation
+		go func() {
+			for character := range characterChan {
+				characterJson, err := json.Marshal(character)
+				if err != nil {
+					log.Println("Error marshalling character to JSON:", err)
+					continue
+				}
+				socket.WriteJSON(AvatarCreateResponse{Event: "creation", ObjectType: objectType, Content: string(characterJson)})
+			}
+		}()
+	case "create_avatar_voice":
+		accentStrength := fmt.Sprintf("%f", arguments.AccentStrength)
+		voiceChan, err := session.CreateVoice(arguments.Summary, arguments.Gender, accentStrength, arguments.Age, arguments.Accent)
+		if err != nil {
+			log.Println("Error creating voice:", err)
+			socket.WriteJSON(AvatarCreateResponse{Event: "error", ObjectType: objectType, Content: err.Error()})
+			return "", false
+		}
+		// handle voice creation
+		go func() {
+			for voice := range voiceChan {
+				voiceJson, err := json.Marshal(voice)
+				if err != nil {
+					log.Println("Error marshalling voice to JSON:", err)
+					continue
+				}
+				socket.WriteJSON(AvatarCreateResponse{Event: "creation", ObjectType: objectType, Content: string(voiceJson)})
+			}
+		}()
+	default:
+		log.Println("Unknown function call:", call.Name)
+		return "", false
+	}
+	return "creation started", true
+}
@@ -110,3 +110,31 @@ func (ctrl *AvatarRemixController) ConfirmImageRemix(c *gin.Context) {
 
 	c.JSON(http.StatusOK, remix)
 }
+
+func (ctrl *AvatarRemixController) CancelImageRemix(c *gin.Context) {
+	userID, ok := utils.GetUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	avatarID := c.Param("avatar_id")
+	if avatarID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Avatar ID is required"})
+		return
+	}
+
+	remixID := c.Param("remix_id")
+	if remixID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Remix ID is required"})
+		return
+	}
+
+	remix, err := ctrl.AvatarRemixService.CancelImageRemix(userID, avatarID, remixID)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, remix)
+}
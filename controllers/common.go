@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/websocket"
 	"gorm.io/gorm"
 )
 
@@ -42,3 +44,12 @@ func GetPagingParams(c *gin.Context) (page int, limit int) {
 	}
 	return
 }
+
+// NewUpgrader returns a websocket upgrader accepting the same origins as CORS.
+func NewUpgrader(allowedOrigins []string) websocket.Upgrader {
+	return websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return slices.Contains(allowedOrigins, r.Header.Get("Origin"))
+		},
+	}
+}
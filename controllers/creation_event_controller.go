@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"avazon-api/controllers/errs"
+	"avazon-api/middleware"
+	"avazon-api/services"
+	"avazon-api/utils"
+	"io"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// keeps proxies from closing idle streams
+const creationEventKeepAlive = 25 * time.Second
+
+const (
+	creationEventPongWait   = 60 * time.Second // a websocket missing two pings is dropped
+	creationEventMaxMessage = 16 << 10         // the client only sends its access token
+)
+
+// CreationEventController streams the status transitions of the user's content creations
+// (music, video, image remix), instead of polling GET .../music/:creation_id.
+type CreationEventController struct {
+	Events   *services.CreationEventBus
+	upgrader websocket.Upgrader
+}
+
+func NewCreationEventController(events *services.CreationEventBus, allowedOrigins []string) *CreationEventController {
+	return &CreationEventController{
+		Events:   events,
+		upgrader: NewUpgrader(allowedOrigins),
+	}
+}
+
+func getCreationEventFilter(c *gin.Context) services.CreationEventFilter {
+	return services.CreationEventFilter{
+		AvatarID:   c.Query("avatar_id"),
+		CreationID: c.Query("creation_id"),
+	}
+}
+
+// GET /events/creations?avatar_id=&creation_id=
+// Server-Sent Events: one "status" event per transition (services.CreationEvent), "ping" to keep alive.
+func (ctrl *CreationEventController) StreamSSE(c *gin.Context) {
+	userID, ok := utils.GetUserID(c)
+	if !ok {
+		HandleError(c, errs.ErrUnauthorized)
+		return
+	}
+	sub := ctrl.Events.Subscribe(userID, getCreationEventFilter(c))
+	defer ctrl.Events.Unsubscribe(sub)
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // nginx
+	keepAlive := time.NewTicker(creationEventKeepAlive)
+	defer keepAlive.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				return false
+			}
+			c.SSEvent("status", event)
+			return true
+		case <-keepAlive.C:
+			c.SSEvent("ping", "")
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// GET /events/creations/ws?avatar_id=&creation_id=
+// websocket upgrade here. The first message is {"access_token": "..."}, then every
+// services.CreationEvent is sent as a JSON message.
+func (ctrl *CreationEventController) StreamWebSocket(c *gin.Context) {
+	filter := getCreationEventFilter(c)
+	conn, err := ctrl.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Println("WebSocket Upgrade Error:", err)
+		return
+	}
+	defer conn.Close()
+	// the access token is due within the deadline, then pongs push it back
+	conn.SetReadLimit(creationEventMaxMessage)
+	conn.SetReadDeadline(time.Now().Add(creationEventPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(creationEventPongWait))
+	})
+
+	accessTokenBody := &struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := conn.ReadJSON(accessTokenBody); err != nil {
+		log.Println("Error reading access token:", err)
+		return
+	}
+	userID, err := middleware.GetUserIDFromTokenString(accessTokenBody.AccessToken)
+	if err != nil {
+		log.Println("Invalid access token:", err)
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "invalid access token"))
+		return
+	}
+
+	sub := ctrl.Events.Subscribe(userID, filter)
+	defer ctrl.Events.Unsubscribe(sub)
+
+	// nothing else is expected from the client; reading notices when it goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(creationEventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"))
+				return
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				log.Println("Error writing creation event:", err)
+				return
+			}
+		case <-keepAlive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"avazon-api/controllers/errs"
+	"avazon-api/dto"
+	"avazon-api/services"
+	"avazon-api/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CreditController struct {
+	credits *services.CreditService
+	admin   *services.AdminService // audit trail of the grants
+}
+
+func NewCreditController(credits *services.CreditService, admin *services.AdminService) *CreditController {
+	return &CreditController{credits: credits, admin: admin}
+}
+
+// balance and transactions of userID
+func (ctrl *CreditController) respond(c *gin.Context, userID string, withPrices bool) {
+	balance, err := ctrl.credits.GetBalance(userID)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	page, limit := GetPagingParams(c)
+	transactions, err := ctrl.credits.GetTransactions(userID, page, limit)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	response := gin.H{"credits": balance, "transactions": transactions}
+	if withPrices {
+		response["prices"] = ctrl.credits.Prices
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GET /users/me/credits
+// query-params: page, limit (of the transactions, the latest first)
+func (ctrl *CreditController) GetMyCredits(c *gin.Context) {
+	userID, ok := utils.GetUserID(c)
+	if !ok {
+		HandleError(c, errs.ErrUnauthorized)
+		return
+	}
+	ctrl.respond(c, userID, true)
+}
+
+// GET /system/credits/users/:user_id
+func (ctrl *CreditController) GetUserCredits(c *gin.Context) {
+	ctrl.respond(c, c.Param("user_id"), false)
+}
+
+// POST /system/credits/users/:user_id/grant
+func (ctrl *CreditController) GrantCredits(c *gin.Context) {
+	var req dto.CreditGrantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		HandleError(c, err)
+		return
+	}
+	grant, err := ctrl.credits.Grant(c.Param("user_id"), req.Amount, req.Note)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	ctrl.admin.Audit(c.GetString("user_id"), services.AA_CreditsGrant, "user", grant.UserID, gin.H{"amount": req.Amount, "note": req.Note, "transaction_id": grant.ID})
+	c.JSON(http.StatusOK, grant)
+}
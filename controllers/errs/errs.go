@@ -31,6 +31,8 @@ var (
 	ErrInvalidJWT          = AppError{StatusCode: http.StatusUnauthorized, Message: "Invalid JWT", ErrorCode: "40102"}
 	ErrRefreshMismatch     = AppError{StatusCode: http.StatusUnauthorized, Message: "Refresh Token Mismatch between access token and refresh token", ErrorCode: "40103"}
 	ErrOAuthTokenInvalid   = AppError{StatusCode: http.StatusUnauthorized, Message: "Invalid OAuth Token", ErrorCode: "40104"}
+	ErrRefreshTokenReused  = AppError{StatusCode: http.StatusUnauthorized, Message: "Refresh Token already used, the session is revoked", ErrorCode: "40105"}
+	ErrSessionRevoked      = AppError{StatusCode: http.StatusUnauthorized, Message: "Session Revoked", ErrorCode: "40106"}
 	// Avatar Creation
 	ErrAvatarAlreadyCreated = AppError{StatusCode: http.StatusConflict, Message: "Avatar Already Created", ErrorCode: "40901"}
 	ErrImageNotCreated      = AppError{StatusCode: http.StatusBadRequest, Message: "Image Not Created", ErrorCode: "40002"}
@@ -42,6 +44,21 @@ var (
 	ErrContentNotCompleted             = AppError{StatusCode: http.StatusBadRequest, Message: "Content Not Completed", ErrorCode: "40007"}
 	ErrContentCreationAlreadyCompleted = AppError{StatusCode: http.StatusBadRequest, Message: "Content Creation Already Completed", ErrorCode: "40008"}
 	ErrContentCreationFailed           = AppError{StatusCode: http.StatusBadRequest, Message: "Content Creation Failed", ErrorCode: "40009"}
+	ErrCreationNotCancellable          = AppError{StatusCode: http.StatusConflict, Message: "Creation Not In Progress", ErrorCode: "40902"}
+	// Admin
+	ErrSelfDemotion = AppError{StatusCode: http.StatusConflict, Message: "Admins Cannot Demote Themselves", ErrorCode: "40904"}
+	// Credits
+	ErrInsufficientCredits = AppError{StatusCode: http.StatusPaymentRequired, Message: "Insufficient Credits", ErrorCode: "40200"}
+	// Web Data Session
+	ErrWebDataTooLarge = AppError{StatusCode: http.StatusRequestEntityTooLarge, Message: "Data Too Large (max 1024 bytes)", ErrorCode: "41300"}
+	// Avatar Creation Session (websocket)
+	ErrTooManySessions = AppError{StatusCode: http.StatusTooManyRequests, Message: "Too Many Creation Sessions", ErrorCode: "42900"}
+	ErrTooManySockets  = AppError{StatusCode: http.StatusTooManyRequests, Message: "Too Many Connections", ErrorCode: "42901"}
+	// Rate Limit
+	ErrTooManyRequests = AppError{StatusCode: http.StatusTooManyRequests, Message: "Too Many Requests", ErrorCode: "42902"}
+	ErrSessionExpired  = AppError{StatusCode: http.StatusRequestTimeout, Message: "Session Expired", ErrorCode: "40800"}
+	ErrAssistantBusy   = AppError{StatusCode: http.StatusConflict, Message: "Assistant Busy, wait for its answer", ErrorCode: "40903"}
+	ErrTooManyMessages = AppError{StatusCode: http.StatusTooManyRequests, Message: "Too Many Messages", ErrorCode: "42903"}
 )
 
 // SendErrorResponse handles common error responses in the Gin context.
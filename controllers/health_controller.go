@@ -0,0 +1,27 @@
+package controllers
+
+import (
+	"avazon-api/tools"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type HealthController struct{}
+
+func NewHealthController() *HealthController {
+	return &HealthController{}
+}
+
+// GET /health/providers
+// circuit breaker state of every provider; "degraded" while one of them is not closed
+func (ctrl *HealthController) GetProvidersHealth(c *gin.Context) {
+	providers := tools.ProvidersHealth()
+	status := "ok"
+	for _, p := range providers {
+		if p.State != tools.Breaker_Closed {
+			status = "degraded"
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"status": status, "providers": providers})
+}
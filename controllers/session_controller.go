@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"avazon-api/controllers/errs"
+	"avazon-api/dto"
+	"avazon-api/middleware"
+	"avazon-api/models"
+	"avazon-api/services"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type SessionController struct {
+	sessions *services.SessionService
+	users    *services.UserService
+	admin    *services.AdminService
+}
+
+func NewSessionController(sessions *services.SessionService, users *services.UserService, admin *services.AdminService) *SessionController {
+	return &SessionController{sessions: sessions, users: users, admin: admin}
+}
+
+// POST /users/session
+// header: Authorization: Bearer <Dynamic wallet JWT>, checked once and exchanged for our own tokens
+func (ctrl *SessionController) StartSession(c *gin.Context) {
+	tokenString := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if tokenString == "" {
+		HandleError(c, errs.ErrUnauthorized)
+		return
+	}
+	token, err := middleware.ValidateDynamicWalletJWT(tokenString)
+	if err != nil {
+		log.Printf("Invalid Dynamic wallet JWT: %v", err)
+		HandleError(c, middleware.TokenError(err))
+		return
+	}
+	userID, err := middleware.GetUserIDFromJWT(*token)
+	if err != nil {
+		HandleError(c, errs.ErrInvalidJWT)
+		return
+	}
+	user, err := ctrl.users.GetUserByIDCreateIfNotExists(userID)
+	if err != nil {
+		log.Printf("Error getting user by ID: %v", err)
+		HandleError(c, err)
+		return
+	}
+	session, refreshToken, err := ctrl.sessions.Create(user.ID)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	ctrl.sendTokens(c, user, session, refreshToken)
+}
+
+// POST /users/session/refresh
+// body: {refresh_token}, used once: the response carries the one replacing it
+func (ctrl *SessionController) RefreshSession(c *gin.Context) {
+	var req dto.RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		HandleError(c, err)
+		return
+	}
+	token, err := middleware.ValidateSessionJWT(req.RefreshToken, middleware.TokenType_Refresh)
+	if err != nil {
+		log.Printf("Invalid refresh token: %v", err)
+		HandleError(c, middleware.TokenError(err))
+		return
+	}
+	session, refreshToken, err := ctrl.sessions.Rotate(middleware.TokenIDOf(token))
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	user, err := ctrl.users.GetUserByID(session.UserID)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	ctrl.sendTokens(c, user, session, refreshToken)
+}
+
+func (ctrl *SessionController) sendTokens(c *gin.Context, user *models.User, session *models.UserSession, refreshToken *models.RefreshToken) {
+	accessJWT, err := middleware.GenerateSessionJWT(user.ID, middleware.TokenType_Access, ctrl.sessions.AccessTTL, string(user.Role), session.ID, uuid.New().String())
+	if err != nil {
+		log.Printf("Cannot generate access token: %v", err)
+		HandleError(c, errs.ErrInternalServerError)
+		return
+	}
+	refreshJWT, err := middleware.GenerateSessionJWT(user.ID, middleware.TokenType_Refresh, time.Until(refreshToken.ExpiresAt), "refresh", session.ID, refreshToken.ID)
+	if err != nil {
+		log.Printf("Cannot generate refresh token: %v", err)
+		HandleError(c, errs.ErrInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, dto.Token{
+		AccessToken:  accessJWT,
+		RefreshToken: refreshJWT,
+		ExpiresIn:    int(ctrl.sessions.AccessTTL.Seconds()),
+	})
+}
+
+// DELETE /users/session
+// logs out the session of the access token
+func (ctrl *SessionController) EndSession(c *gin.Context) {
+	sessionID := c.GetString("session_id")
+	if sessionID == "" {
+		HandleError(c, errs.ErrBadRequest, "Not a session access token")
+		return
+	}
+	if err := ctrl.sessions.Revoke(sessionID, models.SessionRevoked_Logout); err != nil {
+		HandleError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// POST /system/users/:user_id/sessions/revoke
+func (ctrl *SessionController) RevokeUserSessions(c *gin.Context) {
+	userID := c.Param("user_id")
+	revoked, err := ctrl.sessions.RevokeUser(userID, models.SessionRevoked_Admin)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	ctrl.admin.Audit(c.GetString("user_id"), services.AA_SessionsRevoke, "user", userID, gin.H{"sessions": revoked})
+	c.JSON(http.StatusOK, gin.H{"revoked": revoked})
+}
@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"avazon-api/services"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Serves files of storages without a public endpoint (e.g. in-memory)
+type StorageController struct {
+	Storage services.Storage
+}
+
+func NewStorageController(storage services.Storage) *StorageController {
+	return &StorageController{Storage: storage}
+}
+
+// GET /files/*key
+func (ctrl *StorageController) GetFile(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	data, contentType, err := ctrl.Storage.Download(c.Request.Context(), key)
+	if errors.Is(err, services.ErrObjectNotFound) {
+		c.Status(http.StatusNotFound)
+		return
+	} else if err != nil {
+		HandleError(c, err)
+		return
+	}
+	c.Data(http.StatusOK, contentType, data)
+}
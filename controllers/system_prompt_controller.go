@@ -12,10 +12,11 @@ import (
 
 type SystemPromptController struct {
 	service *services.SystemPromptService
+	admin   *services.AdminService // audit trail
 }
 
-func NewSystemPromptController(service *services.SystemPromptService) *SystemPromptController {
-	return &SystemPromptController{service: service}
+func NewSystemPromptController(service *services.SystemPromptService, admin *services.AdminService) *SystemPromptController {
+	return &SystemPromptController{service: service, admin: admin}
 }
 
 // CreateSystemPrompt handles the creation of a new system prompt
@@ -37,6 +38,7 @@ func (ctrl *SystemPromptController) CreateSystemPrompt(c *gin.Context) {
 		HandleError(c, err)
 		return
 	}
+	ctrl.admin.Audit(c.GetString("user_id"), services.AA_SystemPromptUpsert, "system_prompt", promptID, prompt)
 
 	c.JSON(http.StatusCreated, prompt)
 }
@@ -59,6 +61,7 @@ func (ctrl *SystemPromptController) DeleteSystemPrompt(c *gin.Context) {
 		HandleError(c, err)
 		return
 	}
+	ctrl.admin.Audit(c.GetString("user_id"), services.AA_SystemPromptDelete, "system_prompt", promptID, nil)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Prompt deleted successfully"})
 }
@@ -72,6 +75,7 @@ func (ctrl *SystemPromptController) UpdateSystemPromptUsage(c *gin.Context) {
 		HandleError(c, err)
 		return
 	}
+	ctrl.admin.Audit(c.GetString("user_id"), services.AA_SystemPromptUsageSet, "system_prompt_usage", agentID, gin.H{"prompt_id": promptID})
 
 	c.JSON(http.StatusOK, gin.H{"message": "Usage set successfully"})
 }
@@ -94,6 +98,7 @@ func (ctrl *SystemPromptController) DeleteSystemPromptUsage(c *gin.Context) {
 		HandleError(c, err)
 		return
 	}
+	ctrl.admin.Audit(c.GetString("user_id"), services.AA_SystemPromptUsageDelete, "system_prompt_usage", agentID, nil)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Usage deleted successfully"})
 }
@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"avazon-api/controllers/errs"
+	"avazon-api/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UsageController struct {
+	ledger *services.UsageLedger
+}
+
+func NewUsageController(ledger *services.UsageLedger) *UsageController {
+	return &UsageController{ledger: ledger}
+}
+
+// usageFilter reads the query params: from and to (YYYY-MM-DD, both inclusive, UTC), user_id, provider
+func usageFilter(c *gin.Context) (services.UsageFilter, error) {
+	filter := services.UsageFilter{UserID: c.Query("user_id"), Provider: c.Query("provider")}
+	if from := c.Query("from"); from != "" {
+		day, err := time.Parse(time.DateOnly, from)
+		if err != nil {
+			return filter, errs.ErrBadRequest
+		}
+		filter.From = day
+	}
+	if to := c.Query("to"); to != "" {
+		day, err := time.Parse(time.DateOnly, to)
+		if err != nil {
+			return filter, errs.ErrBadRequest
+		}
+		filter.To = day.AddDate(0, 0, 1)
+	}
+	return filter, nil
+}
+
+func (ctrl *UsageController) respond(c *gin.Context, aggregate func(services.UsageFilter) ([]services.UsageCost, error)) {
+	filter, err := usageFilter(c)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	costs, err := aggregate(filter)
+	if err != nil {
+		HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, costs)
+}
+
+// GET /system/usage/users
+// provider cost by user, the most expensive first
+func (ctrl *UsageController) GetCostByUser(c *gin.Context) {
+	ctrl.respond(c, ctrl.ledger.CostByUser)
+}
+
+// GET /system/usage/providers
+// usage and cost by provider, operation and unit
+func (ctrl *UsageController) GetCostByProvider(c *gin.Context) {
+	ctrl.respond(c, ctrl.ledger.CostByProvider)
+}
+
+// GET /system/usage/days
+// provider cost by day
+func (ctrl *UsageController) GetCostByDay(c *gin.Context) {
+	ctrl.respond(c, ctrl.ledger.CostByDay)
+}
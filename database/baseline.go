@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// sqlRecorder is a gorm logger that keeps every statement instead of printing it.
+// Together with DryRun it turns the gorm migrator into a DDL generator.
+type sqlRecorder struct {
+	statements []string
+}
+
+func (r *sqlRecorder) LogMode(logger.LogLevel) logger.Interface      { return r }
+func (r *sqlRecorder) Info(context.Context, string, ...interface{})  {}
+func (r *sqlRecorder) Warn(context.Context, string, ...interface{})  {}
+func (r *sqlRecorder) Error(context.Context, string, ...interface{}) {}
+func (r *sqlRecorder) Trace(_ context.Context, _ time.Time, fc func() (string, int64), _ error) {
+	sql, _ := fc()
+	r.statements = append(r.statements, sql)
+}
+
+// offlineDialector returns a dialector that doesn't need a running server.
+func offlineDialector(driver Driver) (gorm.Dialector, error) {
+	switch driver {
+	case DriverSQLite:
+		return sqlite.Open(":memory:"), nil
+	case DriverPostgres:
+		// pgx connects lazily, and nothing is executed in DryRun mode
+		return postgres.New(postgres.Config{DSN: "host=localhost"}), nil
+	default:
+		return nil, fmt.Errorf("unsupported database driver: %q", driver)
+	}
+}
+
+// GenerateBaseline renders the CREATE statements for the given models as an up/down migration pair.
+// Tables are created in the given order and dropped in reverse.
+func GenerateBaseline(driver Driver, models []interface{}) (up string, down string, err error) {
+	dialector, err := offlineDialector(driver)
+	if err != nil {
+		return "", "", err
+	}
+	recorder := &sqlRecorder{}
+	db, err := gorm.Open(dialector, &gorm.Config{
+		DryRun:                                   true,
+		DisableAutomaticPing:                     true,
+		DisableForeignKeyConstraintWhenMigrating: true,
+		Logger:                                   recorder,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	recorder.statements = nil // drop anything the dialector ran while initializing
+
+	var drops []string
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return "", "", fmt.Errorf("failed to parse model %T: %w", model, err)
+		}
+		if err := db.Migrator().CreateTable(model); err != nil {
+			return "", "", fmt.Errorf("failed to generate table %s: %w", stmt.Schema.Table, err)
+		}
+		var quoted strings.Builder
+		db.Dialector.QuoteTo(&quoted, stmt.Schema.Table)
+		drops = append([]string{"DROP TABLE IF EXISTS " + quoted.String()}, drops...)
+	}
+
+	return renderSQL(recorder.statements), renderSQL(drops), nil
+}
+
+func renderSQL(statements []string) string {
+	var b strings.Builder
+	b.WriteString("-- Generated from the models package by `migrate generate-baseline`.\n\n")
+	for _, statement := range statements {
+		b.WriteString(strings.TrimSpace(statement))
+		b.WriteString(";\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,16 @@
+-- Generated from the models package by `migrate generate-baseline`.
+
+CREATE TABLE "system_prompts" ("id" text,"prompt" varchar(10000) NOT NULL,"used_for" text,"category" text,"created_at" timestamptz,"edited_at" timestamptz,PRIMARY KEY ("id"));
+CREATE TABLE "system_prompt_usages" ("agent_id" text NOT NULL,"prompt_id" text,"created_at" timestamptz,"edited_at" timestamptz,PRIMARY KEY ("agent_id"));
+CREATE TABLE "users" ("id" varchar(255),"email" varchar(255) NOT NULL,"name" varchar(255) NOT NULL,"profile_image_url" varchar(255),"oauth2_provider" text,"oauth2_id" varchar(255),"role" varchar(255) NOT NULL,"created_at" timestamptz,"edited_at" timestamptz,PRIMARY KEY ("id"),CONSTRAINT "uni_users_email" UNIQUE ("email"));
+CREATE TABLE "avatar_creations" ("id" varchar(40),"user_id" varchar(255),"name" varchar(50) NOT NULL,"species" varchar(30) NOT NULL,"gender" varchar(10) NOT NULL,"language" varchar(30) NOT NULL,"country" varchar(30) NOT NULL,"description" varchar(1000) NOT NULL,"image_style" varchar(20) NOT NULL,"started_at" timestamptz NOT NULL,"completed_at" timestamptz,"status" varchar(20) NOT NULL,PRIMARY KEY ("id"));
+CREATE TABLE "avatar_creation_chats" ("id" bigserial,"avatar_creation_id" varchar(40) NOT NULL,"role" varchar(20) NOT NULL,"object_type" varchar(20) NOT NULL,"created_at" timestamptz,"content" text,"created_object_number" bigint DEFAULT 0,"tool_call_id" varchar(255),"tool_call_name" varchar(100),"tool_call_arguments" text,PRIMARY KEY ("id"));
+CREATE TABLE "avatar_character_creations" ("id" bigserial,"user_id" varchar(255),"avatar_creation_id" varchar(40) NOT NULL,"prompt" text,"content" text,"status" text,"failed_reason" text,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE TABLE "avatar_voice_creations" ("id" bigserial,"user_id" varchar(255),"avatar_creation_id" varchar(40) NOT NULL,"prompt" varchar(3000),"voice_url" text NOT NULL,"status" text,"failed_reason" text,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE TABLE "avatar_image_creations" ("id" bigserial,"user_id" varchar(255),"avatar_creation_id" varchar(40) NOT NULL,"prompt" varchar(3000),"image_url" text NOT NULL,"status" text,"failed_reason" text,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE TABLE "avatars" ("id" varchar(255),"user_id" varchar(255),"avatar_creation_id" varchar(40),"remix_avatar_id" varchar(255),"name" varchar(100),"species" varchar(30),"gender" varchar(10),"language" varchar(30),"country" varchar(30),"description" varchar(1000),"created_at" timestamptz,"profile_image_url" text,"voice_url" text,"avatar_video_url" text,"character_description" text,PRIMARY KEY ("id"));
+CREATE TABLE "avatar_musics" ("id" text,"user_id" varchar(255) NOT NULL,"title" text NOT NULL,"avatar_id" varchar(255) NOT NULL,"album_image_url" text NOT NULL,"music_url" text NOT NULL,"created_at" timestamptz,"updated_at" timestamptz,PRIMARY KEY ("id"));
+CREATE TABLE "avatar_videos" ("id" text,"user_id" varchar(255) NOT NULL,"title" text NOT NULL,"avatar_id" varchar(255) NOT NULL,"thumbnail_image_url" text NOT NULL,"video_content_url" text NOT NULL,"created_at" timestamptz,"updated_at" timestamptz,PRIMARY KEY ("id"));
+CREATE TABLE "avatar_music_content_creations" ("id" text,"user_id" varchar(255),"avatar_id" varchar(255) NOT NULL,"title" varchar(255),"style" varchar(300),"description" varchar(3000),"generated_image_prompt" text,"generated_music_prompt" text,"album_image_url" varchar(255),"music_url" varchar(255),"status" text NOT NULL,"failed_reason" varchar(255),"created_at" timestamptz,"updated_at" timestamptz,PRIMARY KEY ("id"));
+CREATE TABLE "avatar_video_content_creations" ("id" text,"user_id" varchar(255),"avatar_id" varchar(255) NOT NULL,"image_prompt" text NOT NULL,"thumbnail_image_url" text,"video_prompt" text NOT NULL,"video_content_url" text,"status" text NOT NULL,"failed_reason" varchar(255),"created_at" timestamptz,"updated_at" timestamptz,PRIMARY KEY ("id"));
+CREATE TABLE "avatar_image_remixes" ("id" varchar(36) NOT NULL,"user_id" varchar(255) NOT NULL,"avatar_id" varchar(255) NOT NULL,"user_prompt" varchar(1000) NOT NULL,"status" varchar(20) NOT NULL,"failed_reason" varchar(255),"image_url" varchar(255),PRIMARY KEY ("id"));
@@ -0,0 +1,3 @@
+CREATE TABLE "jobs" ("id" bigserial,"type" varchar(50) NOT NULL,"ref_id" varchar(255) NOT NULL,"payload" text NOT NULL,"status" varchar(20) NOT NULL,"attempts" bigint NOT NULL DEFAULT 0,"max_attempts" bigint NOT NULL,"run_at" timestamptz NOT NULL,"heartbeat_at" timestamptz,"last_error" text,"finished_at" timestamptz,"created_at" timestamptz,"updated_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_jobs_ref_id" ON "jobs" ("ref_id");
+CREATE INDEX IF NOT EXISTS "idx_jobs_type_status" ON "jobs" ("type","status");
@@ -0,0 +1,4 @@
+CREATE TABLE "usage_records" ("id" bigserial,"user_id" varchar(255),"avatar_id" varchar(255),"creation_type" varchar(50),"creation_id" varchar(255),"provider" varchar(100) NOT NULL,"operation" varchar(50) NOT NULL,"model" varchar(100),"unit" varchar(20) NOT NULL,"quantity" decimal NOT NULL,"input_tokens" bigint NOT NULL DEFAULT 0,"output_tokens" bigint NOT NULL DEFAULT 0,"detail" varchar(255),"cost" decimal NOT NULL,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_usage_records_user_id" ON "usage_records" ("user_id");
+CREATE INDEX IF NOT EXISTS "idx_usage_records_provider" ON "usage_records" ("provider");
+CREATE INDEX IF NOT EXISTS "idx_usage_records_created_at" ON "usage_records" ("created_at");
@@ -0,0 +1,4 @@
+ALTER TABLE "users" ADD "credits" bigint NOT NULL DEFAULT 0;
+CREATE TABLE "credit_transactions" ("id" bigserial,"user_id" varchar(255) NOT NULL,"kind" varchar(20) NOT NULL,"amount" bigint NOT NULL,"balance" bigint NOT NULL,"job_id" bigint,"operation" varchar(100),"note" varchar(255),"refunded_at" timestamptz,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_credit_transactions_job_id" ON "credit_transactions" ("job_id");
+CREATE INDEX IF NOT EXISTS "idx_credit_transactions_user_id" ON "credit_transactions" ("user_id");
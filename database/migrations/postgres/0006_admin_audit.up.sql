@@ -0,0 +1,4 @@
+CREATE TABLE "admin_audit_logs" ("id" bigserial,"actor_id" varchar(255) NOT NULL,"action" varchar(50) NOT NULL,"target_type" varchar(50) NOT NULL,"target_id" varchar(255) NOT NULL,"detail" text,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_admin_audit_logs_created_at" ON "admin_audit_logs" ("created_at");
+CREATE INDEX IF NOT EXISTS "idx_admin_audit_logs_target_id" ON "admin_audit_logs" ("target_id");
+CREATE INDEX IF NOT EXISTS "idx_admin_audit_logs_actor_id" ON "admin_audit_logs" ("actor_id");
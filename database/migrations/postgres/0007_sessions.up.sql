@@ -0,0 +1,5 @@
+CREATE TABLE "user_sessions" ("id" varchar(255),"user_id" varchar(255) NOT NULL,"expires_at" timestamptz NOT NULL,"revoked_at" timestamptz,"revoked_reason" varchar(50),"created_at" timestamptz,"updated_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_user_sessions_revoked_at" ON "user_sessions" ("revoked_at");
+CREATE INDEX IF NOT EXISTS "idx_user_sessions_user_id" ON "user_sessions" ("user_id");
+CREATE TABLE "refresh_tokens" ("id" varchar(255),"session_id" varchar(255) NOT NULL,"expires_at" timestamptz NOT NULL,"used_at" timestamptz,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_refresh_tokens_session_id" ON "refresh_tokens" ("session_id");
@@ -0,0 +1,16 @@
+-- Generated from the models package by `migrate generate-baseline`.
+
+DROP TABLE IF EXISTS `avatar_image_remixes`;
+DROP TABLE IF EXISTS `avatar_video_content_creations`;
+DROP TABLE IF EXISTS `avatar_music_content_creations`;
+DROP TABLE IF EXISTS `avatar_videos`;
+DROP TABLE IF EXISTS `avatar_musics`;
+DROP TABLE IF EXISTS `avatars`;
+DROP TABLE IF EXISTS `avatar_image_creations`;
+DROP TABLE IF EXISTS `avatar_voice_creations`;
+DROP TABLE IF EXISTS `avatar_character_creations`;
+DROP TABLE IF EXISTS `avatar_creation_chats`;
+DROP TABLE IF EXISTS `avatar_creations`;
+DROP TABLE IF EXISTS `users`;
+DROP TABLE IF EXISTS `system_prompt_usages`;
+DROP TABLE IF EXISTS `system_prompts`;
@@ -0,0 +1,3 @@
+CREATE TABLE `jobs` (`id` integer PRIMARY KEY AUTOINCREMENT,`type` varchar(50) NOT NULL,`ref_id` varchar(255) NOT NULL,`payload` text NOT NULL,`status` varchar(20) NOT NULL,`attempts` integer NOT NULL DEFAULT 0,`max_attempts` integer NOT NULL,`run_at` datetime NOT NULL,`heartbeat_at` datetime,`last_error` text,`finished_at` datetime,`created_at` datetime,`updated_at` datetime);
+CREATE INDEX `idx_jobs_ref_id` ON `jobs`(`ref_id`);
+CREATE INDEX `idx_jobs_type_status` ON `jobs`(`type`,`status`);
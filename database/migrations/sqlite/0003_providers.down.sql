@@ -0,0 +1,6 @@
+ALTER TABLE `avatar_image_remixes` DROP COLUMN `provider`;
+ALTER TABLE `avatar_video_content_creations` DROP COLUMN `thumbnail_image_provider`;
+ALTER TABLE `avatar_music_content_creations` DROP COLUMN `music_provider`;
+ALTER TABLE `avatar_music_content_creations` DROP COLUMN `album_image_provider`;
+ALTER TABLE `avatar_voice_creations` DROP COLUMN `provider`;
+ALTER TABLE `avatar_image_creations` DROP COLUMN `provider`;
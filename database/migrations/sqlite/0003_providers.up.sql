@@ -0,0 +1,6 @@
+ALTER TABLE `avatar_image_creations` ADD `provider` varchar(50);
+ALTER TABLE `avatar_voice_creations` ADD `provider` varchar(50);
+ALTER TABLE `avatar_music_content_creations` ADD `album_image_provider` varchar(50);
+ALTER TABLE `avatar_music_content_creations` ADD `music_provider` varchar(50);
+ALTER TABLE `avatar_video_content_creations` ADD `thumbnail_image_provider` varchar(50);
+ALTER TABLE `avatar_image_remixes` ADD `provider` varchar(50);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS `credit_transactions`;
+ALTER TABLE `users` DROP COLUMN `credits`;
@@ -0,0 +1,4 @@
+ALTER TABLE `users` ADD `credits` integer NOT NULL DEFAULT 0;
+CREATE TABLE `credit_transactions` (`id` integer PRIMARY KEY AUTOINCREMENT,`user_id` varchar(255) NOT NULL,`kind` varchar(20) NOT NULL,`amount` integer NOT NULL,`balance` integer NOT NULL,`job_id` integer,`operation` varchar(100),`note` varchar(255),`refunded_at` datetime,`created_at` datetime);
+CREATE INDEX `idx_credit_transactions_job_id` ON `credit_transactions`(`job_id`);
+CREATE INDEX `idx_credit_transactions_user_id` ON `credit_transactions`(`user_id`);
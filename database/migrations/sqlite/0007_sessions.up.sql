@@ -0,0 +1,5 @@
+CREATE TABLE `user_sessions` (`id` varchar(255),`user_id` varchar(255) NOT NULL,`expires_at` datetime NOT NULL,`revoked_at` datetime,`revoked_reason` varchar(50),`created_at` datetime,`updated_at` datetime,PRIMARY KEY (`id`));
+CREATE INDEX `idx_user_sessions_revoked_at` ON `user_sessions`(`revoked_at`);
+CREATE INDEX `idx_user_sessions_user_id` ON `user_sessions`(`user_id`);
+CREATE TABLE `refresh_tokens` (`id` varchar(255),`session_id` varchar(255) NOT NULL,`expires_at` datetime NOT NULL,`used_at` datetime,`created_at` datetime,PRIMARY KEY (`id`));
+CREATE INDEX `idx_refresh_tokens_session_id` ON `refresh_tokens`(`session_id`);
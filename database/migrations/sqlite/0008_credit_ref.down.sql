@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS `idx_credit_transactions_ref`;
+ALTER TABLE `credit_transactions` DROP COLUMN `ref`;
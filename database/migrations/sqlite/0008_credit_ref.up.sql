@@ -0,0 +1,2 @@
+ALTER TABLE `credit_transactions` ADD `ref` varchar(100);
+CREATE INDEX `idx_credit_transactions_ref` ON `credit_transactions`(`ref`);
@@ -0,0 +1,6 @@
+package dto
+
+type CreditGrantRequest struct {
+	Amount int64  `json:"amount" binding:"required,gt=0"`
+	Note   string `json:"note" binding:"max=255"`
+}
@@ -4,3 +4,9 @@ type PageResponse struct {
 	Items interface{} `json:"items"`
 	Total int64       `json:"total"`
 }
+
+// CursorPageResponse is a page of a list read with ?cursor=. NextCursor is null on the last page.
+type CursorPageResponse struct {
+	Items      interface{} `json:"items"`
+	NextCursor *int        `json:"next_cursor"`
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"avazon-api/config"
 	"avazon-api/controllers"
+	"avazon-api/database"
 	"avazon-api/middleware"
 	"avazon-api/models"
 	"avazon-api/services"
-	"avazon-api/tools"
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -14,45 +16,33 @@ import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
-	"gorm.io/driver/sqlite"
+	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 )
 
-func initLocalDB() *gorm.DB {
-	DB, err := gorm.Open(sqlite.Open("./test.db"), &gorm.Config{})
+func initDB(cfg config.DatabaseConfig) *gorm.DB {
+	DB, err := database.Open(database.Config{
+		Driver:          database.Driver(cfg.Driver),
+		DSN:             cfg.DSN,
+		MaxOpenConns:    cfg.MaxOpenConns,
+		MaxIdleConns:    cfg.MaxIdleConns,
+		ConnMaxLifetime: cfg.ConnMaxLifetime,
+	})
 	if err != nil {
 		panic("failed to connect database: " + err.Error())
 	}
 	return DB
 }
 
-func InitDB() *gorm.DB {
-	DB := initLocalDB()
-	err := DB.AutoMigrate(
-		&models.SystemPrompt{},
-		&models.SystemPromptUsage{},
-		&models.User{},
-		&models.AvatarCreation{},
-		&models.AvatarCreationChat{},
-		&models.AvatarCharacterCreation{},
-		&models.AvatarVoiceCreation{},
-		&models.AvatarImageCreation{},
-		&models.Avatar{},
-		&models.AvatarMusic{},
-		&models.AvatarVideo{},
-		&models.AvatarMusicContentCreation{},
-		&models.AvatarVideoContentCreation{},
-		&models.AvatarImageRemix{},
-	)
-	if err != nil {
-		log.Fatal("Failed to migrate database:", err)
-	}
+func InitDB(cfg config.DatabaseConfig) *gorm.DB {
+	DB := initDB(cfg)
+	checkMigrations(DB, cfg.AutoMigrate)
 	return DB
 }
 
-func InitCORS(r *gin.Engine) {
+func InitCORS(r *gin.Engine, allowedOrigins []string) {
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:8081", "http://localhost:5173", "https://gid.cast-ing.kr", "https://staging.d9xje8vs9f8su.amplifyapp.com", "https://avazon.cast-ing.kr"},
+		AllowOrigins:     allowedOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-OAuth2-Token"},
 		ExposeHeaders:    []string{"Content-Length"},
@@ -61,6 +51,54 @@ func InitCORS(r *gin.Engine) {
 	}))
 }
 
+// initStorage creates the configured storage. Local and memory files are served by this server.
+func initStorage(r *gin.Engine, cfg config.StorageConfig) (services.Storage, error) {
+	switch cfg.Driver {
+	case "local":
+		storage, err := services.NewLocalStorage(cfg.LocalDir, cfg.PublicURL)
+		if err != nil {
+			return nil, err
+		}
+		r.Static(cfg.Route, cfg.LocalDir)
+		return storage, nil
+	case "memory":
+		storage := services.NewMemoryStorage(cfg.PublicURL)
+		r.GET(cfg.Route+"/*key", controllers.NewStorageController(storage).GetFile)
+		return storage, nil
+	default:
+		return services.NewS3Service(cfg.S3Bucket, cfg.S3Region)
+	}
+}
+
+// newRedisClient connects to REDIS_URL and checks the server answers
+func newRedisClient(url string) (*redis.Client, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	client := redis.NewClient(options)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("could not connect to redis: %w", err)
+	}
+	return client, nil
+}
+
+func initWebDataSessionStore(cfg *config.Config, redisClient func() *redis.Client) services.WebDataSessionStore {
+	if cfg.WebData.Store == "redis" {
+		return services.NewRedisWebDataSessionStore(redisClient())
+	}
+	return services.NewMemoryWebDataSessionStore(time.Minute)
+}
+
+func initRateLimitStore(cfg *config.Config, redisClient func() *redis.Client) middleware.RateLimitStore {
+	if cfg.RateLimit.Store == "redis" {
+		return middleware.NewRedisRateLimitStore(redisClient())
+	}
+	return middleware.NewMemoryRateLimitStore(time.Minute)
+}
+
 func main() {
 	if os.Getenv("PROFILE") == "local" || os.Getenv("PROFILE") == "" {
 		err := godotenv.Load()
@@ -69,70 +107,125 @@ func main() {
 		}
 	}
 
-	DB := InitDB()
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if len(args) > 0 && args[0] == "migrate" {
+		runMigrate(cfg.Database, args[1:])
+		return
+	}
+	if len(args) > 0 && args[0] == "admin" {
+		runAdmin(cfg.Database, args[1:])
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+
+	DB := InitDB(cfg.Database)
 	r := gin.Default()
 	r.RedirectTrailingSlash = false
 	r.RedirectFixedPath = false
-	InitCORS(r)
+	// the client IP (rate limits) is read from X-Forwarded-For only behind these proxies
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	InitCORS(r, cfg.Server.AllowedOrigins)
 
 	// Init JWT keys
-	err := middleware.InitKeys()
+	err = middleware.InitKeys(cfg.Auth.PrivateKeyFile, cfg.Auth.PublicKeyFile)
 	if err != nil {
 		panic(err)
 	}
-	err = middleware.InitDynamicWalletKey()
-	if err != nil {
-		panic(err)
+	// Dynamic wallet keys, by kid; fetched again on the first login if the JWKS is unreachable now
+	dwKeys := middleware.NewJWKS(cfg.Auth.JWKSURL(), cfg.Auth.DWJWKSFile, cfg.Auth.DWJWKSRefresh, cfg.Auth.DWJWKSMaxAge)
+	if err := dwKeys.Start(context.Background()); err != nil {
+		log.Printf("Error loading Dynamic wallet JWKS: %v", err)
+	}
+	dwAudiences := cfg.Auth.DWAudiences
+	if len(dwAudiences) == 0 {
+		dwAudiences = cfg.Server.AllowedOrigins
 	}
+	middleware.InitDynamicWalletKeys(dwKeys, cfg.Auth.DWEnvironment, cfg.Auth.Issuer(), dwAudiences)
 
 	// Init validator
 	models.InitValidator()
 
 	// ======= Tools =======
-	// 1. keys
-	openAIKey := os.Getenv("OPENAI_API_KEY")
-	if openAIKey == "" {
-		panic("OPENAI_API_KEY is not set")
+	storage, err := initStorage(r, cfg.Storage)
+	if err != nil {
+		fmt.Println("Error initializing storage:", err)
+		return
+	}
+	providers, err := initProviders(cfg)
+	if err != nil {
+		log.Fatal("Error initializing providers:", err)
 	}
-	openArtKey := os.Getenv("OPENART_API_KEY")
-	if openArtKey == "" {
-		panic("OPENART_API_KEY is not set")
+	// connected on first use, so redis is only needed when a redis store is configured
+	var redisClient *redis.Client
+	getRedisClient := func() *redis.Client {
+		if redisClient == nil {
+			if redisClient, err = newRedisClient(cfg.Redis.URL); err != nil {
+				log.Fatal("Error initializing redis:", err)
+			}
+		}
+		return redisClient
 	}
-	elevenLabsKey := os.Getenv("ELEVENLABS_API_KEY")
-	if elevenLabsKey == "" {
-		panic("ELEVENLABS_API_KEY is not set")
+	jobConcurrency, _ := cfg.Jobs.ConcurrencyByType() // checked by Validate
+	jobQueue := services.NewJobQueue(DB, services.JobQueueConfig{
+		Concurrency:        jobConcurrency,
+		DefaultConcurrency: cfg.Jobs.DefaultConcurrency,
+		MaxAttempts:        cfg.Jobs.MaxAttempts,
+		RetryBackoff:       cfg.Jobs.RetryBackoff,
+		PollInterval:       cfg.Jobs.PollInterval,
+		StaleAfter:         cfg.Jobs.StaleAfter,
+	})
+
+	// status transitions of the content creations, pushed over SSE/WebSocket
+	var creationEvents *services.CreationEventBus
+	if cfg.Events.Bus == "redis" {
+		creationEvents = services.NewCreationEventBus(getRedisClient())
+	} else {
+		creationEvents = services.NewCreationEventBus(nil)
 	}
-	runwayKey := os.Getenv("RUNWAY_API_KEY")
-	if runwayKey == "" {
-		panic("RUNWAY_API_KEY is not set")
+	if err := creationEvents.Start(context.Background()); err != nil {
+		log.Fatal("Error starting creation event bus:", err)
 	}
-	jenAIKey := os.Getenv("JENAI_API_KEY")
-	if jenAIKey == "" {
-		panic("JENAI_API_KEY is not set")
+
+	// provider usage and cost, attributed to the users and creations
+	usagePrices, _ := cfg.Usage.PricesByKey() // checked by Validate
+	ledgerPrices := make(map[string]services.UsagePrice, len(usagePrices))
+	for key, price := range usagePrices {
+		ledgerPrices[key] = services.UsagePrice(price)
 	}
-	// 2. components
-	s3Service, err := services.NewS3Service("avazon", "us-west-1")
-	if err != nil {
-		fmt.Println("Error initializing S3 service:", err)
-		return
+	usageLedger := services.NewUsageLedger(DB, ledgerPrices)
+
+	// credits paying for the jobs of the users
+	creditPrices, _ := cfg.Credits.PricesByJobType() // checked by Validate
+	creditService := services.NewCreditService(DB, jobQueue, creditPrices, int64(cfg.Credits.SignupGrant))
+	// admins are the users with the admin role, their changes are audited
+	adminService := services.NewAdminService(DB)
+	creditController := controllers.NewCreditController(creditService, adminService)
+
+	// token buckets of the route groups: public by IP, the others by user
+	rateLimits, _ := cfg.RateLimit.LimitsByGroup() // checked by Validate
+	groupLimits := make(map[string]middleware.RateLimit, len(rateLimits))
+	for group, limit := range rateLimits {
+		groupLimits[group] = middleware.RateLimit(limit)
 	}
-	openAIPainter := tools.NewOpenAIPainter(openAIKey)
-	openArtPainter := tools.NewOpenArtPainter(openArtKey)
-	elevenLabsVoiceActor := tools.NewElevenLabsVoiceActor(elevenLabsKey)
-	runwayVideoProducer := tools.NewRunwayVideoProducer(runwayKey)
-	jenAIProducer := tools.NewJENAIProducer(jenAIKey)
+	rateLimiter := middleware.NewRateLimiter(initRateLimitStore(cfg, getRedisClient), groupLimits)
+	publicLimit := rateLimiter.Middleware("public")
+	userLimit := rateLimiter.Middleware("user")
+	creationLimit := rateLimiter.Middleware("creation") // requests starting paid work
+	adminOnly := middleware.RoleAuthMiddleware(adminService.RoleOf, models.UserRoleAdmin)
 
 	// ======= System Prompt Domain =======
 	// system prompts
-	systemPromptService := services.NewSystemPromptService(
-		DB,
-		func() tools.Assistant {
-			return tools.NewOpenAIAssistant(openAIKey, "gpt-4o")
-		},
-	)
-	systemPromptController := controllers.NewSystemPromptController(systemPromptService)
+	systemPromptService := services.NewSystemPromptService(DB, providers.NewAssistant)
+	systemPromptController := controllers.NewSystemPromptController(systemPromptService, adminService)
 	systemPromptRG := r.Group("/system/prompts")
-	systemPromptRG.Use(middleware.AdminAuthMiddleware())
+	systemPromptRG.Use(middleware.JWTAuthMiddleware(), userLimit, adminOnly)
 	{
 		systemPromptRG.POST("/:prompt_id", systemPromptController.CreateSystemPrompt)
 		systemPromptRG.GET("/", systemPromptController.GetAllSystemPrompts)
@@ -143,11 +236,15 @@ func main() {
 	}
 
 	// ======= Web Data Session Domain =======
-	webDataSessionService := services.NewWebDataSessionService()
+	webDataSessionService := services.NewWebDataSessionService(
+		initWebDataSessionStore(cfg, getRedisClient),
+		cfg.WebData.TokenTTL,
+		cfg.WebData.DataIdleTTL,
+	)
 	webDataSessionController := controllers.NewWebDataSessionController(webDataSessionService)
 	webDataSessionRG := r.Group("/web-data-session")
-	webDataSessionRG.POST("/token/fetch", webDataSessionController.GetToken)
-	webDataSessionRG.Use(middleware.JWTAuthMiddleware())
+	webDataSessionRG.POST("/token/fetch", publicLimit, webDataSessionController.GetToken)
+	webDataSessionRG.Use(middleware.JWTAuthMiddleware(), userLimit)
 	{
 		// 1. put token
 		webDataSessionRG.PUT("/token", webDataSessionController.PutToken)
@@ -162,49 +259,83 @@ func main() {
 	}
 
 	// ======= User Domain =======
-	dwUserService := services.NewDynamicWalletUserService(os.Getenv("DW_LIVE_KEY"))
-	userService := services.NewUserService(DB, dwUserService)
+	dwUserService := services.NewDynamicWalletUserService(cfg.Auth.DWLiveKey)
+	userService := services.NewUserService(DB, dwUserService, creditService)
 	userController := controllers.NewUserController(userService)
+	// sessions: the Dynamic wallet JWT exchanged once for our own access and refresh tokens
+	sessionService := services.NewSessionService(DB, cfg.Auth.AccessTokenTTL, cfg.Auth.RefreshTokenTTL)
+	if err := sessionService.Start(context.Background(), cfg.Auth.RevocationPoll); err != nil {
+		log.Fatal("Error loading revoked sessions:", err)
+	}
+	middleware.InitSessionAuth(sessionService.IsRevoked, cfg.Auth.AcceptDynamicJWT)
+	sessionController := controllers.NewSessionController(sessionService, userService, adminService)
 	userRG := r.Group("/users")
 	// userRG.POST("/oauth2/:provider", userController.OAuth2Login)
-	userRG.Use(middleware.JWTAuthMiddleware())
+	userRG.POST("/session", publicLimit, sessionController.StartSession)
+	userRG.POST("/session/refresh", publicLimit, sessionController.RefreshSession)
+	userRG.Use(middleware.JWTAuthMiddleware(), userLimit)
 	{
 		userRG.GET("/me", userController.GetMyInfo)
+		userRG.GET("/me/credits", creditController.GetMyCredits)
+		userRG.DELETE("/session", sessionController.EndSession)
 	}
-	// r.POST("/users/token/refresh", middleware.JWTAuthMiddleware("refresh"), userController.RefreshToken)
 
 	// ======= Avatar Domain =======
 	// ** Avatar Creation API **
 	avatarCreationService := services.NewAvatarCreateService(
 		DB,
-		func() tools.Assistant {
-			return tools.NewOpenAIAssistant(openAIKey, "gpt-4o")
-		},
+		providers.NewAssistant,
 		systemPromptService,
-		openArtPainter,
-		elevenLabsVoiceActor,
-		runwayVideoProducer,
-		s3Service,
+		providers.ImagePainter,
+		providers.VoiceActor,
+		providers.VideoProducer,
+		storage,
+		jobQueue,
+		usageLedger,
+		creditService,
+		services.AvatarSessionConfig{
+			IdleTTL:            cfg.Sessions.IdleTTL,
+			MaxSessionsPerUser: cfg.Sessions.MaxSessionsPerUser,
+			MaxSocketsPerUser:  cfg.Sessions.MaxSocketsPerUser,
+		},
 	)
-	avatarCreationController := controllers.NewAvatarCreationController(avatarCreationService)
+	avatarCreationService.StartReaper(context.Background())
+	messageBudget, _ := cfg.Sessions.MessageBudget() // checked by Validate
+	avatarCreationController := controllers.NewAvatarCreationController(avatarCreationService, cfg.Server.AllowedOrigins, controllers.AvatarSocketConfig{
+		MaxMessageSize: int64(cfg.Sessions.SocketMaxMessage),
+		MessageBudget:  middleware.RateLimit(messageBudget),
+		PongWait:       cfg.Sessions.SocketPongWait,
+		MaxRejected:    cfg.Sessions.SocketMaxRejected,
+	})
+	// for support staff reviewing sessions
+	avatarCreationAdminRG := r.Group("/system/avatar-creations")
+	avatarCreationAdminRG.Use(middleware.JWTAuthMiddleware(), userLimit, adminOnly)
+	{
+		avatarCreationAdminRG.GET("/:creation_id/chats", avatarCreationController.GetSessionChatsAdmin)
+	}
 	avatarCreateRG := r.Group("/avatar/create")
-	avatarCreateRG.GET("/:creation_id/enter/", avatarCreationController.EnterSession) // Websocket exchange
-	avatarCreateRG.GET("/:creation_id/enter", avatarCreationController.EnterSession)  // Websocket exchange
-	avatarCreateRG.Use(middleware.JWTAuthMiddleware())
+	avatarCreateRG.GET("/:creation_id/enter/", publicLimit, avatarCreationController.EnterSession) // Websocket exchange
+	avatarCreateRG.GET("/:creation_id/enter", publicLimit, avatarCreationController.EnterSession)  // Websocket exchange
+	avatarCreateRG.Use(middleware.JWTAuthMiddleware(), userLimit)
 	{
-		avatarCreateRG.POST("/new", avatarCreationController.StartCreation)
+		avatarCreateRG.POST("/new", creationLimit, avatarCreationController.StartCreation)
 		avatarCreateRG.GET("/:creation_id", avatarCreationController.GetOneSession)
+		avatarCreateRG.GET("/:creation_id/chats", avatarCreationController.GetSessionChats)
 		avatarCreateRG.POST("/:creation_id", avatarCreationController.CreateAvatar)
 		// also has RESTful interface
-		avatarCreateRG.POST("/:creation_id/image", avatarCreationController.CreateAvatarImage)
-		avatarCreateRG.POST("/:creation_id/character", avatarCreationController.CreateAvatarCharacter)
-		avatarCreateRG.POST("/:creation_id/voice", avatarCreationController.CreateAvatarVoice)
+		avatarCreateRG.POST("/:creation_id/image", creationLimit, avatarCreationController.CreateAvatarImage)
+		avatarCreateRG.POST("/:creation_id/character", creationLimit, avatarCreationController.CreateAvatarCharacter)
+		avatarCreateRG.POST("/:creation_id/voice", creationLimit, avatarCreationController.CreateAvatarVoice)
+		avatarCreateRG.POST("/:creation_id/image/:part_id/cancel", avatarCreationController.CancelAvatarImage)
+		avatarCreateRG.POST("/:creation_id/character/:part_id/cancel", avatarCreationController.CancelAvatarCharacter)
+		avatarCreateRG.POST("/:creation_id/voice/:part_id/cancel", avatarCreationController.CancelAvatarVoice)
 	}
 
 	// ** Avatar Public API **
 	avatarService := services.NewAvatarService(DB)
 	avatarController := controllers.NewAvatarController(avatarService)
 	avatarPublicRG := r.Group("/avatar")
+	avatarPublicRG.Use(publicLimit)
 	{
 		avatarPublicRG.GET("", avatarController.GetAvatars)
 		avatarPublicRG.GET("/:avatar_id", avatarController.GetOneAvatar)
@@ -214,7 +345,7 @@ func main() {
 		avatarPublicRG.GET("/contents/:content_type/:content_id", avatarController.GetOneAvatarContent)
 	}
 	myAvatarRG := r.Group("/avatar/my")
-	myAvatarRG.Use(middleware.JWTAuthMiddleware())
+	myAvatarRG.Use(middleware.JWTAuthMiddleware(), userLimit)
 	{
 		myAvatarRG.GET("", avatarController.GetMyAvatars)
 		myAvatarRG.GET("/contents/:content_type", avatarController.GetMyAvatarContents)
@@ -223,41 +354,107 @@ func main() {
 	// ** Avatar Content Creation API **
 	avatarContentCreationService := services.NewAvatarContentCreationService(
 		DB,
-		s3Service,
+		storage,
 		systemPromptService,
-		openAIPainter,
-		openArtPainter,
-		jenAIProducer,
-		runwayVideoProducer,
+		providers.AlbumPainter,
+		providers.ImagePainter,
+		providers.MusicProducer,
+		providers.VideoProducer,
+		jobQueue,
+		creationEvents,
+		usageLedger,
+		creditService,
 	)
 	avatarContentCreationController := controllers.NewAvatarContentCreationController(avatarContentCreationService)
 	avatarCreationRG := r.Group("/avatar/:avatar_id/contents/create")
-	avatarCreationRG.Use(middleware.JWTAuthMiddleware())
+	avatarCreationRG.Use(middleware.JWTAuthMiddleware(), userLimit)
 	{
 		// music : prompt -> create by one step
-		avatarCreationRG.POST("/music", avatarContentCreationController.StartMusicCreation)
+		avatarCreationRG.POST("/music", creationLimit, avatarContentCreationController.StartMusicCreation)
 		avatarCreationRG.GET("/music", avatarContentCreationController.GetMusicCreations)
 		avatarCreationRG.GET("/music/:creation_id", avatarContentCreationController.GetOneMusicCreation)
 		avatarCreationRG.POST("/music/:creation_id/confirm", avatarContentCreationController.ConfirmAvatarMusic) // confirm with NFT
+		avatarCreationRG.POST("/music/:creation_id/cancel", avatarContentCreationController.CancelMusicCreation)
 
 		// video : prompt -> create by two step (1. image, 2. video)
-		avatarCreationRG.POST("/video/image", avatarContentCreationController.StartVideoImageCreation)
+		avatarCreationRG.POST("/video/image", creationLimit, avatarContentCreationController.StartVideoImageCreation)
 		avatarCreationRG.GET("/video", avatarContentCreationController.GetVideoCreation)
 		avatarCreationRG.GET("/video/:creation_id", avatarContentCreationController.GetOneVideoCreation)
-		avatarCreationRG.POST("/video/image/:creation_id/create", avatarContentCreationController.StartVideoCreationFromImage)
+		avatarCreationRG.POST("/video/image/:creation_id/create", creationLimit, avatarContentCreationController.StartVideoCreationFromImage)
 		avatarCreationRG.POST("/video/image/:creation_id/confirm", avatarContentCreationController.ConfirmAvatarVideo) // confirm with NFT
+		avatarCreationRG.POST("/video/:creation_id/cancel", avatarContentCreationController.CancelVideoCreation)
 	}
 
 	// ** Avatar Remix API **
-	avatarRemixService := services.NewAvatarRemixService(DB, s3Service, openArtPainter)
+	avatarRemixService := services.NewAvatarRemixService(DB, storage, providers.ImagePainter, jobQueue, creationEvents, usageLedger, creditService)
 	avatarRemixController := controllers.NewAvatarRemixController(avatarRemixService)
 	avatarRemixRG := r.Group("/avatar/:avatar_id/remix")
-	avatarRemixRG.Use(middleware.JWTAuthMiddleware())
+	avatarRemixRG.Use(middleware.JWTAuthMiddleware(), userLimit)
 	{
-		avatarRemixRG.POST("/image", avatarRemixController.StartImageRemix)
+		avatarRemixRG.POST("/image", creationLimit, avatarRemixController.StartImageRemix)
 		avatarRemixRG.GET("/image/:remix_id", avatarRemixController.GetOneImageRemix)
 		avatarRemixRG.POST("/image/:remix_id/confirm", avatarRemixController.ConfirmImageRemix)
+		avatarRemixRG.POST("/image/:remix_id/cancel", avatarRemixController.CancelImageRemix)
+	}
+
+	// ** Creation Status Events **
+	creationEventController := controllers.NewCreationEventController(creationEvents, cfg.Server.AllowedOrigins)
+	eventsRG := r.Group("/events/creations")
+	eventsRG.GET("/ws", publicLimit, creationEventController.StreamWebSocket) // Websocket exchange
+	eventsRG.Use(middleware.JWTAuthMiddleware(), userLimit)
+	{
+		eventsRG.GET("", creationEventController.StreamSSE)
+	}
+
+	// ======= Admin =======
+	adminController := controllers.NewAdminController(adminService)
+	adminUserRG := r.Group("/system/users")
+	adminUserRG.Use(middleware.JWTAuthMiddleware(), userLimit, adminOnly)
+	{
+		adminUserRG.GET("/admins", adminController.GetAdmins)
+		adminUserRG.POST("/:user_id/promote", adminController.PromoteUser)
+		adminUserRG.POST("/:user_id/demote", adminController.DemoteUser)
+		adminUserRG.POST("/:user_id/sessions/revoke", sessionController.RevokeUserSessions)
+	}
+	auditRG := r.Group("/system/audit")
+	auditRG.Use(middleware.JWTAuthMiddleware(), userLimit, adminOnly)
+	{
+		// query-params: actor_id, target_id, action, page, limit
+		auditRG.GET("", adminController.GetAuditLog)
+	}
+
+	// ======= Usage =======
+	usageController := controllers.NewUsageController(usageLedger)
+	usageRG := r.Group("/system/usage")
+	usageRG.Use(middleware.JWTAuthMiddleware(), userLimit, adminOnly)
+	{
+		// query-params: from, to (YYYY-MM-DD), user_id, provider
+		usageRG.GET("/users", usageController.GetCostByUser)
+		usageRG.GET("/providers", usageController.GetCostByProvider)
+		usageRG.GET("/days", usageController.GetCostByDay)
+	}
+
+	// ======= Credits =======
+	creditAdminRG := r.Group("/system/credits")
+	creditAdminRG.Use(middleware.JWTAuthMiddleware(), userLimit, adminOnly)
+	{
+		// query-params: page, limit (of the transactions)
+		creditAdminRG.GET("/users/:user_id", creditController.GetUserCredits)
+		creditAdminRG.POST("/users/:user_id/grant", creditController.GrantCredits)
+	}
+
+	// ======= Health =======
+	healthController := controllers.NewHealthController()
+	healthRG := r.Group("/health")
+	healthRG.Use(publicLimit)
+	{
+		healthRG.GET("/providers", healthController.GetProvidersHealth)
+	}
+
+	// every job handler is registered by now
+	if err := jobQueue.Start(context.Background()); err != nil {
+		log.Fatalf("Error starting job queue: %v", err)
 	}
 
-	r.Run(":8080")
+	r.Run(cfg.Server.Addr)
 }
@@ -2,66 +2,72 @@ package middleware
 
 import (
 	"avazon-api/models"
-	"crypto/rsa"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	"os"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var dynamicWalletKey *rsa.PublicKey
+// clock skew tolerated on exp and nbf
+const dynamicWalletLeeway = 30 * time.Second
 
-// Initialize RSA keys (should be called during app initialization)
-func InitDynamicWalletKey() error {
-	// Load the public key
-	dwBytes, err := os.ReadFile("dw_key.pem")
-	if err != nil {
-		return fmt.Errorf("could not read public key file: %v", err)
-	}
-
-	dynamicWalletKey, err = jwt.ParseRSAPublicKeyFromPEM(dwBytes)
-	if err != nil {
-		return fmt.Errorf("could not parse public key: %v", err)
-	}
+var (
+	dynamicWalletKeys        *JWKS
+	dynamicWalletEnvironment string
+	dynamicWalletIssuer      string
+	dynamicWalletAudiences   []string
+)
 
-	return nil
+// InitDynamicWalletKeys sets how the Dynamic wallet JWTs are checked (should be called during app initialization):
+// signed by a key of keys, with the iss, environment_id and one of the aud given
+func InitDynamicWalletKeys(keys *JWKS, environmentID string, issuer string, audiences []string) {
+	dynamicWalletKeys = keys
+	dynamicWalletEnvironment = environmentID
+	dynamicWalletIssuer = issuer
+	dynamicWalletAudiences = audiences
 }
 
 func ValidateDynamicWalletJWT(tokenString string) (*jwt.Token, error) {
 	// Ensure the keys are loaded
-	if dynamicWalletKey == nil {
-		return nil, fmt.Errorf("public key is not initialized")
+	if dynamicWalletKeys == nil {
+		return nil, fmt.Errorf("dynamic wallet keys are not initialized")
 	}
 
-	log.Println("validate dynamic wallet jwt", tokenString[:30])
-
-	// Parse and validate the JWT token
-	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Check if the token signing method is RS256
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-
+	// Parse and validate the JWT token: RS256, exp (required), nbf and iss
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			if envID, ok := claims["environment_id"].(string); !ok || envID != os.Getenv("DW_ENVIRONMENT") {
+			if envID, ok := claims["environment_id"].(string); !ok || envID != dynamicWalletEnvironment {
 				return nil, fmt.Errorf("invalid environment_id: %v", envID)
 			}
 		}
 
-		// Validate the audience (aud) claim
-		// if claims, ok := token.Claims.(jwt.MapClaims); ok {
-		// 	if aud, ok := claims["aud"]; !ok || aud != "http://localhost:5173" {
-		// 		return nil, fmt.Errorf("invalid audience: %v", aud)
-		// 	}
-		// }
+		// the key it was signed with, from the JWKS
+		kid, ok := token.Header["kid"].(string)
+		if !ok || kid == "" {
+			return nil, fmt.Errorf("invalid token: kid not found")
+		}
+		return dynamicWalletKeys.Key(kid)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(dynamicWalletIssuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(dynamicWalletLeeway),
+	)
+	if err != nil {
+		return nil, err
+	}
 
-		return dynamicWalletKey, nil
-	})
+	// Validate the audience (aud) claim: the origin of the app
+	audiences, err := token.Claims.GetAudience()
+	if err != nil || !slices.ContainsFunc(audiences, func(aud string) bool { return slices.Contains(dynamicWalletAudiences, aud) }) {
+		return nil, fmt.Errorf("invalid audience: %v", audiences)
+	}
+	return token, nil
 }
 
 func ExtractJWT(tokenString string) (*jwt.Token, error) {
@@ -97,9 +103,9 @@ func ExtractPayload(jwtToken string) (map[string]interface{}, error) {
 	return payloadData, nil
 }
 
-// Extract userId from JWT token string
+// Extract userId from an access token string (see ValidateAccessToken)
 func GetUserIDFromTokenString(tokenString string) (string, error) {
-	token, err := ValidateDynamicWalletJWT(tokenString)
+	token, err := ValidateAccessToken(tokenString)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,205 @@
+package middleware
+
+import (
+	"avazon-api/utils"
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	jwksFetchTimeout = 10 * time.Second
+	jwksRefetchAfter = time.Minute // at most one fetch per minute for an unknown kid
+)
+
+// JWKS resolves the RSA keys of a JSON Web Key Set by kid. The set is fetched from a URL, or read from a file
+// for offline tests, and refreshed every refreshInterval. While a refresh fails the cached keys are still used,
+// up to maxAge after the last successful fetch. A kid missing from the set (a key rotated in) fetches it again.
+type JWKS struct {
+	url             string
+	file            string
+	refreshInterval time.Duration
+	maxAge          time.Duration
+	keys            map[string]*rsa.PublicKey // by kid
+	fetchedAt       time.Time                 // last success
+	triedAt         time.Time                 // last attempt
+	mu              sync.RWMutex
+	fetchMu         sync.Mutex
+}
+
+// NewJWKS returns the set at url, or in file if it is set
+func NewJWKS(url string, file string, refreshInterval time.Duration, maxAge time.Duration) *JWKS {
+	return &JWKS{url: url, file: file, refreshInterval: refreshInterval, maxAge: maxAge, keys: make(map[string]*rsa.PublicKey)}
+}
+
+// Start fetches the set, then refreshes it every refreshInterval. The keys are fetched again on
+// the first lookup if it fails, so the server can start while the set is unreachable.
+func (j *JWKS) Start(ctx context.Context) error {
+	err := j.refresh(ctx)
+	go func() {
+		ticker := time.NewTicker(j.refreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := j.refresh(ctx); err != nil {
+					log.Printf("Error refreshing JWKS %s, keeping the cached keys: %v", j.source(), err)
+				}
+			}
+		}
+	}()
+	return err
+}
+
+// Key -> the key kid, unless the cached set is older than maxAge
+func (j *JWKS) Key(kid string) (*rsa.PublicKey, error) {
+	key, fetchedAt := j.lookup(kid)
+	if key == nil || time.Since(fetchedAt) > j.maxAge {
+		if j.mayRefetch() {
+			if err := j.refresh(context.Background()); err != nil {
+				log.Printf("Error fetching JWKS %s for kid %s: %v", j.source(), kid, err)
+			}
+			key, fetchedAt = j.lookup(kid)
+		}
+	}
+	if fetchedAt.IsZero() || time.Since(fetchedAt) > j.maxAge {
+		return nil, fmt.Errorf("JWKS %s not fetched since %s", j.source(), fetchedAt.Format(time.RFC3339))
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown kid: %s", kid)
+	}
+	return key, nil
+}
+
+func (j *JWKS) lookup(kid string) (*rsa.PublicKey, time.Time) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.keys[kid], j.fetchedAt
+}
+
+// mayRefetch tells whether a lookup may fetch the set, so unknown kids can't flood its server
+func (j *JWKS) mayRefetch() bool {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return time.Since(j.triedAt) >= jwksRefetchAfter
+}
+
+// refresh replaces the cached keys by the fetched ones; on failure they are kept
+func (j *JWKS) refresh(ctx context.Context) error {
+	j.fetchMu.Lock()
+	defer j.fetchMu.Unlock()
+	j.mu.Lock()
+	j.triedAt = time.Now()
+	j.mu.Unlock()
+
+	keys, err := j.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	j.keys = keys
+	j.fetchedAt = time.Now()
+	j.mu.Unlock()
+	return nil
+}
+
+func (j *JWKS) source() string {
+	if j.file != "" {
+		return j.file
+	}
+	return j.url
+}
+
+func (j *JWKS) fetch(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	var data []byte
+	var err error
+	if j.file != "" {
+		data, err = os.ReadFile(j.file)
+	} else {
+		data, err = j.download(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseJWKS(data)
+}
+
+func (j *JWKS) download(ctx context.Context) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, jwksFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := utils.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// jwk is a JSON Web Key (RFC 7517); only the RSA signing keys are used
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func parseJWKS(data []byte) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("could not parse JWKS: %v", err)
+	}
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") || k.Kid == "" {
+			continue
+		}
+		key, err := k.rsaKey()
+		if err != nil {
+			return nil, fmt.Errorf("could not parse key %s: %v", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no RSA signing key in JWKS")
+	}
+	return keys, nil
+}
+
+func (k jwk) rsaKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid modulus or exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
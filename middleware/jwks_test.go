@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testRSAKey(t *testing.T) *rsa.PublicKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &key.PublicKey
+}
+
+func testJWK(kid string, key *rsa.PublicKey) jwk {
+	return jwk{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func testJWKSData(t *testing.T, keys ...jwk) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string][]jwk{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParseJWKS(t *testing.T) {
+	key := testRSAKey(t)
+	valid := testJWK("k1", key)
+	with := func(change func(k *jwk)) jwk {
+		k := valid
+		change(&k)
+		return k
+	}
+	tests := []struct {
+		name    string
+		data    []byte
+		kids    []string
+		wantErr string
+	}{
+		{"valid", testJWKSData(t, valid), []string{"k1"}, ""},
+		{"no use", testJWKSData(t, with(func(k *jwk) { k.Use = "" })), []string{"k1"}, ""},
+		{"other keys skipped", testJWKSData(t, valid,
+			with(func(k *jwk) { k.Kid, k.Kty = "ec", "EC" }),
+			with(func(k *jwk) { k.Kid, k.Use = "enc", "enc" }),
+			with(func(k *jwk) { k.Kid = "" }),
+		), []string{"k1"}, ""},
+		{"not JSON", []byte("<html>"), nil, "could not parse JWKS"},
+		{"empty", testJWKSData(t), nil, "no RSA signing key"},
+		{"only encryption keys", testJWKSData(t, with(func(k *jwk) { k.Use = "enc" })), nil, "no RSA signing key"},
+		{"bad modulus", testJWKSData(t, with(func(k *jwk) { k.N = "not base64!" })), nil, "could not parse key k1"},
+		{"empty modulus", testJWKSData(t, with(func(k *jwk) { k.N = "" })), nil, "invalid modulus or exponent"},
+		{"exponent too small", testJWKSData(t, with(func(k *jwk) { k.E = "AQ" })), nil, "invalid modulus or exponent"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := parseJWKS(tt.data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != len(tt.kids) {
+				t.Fatalf("got %d keys, want %v", len(keys), tt.kids)
+			}
+			for _, kid := range tt.kids {
+				if !keys[kid].Equal(key) {
+					t.Errorf("key %s differs", kid)
+				}
+			}
+		})
+	}
+}
+
+func TestJWKSKey(t *testing.T) {
+	key, rotated := testRSAKey(t), testRSAKey(t)
+	const maxAge = time.Hour
+	tests := []struct {
+		name    string
+		kid     string
+		file    []jwk         // served once the cache is filled; nil -> unreachable
+		age     time.Duration // of the cache
+		triedAt time.Duration // ago
+		want    *rsa.PublicKey
+		wantErr string
+	}{
+		{"cached", "k1", nil, time.Minute, time.Minute, key, ""},
+		{"unknown kid", "k2", []jwk{testJWK("k1", key)}, time.Minute, 2 * time.Minute, nil, "unknown kid: k2"},
+		{"rotated in", "k2", []jwk{testJWK("k2", rotated)}, time.Minute, 2 * time.Minute, rotated, ""},
+		{"unknown kid refetched lately", "k2", []jwk{testJWK("k2", rotated)}, time.Minute, time.Second, nil, "unknown kid: k2"},
+		{"stale, refetched", "k1", []jwk{testJWK("k1", key)}, 2 * maxAge, 2 * time.Minute, key, ""},
+		{"stale, unreachable", "k1", nil, 2 * maxAge, 2 * time.Minute, nil, "not fetched since"},
+		{"stale, refetched lately", "k1", []jwk{testJWK("k1", key)}, 2 * maxAge, time.Second, nil, "not fetched since"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "jwks.json")
+			if err := os.WriteFile(file, testJWKSData(t, testJWK("k1", key)), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			j := NewJWKS("", file, time.Hour, maxAge)
+			if err := j.refresh(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if tt.file == nil {
+				os.Remove(file)
+			} else if err := os.WriteFile(file, testJWKSData(t, tt.file...), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			j.fetchedAt = time.Now().Add(-tt.age)
+			j.triedAt = time.Now().Add(-tt.triedAt)
+
+			got, err := j.Key(tt.kid)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tt.want) {
+				t.Error("got another key")
+			}
+		})
+	}
+}
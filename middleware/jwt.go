@@ -16,9 +16,9 @@ var (
 )
 
 // Initialize RSA keys (should be called during app initialization)
-func InitKeys() error {
+func InitKeys(privateKeyFile string, publicKeyFile string) error {
 	// Load the private key
-	privateKeyBytes, err := os.ReadFile("private_key.pem")
+	privateKeyBytes, err := os.ReadFile(privateKeyFile)
 	if err != nil {
 		return fmt.Errorf("could not read private key file: %v", err)
 	}
@@ -29,7 +29,7 @@ func InitKeys() error {
 	}
 
 	// Load the public key
-	publicKeyBytes, err := os.ReadFile("public_key.pem")
+	publicKeyBytes, err := os.ReadFile(publicKeyFile)
 	if err != nil {
 		return fmt.Errorf("could not read public key file: %v", err)
 	}
@@ -59,12 +59,17 @@ func GenerateJWT(userID string, tokenType string, expireMin int, scope string) (
 	}
 
 	// Create the token claims
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+	return signJWT(jwt.MapClaims{
 		"sub":   userID,
 		"exp":   time.Now().Add(time.Minute * time.Duration(expireMin)).Unix(),
 		"type":  tokenType,
 		"scope": scope,
 	})
+}
+
+// signJWT signs the claims with the RSA private key (RS256)
+func signJWT(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
 
 	// Sign the token using the RSA private key
 	tokenString, err := token.SignedString(privateKey)
@@ -1,14 +1,17 @@
 package middleware
 
 import (
+	"avazon-api/controllers/errs"
+	"avazon-api/models"
+	"errors"
 	"log"
 	"net/http"
-	"os"
 	"slices"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
 )
 
 // JWT Middleware
@@ -25,7 +28,7 @@ func JWTAuthMiddleware(userRoles ...string) gin.HandlerFunc {
 		// Remove the "Bearer " prefix and extract the token
 		tokenString = strings.TrimPrefix(tokenString, "Bearer ")
 
-		token, err := ValidateDynamicWalletJWT(tokenString)
+		token, err := ValidateAccessToken(tokenString)
 		if err != nil || !token.Valid {
 			log.Println(err)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
@@ -54,6 +57,9 @@ func JWTAuthMiddleware(userRoles ...string) gin.HandlerFunc {
 
 		// Store user_id in context
 		c.Set("user_id", userId)
+		if sessionID := SessionIDOf(token); sessionID != "" {
+			c.Set("session_id", sessionID)
+		}
 		// If the token is valid, proceed to the next handler.
 		c.Next()
 	}
@@ -73,21 +79,26 @@ func CORSMiddleware() gin.HandlerFunc {
 	}
 }
 
-func AdminAuthMiddleware() gin.HandlerFunc {
+// RoleAuthMiddleware lets through the users whose role, read by roleOf from User.Role, is one of roles.
+// Use it after JWTAuthMiddleware.
+func RoleAuthMiddleware(roleOf func(userID string) (models.UserRole, error), roles ...models.UserRole) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		keyString := c.GetHeader("Authorization")
-		if keyString == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
-			c.Abort()
+		userID := c.GetString("user_id")
+		if userID == "" {
+			errs.SendErrorResponse(c, errs.ErrUnauthorized)
 			return
 		}
-
-		if keyString != os.Getenv("ADMIN_KEY") {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid key"})
-			c.Abort()
+		role, err := roleOf(userID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Error reading role of user %s: %v", userID, err)
+			errs.SendErrorResponse(c, errs.ErrInternalServerError)
+			return
+		}
+		if !slices.Contains(roles, role) {
+			log.Printf("User %s does not have the required role: %q not in %v", userID, role, roles)
+			errs.SendErrorResponse(c, errs.ErrForbidden)
 			return
 		}
-
 		c.Next()
 	}
 }
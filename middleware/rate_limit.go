@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"avazon-api/controllers/errs"
+	"context"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit is a token bucket of Requests tokens, refilled evenly over Period
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// per second
+func (l RateLimit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// RateLimitStore keeps the token buckets.
+//   - MemoryRateLimitStore: process memory (single server)
+//   - RedisRateLimitStore: redis, shared by every replica
+type RateLimitStore interface {
+	// Take takes a token from the bucket of key. If it is empty, returns false and how long until the next token.
+	Take(ctx context.Context, key string, limit RateLimit) (bool, time.Duration, error)
+}
+
+// RateLimiter limits the requests of every route group, by user (routes behind JWTAuthMiddleware) or by IP
+type RateLimiter struct {
+	store  RateLimitStore
+	limits map[string]RateLimit // by group
+}
+
+func NewRateLimiter(store RateLimitStore, limits map[string]RateLimit) *RateLimiter {
+	return &RateLimiter{store: store, limits: limits}
+}
+
+// Middleware limits the requests of group; a group without limit passes everything.
+// Use it after JWTAuthMiddleware to limit by user.
+func (l *RateLimiter) Middleware(group string) gin.HandlerFunc {
+	limit, ok := l.limits[group]
+	if !ok {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return func(c *gin.Context) {
+		key := group + ":ip:" + c.ClientIP()
+		if userID := c.GetString("user_id"); userID != "" {
+			key = group + ":user:" + userID
+		}
+		allowed, retryAfter, err := l.store.Take(c.Request.Context(), key, limit)
+		if err != nil {
+			// rather serve than lock everybody out while the store is down
+			log.Printf("Error rate limiting %s: %v", key, err)
+			c.Next()
+			return
+		}
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			errs.SendErrorResponse(c, errs.ErrTooManyRequests)
+			return
+		}
+		c.Next()
+	}
+}
+
+// TokenBucket is a single bucket, for limits kept by their owner (e.g. the messages of one websocket).
+// It isn't safe for concurrent use.
+type TokenBucket struct {
+	limit     RateLimit
+	tokens    float64
+	updatedAt time.Time
+}
+
+// NewTokenBucket returns a full bucket
+func NewTokenBucket(limit RateLimit) *TokenBucket {
+	return &TokenBucket{limit: limit, tokens: float64(limit.Requests), updatedAt: time.Now()}
+}
+
+// Take takes a token. If the bucket is empty, returns false and how long until the next token.
+func (b *TokenBucket) Take() (bool, time.Duration) {
+	now := time.Now()
+	rate := b.limit.rate()
+	b.tokens = min(float64(b.limit.Requests), b.tokens+now.Sub(b.updatedAt).Seconds()*rate)
+	b.updatedAt = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// when it is full again
+func (b *TokenBucket) fullAt() time.Time {
+	missing := float64(b.limit.Requests) - b.tokens
+	return b.updatedAt.Add(time.Duration(missing / b.limit.rate() * float64(time.Second)))
+}
+
+// MemoryRateLimitStore keeps the buckets in a map. A sweeper removes the full ones every sweepInterval.
+type MemoryRateLimitStore struct {
+	buckets map[string]*TokenBucket
+	mu      sync.Mutex
+}
+
+func NewMemoryRateLimitStore(sweepInterval time.Duration) *MemoryRateLimitStore {
+	s := &MemoryRateLimitStore{buckets: make(map[string]*TokenBucket)}
+	go s.sweep(sweepInterval)
+	return s
+}
+
+func (s *MemoryRateLimitStore) sweep(interval time.Duration) {
+	for range time.Tick(interval) {
+		now := time.Now()
+		s.mu.Lock()
+		for key, bucket := range s.buckets {
+			if now.After(bucket.fullAt()) {
+				delete(s.buckets, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bucket, ok := s.buckets[key]
+	if !ok || bucket.limit != limit {
+		bucket = NewTokenBucket(limit)
+		s.buckets[key] = bucket
+	}
+	allowed, retryAfter := bucket.Take()
+	return allowed, retryAfter, nil
+}
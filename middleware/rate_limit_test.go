@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketTake(t *testing.T) {
+	limit := RateLimit{Requests: 2, Period: 2 * time.Second} // a token per second
+	tests := []struct {
+		name       string
+		taken      int           // tokens taken before
+		elapsed    time.Duration // since then
+		want       bool
+		retryAfter time.Duration
+	}{
+		{"full", 0, 0, true, 0},
+		{"last token", 1, 0, true, 0},
+		{"empty", 2, 0, false, time.Second},
+		{"partly refilled", 2, 250 * time.Millisecond, false, 750 * time.Millisecond},
+		{"refilled", 2, time.Second, true, 0},
+		{"capped at the limit", 0, time.Hour, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewTokenBucket(limit)
+			for i := 0; i < tt.taken; i++ {
+				if ok, _ := b.Take(); !ok {
+					t.Fatalf("take %d refused", i)
+				}
+			}
+			b.updatedAt = b.updatedAt.Add(-tt.elapsed)
+			ok, retryAfter := b.Take()
+			if ok != tt.want {
+				t.Fatalf("Take() = %v, want %v", ok, tt.want)
+			}
+			// a few ms pass between the takes
+			if diff := tt.retryAfter - retryAfter; diff < 0 || diff > 50*time.Millisecond {
+				t.Errorf("retry after %s, want %s", retryAfter, tt.retryAfter)
+			}
+			if b.tokens > float64(limit.Requests) {
+				t.Errorf("%v tokens, more than the limit", b.tokens)
+			}
+		})
+	}
+}
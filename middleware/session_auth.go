@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"avazon-api/controllers/errs"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SessionIssuer is the iss claim of the first-party tokens, issued by POST /users/session
+const SessionIssuer = "avazon-api"
+
+// token types of the first-party tokens
+const (
+	TokenType_Access  = "access"
+	TokenType_Refresh = "refresh"
+)
+
+var (
+	isSessionRevoked = func(sessionID string) bool { return false }
+	acceptDynamicJWT = true
+)
+
+// InitSessionAuth sets how the access tokens are checked (should be called during app initialization):
+//   - isRevoked tells the revoked sessions, whose access tokens are refused
+//   - acceptDynamic lets Dynamic wallet JWTs through as well, until every client exchanges them at POST /users/session
+func InitSessionAuth(isRevoked func(sessionID string) bool, acceptDynamic bool) {
+	isSessionRevoked = isRevoked
+	acceptDynamicJWT = acceptDynamic
+}
+
+// GenerateSessionJWT signs a first-party token of a session with the RSA key of GenerateJWT.
+// tokenID is its jti: the refresh token id for refresh tokens, unique for access tokens.
+func GenerateSessionJWT(userID string, tokenType string, ttl time.Duration, scope string, sessionID string, tokenID string) (string, error) {
+	if privateKey == nil {
+		return "", fmt.Errorf("private key is not initialized")
+	}
+	now := time.Now()
+	return signJWT(jwt.MapClaims{
+		"iss":   SessionIssuer,
+		"sub":   userID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(ttl).Unix(),
+		"type":  tokenType,
+		"scope": scope,
+		"sid":   sessionID,
+		"jti":   tokenID,
+	})
+}
+
+// ValidateSessionJWT validates a first-party token of the given type
+func ValidateSessionJWT(tokenString string, tokenType string) (*jwt.Token, error) {
+	if publicKey == nil {
+		return nil, fmt.Errorf("public key is not initialized")
+	}
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return publicKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}), jwt.WithIssuer(SessionIssuer), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	if claims["type"] != tokenType {
+		return nil, fmt.Errorf("invalid token: %v token, expected %s", claims["type"], tokenType)
+	}
+	if sessionID, _ := claims["sid"].(string); sessionID == "" {
+		return nil, fmt.Errorf("invalid token: session not found")
+	}
+	return token, nil
+}
+
+// ValidateAccessToken validates the access token of a request or websocket:
+// a first-party one whose session isn't revoked, or a Dynamic wallet JWT while they are accepted
+func ValidateAccessToken(tokenString string) (*jwt.Token, error) {
+	if !isSessionJWT(tokenString) {
+		if !acceptDynamicJWT {
+			return nil, fmt.Errorf("invalid token: not issued by %s", SessionIssuer)
+		}
+		return ValidateDynamicWalletJWT(tokenString)
+	}
+	token, err := ValidateSessionJWT(tokenString, TokenType_Access)
+	if err != nil {
+		return nil, err
+	}
+	if isSessionRevoked(SessionIDOf(token)) {
+		return nil, errs.ErrSessionRevoked
+	}
+	return token, nil
+}
+
+// isSessionJWT tells the first-party tokens apart by their issuer, before checking anything
+func isSessionJWT(tokenString string) bool {
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, claims); err != nil {
+		return false
+	}
+	issuer, _ := claims["iss"].(string)
+	return issuer == SessionIssuer
+}
+
+// SessionIDOf -> the session of a first-party token, "" for a Dynamic wallet JWT
+func SessionIDOf(token *jwt.Token) string {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+	sessionID, _ := claims["sid"].(string)
+	return sessionID
+}
+
+// TokenIDOf -> the jti of a first-party token
+func TokenIDOf(token *jwt.Token) string {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+	tokenID, _ := claims["jti"].(string)
+	return tokenID
+}
+
+// TokenError maps a token validation error to the error sent to the client
+func TokenError(err error) error {
+	if _, ok := err.(errs.AppError); ok {
+		return err
+	}
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return errs.ErrJWTExpired
+	default:
+		return errs.ErrInvalidJWT
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"avazon-api/config"
+	"avazon-api/database"
+	"avazon-api/models"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+const migrationsDir = "database/migrations"
+
+const migrateUsage = `usage: avazon-api [flags] migrate <command>
+
+commands:
+  up [N]              apply all (or the next N) pending migrations
+  down [N]            revert the last (or the last N) applied migrations
+  status              list migrations and whether they are applied
+  force VERSION       mark migrations up to VERSION as applied without running them
+                      (use "force 1" once on a database created by the old AutoMigrate-at-boot)
+  new NAME            create empty up/down files for the next version, for every driver
+  generate-baseline   regenerate 0001_baseline from the models package (no database needed)`
+
+// checkMigrations runs at boot. Pending migrations are applied when DB_AUTO_MIGRATE=true,
+// otherwise the server refuses to start against an outdated schema.
+func checkMigrations(DB *gorm.DB, autoMigrate bool) {
+	migrator, err := database.NewMigrator(DB)
+	if err != nil {
+		log.Fatal("Failed to load migrations:", err)
+	}
+	if autoMigrate {
+		applied, err := migrator.Up(0)
+		for _, m := range applied {
+			log.Printf("Applied migration %04d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal("Failed to migrate database:", err)
+		}
+		return
+	}
+	if err := migrator.CheckUpToDate(); err != nil {
+		log.Fatalf("%v\nrun `avazon-api migrate up` (or set DB_AUTO_MIGRATE=true)", err)
+	}
+}
+
+func runMigrate(cfg config.DatabaseConfig, args []string) {
+	if len(args) == 0 {
+		fmt.Println(migrateUsage)
+		os.Exit(2)
+	}
+	command, args := args[0], args[1:]
+
+	// commands that don't touch the database
+	switch command {
+	case "new":
+		if len(args) != 1 {
+			log.Fatal("usage: migrate new NAME")
+		}
+		if err := newMigrationFiles(args[0]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "generate-baseline":
+		if err := generateBaseline(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	migrator, err := database.NewMigrator(initDB(cfg))
+	if err != nil {
+		log.Fatal("Failed to load migrations:", err)
+	}
+	switch command {
+	case "up":
+		applied, err := migrator.Up(countArg(args))
+		for _, m := range applied {
+			fmt.Printf("applied  %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+	case "down":
+		reverted, err := migrator.Down(countArg(args))
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied at " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-40s %s\n", s.Version, s.Name, state)
+		}
+	case "force":
+		if len(args) != 1 {
+			log.Fatal("usage: migrate force VERSION")
+		}
+		version, err := strconv.Atoi(args[0])
+		if err != nil {
+			log.Fatal("invalid version: ", args[0])
+		}
+		if err := migrator.Force(version); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("schema version forced to %04d\n", version)
+	default:
+		fmt.Println(migrateUsage)
+		os.Exit(2)
+	}
+}
+
+func countArg(args []string) int {
+	if len(args) == 0 {
+		return 0
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 {
+		log.Fatal("invalid count: ", args[0])
+	}
+	return n
+}
+
+var migrationName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+func newMigrationFiles(name string) error {
+	if !migrationName.MatchString(name) {
+		return fmt.Errorf("migration name must be snake_case: %q", name)
+	}
+	next := 1
+	for _, driver := range []database.Driver{database.DriverSQLite, database.DriverPostgres} {
+		migrations, err := database.LoadMigrations(driver)
+		if err != nil {
+			return err
+		}
+		if len(migrations) > 0 {
+			next = max(next, migrations[len(migrations)-1].Version+1)
+		}
+	}
+	for _, driver := range []database.Driver{database.DriverSQLite, database.DriverPostgres} {
+		for _, direction := range []string{"up", "down"} {
+			path := filepath.Join(migrationsDir, string(driver), fmt.Sprintf("%04d_%s.%s.sql", next, name, direction))
+			if err := os.WriteFile(path, []byte("-- "+string(driver)+"\n"), 0644); err != nil {
+				return err
+			}
+			fmt.Println("created", path)
+		}
+	}
+	return nil
+}
+
+func generateBaseline() error {
+	for _, driver := range []database.Driver{database.DriverSQLite, database.DriverPostgres} {
+		up, down, err := database.GenerateBaseline(driver, models.All())
+		if err != nil {
+			return err
+		}
+		dir := filepath.Join(migrationsDir, string(driver))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		for file, content := range map[string]string{"0001_baseline.up.sql": up, "0001_baseline.down.sql": down} {
+			path := filepath.Join(dir, file)
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				return err
+			}
+			fmt.Println("wrote", path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// AdminAuditLog is one change made by an admin (or from the command line). Written by services.AdminService.
+type AdminAuditLog struct {
+	ID         uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	ActorID    string    `json:"actor_id" gorm:"type:varchar(255);not null;index"` // user id of the admin, "cli" for the admin command
+	Action     string    `json:"action" gorm:"type:varchar(50);not null"`          // e.g. user.promote, credits.grant, system_prompt.upsert
+	TargetType string    `json:"target_type" gorm:"type:varchar(50);not null"`     // user, system_prompt, system_prompt_usage
+	TargetID   string    `json:"target_id" gorm:"type:varchar(255);not null;index"`
+	Detail     *string   `json:"detail" gorm:"type:text"` // JSON, e.g. the role before and after
+	CreatedAt  time.Time `json:"created_at" gorm:"autoCreateTime;index"`
+}
@@ -3,7 +3,7 @@ package models
 import "time"
 
 type Avatar struct {
-	ID               string         `json:"id" gorm:"primary_key;type:varchar(255 )"`
+	ID               string         `json:"id" gorm:"primary_key;type:varchar(255)"`
 	UserID           string         `json:"user_id" gorm:"type:varchar(255)"`
 	User             User           `json:"user" gorm:"foreignKey:UserID"`
 	AvatarCreationID string         `json:"-" gorm:"type:varchar(255)"`
@@ -7,6 +7,7 @@ type AvatarContentCreationStatus string
 // yet -> image_progressing -> image_completed
 // -> content_progressing -> content_completed -> confirmed
 // if error occurs, status -> failed
+// a creation may be cancelled until its content is completed
 const (
 	ACC_Yet                AvatarContentCreationStatus = "yet"
 	ACC_ImageProgressing   AvatarContentCreationStatus = "image_progressing"
@@ -15,6 +16,7 @@ const (
 	ACC_ContentCompleted   AvatarContentCreationStatus = "content_completed"
 	ACC_Confirmed          AvatarContentCreationStatus = "confirmed"
 	ACC_Failed             AvatarContentCreationStatus = "failed"
+	ACC_Cancelled          AvatarContentCreationStatus = "cancelled"
 )
 
 type AvatarMusicContentCreation struct {
@@ -30,6 +32,8 @@ type AvatarMusicContentCreation struct {
 	GeneratedMusicPrompt *string                     `json:"generated_music_prompt" gorm:"type:text"`
 	AlbumImageURL        *string                     `json:"album_image_url" gorm:"type:varchar(255)"`
 	MusicURL             *string                     `json:"music_url" gorm:"type:varchar(255)"`
+	AlbumImageProvider   *string                     `json:"album_image_provider" gorm:"type:varchar(50)"` // painter of the album image
+	MusicProvider        *string                     `json:"music_provider" gorm:"type:varchar(50)"`       // producer of the music
 	Status               AvatarContentCreationStatus `json:"status" gorm:"varchar(20);not null"`
 	FailedReason         *string                     `json:"failed_reason" gorm:"type:varchar(255)"`
 	CreatedAt            time.Time                   `json:"created_at" gorm:"autoCreateTime"`
@@ -43,8 +47,9 @@ type AvatarVideoContentCreation struct {
 	AvatarID string `json:"avatar_id" gorm:"varchar(36);not null"`
 	Avatar   Avatar `json:"avatar" gorm:"foreignKey:AvatarID;"`
 	// step 1: image prompt
-	ImagePrompt       string  `json:"image_prompt" gorm:"varchar(255);not null"`
-	ThumbnailImageURL *string `json:"thumbnail_image_url" gorm:"varchar(255);"`
+	ImagePrompt            string  `json:"image_prompt" gorm:"varchar(255);not null"`
+	ThumbnailImageURL      *string `json:"thumbnail_image_url" gorm:"varchar(255);"`
+	ThumbnailImageProvider *string `json:"thumbnail_image_provider" gorm:"type:varchar(50)"` // painter of the thumbnail
 	// step 2: content prompt
 	VideoPrompt     string                      `json:"video_prompt" gorm:"varchar(255);not null"`
 	VideoContentURL *string                     `json:"video_content_url" gorm:"varchar(255);"`
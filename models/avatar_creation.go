@@ -12,6 +12,7 @@ const (
 	AC_Processing AvatarCreationStatus = "processing"
 	AC_Completed  AvatarCreationStatus = "completed"
 	AC_Failed     AvatarCreationStatus = "failed"
+	AC_Cancelled  AvatarCreationStatus = "cancelled"
 )
 
 type AvatarCreation struct {
@@ -40,11 +41,11 @@ type AvatarCreationChat struct {
 	Role                string         `json:"role" gorm:"type:varchar(20);not null"`        // user, assistant, tool
 	ObjectType          string         `json:"object_type" gorm:"type:varchar(20);not null"` // image, character, voice
 	CreatedAt           time.Time      `json:"created_at"`
-	Content             string         `json:"content" gorm:"type:varchar(3000)"`
+	Content             string         `json:"content" gorm:"type:text"`
 	CreatedObjectNumber int            `json:"created_object_number" gorm:"default:0"` // current number of objects created
 	ToolCallId          string         `json:"tool_call_id" gorm:"type:varchar(255)"`
 	ToolCallName        string         `json:"tool_call_name" gorm:"type:varchar(100)"`
-	ToolCallArguments   string         `json:"tool_call_arguments" gorm:"type:varchar(1000)"`
+	ToolCallArguments   string         `json:"tool_call_arguments" gorm:"type:text"`
 }
 
 type AvatarImageCreation struct {
@@ -55,6 +56,7 @@ type AvatarImageCreation struct {
 	AvatarCreation   AvatarCreation       `json:"-" gorm:"foreignKey:AvatarCreationID;constraint:OnDelete:CASCADE"`
 	Prompt           string               `json:"prompt" gorm:"type:varchar(3000)"`
 	ImageURL         string               `json:"image_url" gorm:"not null"`
+	Provider         string               `json:"provider" gorm:"type:varchar(50)"` // painter of the image
 	Status           AvatarCreationStatus `json:"status"`
 	FailedReason     string               `json:"failed_reason"` // reason for failure
 	CreatedAt        time.Time            `json:"created_at"`
@@ -66,8 +68,8 @@ type AvatarCharacterCreation struct {
 	User             User                 `json:"-" gorm:"foreignKey:UserID"`
 	AvatarCreationID string               `json:"avatar_creation_id" gorm:"not null;foreignKey:AvatarCreationID;constraint:OnDelete:CASCADE"`
 	AvatarCreation   AvatarCreation       `json:"-" gorm:"foreignKey:AvatarCreationID;constraint:OnDelete:CASCADE"`
-	Prompt           string               `json:"prompt" gorm:"type:text"`           // input prompt
-	Content          string               `json:"content" gorm:"type:varchar(3000)"` // result here
+	Prompt           string               `json:"prompt" gorm:"type:text"`  // input prompt
+	Content          string               `json:"content" gorm:"type:text"` // result here
 	Status           AvatarCreationStatus `json:"status"`
 	FailedReason     string               `json:"failed_reason"` // reason for failure
 	CreatedAt        time.Time            `json:"created_at"`
@@ -81,6 +83,7 @@ type AvatarVoiceCreation struct {
 	AvatarCreation   AvatarCreation       `json:"-" gorm:"foreignKey:AvatarCreationID;constraint:OnDelete:CASCADE"`
 	Prompt           string               `json:"prompt" gorm:"type:varchar(3000)"`
 	VoiceURL         string               `json:"voice_url" gorm:"not null"`
+	Provider         string               `json:"provider" gorm:"type:varchar(50)"` // voice actor of the voice
 	Status           AvatarCreationStatus `json:"status"`
 	FailedReason     string               `json:"failed_reason"` // reason for failure
 	CreatedAt        time.Time            `json:"created_at"`
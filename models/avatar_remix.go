@@ -8,6 +8,7 @@ const (
 	AR_Completed   AvatarRemixStatus = "completed"
 	AR_Confirmed   AvatarRemixStatus = "confirmed"
 	AR_Failed      AvatarRemixStatus = "failed"
+	AR_Cancelled   AvatarRemixStatus = "cancelled"
 )
 
 type AvatarImageRemix struct {
@@ -20,4 +21,5 @@ type AvatarImageRemix struct {
 	Status       AvatarRemixStatus `json:"status" gorm:"type:varchar(20);not null"`
 	FailedReason *string           `json:"failed_reason" gorm:"type:varchar(255);"`
 	ImageURL     *string           `json:"image_url" gorm:"type:varchar(255);"`
+	Provider     *string           `json:"provider" gorm:"type:varchar(50)"` // painter of the image
 }
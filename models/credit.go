@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+type CreditTransactionKind string
+
+const (
+	Credit_Grant   CreditTransactionKind = "grant"   // by an admin, or at signup
+	Credit_Reserve CreditTransactionKind = "reserve" // taken when a job is enqueued
+	Credit_Refund  CreditTransactionKind = "refund"  // given back when the job failed or was cancelled
+)
+
+// CreditTransaction is one change of the credit balance of a user. Written by services.CreditService.
+type CreditTransaction struct {
+	ID         uint                  `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserID     string                `json:"user_id" gorm:"type:varchar(255);not null;index"`
+	Kind       CreditTransactionKind `json:"kind" gorm:"type:varchar(20);not null"`
+	Amount     int64                 `json:"amount" gorm:"not null"`             // negative for reservations
+	Balance    int64                 `json:"balance" gorm:"not null"`            // after the transaction
+	JobID      *uint                 `json:"job_id" gorm:"index"`                // reserve, refund: the job paid for
+	Ref        *string               `json:"ref" gorm:"type:varchar(100);index"` // reserve, refund: the work paid for when it isn't a job, e.g. avatar_image:12 (a chat session image)
+	Operation  *string               `json:"operation" gorm:"type:varchar(100)"` // reserve, refund: the job types paid for, e.g. music_image,music
+	Note       *string               `json:"note" gorm:"type:varchar(255)"`
+	RefundedAt *time.Time            `json:"refunded_at"` // reserve: once refunded
+	CreatedAt  time.Time             `json:"created_at" gorm:"autoCreateTime"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+type JobStatus string
+
+// queued -> running -> succeeded
+// a failed attempt goes back to queued (with backoff) until MaxAttempts, then failed
+// queued or running jobs may be cancelled
+const (
+	Job_Queued    JobStatus = "queued"
+	Job_Running   JobStatus = "running"
+	Job_Succeeded JobStatus = "succeeded"
+	Job_Failed    JobStatus = "failed"
+	Job_Cancelled JobStatus = "cancelled"
+)
+
+// Job is one unit of background work (image painting, music production, ...), run by services.JobQueue.
+type Job struct {
+	ID          uint       `json:"id" gorm:"primaryKey;autoIncrement"`
+	Type        string     `json:"type" gorm:"type:varchar(50);not null;index:idx_jobs_type_status"`
+	RefID       string     `json:"ref_id" gorm:"type:varchar(255);not null;index"` // id of the row the job works on
+	Payload     string     `json:"payload" gorm:"type:text;not null"`              // JSON
+	Status      JobStatus  `json:"status" gorm:"type:varchar(20);not null;index:idx_jobs_type_status"`
+	Attempts    int        `json:"attempts" gorm:"not null;default:0"`
+	MaxAttempts int        `json:"max_attempts" gorm:"not null"`
+	RunAt       time.Time  `json:"run_at" gorm:"not null"` // not picked up before this time
+	HeartbeatAt *time.Time `json:"heartbeat_at"`           // refreshed while running
+	LastError   *string    `json:"last_error" gorm:"type:text"`
+	FinishedAt  *time.Time `json:"finished_at"`
+	CreatedAt   time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt   time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
+}
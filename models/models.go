@@ -0,0 +1,23 @@
+package models
+
+// All returns the models of the baseline migration (0001), in the order their tables are created.
+// The baseline migration is generated from this list (see `go run . migrate generate-baseline`).
+func All() []interface{} {
+	return []interface{}{
+		&SystemPrompt{},
+		&SystemPromptUsage{},
+		&User{},
+		&AvatarCreation{},
+		&AvatarCreationChat{},
+		&AvatarCharacterCreation{},
+		&AvatarVoiceCreation{},
+		&AvatarImageCreation{},
+		&Avatar{},
+		&AvatarMusic{},
+		&AvatarVideo{},
+		&AvatarMusicContentCreation{},
+		&AvatarVideoContentCreation{},
+		&AvatarImageRemix{},
+		// the tables added since (jobs, usage_records, credit_transactions, ...) are created by their own migrations
+	}
+}
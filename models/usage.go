@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// UsageRecord is what one provider call consumed, and what it cost. Written by services.UsageLedger.
+type UsageRecord struct {
+	ID           uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserID       *string   `json:"user_id" gorm:"type:varchar(255);index"` // who the call was made for
+	AvatarID     *string   `json:"avatar_id" gorm:"type:varchar(255)"`
+	CreationType *string   `json:"creation_type" gorm:"type:varchar(50)"` // avatar_creation, music, video or remix
+	CreationID   *string   `json:"creation_id" gorm:"type:varchar(255)"`
+	Provider     string    `json:"provider" gorm:"type:varchar(100);not null;index"`
+	Operation    string    `json:"operation" gorm:"type:varchar(50);not null"` // chat, image, prompt, tts, voice_design, video, music
+	Model        *string   `json:"model" gorm:"type:varchar(100)"`
+	Unit         string    `json:"unit" gorm:"type:varchar(20);not null"` // tokens, images, requests, characters, seconds
+	Quantity     float64   `json:"quantity" gorm:"not null"`
+	InputTokens  int       `json:"input_tokens" gorm:"not null;default:0"`
+	OutputTokens int       `json:"output_tokens" gorm:"not null;default:0"`
+	Detail       *string   `json:"detail" gorm:"type:varchar(255)"` // e.g. the image size
+	Cost         float64   `json:"cost" gorm:"not null"`            // USD, at the prices of the time of the call
+	CreatedAt    time.Time `json:"created_at" gorm:"autoCreateTime;index"`
+}
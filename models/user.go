@@ -17,6 +17,7 @@ type User struct {
 	OAuth2Provider  string    `json:"oauth2_provider" gorm:"column:oauth2_provider;varchar(255)"` // google
 	OAuth2ID        string    `json:"-" gorm:"column:oauth2_id;type:varchar(255)"`
 	Role            UserRole  `json:"role" gorm:"not null;type:varchar(255)"`
+	Credits         int64     `json:"credits" gorm:"not null;default:0"` // see CreditTransaction
 	CreatedAt       time.Time `json:"created_at"`
 	EditedAt        time.Time `json:"-" gorm:"autoUpdateTime"`
 }
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// why a session was revoked
+const (
+	SessionRevoked_Logout = "logout"
+	SessionRevoked_Reuse  = "refresh_token_reuse" // a used refresh token came back
+	SessionRevoked_Admin  = "admin"
+)
+
+// UserSession is one sign-in of a user (POST /users/session), kept alive by rotating its refresh tokens.
+// Its access tokens carry its id (sid claim) and stop working once it is revoked.
+type UserSession struct {
+	ID            string     `json:"id" gorm:"primaryKey;type:varchar(255)"`
+	UserID        string     `json:"user_id" gorm:"type:varchar(255);not null;index"`
+	ExpiresAt     time.Time  `json:"expires_at" gorm:"not null"` // of its latest refresh token
+	RevokedAt     *time.Time `json:"revoked_at" gorm:"index"`
+	RevokedReason *string    `json:"revoked_reason" gorm:"type:varchar(50)"`
+	CreatedAt     time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt     time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// RefreshToken is one refresh token of a session (its jti). It can be used once: presenting it again revokes the session.
+type RefreshToken struct {
+	ID        string     `json:"id" gorm:"primaryKey;type:varchar(255)"`
+	SessionID string     `json:"session_id" gorm:"type:varchar(255);not null;index"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at"` // rotated
+	CreatedAt time.Time  `json:"created_at" gorm:"autoCreateTime"`
+}
@@ -2,10 +2,12 @@ package models
 
 import "time"
 
+const WebDataContentMaxBytes = 1024
+
 type WebDataSession struct {
 	SessionID  string    `json:"-"`       // uuid
 	UserID     string    `json:"-"`       // user's id
-	Content    string    `json:"content"` // it must be shorter than 1024 bytes
+	Content    string    `json:"content"` // at most WebDataContentMaxBytes
 	CreatedAt  time.Time `json:"created_at"`
 	LastUsedAt time.Time `json:"last_used_at"`
 }
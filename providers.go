@@ -0,0 +1,119 @@
+package main
+
+import (
+	"avazon-api/config"
+	"avazon-api/tools"
+	"cmp"
+	"fmt"
+	"log"
+	"os"
+)
+
+// Providers are the third-party tools the services are built with.
+type Providers struct {
+	NewAssistant  func(agent string) tools.Assistant // vendor and model configured for the agent (AGENT_ASSISTANTS)
+	AlbumPainter  tools.Painter                      // music album art
+	ImagePainter  tools.Painter                      // avatar images, video thumbnails and remixes
+	VoiceActor    tools.VoiceActor
+	VideoProducer tools.VideoProducer
+	MusicProducer tools.MusicProducer
+}
+
+// initProviders wires the real providers, or offline fakes when PROVIDERS=fake.
+// Painters, voice actors and music producers are fallback chains (IMAGE_PAINTERS, ...), so one provider going down doesn't stop the others.
+// Every real provider call is retried on 429/5xx and goes through the circuit breaker of its provider (PROVIDER_*).
+func initProviders(cfg *config.Config) (*Providers, error) {
+	if cfg.Providers.Mode == config.ProvidersFake {
+		log.Println("PROVIDERS=fake: using offline fake providers")
+		delay := cfg.Providers.FakeDelay
+		videoFixture, err := readFixture(cfg.Providers.FakeVideoFixture)
+		if err != nil {
+			return nil, err
+		}
+		musicFixture, err := readFixture(cfg.Providers.FakeMusicFixture)
+		if err != nil {
+			return nil, err
+		}
+		painter := tools.NewPainterChain(tools.Provider[tools.Painter]{Name: "fake", Tool: tools.NewFakePainter(delay)})
+		return &Providers{
+			NewAssistant:  func(string) tools.Assistant { return tools.NewFakeAssistant() },
+			AlbumPainter:  painter,
+			ImagePainter:  painter,
+			VoiceActor:    tools.NewVoiceActorChain(tools.Provider[tools.VoiceActor]{Name: "fake", Tool: tools.NewFakeVoiceActor(delay)}),
+			VideoProducer: tools.NewFakeVideoProducer(videoFixture, delay),
+			MusicProducer: tools.NewMusicProducerChain(tools.Provider[tools.MusicProducer]{Name: "fake", Tool: tools.NewFakeMusicProducer(musicFixture, delay)}),
+		}, nil
+	}
+
+	tools.ConfigureResilience(tools.ResilienceConfig{
+		MaxRetries:       cfg.Providers.MaxRetries,
+		RetryBackoff:     cfg.Providers.RetryBackoff,
+		MaxRetryWait:     cfg.Providers.MaxRetryWait,
+		BreakerThreshold: cfg.Providers.BreakerThreshold,
+		BreakerCooldown:  cfg.Providers.BreakerCooldown,
+	})
+	painters := map[string]tools.Painter{
+		"openart": tools.NewOpenArtPainter(cfg.Keys.OpenArt),
+		"openai":  tools.NewOpenAIPainter(cfg.Keys.OpenAI, cfg.OpenAI.BaseURL, cfg.OpenAI.Model),
+	}
+	imagePainters, err := chain("IMAGE_PAINTERS", cfg.Providers.ImagePainters, painters)
+	if err != nil {
+		return nil, err
+	}
+	albumPainters, err := chain("ALBUM_PAINTERS", cfg.Providers.AlbumPainters, painters)
+	if err != nil {
+		return nil, err
+	}
+	voiceActors, err := chain("VOICE_ACTORS", cfg.Providers.VoiceActors, map[string]tools.VoiceActor{
+		"elevenlabs": tools.NewElevenLabsVoiceActor(cfg.Keys.ElevenLabs),
+	})
+	if err != nil {
+		return nil, err
+	}
+	assistants, _ := cfg.Assistants.ByAgent() // checked by Validate
+	musicProducers, err := chain("MUSIC_PRODUCERS", cfg.Providers.MusicProducers, map[string]tools.MusicProducer{
+		"jenai": tools.NewJENAIProducer(cfg.Keys.JENAI),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Providers{
+		NewAssistant: func(agent string) tools.Assistant {
+			choice, ok := assistants[agent]
+			if !ok {
+				choice = config.AssistantChoice{Vendor: cfg.Assistants.Vendor}
+			}
+			if choice.Vendor == config.VendorAnthropic {
+				return tools.NewAnthropicAssistant(cfg.Anthropic.BaseURL, cfg.Keys.Anthropic, cmp.Or(choice.Model, cfg.Anthropic.Model), cfg.Anthropic.MaxTokens)
+			}
+			return tools.NewOpenAIAssistant(cfg.OpenAI.BaseURL, cfg.Keys.OpenAI, cmp.Or(choice.Model, cfg.OpenAI.Model))
+		},
+		AlbumPainter:  tools.NewPainterChain(albumPainters...),
+		ImagePainter:  tools.NewPainterChain(imagePainters...),
+		VoiceActor:    tools.NewVoiceActorChain(voiceActors...),
+		VideoProducer: tools.NewRunwayVideoProducer(cfg.Keys.Runway),
+		MusicProducer: tools.NewMusicProducerChain(musicProducers...),
+	}, nil
+}
+
+// chain picks the providers named in a setting, in order
+func chain[T any](setting string, names []string, available map[string]T) ([]tools.Provider[T], error) {
+	providers := make([]tools.Provider[T], 0, len(names))
+	for _, name := range names {
+		tool, ok := available[name]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown provider %q", setting, name)
+		}
+		providers = append(providers, tools.Provider[T]{Name: name, Tool: tool})
+	}
+	return providers, nil
+}
+
+// readFixture returns nil for an empty path, so the fake falls back to its built-in placeholder.
+func readFixture(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return os.ReadFile(path)
+}
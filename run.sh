@@ -1,6 +1,5 @@
 #!/bin/bash
 
 touch app.log
-touch test.db
 
 docker-compose up
\ No newline at end of file
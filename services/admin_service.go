@@ -0,0 +1,122 @@
+package services
+
+import (
+	"avazon-api/controllers/errs"
+	"avazon-api/models"
+	"encoding/json"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// ActorCLI is the actor of the changes made with the admin command
+const ActorCLI = "cli"
+
+// audited actions
+const (
+	AA_UserPromote             = "user.promote"
+	AA_UserDemote              = "user.demote"
+	AA_CreditsGrant            = "credits.grant"
+	AA_SystemPromptUpsert      = "system_prompt.upsert"
+	AA_SystemPromptDelete      = "system_prompt.delete"
+	AA_SystemPromptUsageSet    = "system_prompt_usage.set"
+	AA_SystemPromptUsageDelete = "system_prompt_usage.delete"
+	AA_SessionsRevoke          = "sessions.revoke"
+)
+
+// AdminService manages the roles of the users and the audit trail of the admin changes
+type AdminService struct {
+	DB *gorm.DB
+}
+
+func NewAdminService(db *gorm.DB) *AdminService {
+	return &AdminService{DB: db}
+}
+
+// RoleOf -> role of the user, gorm.ErrRecordNotFound if they never signed in
+func (s *AdminService) RoleOf(userID string) (models.UserRole, error) {
+	var user models.User
+	if err := s.DB.Select("role").Where("id = ?", userID).First(&user).Error; err != nil {
+		return "", err
+	}
+	return user.Role, nil
+}
+
+// SetRole changes the role of userID, recording it in the audit trail.
+// Admins can't demote themselves, so there is always one left.
+func (s *AdminService) SetRole(actorID string, userID string, role models.UserRole) (*models.User, error) {
+	if actorID == userID && role != models.UserRoleAdmin {
+		return nil, errs.ErrSelfDemotion
+	}
+	action := AA_UserPromote
+	if role != models.UserRoleAdmin {
+		action = AA_UserDemote
+	}
+	var user models.User
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ?", userID).First(&user).Error; err != nil {
+			return err
+		}
+		if user.Role == role {
+			return nil
+		}
+		detail := map[string]models.UserRole{"from": user.Role, "to": role}
+		if err := tx.Model(&user).Update("role", role).Error; err != nil {
+			return err
+		}
+		return s.record(tx, actorID, action, "user", userID, detail)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Audit records a change made by actorID. The change is done already, so a failure is only logged.
+func (s *AdminService) Audit(actorID string, action string, targetType string, targetID string, detail interface{}) {
+	if err := s.record(s.DB, actorID, action, targetType, targetID, detail); err != nil {
+		log.Printf("Error recording %s of %s %s by %s: %v", action, targetType, targetID, actorID, err)
+	}
+}
+
+func (s *AdminService) record(tx *gorm.DB, actorID string, action string, targetType string, targetID string, detail interface{}) error {
+	entry := models.AdminAuditLog{ActorID: actorID, Action: action, TargetType: targetType, TargetID: targetID}
+	if detail != nil {
+		data, err := json.Marshal(detail)
+		if err != nil {
+			return err
+		}
+		entry.Detail = optional(string(data))
+	}
+	return tx.Create(&entry).Error
+}
+
+// AuditFilter selects the entries of the audit trail. Zero values select everything.
+type AuditFilter struct {
+	ActorID  string
+	TargetID string
+	Action   string
+}
+
+// GetAuditLog -> the latest first
+func (s *AdminService) GetAuditLog(filter AuditFilter, page int, limit int) ([]models.AdminAuditLog, error) {
+	query := s.DB.Order("id DESC")
+	if filter.ActorID != "" {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.TargetID != "" {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	entries := []models.AdminAuditLog{}
+	err := query.Offset(page * limit).Limit(limit).Find(&entries).Error
+	return entries, err
+}
+
+func (s *AdminService) GetAdmins() ([]models.User, error) {
+	admins := []models.User{}
+	err := s.DB.Where("role = ?", models.UserRoleAdmin).Order("created_at").Find(&admins).Error
+	return admins, err
+}
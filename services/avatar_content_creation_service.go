@@ -7,6 +7,7 @@ import (
 	"avazon-api/tools"
 	"avazon-api/utils"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 
@@ -16,52 +17,176 @@ import (
 
 type AvatarContentCreationService struct {
 	DB                *gorm.DB
-	S3Service         *S3Service
+	Storage           Storage
 	PromptService     *SystemPromptService
 	AlbumImagePainter tools.Painter
 	VideoImagePainter tools.Painter
 	MusicProducer     tools.MusicProducer
 	VideoProducer     tools.VideoProducer
+	Jobs              *JobQueue
+	Events            *CreationEventBus
+	Usage             *UsageLedger
+	Credits           *CreditService
 }
 
 func NewAvatarContentCreationService(
 	db *gorm.DB,
-	s3Service *S3Service,
+	storage Storage,
 	promptService *SystemPromptService,
 	albumImagePainter tools.Painter,
 	videoImagePainter tools.Painter,
 	musicProducer tools.MusicProducer,
 	videoProducer tools.VideoProducer,
+	jobs *JobQueue,
+	events *CreationEventBus,
+	usage *UsageLedger,
+	credits *CreditService,
 ) *AvatarContentCreationService {
-	return &AvatarContentCreationService{
+	s := &AvatarContentCreationService{
 		DB:                db,
-		S3Service:         s3Service,
+		Storage:           storage,
 		PromptService:     promptService,
 		AlbumImagePainter: albumImagePainter,
 		VideoImagePainter: videoImagePainter,
 		MusicProducer:     musicProducer,
 		VideoProducer:     videoProducer,
+		Jobs:              jobs,
+		Events:            events,
+		Usage:             usage,
+		Credits:           credits,
+	}
+	jobs.Register(JT_VideoImage, s.runVideoImageJob, s.onVideoJobFailed)
+	jobs.Register(JT_Video, s.runVideoJob, s.onVideoJobFailed)
+	jobs.Register(JT_MusicImage, s.runMusicImageJob, s.onMusicJobFailed)
+	jobs.Register(JT_Music, s.runMusicJob, s.onMusicJobFailed)
+	jobs.OnRecover(s.recoverStuckCreations)
+	return s
+}
+
+// providerName is the provider that a tool chain reported, nil if none
+func providerName(used *tools.UsedProvider) *string {
+	if name := used.Name(); name != "" {
+		return &name
 	}
+	return nil
+}
+
+func (s *AvatarContentCreationService) trackVideo(ctx context.Context, avatarVideo *models.AvatarVideoContentCreation) context.Context {
+	return s.Usage.Track(ctx, UsageOwner{UserID: avatarVideo.UserID, AvatarID: avatarVideo.AvatarID, CreationType: UC_Video, CreationID: avatarVideo.ID})
+}
+
+func (s *AvatarContentCreationService) trackMusic(ctx context.Context, avatarMusic *models.AvatarMusicContentCreation) context.Context {
+	return s.Usage.Track(ctx, UsageOwner{UserID: avatarMusic.UserID, AvatarID: avatarMusic.AvatarID, CreationType: UC_Music, CreationID: avatarMusic.ID})
 }
 
 // called when video creation failed while progressing
 func (s *AvatarContentCreationService) onVideoFailed(avatarVideo *models.AvatarVideoContentCreation, reason string) {
 	avatarVideo.Status = models.ACC_Failed
+	reason = utils.TruncateString(reason, 255)
 	avatarVideo.FailedReason = &reason
-	if err := s.DB.Model(&avatarVideo).Updates(avatarVideo).Error; err != nil {
-		log.Printf("Error updating avatar video status: %v", err)
+	result := s.DB.Model(&avatarVideo).Where("status <> ?", models.ACC_Cancelled).Updates(avatarVideo)
+	if result.Error != nil {
+		log.Printf("Error updating avatar video status: %v", result.Error)
 		return
 	}
+	if result.RowsAffected == 1 { // not cancelled meanwhile
+		s.Events.Publish(videoCreationEvent(avatarVideo))
+	}
 }
 
 // called when music creation failed while progressing
 func (s *AvatarContentCreationService) onMusicFailed(avatarMusic *models.AvatarMusicContentCreation, reason string) {
 	avatarMusic.Status = models.ACC_Failed
+	reason = utils.TruncateString(reason, 255)
 	avatarMusic.FailedReason = &reason
-	if err := s.DB.Model(&avatarMusic).Updates(avatarMusic).Error; err != nil {
-		log.Printf("Error updating avatar music status: %v", err)
+	result := s.DB.Model(&avatarMusic).Where("status <> ?", models.ACC_Cancelled).Updates(avatarMusic)
+	if result.Error != nil {
+		log.Printf("Error updating avatar music status: %v", result.Error)
+		return
+	}
+	if result.RowsAffected == 1 { // not cancelled meanwhile
+		s.Events.Publish(musicCreationEvent(avatarMusic))
+	}
+}
+
+func (s *AvatarContentCreationService) onVideoJobFailed(job *models.Job, err error) {
+	var avatarVideo *models.AvatarVideoContentCreation
+	if dbErr := s.DB.Where("id = ?", job.RefID).First(&avatarVideo).Error; dbErr != nil {
+		log.Printf("Error fetching avatar video: %v", dbErr)
 		return
 	}
+	s.onVideoFailed(avatarVideo, err.Error())
+}
+
+func (s *AvatarContentCreationService) onMusicJobFailed(job *models.Job, err error) {
+	var avatarMusic *models.AvatarMusicContentCreation
+	if dbErr := s.DB.Where("id = ?", job.RefID).First(&avatarMusic).Error; dbErr != nil {
+		log.Printf("Error fetching avatar music: %v", dbErr)
+		return
+	}
+	s.onMusicFailed(avatarMusic, err.Error())
+}
+
+// recoverStuckCreations fails the creations left progressing without a job (e.g. started before the job queue existed)
+func (s *AvatarContentCreationService) recoverStuckCreations() error {
+	checks := []struct {
+		model   interface{}
+		status  models.AvatarContentCreationStatus
+		jobType string
+		publish func(ids []string) error
+	}{
+		{&models.AvatarVideoContentCreation{}, models.ACC_ImageProgressing, JT_VideoImage, s.publishVideos},
+		{&models.AvatarVideoContentCreation{}, models.ACC_ContentProgressing, JT_Video, s.publishVideos},
+		{&models.AvatarMusicContentCreation{}, models.ACC_ImageProgressing, JT_MusicImage, s.publishMusics},
+		{&models.AvatarMusicContentCreation{}, models.ACC_ContentProgressing, JT_Music, s.publishMusics},
+	}
+	for _, check := range checks {
+		var ids []string
+		if err := s.DB.Model(check.model).Where("status = ?", check.status).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		orphaned, err := s.Jobs.Orphaned(check.jobType, ids)
+		if err != nil {
+			return err
+		}
+		if len(orphaned) == 0 {
+			continue
+		}
+		log.Printf("Marking %d %s creation(s) stuck in %s as failed", len(orphaned), check.jobType, check.status)
+		if err := s.DB.Model(check.model).
+			Where("id IN ? AND status = ?", orphaned, check.status).
+			Updates(map[string]interface{}{"status": models.ACC_Failed, "failed_reason": "interrupted by a server restart"}).Error; err != nil {
+			return err
+		}
+		if err := check.publish(orphaned); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishVideos publishes the current status of the video creations
+func (s *AvatarContentCreationService) publishVideos(ids []string) error {
+	var videos []*models.AvatarVideoContentCreation
+	if err := s.DB.Where("id IN ?", ids).Find(&videos).Error; err != nil {
+		return err
+	}
+	for _, video := range videos {
+		s.Events.Publish(videoCreationEvent(video))
+	}
+	return nil
+}
+
+// publishMusics publishes the current status of the music creations
+func (s *AvatarContentCreationService) publishMusics(ids []string) error {
+	var musics []*models.AvatarMusicContentCreation
+	if err := s.DB.Where("id IN ?", ids).Find(&musics).Error; err != nil {
+		return err
+	}
+	for _, music := range musics {
+		s.Events.Publish(musicCreationEvent(music))
+	}
+	return nil
 }
 
 func (s *AvatarContentCreationService) CreateAvatarVideoImage(userID string, avatarID string, request dto.AvatarVideoImageRequest) (*models.AvatarVideoContentCreation, error) {
@@ -72,79 +197,72 @@ func (s *AvatarContentCreationService) CreateAvatarVideoImage(userID string, ava
 		return nil, err
 	}
 
-	imageURL := avatar.ProfileImageURL
-	imageBytes, mimeType, err := utils.GetDataFromURL(imageURL)
-	if err != nil {
-		log.Printf("Error getting data from URL: %v", err)
-		return nil, err
-	}
-
 	avatarVideo := &models.AvatarVideoContentCreation{
 		ID:          uuid.New().String(),
 		UserID:      userID,
 		AvatarID:    avatarID,
 		Avatar:      avatar,
 		ImagePrompt: request.Prompt,
-		Status:      models.ACC_Yet,
+		Status:      models.ACC_ImageProgressing,
 	}
-	if err := s.DB.Create(avatarVideo).Error; err != nil {
+	if err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(avatarVideo).Error; err != nil {
+			return err
+		}
+		job, err := s.Jobs.EnqueueTx(tx, JT_VideoImage, avatarVideo.ID, nil)
+		if err != nil {
+			return err
+		}
+		return s.Credits.ReserveTx(tx, userID, job, JT_VideoImage)
+	}); err != nil {
 		log.Printf("Error creating avatar video: %v", err)
 		return nil, err
 	}
+	s.Events.Publish(videoCreationEvent(avatarVideo))
 
-	go func() {
-		avatarVideo.Status = models.ACC_ImageProgressing
-		if err := s.DB.Model(&avatarVideo).Updates(avatarVideo).Error; err != nil {
-			avatarVideo.Status = models.ACC_Failed
-			reason := err.Error()
-			avatarVideo.FailedReason = &reason
-			log.Printf("Error updating avatar video status to progressing: %v", err)
-			return
-		}
+	return avatarVideo, nil
+}
 
-		newImageBytes, newImageMimeType, err := s.VideoImagePainter.PaintFromReference(imageBytes, mimeType, request.Prompt, 672, 1024)
-		if err != nil {
-			s.onVideoFailed(avatarVideo, err.Error())
-			log.Printf("Error painting video image: %v", err)
-			return
-		}
-		newImageExtension, err := utils.GetExtensionFromMimeType(newImageMimeType)
-		if err != nil {
-			s.onVideoFailed(avatarVideo, err.Error())
-			log.Printf("Error getting extension from MIME type: %v", err)
-			return
-		}
-		fileName := fmt.Sprintf("%s%s", uuid.New().String(), newImageExtension)
-
-		// upload to S3
-		uploadedURL, err := s.S3Service.UploadPublicFile(
-			context.TODO(),
-			fileName,
-			newImageBytes,
-			newImageMimeType,
-		)
-		if err != nil {
-			s.onVideoFailed(avatarVideo, err.Error())
-			log.Printf("Error uploading file to S3: %v", err)
-			return
-		}
+// paints the thumbnail of a video creation from the avatar's profile image
+func (s *AvatarContentCreationService) runVideoImageJob(ctx context.Context, job *models.Job) error {
+	var avatarVideo *models.AvatarVideoContentCreation
+	if err := loadJobRef(s.DB, &avatarVideo, job); err != nil {
+		return err
+	}
+	var avatar models.Avatar
+	if err := s.DB.Where("id = ?", avatarVideo.AvatarID).First(&avatar).Error; err != nil {
+		return err
+	}
 
-		avatarVideo.ThumbnailImageURL = &uploadedURL
-		if err := s.DB.Model(&avatarVideo).Updates(&avatarVideo).Error; err != nil {
-			s.onVideoFailed(avatarVideo, err.Error())
-			log.Printf("Error updating avatar video with thumbnail URL: %v", err)
-			return
-		}
+	imageBytes, mimeType, err := ReadURL(ctx, s.Storage, avatar.ProfileImageURL)
+	if err != nil {
+		return fmt.Errorf("error getting avatar image: %w", err)
+	}
 
-		avatarVideo.Status = models.ACC_ImageCompleted
-		if err := s.DB.Model(&avatarVideo).Updates(avatarVideo).Error; err != nil {
-			s.onVideoFailed(avatarVideo, err.Error())
-			log.Printf("Error updating avatar video status to completed: %v", err)
-			return
-		}
-	}()
+	ctx, painter := tools.WithUsedProvider(s.trackVideo(ctx, avatarVideo))
+	newImageBytes, newImageMimeType, err := s.VideoImagePainter.PaintFromReference(ctx, imageBytes, mimeType, avatarVideo.ImagePrompt, 672, 1024)
+	if err != nil {
+		return fmt.Errorf("error painting video image: %w", err)
+	}
+	newImageExtension, err := utils.GetExtensionFromMimeType(newImageMimeType)
+	if err != nil {
+		return Permanent(err)
+	}
+	fileName := fmt.Sprintf("%s%s", uuid.New().String(), newImageExtension)
 
-	return avatarVideo, nil
+	uploadedURL, err := s.Storage.Upload(ctx, fileName, newImageBytes, newImageMimeType)
+	if err != nil {
+		return fmt.Errorf("error uploading video image: %w", err)
+	}
+
+	avatarVideo.ThumbnailImageURL = &uploadedURL
+	avatarVideo.ThumbnailImageProvider = providerName(painter)
+	avatarVideo.Status = models.ACC_ImageCompleted
+	if err := updateJobRef(s.DB, avatarVideo, models.ACC_ImageProgressing); err != nil {
+		return err
+	}
+	s.Events.Publish(videoCreationEvent(avatarVideo))
+	return nil
 }
 
 func (s *AvatarContentCreationService) CreateAvatarVideoFromImage(userID string, avatarID string, videoID string, request dto.AvatarVideoRequest) (*models.AvatarVideoContentCreation, error) {
@@ -168,56 +286,65 @@ func (s *AvatarContentCreationService) CreateAvatarVideoFromImage(userID string,
 	if avatarVideo.Status == models.ACC_Confirmed {
 		return nil, errs.ErrContentCreationAlreadyCompleted
 	}
+	if avatarVideo.ThumbnailImageURL == nil {
+		return nil, errs.ErrImageNotCompleted
+	}
 
-	go func() {
-		avatarVideo.VideoPrompt = request.Prompt
-		avatarVideo.Status = models.ACC_ContentProgressing
-		if err := s.DB.Model(&avatarVideo).Updates(avatarVideo).Error; err != nil {
-			s.onVideoFailed(avatarVideo, err.Error())
-			log.Printf("Error updating avatar video status to content progressing: %v", err)
-			return
+	avatarVideo.VideoPrompt = request.Prompt
+	avatarVideo.Status = models.ACC_ContentProgressing
+	if err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&avatarVideo).Updates(avatarVideo).Error; err != nil {
+			return err
 		}
-
-		videoBytes, err := s.VideoProducer.Create(*avatarVideo.ThumbnailImageURL, avatarVideo.VideoPrompt)
+		job, err := s.Jobs.EnqueueTx(tx, JT_Video, avatarVideo.ID, nil)
 		if err != nil {
-			s.onVideoFailed(avatarVideo, err.Error())
-			log.Printf("Error creating video: %v", err)
-			return
+			return err
 		}
+		return s.Credits.ReserveTx(tx, userID, job, JT_Video)
+	}); err != nil {
+		log.Printf("Error updating avatar video status to content progressing: %v", err)
+		return nil, err
+	}
+	s.Events.Publish(videoCreationEvent(avatarVideo))
 
-		fileName := fmt.Sprintf("%s.%s", uuid.New().String(), "mp4")
+	return avatarVideo, nil
+}
 
-		uploadedURL, err := s.S3Service.UploadPublicFile(
-			context.TODO(),
-			fileName,
-			videoBytes,
-			"video/mp4",
-		)
-		if err != nil {
-			s.onVideoFailed(avatarVideo, err.Error())
-			log.Printf("Error uploading video to S3: %v", err)
-			return
-		}
+// renders the video of a video creation from its thumbnail
+func (s *AvatarContentCreationService) runVideoJob(ctx context.Context, job *models.Job) error {
+	var avatarVideo *models.AvatarVideoContentCreation
+	if err := loadJobRef(s.DB, &avatarVideo, job); err != nil {
+		return err
+	}
+	if avatarVideo.ThumbnailImageURL == nil {
+		return Permanent(errors.New("video image is not created"))
+	}
 
-		avatarVideo.VideoContentURL = &uploadedURL
-		if err := s.DB.Model(&avatarVideo).Updates(avatarVideo).Error; err != nil {
-			s.onVideoFailed(avatarVideo, err.Error())
-			log.Printf("Error updating avatar video with video URL: %v", err)
-			return
-		}
+	videoBytes, err := s.VideoProducer.Create(s.trackVideo(ctx, avatarVideo), *avatarVideo.ThumbnailImageURL, avatarVideo.VideoPrompt)
+	if err != nil {
+		return fmt.Errorf("error creating video: %w", err)
+	}
 
-		avatarVideo.Status = models.ACC_ContentCompleted
-		if err := s.DB.Model(&avatarVideo).Updates(avatarVideo).Error; err != nil {
-			s.onVideoFailed(avatarVideo, err.Error())
-			log.Printf("Error updating avatar video status to content completed: %v", err)
-			return
-		}
-	}()
+	fileName := fmt.Sprintf("%s.%s", uuid.New().String(), "mp4")
+	uploadedURL, err := s.Storage.Upload(ctx, fileName, videoBytes, "video/mp4")
+	if err != nil {
+		return fmt.Errorf("error uploading video: %w", err)
+	}
 
-	return avatarVideo, nil
+	avatarVideo.VideoContentURL = &uploadedURL
+	avatarVideo.Status = models.ACC_ContentCompleted
+	if err := updateJobRef(s.DB, avatarVideo, models.ACC_ContentProgressing); err != nil {
+		return err
+	}
+	s.Events.Publish(videoCreationEvent(avatarVideo))
+	return nil
 }
 
-func (s *AvatarContentCreationService) CreateAvatarMusicImage(userID string, avatarID string, request dto.AvatarMusicRequest) (*models.AvatarMusicContentCreation, error) {
+type musicImageJobPayload struct {
+	ThenMusic bool `json:"then_music"` // produce the music once the album image is done
+}
+
+func (s *AvatarContentCreationService) CreateAvatarMusicImage(ctx context.Context, userID string, avatarID string, request dto.AvatarMusicRequest) (*models.AvatarMusicContentCreation, error) {
 	// Fetch the avatar using the provided avatarID
 	var avatar models.Avatar
 	if err := s.DB.Where("id = ?", avatarID).First(&avatar).Error; err != nil {
@@ -225,93 +352,44 @@ func (s *AvatarContentCreationService) CreateAvatarMusicImage(userID string, ava
 		return nil, err
 	}
 
-	musicSummary, err := s.PromptService.Use(AG_MusicSummarizer, request.GetMusicInfo())
+	// the summary is paid for as well, so don't start without the credits of the album image and the music
+	if err := s.Credits.Check(userID, JT_MusicImage, JT_Music); err != nil {
+		return nil, err
+	}
+
+	musicID := uuid.New().String()
+	ctx = s.Usage.Track(ctx, UsageOwner{UserID: userID, AvatarID: avatarID, CreationType: UC_Music, CreationID: musicID})
+	musicSummary, err := s.PromptService.Use(ctx, AG_MusicSummarizer, request.GetMusicInfo())
 	if err != nil {
 		log.Printf("Error summarizing music: %v", err)
 		return nil, errs.ErrInternalServerError
 	}
 
 	avatarMusic := &models.AvatarMusicContentCreation{
-		ID:                   uuid.New().String(),
+		ID:                   musicID,
 		UserID:               userID,
 		Title:                request.Title,
 		Style:                request.Style,
 		AvatarID:             avatarID,
 		Avatar:               avatar,
 		GeneratedMusicPrompt: &musicSummary,
-		Status:               models.ACC_Yet,
-	}
-	if err := s.DB.Create(avatarMusic).Error; err != nil {
-		log.Printf("Error creating avatar music: %v", err)
-		return nil, err
+		Status:               models.ACC_ImageProgressing,
 	}
-
-	go func() {
-		avatarMusic.Status = models.ACC_ImageProgressing
-		if err := s.DB.Model(&avatarMusic).Updates(avatarMusic).Error; err != nil {
-			s.onMusicFailed(avatarMusic, err.Error())
-			log.Printf("Error updating avatar music status to image progressing: %v", err)
-			return
+	// TODO: May be separated into two steps
+	if err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(avatarMusic).Error; err != nil {
+			return err
 		}
-
-		imagePrompt, err := s.PromptService.Use(AG_MusicImagePromptCreation, *avatarMusic.GeneratedMusicPrompt)
+		job, err := s.Jobs.EnqueueTx(tx, JT_MusicImage, avatarMusic.ID, musicImageJobPayload{ThenMusic: true})
 		if err != nil {
-			s.onMusicFailed(avatarMusic, err.Error())
-			log.Printf("Error creating image prompt: %v", err)
-			return
-		}
-
-		avatarMusic.GeneratedImagePrompt = &imagePrompt
-		if err := s.DB.Model(&avatarMusic).Updates(avatarMusic).Error; err != nil {
-			s.onMusicFailed(avatarMusic, err.Error())
-			log.Printf("Error updating avatar music with generated image prompt: %v", err)
-			return
+			return err
 		}
-
-		imageBytes, mimeType, err := s.AlbumImagePainter.Paint(imagePrompt, "", 1024, 1024)
-		if err != nil {
-			s.onMusicFailed(avatarMusic, err.Error())
-			log.Printf("Error painting album image: %v", err)
-			return
-		}
-
-		fileExtension, err := utils.GetExtensionFromMimeType(mimeType)
-		if err != nil {
-			s.onMusicFailed(avatarMusic, err.Error())
-			log.Printf("Error getting extension from MIME type: %v", err)
-			return
-		}
-		fileName := fmt.Sprintf("%s%s", uuid.New().String(), fileExtension)
-
-		uploadedURL, err := s.S3Service.UploadPublicFile(
-			context.TODO(),
-			fileName,
-			imageBytes,
-			mimeType,
-		)
-		if err != nil {
-			s.onMusicFailed(avatarMusic, err.Error())
-			log.Printf("Error uploading album image to S3: %v", err)
-			return
-		}
-
-		avatarMusic.AlbumImageURL = &uploadedURL
-		if err := s.DB.Model(&avatarMusic).Updates(avatarMusic).Error; err != nil {
-			s.onMusicFailed(avatarMusic, err.Error())
-			log.Printf("Error updating avatar music with album image URL: %v", err)
-			return
-		}
-
-		avatarMusic.Status = models.ACC_ImageCompleted
-		if err := s.DB.Model(&avatarMusic).Updates(avatarMusic).Error; err != nil {
-			s.onMusicFailed(avatarMusic, err.Error())
-			log.Printf("Error updating avatar music status to image completed: %v", err)
-			return
-		}
-
-		// TODO: May be separated into two steps
-		s.CreateAvatarMusic(userID, avatarID, avatarMusic.ID)
-	}()
+		return s.Credits.ReserveTx(tx, userID, job, JT_MusicImage, JT_Music)
+	}); err != nil {
+		log.Printf("Error creating avatar music: %v", err)
+		return nil, err
+	}
+	s.Events.Publish(musicCreationEvent(avatarMusic))
 
 	return avatarMusic, nil
 }
@@ -326,80 +404,106 @@ func (s *AvatarContentCreationService) RegenerateAvatarMusicImage(userID string,
 	if mc.Status == models.ACC_ImageProgressing {
 		return nil, errs.ErrImageNotCompleted
 	}
+	// the music job only saves its result while the status is still content progressing
+	if mc.Status == models.ACC_ContentProgressing {
+		return nil, errs.ErrContentNotCompleted
+	}
 	if mc.Status == models.ACC_Confirmed {
 		return nil, errs.ErrContentCreationAlreadyCompleted
 	}
 
-	go func() {
-		mc.AlbumImageURL = nil
-		mc.Status = models.ACC_ImageProgressing
-		if err := s.DB.Model(&mc).Updates(mc).Error; err != nil {
-			s.onMusicFailed(mc, err.Error())
-			log.Printf("Error updating avatar music status to image progressing: %v", err)
-			return
+	mc.Status = models.ACC_ImageProgressing
+	if err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&mc).Updates(mc).Error; err != nil {
+			return err
 		}
-
-		imagePrompt, err := s.PromptService.Use(AG_MusicImagePromptCreation, *mc.GeneratedMusicPrompt)
+		job, err := s.Jobs.EnqueueTx(tx, JT_MusicImage, mc.ID, musicImageJobPayload{})
 		if err != nil {
-			s.onMusicFailed(mc, err.Error())
-			log.Printf("Error creating image prompt: %v", err)
-			return
+			return err
 		}
+		return s.Credits.ReserveTx(tx, userID, job, JT_MusicImage)
+	}); err != nil {
+		log.Printf("Error updating avatar music status to image progressing: %v", err)
+		return nil, err
+	}
+	s.Events.Publish(musicCreationEvent(mc))
 
-		mc.GeneratedImagePrompt = &imagePrompt
-		if err := s.DB.Model(&mc).Updates(mc).Error; err != nil {
-			s.onMusicFailed(mc, err.Error())
-			log.Printf("Error updating avatar music with generated image prompt: %v", err)
-			return
-		}
+	return mc, nil
+}
 
-		imageBytes, mimeType, err := s.AlbumImagePainter.Paint(imagePrompt, "", 1024, 1024)
-		if err != nil {
-			s.onMusicFailed(mc, err.Error())
-			log.Printf("Error painting album image: %v", err)
-			return
-		}
+// paints the album image of a music creation
+func (s *AvatarContentCreationService) runMusicImageJob(ctx context.Context, job *models.Job) error {
+	var payload musicImageJobPayload
+	if err := decodeJobPayload(job, &payload); err != nil {
+		return err
+	}
+	var mc *models.AvatarMusicContentCreation
+	if err := loadJobRef(s.DB, &mc, job); err != nil {
+		return err
+	}
+	if mc.GeneratedMusicPrompt == nil {
+		return Permanent(errors.New("music prompt is not generated"))
+	}
 
-		fileExtension, err := utils.GetExtensionFromMimeType(mimeType)
-		if err != nil {
-			s.onMusicFailed(mc, err.Error())
-			log.Printf("Error getting extension from MIME type: %v", err)
-			return
-		}
-		fileName := fmt.Sprintf("%s%s", uuid.New().String(), fileExtension)
-
-		uploadedURL, err := s.S3Service.UploadPublicFile(
-			context.TODO(),
-			fileName,
-			imageBytes,
-			mimeType,
-		)
-		if err != nil {
-			s.onMusicFailed(mc, err.Error())
-			log.Printf("Error uploading album image to S3: %v", err)
-			return
-		}
+	ctx = s.trackMusic(ctx, mc)
+	imagePrompt, err := s.PromptService.Use(ctx, AG_MusicImagePromptCreation, *mc.GeneratedMusicPrompt)
+	if err != nil {
+		return fmt.Errorf("error creating image prompt: %w", err)
+	}
 
-		mc.AlbumImageURL = &uploadedURL
-		if err := s.DB.Model(&mc).Updates(mc).Error; err != nil {
-			s.onMusicFailed(mc, err.Error())
-			log.Printf("Error updating avatar music with album image URL: %v", err)
-			return
-		}
+	mc.GeneratedImagePrompt = &imagePrompt
+	if err := updateJobRef(s.DB, mc, models.ACC_ImageProgressing); err != nil {
+		return err
+	}
+
+	ctx, painter := tools.WithUsedProvider(ctx)
+	imageBytes, mimeType, err := s.AlbumImagePainter.Paint(ctx, imagePrompt, "", 1024, 1024)
+	if err != nil {
+		return fmt.Errorf("error painting album image: %w", err)
+	}
+
+	fileExtension, err := utils.GetExtensionFromMimeType(mimeType)
+	if err != nil {
+		return Permanent(err)
+	}
+	fileName := fmt.Sprintf("%s%s", uuid.New().String(), fileExtension)
+
+	uploadedURL, err := s.Storage.Upload(ctx, fileName, imageBytes, mimeType)
+	if err != nil {
+		return fmt.Errorf("error uploading album image: %w", err)
+	}
 
-		if mc.MusicURL != nil {
-			mc.Status = models.ACC_ContentCompleted
-		} else {
-			mc.Status = models.ACC_ImageCompleted
+	mc.AlbumImageURL = &uploadedURL
+	mc.AlbumImageProvider = providerName(painter)
+	if mc.MusicURL != nil {
+		mc.Status = models.ACC_ContentCompleted
+	} else {
+		mc.Status = models.ACC_ImageCompleted
+	}
+	if !payload.ThenMusic {
+		if err := updateJobRef(s.DB, mc, models.ACC_ImageProgressing); err != nil {
+			return err
+		}
+		s.Events.Publish(musicCreationEvent(mc))
+		return nil
+	}
+	mc.Status = models.ACC_ContentProgressing
+	if err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := updateJobRef(tx, mc, models.ACC_ImageProgressing); err != nil {
+			return err
 		}
-		if err := s.DB.Model(&mc).Updates(mc).Error; err != nil {
-			s.onMusicFailed(mc, err.Error())
-			log.Printf("Error updating avatar music status to image completed: %v", err)
-			return
+		musicJob, err := s.Jobs.EnqueueTx(tx, JT_Music, mc.ID, nil)
+		if err != nil {
+			return err
 		}
-	}()
-
-	return mc, nil
+		// the music was paid for with the image; the image itself is settled
+		return s.Credits.MoveTx(tx, job, musicJob, JT_Music)
+	}); err != nil {
+		return err
+	}
+	// the image is done as well, but the music is already on its way
+	s.Events.Publish(musicCreationEvent(mc))
+	return nil
 }
 
 func (s *AvatarContentCreationService) CreateAvatarMusic(userID string, avatarID string, musicID string) (*models.AvatarMusicContentCreation, error) {
@@ -422,67 +526,117 @@ func (s *AvatarContentCreationService) CreateAvatarMusic(userID string, avatarID
 		return nil, errs.ErrContentCreationAlreadyCompleted
 	}
 
-	go func() {
-		avatarMusic.Status = models.ACC_ContentProgressing
-		if err := s.DB.Model(&avatarMusic).Updates(avatarMusic).Error; err != nil {
-			s.onMusicFailed(avatarMusic, err.Error())
-			log.Printf("Error updating avatar music status to content progressing: %v", err)
-			return
+	avatarMusic.Status = models.ACC_ContentProgressing
+	if err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&avatarMusic).Updates(avatarMusic).Error; err != nil {
+			return err
 		}
-
-		musicPrompt, err := s.PromptService.Use(AG_MusicPromptCreation, *avatarMusic.GeneratedMusicPrompt)
+		job, err := s.Jobs.EnqueueTx(tx, JT_Music, avatarMusic.ID, nil)
 		if err != nil {
-			s.onMusicFailed(avatarMusic, err.Error())
-			log.Printf("Error creating music prompt: %v", err)
-			return
-		}
-		avatarMusic.GeneratedMusicPrompt = &musicPrompt
-		if err := s.DB.Model(&avatarMusic).Updates(avatarMusic).Error; err != nil {
-			s.onMusicFailed(avatarMusic, err.Error())
-			log.Printf("Error updating avatar music with generated music prompt: %v", err)
-			return
+			return err
 		}
+		return s.Credits.ReserveTx(tx, userID, job, JT_Music)
+	}); err != nil {
+		log.Printf("Error updating avatar music status to content progressing: %v", err)
+		return nil, err
+	}
+	s.Events.Publish(musicCreationEvent(avatarMusic))
 
-		musicBytes, err := s.MusicProducer.Produce(musicPrompt, avatarMusic.Title, avatarMusic.Style)
-		if err != nil {
-			s.onMusicFailed(avatarMusic, err.Error())
-			log.Printf("Error producing music: %v", err)
-			return
-		}
+	return avatarMusic, nil
+}
 
-		fileName := fmt.Sprintf("%s.%s", uuid.New().String(), "mp3")
+// produces the music of a music creation
+func (s *AvatarContentCreationService) runMusicJob(ctx context.Context, job *models.Job) error {
+	var avatarMusic *models.AvatarMusicContentCreation
+	if err := loadJobRef(s.DB, &avatarMusic, job); err != nil {
+		return err
+	}
+	if avatarMusic.GeneratedMusicPrompt == nil {
+		return Permanent(errors.New("music prompt is not generated"))
+	}
 
-		uploadedURL, err := s.S3Service.UploadPublicFile(
-			context.TODO(),
-			fileName,
-			musicBytes,
-			"audio/mpeg",
-		)
+	ctx = s.trackMusic(ctx, avatarMusic)
+	musicPrompt, err := s.PromptService.Use(ctx, AG_MusicPromptCreation, *avatarMusic.GeneratedMusicPrompt)
+	if err != nil {
+		return fmt.Errorf("error creating music prompt: %w", err)
+	}
 
-		if err != nil {
-			s.onMusicFailed(avatarMusic, err.Error())
-			log.Printf("Error uploading music to S3: %v", err)
-			return
-		}
+	ctx, producer := tools.WithUsedProvider(ctx)
+	musicBytes, err := s.MusicProducer.Produce(ctx, musicPrompt, avatarMusic.Title, avatarMusic.Style)
+	if err != nil {
+		return fmt.Errorf("error producing music: %w", err)
+	}
 
-		avatarMusic.MusicURL = &uploadedURL
-		if err := s.DB.Model(&avatarMusic).Updates(avatarMusic).Error; err != nil {
-			s.onMusicFailed(avatarMusic, err.Error())
-			log.Printf("Error updating avatar music with music URL: %v", err)
-			return
-		}
+	fileName := fmt.Sprintf("%s.%s", uuid.New().String(), "mp3")
+	uploadedURL, err := s.Storage.Upload(ctx, fileName, musicBytes, "audio/mpeg")
+	if err != nil {
+		return fmt.Errorf("error uploading music: %w", err)
+	}
 
-		avatarMusic.Status = models.ACC_ContentCompleted
-		if err := s.DB.Model(&avatarMusic).Updates(avatarMusic).Error; err != nil {
-			s.onMusicFailed(avatarMusic, err.Error())
-			log.Printf("Error updating avatar music status to content completed: %v", err)
-			return
-		}
-	}()
+	// the prompt is only saved now, so a retry starts from the same summary
+	avatarMusic.GeneratedMusicPrompt = &musicPrompt
+	avatarMusic.MusicURL = &uploadedURL
+	avatarMusic.MusicProvider = providerName(producer)
+	avatarMusic.Status = models.ACC_ContentCompleted
+	if err := updateJobRef(s.DB, avatarMusic, models.ACC_ContentProgressing); err != nil {
+		return err
+	}
+	s.Events.Publish(musicCreationEvent(avatarMusic))
+	return nil
+}
 
+// the statuses in which a music or video creation may be cancelled
+var cancellableContentStatuses = []models.AvatarContentCreationStatus{models.ACC_ImageProgressing, models.ACC_ContentProgressing}
+
+// CancelAvatarMusic stops the album image or music being produced for a music creation
+func (s *AvatarContentCreationService) CancelAvatarMusic(userID string, musicCreationID string) (*models.AvatarMusicContentCreation, error) {
+	var avatarMusic *models.AvatarMusicContentCreation
+	if err := s.DB.Where("id = ? AND user_id = ?", musicCreationID, userID).First(&avatarMusic).Error; err != nil {
+		log.Printf("Error fetching avatar music: %v", err)
+		return nil, err
+	}
+	if err := s.cancelContent(avatarMusic, avatarMusic.ID, JT_MusicImage, JT_Music); err != nil {
+		return nil, err
+	}
+	avatarMusic.Status = models.ACC_Cancelled
+	s.Events.Publish(musicCreationEvent(avatarMusic))
 	return avatarMusic, nil
 }
 
+// CancelAvatarVideo stops the thumbnail or video being rendered for a video creation
+func (s *AvatarContentCreationService) CancelAvatarVideo(userID string, videoCreationID string) (*models.AvatarVideoContentCreation, error) {
+	var avatarVideo *models.AvatarVideoContentCreation
+	if err := s.DB.Where("id = ? AND user_id = ?", videoCreationID, userID).First(&avatarVideo).Error; err != nil {
+		log.Printf("Error fetching avatar video: %v", err)
+		return nil, err
+	}
+	if err := s.cancelContent(avatarVideo, avatarVideo.ID, JT_VideoImage, JT_Video); err != nil {
+		return nil, err
+	}
+	avatarVideo.Status = models.ACC_Cancelled
+	s.Events.Publish(videoCreationEvent(avatarVideo))
+	return avatarVideo, nil
+}
+
+// cancelContent marks a progressing creation cancelled and stops its jobs
+func (s *AvatarContentCreationService) cancelContent(creation interface{}, id string, jobTypes ...string) error {
+	result := s.DB.Model(creation).
+		Where("status IN ?", cancellableContentStatuses).
+		Update("status", models.ACC_Cancelled)
+	if result.Error != nil {
+		log.Printf("Error cancelling content creation %s: %v", id, result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errs.ErrCreationNotCancellable
+	}
+	if _, err := s.Jobs.Cancel(id, jobTypes...); err != nil {
+		log.Printf("Error cancelling the jobs of content creation %s: %v", id, err)
+		return err
+	}
+	return nil
+}
+
 func (s *AvatarContentCreationService) GetAvatarMusicCreations(userID string, avatarID *string, page int, limit int) ([]*models.AvatarMusicContentCreation, error) {
 	var musicCreations []*models.AvatarMusicContentCreation
 
@@ -559,6 +713,7 @@ func (s *AvatarContentCreationService) ConfirmAvatarMusic(userID string, musicCr
 		log.Printf("Error updating avatar music creation status to completed: %v", err)
 		return nil, err
 	}
+	s.Events.Publish(musicCreationEvent(AvatarMusicContentCreation))
 
 	avatarMusic := models.AvatarMusic{
 		ID:            contentID,
@@ -600,6 +755,7 @@ func (s *AvatarContentCreationService) ConfirmAvatarVideo(userID string, videoCr
 		log.Printf("Error updating avatar video creation status to completed: %v", err)
 		return nil, err
 	}
+	s.Events.Publish(videoCreationEvent(AvatarVideoContentCreation))
 
 	avatarVideo := models.AvatarVideo{
 		ID:                contentID,
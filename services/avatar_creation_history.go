@@ -0,0 +1,103 @@
+package services
+
+import (
+	"avazon-api/models"
+	"avazon-api/tools"
+)
+
+// countCreations is the number of objects of objectType created so far in the session.
+// Chats are tagged with it, so CreateCharacter/CreateVoice only read the chats of the current round.
+func (t *AvatarCreateTools) countCreations(avatarCreationID string, objectType string) (int, error) {
+	var model interface{}
+	switch objectType {
+	case "image":
+		model = &models.AvatarImageCreation{}
+	case "character":
+		model = &models.AvatarCharacterCreation{}
+	case "voice":
+		model = &models.AvatarVoiceCreation{}
+	default:
+		return 0, nil
+	}
+	var count int64
+	err := t.DB.Model(model).Where("avatar_creation_id = ?", avatarCreationID).Count(&count).Error
+	return int(count), err
+}
+
+// loadChats returns every stored chat of the session, oldest first
+func (t *AvatarCreateTools) loadChats(avatarCreationID string) ([]models.AvatarCreationChat, error) {
+	var chats []models.AvatarCreationChat
+	err := t.DB.Where("avatar_creation_id = ?", avatarCreationID).Order("id ASC").Find(&chats).Error
+	return chats, err
+}
+
+// chatHistory rebuilds the message list of one assistant (for Assistant.Init) from the stored chats.
+// A tool call is only restored together with its tool result, as the vendors reject unanswered tool calls.
+func chatHistory(systemPrompt string, chats []models.AvatarCreationChat, objectType string) []tools.Message {
+	history := []tools.Message{{Role: "system", Content: systemPrompt}}
+	var pendingCall *models.AvatarCreationChat
+	for i := range chats {
+		chat := &chats[i]
+		if chat.ObjectType != objectType {
+			continue
+		}
+		switch chat.Role {
+		case "user":
+			pendingCall = nil
+			// older sessions saved the user chat twice
+			last := history[len(history)-1]
+			if last.Role == "user" && last.Content == chat.Content {
+				continue
+			}
+			history = append(history, tools.Message{Role: "user", Content: chat.Content})
+		case "assistant":
+			if chat.ToolCallId != "" {
+				pendingCall = chat
+				continue
+			}
+			pendingCall = nil
+			if chat.Content != "" {
+				history = append(history, tools.Message{Role: "assistant", Content: chat.Content})
+			}
+		case "tool":
+			if pendingCall == nil || (chat.ToolCallId != "" && chat.ToolCallId != pendingCall.ToolCallId) {
+				continue
+			}
+			history = append(history,
+				tools.Message{
+					Role:    "assistant",
+					Content: pendingCall.Content,
+					ToolCalls: []tools.ToolCall{{
+						ID:        pendingCall.ToolCallId,
+						Name:      pendingCall.ToolCallName,
+						Arguments: pendingCall.ToolCallArguments,
+					}},
+				},
+				tools.Message{Role: "tool", Content: chat.Content, ToolCallID: pendingCall.ToolCallId},
+			)
+			pendingCall = nil
+		}
+	}
+	return history
+}
+
+// History is the transcript shown to the user when they (re)enter the session.
+// Tool calls and duplicated user chats are left out.
+func (ss *AvatarCreateSession) History() ([]models.AvatarCreationChat, error) {
+	chats, err := ss.tools.loadChats(ss.session.ID)
+	if err != nil {
+		return nil, err
+	}
+	history := []models.AvatarCreationChat{}
+	for _, chat := range chats {
+		if chat.Role == "tool" || chat.Content == "" {
+			continue
+		}
+		if last := len(history) - 1; chat.Role == "user" && last >= 0 &&
+			history[last].Role == "user" && history[last].ObjectType == chat.ObjectType && history[last].Content == chat.Content {
+			continue
+		}
+		history = append(history, chat)
+	}
+	return history, nil
+}
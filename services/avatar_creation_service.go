@@ -10,11 +10,13 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type AvatarFunction string
@@ -25,44 +27,69 @@ const (
 	AF_CreateVoice     AvatarFunction = "create_avatar_voice"
 )
 
+// chat session creations (not run as jobs) left ready/processing for this long are failed
+const abandonedCreationAge = 30 * time.Minute
+
 type AvatarCreateService struct {
-	AssistantCreator func() tools.Assistant
-	sessions         map[string]*AvatarCreateSession
+	AssistantCreator func(agent string) tools.Assistant // assistant of each agent (see SystemPromptService)
+	sessions         map[string]*AvatarCreateSession    // guarded by mu
+	sessionCfg       AvatarSessionConfig
 	tools            *AvatarCreateTools
+	jobs             *JobQueue
+	credits          *CreditService
 	mu               sync.Mutex
 }
 
 func NewAvatarCreateService(
 	db *gorm.DB,
-	assistantCreator func() tools.Assistant,
+	assistantCreator func(agent string) tools.Assistant,
 	promptService *SystemPromptService,
 	Painter tools.Painter,
 	VoiceActor tools.VoiceActor,
 	VideoProducer tools.VideoProducer,
-	S3Service *S3Service,
+	Storage Storage,
+	jobs *JobQueue,
+	usage *UsageLedger,
+	credits *CreditService,
+	sessionCfg AvatarSessionConfig,
 ) *AvatarCreateService {
-	return &AvatarCreateService{
+	s := &AvatarCreateService{
 		AssistantCreator: assistantCreator,
 		sessions:         make(map[string]*AvatarCreateSession),
+		sessionCfg:       sessionCfg,
 		tools: &AvatarCreateTools{
 			DB:            db,
 			Painter:       Painter,
 			VoiceActor:    VoiceActor,
 			VideoProducer: VideoProducer,
 			PromptService: promptService,
-			S3Service:     S3Service,
+			Storage:       Storage,
+			Usage:         usage,
 		},
+		jobs:    jobs,
+		credits: credits,
 	}
+	jobs.Register(JT_AvatarImage, s.runImageJob, s.onImageJobFailed)
+	jobs.Register(JT_AvatarVoice, s.runVoiceJob, s.onVoiceJobFailed)
+	jobs.Register(JT_AvatarChatVideo, s.runChatVideoJob, nil)
+	jobs.OnRecover(s.recoverStuckCreations)
+	return s
 }
 
 type AvatarCreateSession struct {
+	userID             string
 	tools              *AvatarCreateTools // Use tools for various operations
+	credits            *CreditService     // the image and voice creations are paid for like the jobs
 	session            *models.AvatarCreation
 	imageAssistant     tools.Assistant
 	characterAssistant tools.Assistant
 	voiceAssistant     tools.Assistant
-	enteredAt          time.Time // when user entered the room
-	updatedAt          time.Time // when user updated the room (if last update is more than 30 minutes, expire)
+	sockets            map[SessionSocket]struct{} // guarded by AvatarCreateService.mu
+	ctx                context.Context            // of the creations started in the session, done once the session is closed or evicted
+	cancel             context.CancelFunc
+	parts              map[string]context.CancelFunc // creations running in the session, by partKey; guarded by mu
+	enteredAt          time.Time                     // when user entered the room
+	updatedAt          time.Time                     // when user updated the room (if last update is older than IdleTTL, expire)
 	mu                 sync.Mutex
 }
 
@@ -72,7 +99,17 @@ type AvatarCreateTools struct {
 	VoiceActor    tools.VoiceActor
 	VideoProducer tools.VideoProducer
 	PromptService *SystemPromptService
-	S3Service     *S3Service
+	Storage       Storage
+	Usage         *UsageLedger
+}
+
+// track attributes the provider calls made with ctx to the avatar creation
+func (t *AvatarCreateTools) track(ctx context.Context, userID string, avatarCreationID string) context.Context {
+	return t.Usage.Track(ctx, UsageOwner{UserID: userID, CreationType: UC_AvatarCreation, CreationID: avatarCreationID})
+}
+
+func (ss *AvatarCreateSession) track(ctx context.Context) context.Context {
+	return ss.tools.track(ctx, ss.userID, ss.session.ID)
 }
 
 func (s *AvatarCreateService) StartCreation(userID string, req dto.AvatarCreationRequest) (models.AvatarCreation, error) {
@@ -134,15 +171,49 @@ func (s *AvatarCreateService) GetOneSession(userID string, avatarCreationID stri
 
 func (s *AvatarCreateService) CreateAvatarImage() {}
 
-func (s *AvatarCreateService) GetCreateSessionChat(avatarCreationID string, objectType string, cursor int, size int) ([]models.AvatarCreationChat, error) {
-	var chats []models.AvatarCreationChat
-	if err := s.tools.DB.Where("avatar_creation_id = ? AND object_type = ? AND id < ?", avatarCreationID, objectType, cursor).
-		Order("id DESC").
-		Limit(size).
-		Find(&chats).Error; err != nil {
-		return []models.AvatarCreationChat{}, err
+// GetMyCreateSessionChat is GetCreateSessionChat for the owner of the creation session only.
+func (s *AvatarCreateService) GetMyCreateSessionChat(userID string, avatarCreationID string, objectType string, cursor int, size int, withTools bool) ([]models.AvatarCreationChat, *int, error) {
+	if err := s.tools.DB.Select("id").
+		Where("id = ? AND user_id = ?", avatarCreationID, userID).
+		First(&models.AvatarCreation{}).Error; err != nil {
+		return nil, nil, err
 	}
-	return chats, nil
+	return s.GetCreateSessionChat(avatarCreationID, objectType, cursor, size, withTools)
+}
+
+// GetCreateSessionChat pages the chats of a creation session, newest first.
+//   - objectType: image, character, voice or "" for all
+//   - cursor: id of the last chat of the previous page, 0 for the first page
+//   - withTools: include the tool call rows (assistant tool calls and tool results)
+//
+// -> chats, next cursor (nil on the last page), error
+func (s *AvatarCreateService) GetCreateSessionChat(avatarCreationID string, objectType string, cursor int, size int, withTools bool) ([]models.AvatarCreationChat, *int, error) {
+	query := s.tools.DB.Where("avatar_creation_id = ?", avatarCreationID)
+	switch objectType {
+	case "":
+	case "image", "character", "voice":
+		query = query.Where("object_type = ?", objectType)
+	default:
+		return nil, nil, errs.ErrBadRequest
+	}
+	if cursor > 0 {
+		query = query.Where("id < ?", cursor)
+	}
+	if !withTools {
+		query = query.Where("role <> ? AND (tool_call_id IS NULL OR tool_call_id = '')", "tool")
+	}
+
+	chats := []models.AvatarCreationChat{}
+	// one more to know if there is a next page
+	if err := query.Order("id DESC").Limit(size + 1).Find(&chats).Error; err != nil {
+		return nil, nil, err
+	}
+	if len(chats) <= size {
+		return chats, nil, nil
+	}
+	chats = chats[:size]
+	nextCursor := chats[size-1].ID
+	return chats, &nextCursor, nil
 }
 
 // avatarID is for hashed NFT key
@@ -202,184 +273,160 @@ func (s *AvatarCreateService) CreateAvatar(userID string, avatarCreationID strin
 		return models.Avatar{}, err
 	}
 
-	go func() {
-		video, err := s.tools.VideoProducer.Create(avatar.ProfileImageURL, string(AG_AvatarChatVideoPrompt))
-		if err != nil {
-			log.Println(err)
-		}
-
-		fileName := fmt.Sprintf("%s_chat.mp4", avatarID)
-		videoURL, err := s.tools.S3Service.UploadPublicFile(context.TODO(), fileName, video, "video/mp4")
-		if err != nil {
-			log.Println(err)
-		}
-
-		avatar.AvatarVideoURL = &videoURL
-		s.tools.DB.Model(&avatar).Update("avatar_video_url", videoURL)
-	}()
+	if _, err := s.jobs.Enqueue(JT_AvatarChatVideo, avatar.ID, nil); err != nil {
+		log.Println("Failed to enqueue avatar chat video:", err)
+	}
 
 	return avatar, nil
 }
 
-func (s *AvatarCreateService) EnterSession(userID string, sessionID string) (*AvatarCreateSession, error) {
-	session, ok := s.sessions[sessionID]
-	// if not exists, create new room
-	if !ok {
-		// find creation from DB
-		var creation *models.AvatarCreation
-		s.tools.DB.Where("user_id=? AND id=?", userID, sessionID).
-			Preload("CharacterCreations").
-			Preload("VoiceCreations").
-			Preload("ImageCreations").
-			First(&creation)
-		if creation == nil {
-			return nil, errors.New("session not found")
-		}
-
-		// create assistants
-		// 1. image assistant
-		imageAssistant := s.AssistantCreator()
-		imagePrompt, err := s.tools.PromptService.GetSystemPrompt(AG_AvatarImageCreationChat)
-		if err == nil {
-			imageAssistant.SetSystemPrompt(imagePrompt)
-		} else {
-			log.Println("Failed to get image assistant prompt:", err)
-			imageAssistant.SetSystemPrompt("You are a helpful assistant. Your'e helping user to create an avatar image.")
-		}
-		// set function
-		imageAssistant.SetTools([]tools.OpenAITool{
-			{
-				Type: "function",
-				Function: tools.OpenAIToolFunction{
-					Name:        string(AF_CreateImage),
-					Description: "You can request avatar image creation to server. You have to call this function when you think it is necessary. Summarize avatar appearance with avatar's basic information and user's chattings.",
-					Parameters: tools.OpenAIFunctionParameters{
-						Type: "object",
-						Properties: map[string]tools.OpenAIParameter{
-							"summary": {
-								Type:        "string",
-								Description: "Summary of current creating avatar based on avatar's basic information, and user's chattings. It MUST be shorter than 250 characters.",
-							},
-						},
-						Required:             []string{"summary"},
-						AdditionalProperties: false,
+// openSession loads a creation session from DB and prepares its assistants
+func (s *AvatarCreateService) openSession(userID string, sessionID string) (*AvatarCreateSession, error) {
+	// find creation from DB
+	var creation *models.AvatarCreation
+	s.tools.DB.Where("user_id=? AND id=?", userID, sessionID).
+		Preload("CharacterCreations").
+		Preload("VoiceCreations").
+		Preload("ImageCreations").
+		First(&creation)
+	if creation == nil {
+		return nil, errors.New("session not found")
+	}
+
+	// create assistants
+	// 1. image assistant
+	imageAssistant := s.AssistantCreator(string(AG_AvatarImageCreationChat))
+	imagePrompt, err := s.tools.PromptService.GetSystemPrompt(AG_AvatarImageCreationChat)
+	if err != nil {
+		log.Println("Failed to get image assistant prompt:", err)
+		imagePrompt = "You are a helpful assistant. Your'e helping user to create an avatar image."
+	}
+	imageAssistant.SetSystemPrompt(imagePrompt)
+	// set function
+	imageAssistant.SetTools([]tools.Tool{
+		{
+			Name:        string(AF_CreateImage),
+			Description: "You can request avatar image creation to server. You have to call this function when you think it is necessary. Summarize avatar appearance with avatar's basic information and user's chattings.",
+			Parameters: tools.ToolParameters{
+				Properties: map[string]tools.ToolParameter{
+					"summary": {
+						Type:        "string",
+						Description: "Summary of current creating avatar based on avatar's basic information, and user's chattings. It MUST be shorter than 250 characters.",
 					},
 				},
+				Required: []string{"summary"},
 			},
-		})
+		},
+	})
 
-		// 2. character assistant
-		characterAssistant := s.AssistantCreator()
-		characterPrompt, err := s.tools.PromptService.GetSystemPrompt(AG_AvatarCharacterCreationChat)
-		if err == nil {
-			characterAssistant.SetSystemPrompt(characterPrompt)
-		} else {
-			log.Println("Failed to get character assistant prompt:", err)
-			characterAssistant.SetSystemPrompt("You are a helpful assistant. Your'e helping user to create an avatar character. Especially for character personality, you have to make it more detailed and unique.")
-		}
-		// set function
-		characterAssistant.SetTools([]tools.OpenAITool{
-			{
-				Type: "function",
-				Function: tools.OpenAIToolFunction{
-					Name:        string(AF_CreateCharacter),
-					Description: "You can request avatar character creation to server. You have to call this function when you think it is necessary. Server has all chat details and information, so arguments are not needed.",
-					Parameters: tools.OpenAIFunctionParameters{
-						Type:                 "object",
-						Properties:           map[string]tools.OpenAIParameter{},
-						AdditionalProperties: false,
-						Required:             []string{},
-					},
-				},
+	// 2. character assistant
+	characterAssistant := s.AssistantCreator(string(AG_AvatarCharacterCreationChat))
+	characterPrompt, err := s.tools.PromptService.GetSystemPrompt(AG_AvatarCharacterCreationChat)
+	if err != nil {
+		log.Println("Failed to get character assistant prompt:", err)
+		characterPrompt = "You are a helpful assistant. Your'e helping user to create an avatar character. Especially for character personality, you have to make it more detailed and unique."
+	}
+	characterAssistant.SetSystemPrompt(characterPrompt)
+	// set function
+	characterAssistant.SetTools([]tools.Tool{
+		{
+			Name:        string(AF_CreateCharacter),
+			Description: "You can request avatar character creation to server. You have to call this function when you think it is necessary. Server has all chat details and information, so arguments are not needed.",
+			Parameters: tools.ToolParameters{
+				Properties: map[string]tools.ToolParameter{},
+				Required:   []string{},
 			},
-		})
+		},
+	})
 
-		// 3. voice assistant
-		voiceAssistant := s.AssistantCreator()
-		voicePrompt, err := s.tools.PromptService.GetSystemPrompt(AG_AvatarVoiceCreationChat)
-		if err == nil {
-			voiceAssistant.SetSystemPrompt(voicePrompt)
-		} else {
-			log.Println("Failed to get voice assistant prompt:", err)
-			voiceAssistant.SetSystemPrompt("You are a helpful assistant. You're helping user to create an avatar voice. Do not ask user parameters directly. Inference them from user's chattings by yourself.")
-		}
-		// set function
-		voiceAssistant.SetTools([]tools.OpenAITool{
-			{
-				Type: "function",
-				Function: tools.OpenAIToolFunction{
-					Name:        string(AF_CreateVoice),
-					Description: "You can request avatar voice creation to server. You have to call this function when you think it is necessary. First, summarize avatar voice with avatar's basic information and user's chattings, and use it as input parameter in this function. Do not ask user parameters directly. Inference them from user's chattings by yourself.",
-					Parameters: tools.OpenAIFunctionParameters{
-						Type: "object",
-						Properties: map[string]tools.OpenAIParameter{
-							"summary": {
-								Type:        "string",
-								Description: "Summary of current creating avatar based on avatar's basic information, and user's chattings.",
-							},
-							"gender": {
-								Type:        "string",
-								Description: "Gender of avatar. It must be 'male' or 'female'.",
-								Enum:        []string{"male", "female"},
-							},
-							"accent_strength": {
-								Type:        "number",
-								Description: "Accent strength of avatar voice. It has to be between 0.3 and 2.0.",
-							},
-							"age": {
-								Type:        "string",
-								Description: "Age of avatar voice. It must be 'young', 'middle_aged', or 'old'.",
-								Enum:        []string{"young", "middle_aged", "old"},
-							},
-							"accent": {
-								Type:        "string",
-								Description: "Accent of avatar voice. It must be 'american', 'british', 'african', 'australian', or 'indian'.",
-								Enum:        []string{"american", "british", "african", "australian", "indian"},
-							},
-						},
-						Required:             []string{"summary", "gender", "accent_strength", "age", "accent"},
-						AdditionalProperties: false,
+	// 3. voice assistant
+	voiceAssistant := s.AssistantCreator(string(AG_AvatarVoiceCreationChat))
+	voicePrompt, err := s.tools.PromptService.GetSystemPrompt(AG_AvatarVoiceCreationChat)
+	if err != nil {
+		log.Println("Failed to get voice assistant prompt:", err)
+		voicePrompt = "You are a helpful assistant. You're helping user to create an avatar voice. Do not ask user parameters directly. Inference them from user's chattings by yourself."
+	}
+	voiceAssistant.SetSystemPrompt(voicePrompt)
+	// set function
+	voiceAssistant.SetTools([]tools.Tool{
+		{
+			Name:        string(AF_CreateVoice),
+			Description: "You can request avatar voice creation to server. You have to call this function when you think it is necessary. First, summarize avatar voice with avatar's basic information and user's chattings, and use it as input parameter in this function. Do not ask user parameters directly. Inference them from user's chattings by yourself.",
+			Parameters: tools.ToolParameters{
+				Properties: map[string]tools.ToolParameter{
+					"summary": {
+						Type:        "string",
+						Description: "Summary of current creating avatar based on avatar's basic information, and user's chattings.",
+					},
+					"gender": {
+						Type:        "string",
+						Description: "Gender of avatar. It must be 'male' or 'female'.",
+						Enum:        []string{"male", "female"},
+					},
+					"accent_strength": {
+						Type:        "number",
+						Description: "Accent strength of avatar voice. It has to be between 0.3 and 2.0.",
+					},
+					"age": {
+						Type:        "string",
+						Description: "Age of avatar voice. It must be 'young', 'middle_aged', or 'old'.",
+						Enum:        []string{"young", "middle_aged", "old"},
+					},
+					"accent": {
+						Type:        "string",
+						Description: "Accent of avatar voice. It must be 'american', 'british', 'african', 'australian', or 'indian'.",
+						Enum:        []string{"american", "british", "african", "australian", "indian"},
 					},
 				},
+				Required: []string{"summary", "gender", "accent_strength", "age", "accent"},
 			},
-		})
+		},
+	})
 
-		session = &AvatarCreateSession{
-			session:            creation,
-			tools:              s.tools,
-			imageAssistant:     imageAssistant,
-			characterAssistant: characterAssistant,
-			voiceAssistant:     voiceAssistant,
-		}
-		s.sessions[sessionID] = session
+	// 4. restore the conversations of the previous sessions
+	chats, err := s.tools.loadChats(creation.ID)
+	if err != nil {
+		return nil, err
 	}
-
-	session.enteredAt = time.Now()
-	session.updatedAt = time.Now()
-
-	return session, nil
-}
-
-func (s *AvatarCreateService) CloseSession(userID string, sessionID string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	delete(s.sessions, sessionID)
+	imageAssistant.Init(chatHistory(imagePrompt, chats, "image"))
+	characterAssistant.Init(chatHistory(characterPrompt, chats, "character"))
+	voiceAssistant.Init(chatHistory(voicePrompt, chats, "voice"))
+
+	ctx, cancel := context.WithCancel(s.tools.track(context.Background(), userID, creation.ID))
+	return &AvatarCreateSession{
+		userID:             userID,
+		session:            creation,
+		tools:              s.tools,
+		credits:            s.credits,
+		imageAssistant:     imageAssistant,
+		characterAssistant: characterAssistant,
+		voiceAssistant:     voiceAssistant,
+		sockets:            make(map[SessionSocket]struct{}),
+		ctx:                ctx,
+		cancel:             cancel,
+		parts:              make(map[string]context.CancelFunc),
+	}, nil
 }
 
-// call by controller when assistant chat is done
+// call by controller when user or assistant chat is done
 func (s *AvatarCreateService) SaveChat(sessionID string, role string, objectType string, content string) (models.AvatarCreationChat, error) {
+	createdObjectNumber, err := s.tools.countCreations(sessionID, objectType)
+	if err != nil {
+		return models.AvatarCreationChat{}, err
+	}
 	chat := models.AvatarCreationChat{
-		AvatarCreationID: sessionID,
-		Role:             role,
-		ObjectType:       objectType,
-		Content:          content,
+		AvatarCreationID:    sessionID,
+		Role:                role,
+		ObjectType:          objectType,
+		Content:             content,
+		CreatedObjectNumber: createdObjectNumber,
 	}
 	return chat, s.tools.DB.Create(&chat).Error
 }
 
 // chat between user and image assistant
-func (ss *AvatarCreateSession) HandleImageChat(userMessage string) (output chan string, done chan string, err chan error) {
-	output, done, err = ss.imageAssistant.HandleAsync(userMessage)
+func (ss *AvatarCreateSession) HandleImageChat(ctx context.Context, userMessage string) <-chan tools.AssistantEvent {
+	events := ss.imageAssistant.HandleAsync(ss.track(ctx), userMessage)
 
 	// go func() {
 	// 	userChat := models.AvatarCreationChat{
@@ -393,45 +440,21 @@ func (ss *AvatarCreateSession) HandleImageChat(userMessage string) (output chan
 	// 	// Assistant Chat saving code will be called after output is done
 	// }()
 
-	return output, done, err
+	return events
 }
 
 // chat between user and character assistant
-func (ss *AvatarCreateSession) HandleCharacterChat(userMessage string) (output chan string, done chan string, err chan error) {
-	output, done, err = ss.characterAssistant.HandleAsync(userMessage)
-
-	go func() {
-		userChat := models.AvatarCreationChat{
-			AvatarCreationID:    ss.session.ID,
-			Role:                "user",
-			ObjectType:          "character",
-			Content:             userMessage,
-			CreatedObjectNumber: len(ss.session.CharacterCreations),
-		}
-		ss.tools.DB.Create(&userChat)
-		// Assistant Chat saving code will be called after output is done
-	}()
-
-	return output, done, err
+func (ss *AvatarCreateSession) HandleCharacterChat(ctx context.Context, userMessage string) <-chan tools.AssistantEvent {
+	events := ss.characterAssistant.HandleAsync(ss.track(ctx), userMessage)
+	// user and assistant chats are saved by the controller (SaveChat)
+	return events
 }
 
 // chat between user and voice assistant
-func (ss *AvatarCreateSession) HandleVoiceChat(userMessage string) (output chan string, done chan string, err chan error) {
-	output, done, err = ss.voiceAssistant.HandleAsync(userMessage)
-
-	go func() {
-		userChat := models.AvatarCreationChat{
-			AvatarCreationID:    ss.session.ID,
-			Role:                "user",
-			ObjectType:          "voice",
-			Content:             userMessage,
-			CreatedObjectNumber: len(ss.session.VoiceCreations),
-		}
-		ss.tools.DB.Create(&userChat)
-		// Assistant Chat saving code will be called after output is done
-	}()
-
-	return output, done, err
+func (ss *AvatarCreateSession) HandleVoiceChat(ctx context.Context, userMessage string) <-chan tools.AssistantEvent {
+	events := ss.voiceAssistant.HandleAsync(ss.track(ctx), userMessage)
+	// user and assistant chats are saved by the controller (SaveChat)
+	return events
 }
 
 func (ss *AvatarCreateSession) CanCreateNow(objectType string) bool {
@@ -440,19 +463,19 @@ func (ss *AvatarCreateSession) CanCreateNow(objectType string) bool {
 			return true
 		}
 		lastImage := ss.session.ImageCreations[len(ss.session.ImageCreations)-1]
-		return lastImage.Status == models.AC_Completed || lastImage.Status == models.AC_Failed
+		return lastImage.Status == models.AC_Completed || lastImage.Status == models.AC_Failed || lastImage.Status == models.AC_Cancelled
 	} else if objectType == "character" {
 		if len(ss.session.CharacterCreations) == 0 {
 			return true
 		}
 		lastCharacter := ss.session.CharacterCreations[len(ss.session.CharacterCreations)-1]
-		return lastCharacter.Status == models.AC_Completed || lastCharacter.Status == models.AC_Failed
+		return lastCharacter.Status == models.AC_Completed || lastCharacter.Status == models.AC_Failed || lastCharacter.Status == models.AC_Cancelled
 	} else if objectType == "voice" {
 		if len(ss.session.VoiceCreations) == 0 {
 			return true
 		}
 		lastVoice := ss.session.VoiceCreations[len(ss.session.VoiceCreations)-1]
-		return lastVoice.Status == models.AC_Completed || lastVoice.Status == models.AC_Failed
+		return lastVoice.Status == models.AC_Completed || lastVoice.Status == models.AC_Failed || lastVoice.Status == models.AC_Cancelled
 	}
 	return false
 }
@@ -460,24 +483,52 @@ func (ss *AvatarCreateSession) CanCreateNow(objectType string) bool {
 // create image from image chattings.
 // This function is called by image assistant (Reference: https://platform.openai.com/docs/guides/function-calling)
 //   - summary: the summary of avatar appearance (must be shorter than 270 characters)
+//
+// The channel gets every status change, and is closed once the creation is over (as for CreateCharacter and CreateVoice).
 func (ss *AvatarCreateSession) CreateImage(summary string) (<-chan models.AvatarImageCreation, error) {
 	if !ss.CanCreateNow("image") {
 		return nil, errors.New("image creation is blocked: the last image creation is not completed")
 	}
 
+	if err := ss.credits.Check(ss.userID, JT_AvatarImage); err != nil {
+		return nil, err
+	}
+
 	ss.mu.Lock()
 	imageCreationChan := make(chan models.AvatarImageCreation)
 	imageCreation := &models.AvatarImageCreation{
+		UserID:           ss.userID,
 		AvatarCreationID: ss.session.ID,
 		AvatarCreation:   *ss.session,
 		Prompt:           "",
 		Status:           models.AC_Ready,
 	}
-	ss.tools.DB.Create(&imageCreation)
+	err := ss.tools.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&imageCreation).Error; err != nil {
+			return err
+		}
+		return ss.credits.ReserveRefTx(tx, ss.userID, partRef(JT_AvatarImage, imageCreation.ID), JT_AvatarImage)
+	})
+	if err != nil {
+		ss.mu.Unlock()
+		return nil, err
+	}
 	ss.session.ImageCreations = append(ss.session.ImageCreations, imageCreation)
 	ss.mu.Unlock()
 
+	ctx, done := ss.startPart("image", imageCreation.ID)
+	ctx, painter := tools.WithUsedProvider(ctx)
 	go func() {
+		defer close(imageCreationChan)
+		defer done()
+		defer ss.refundUnlessCompleted(JT_AvatarImage, imageCreation.ID, &imageCreation.Status)
+		fail := func(err error) {
+			imageCreation.Status = failedStatus(ctx)
+			imageCreation.FailedReason = err.Error()
+			ss.savePart(imageCreation, &imageCreation.Status)
+			imageCreationChan <- *imageCreation
+		}
+
 		imagePrompt := ""
 		switch ss.session.ImageStyle {
 		case "realistic":
@@ -491,13 +542,10 @@ func (ss *AvatarCreateSession) CreateImage(summary string) (<-chan models.Avatar
 		imagePrompt += summary
 		// imagePrompt += ",white background"
 		// imagePrompt={style},{summary},white background
-		imagePrompt, err := ss.tools.Painter.EnhancePrompt(imagePrompt)
+		imagePrompt, err := ss.tools.Painter.EnhancePrompt(ctx, imagePrompt)
 		if err != nil {
 			log.Println("Failed to enhance image prompt:", err)
-			imageCreation.Status = models.AC_Failed
-			imageCreation.FailedReason = err.Error()
-			ss.tools.DB.Save(&imageCreation)
-			imageCreationChan <- *imageCreation
+			fail(err)
 			return
 		}
 		// image prompt done
@@ -506,39 +554,31 @@ func (ss *AvatarCreateSession) CreateImage(summary string) (<-chan models.Avatar
 		imageCreation.Prompt = imagePrompt
 		// request to painter
 		imageCreationChan <- *imageCreation
-		imageBytes, mimeType, err := ss.tools.Painter.Paint(imagePrompt, "", 682, 1024)
+		imageBytes, mimeType, err := ss.tools.Painter.Paint(ctx, imagePrompt, "", 682, 1024)
 		if err != nil {
 			log.Println("Failed to paint image:", err)
-			imageCreation.Status = models.AC_Failed
-			imageCreation.FailedReason = err.Error()
-			ss.tools.DB.Save(&imageCreation)
-			imageCreationChan <- *imageCreation
+			fail(err)
 			return
 		}
 
 		extension, err := utils.GetExtensionFromMimeType(mimeType)
 		if err != nil {
 			log.Println("Failed to get extension from mime type:", err)
-			imageCreation.Status = models.AC_Failed
-			imageCreation.FailedReason = err.Error()
-			ss.tools.DB.Save(&imageCreation)
-			imageCreationChan <- *imageCreation
+			fail(err)
 			return
 		}
 		fileName := fmt.Sprintf("%s_image_%d%s", imageCreation.AvatarCreationID, imageCreation.ID, extension)
-		imageURL, err := ss.tools.S3Service.UploadPublicFile(context.TODO(), fileName, imageBytes, mimeType)
+		imageURL, err := ss.tools.Storage.Upload(ctx, fileName, imageBytes, mimeType)
 		if err != nil {
 			log.Println("Failed to upload image to S3:", err)
-			imageCreation.Status = models.AC_Failed
-			imageCreation.FailedReason = err.Error()
-			ss.tools.DB.Save(&imageCreation)
-			imageCreationChan <- *imageCreation
+			fail(err)
 			return
 		}
 
 		imageCreation.ImageURL = imageURL
+		imageCreation.Provider = painter.Name()
 		imageCreation.Status = models.AC_Completed
-		ss.tools.DB.Save(&imageCreation)
+		ss.savePart(imageCreation, &imageCreation.Status)
 		imageCreationChan <- *imageCreation
 	}()
 
@@ -563,7 +603,7 @@ func (ss *AvatarCreateSession) CreateCharacter() (<-chan models.AvatarCharacterC
 
 	var characterChats []models.AvatarCreationChat
 	ss.tools.DB.Where(
-		"avatar_creation_id=? AND object_type=? AND object_created_number=?",
+		"avatar_creation_id=? AND object_type=? AND created_object_number=?",
 		ss.session.ID,
 		"character",
 		len(ss.session.CharacterCreations),
@@ -576,6 +616,7 @@ func (ss *AvatarCreateSession) CreateCharacter() (<-chan models.AvatarCharacterC
 
 	characterCreationChan := make(chan models.AvatarCharacterCreation)
 	characterCreation := &models.AvatarCharacterCreation{
+		UserID:           ss.userID,
 		AvatarCreationID: ss.session.ID,
 		AvatarCreation:   *ss.session,
 		Status:           models.AC_Ready,
@@ -583,33 +624,36 @@ func (ss *AvatarCreateSession) CreateCharacter() (<-chan models.AvatarCharacterC
 	ss.tools.DB.Create(characterCreation)
 	ss.session.CharacterCreations = append(ss.session.CharacterCreations, characterCreation)
 
+	ctx, done := ss.startPart("character", characterCreation.ID)
 	go func() {
+		defer close(characterCreationChan)
+		defer done()
 		characterCreation.Status = models.AC_Processing
 		characterCreation.Prompt = reqInputStr
 		characterCreationChan <- *characterCreation
-		ss.tools.DB.Save(characterCreation)
+		ss.savePart(characterCreation, &characterCreation.Status)
 
 		// generate character
 		var result string
 		var err error
 		if editFlag {
-			result, err = ss.tools.PromptService.Use(AG_AvatarCharacterEdit, reqInputStr)
+			result, err = ss.tools.PromptService.Use(ctx, AG_AvatarCharacterEdit, reqInputStr)
 		} else {
-			result, err = ss.tools.PromptService.Use(AG_AvatarCharacterCreation, reqInputStr)
+			result, err = ss.tools.PromptService.Use(ctx, AG_AvatarCharacterCreation, reqInputStr)
 		}
 
 		if err != nil {
 			log.Println("Failed to create character:", err)
-			characterCreation.Status = models.AC_Failed
+			characterCreation.Status = failedStatus(ctx)
 			characterCreation.FailedReason = err.Error()
-			ss.tools.DB.Save(characterCreation)
+			ss.savePart(characterCreation, &characterCreation.Status)
 			characterCreationChan <- *characterCreation
 			return
 		}
 
 		characterCreation.Content = result
 		characterCreation.Status = models.AC_Completed
-		ss.tools.DB.Save(characterCreation)
+		ss.savePart(characterCreation, &characterCreation.Status)
 		characterCreationChan <- *characterCreation
 	}()
 
@@ -623,6 +667,9 @@ func (ss *AvatarCreateSession) CreateVoice(summary string, gender string, accent
 	if !ss.CanCreateNow("voice") {
 		return nil, errors.New("voice creation is blocked: the last voice creation is not completed")
 	}
+	if err := ss.credits.Check(ss.userID, JT_AvatarVoice); err != nil {
+		return nil, err
+	}
 
 	reqInputStr := ""
 	editFlag := len(ss.session.VoiceCreations) > 0
@@ -635,7 +682,7 @@ func (ss *AvatarCreateSession) CreateVoice(summary string, gender string, accent
 
 	var voiceChats []models.AvatarCreationChat
 	ss.tools.DB.Where(
-		"avatar_creation_id=? AND object_type=? AND object_created_number=?",
+		"avatar_creation_id=? AND object_type=? AND created_object_number=?",
 		ss.session.ID,
 		"voice",
 		len(ss.session.VoiceCreations),
@@ -648,80 +695,223 @@ func (ss *AvatarCreateSession) CreateVoice(summary string, gender string, accent
 
 	voiceCreationChan := make(chan models.AvatarVoiceCreation)
 	voiceCreation := &models.AvatarVoiceCreation{
+		UserID:           ss.userID,
 		AvatarCreationID: ss.session.ID,
 		AvatarCreation:   *ss.session,
 		Status:           models.AC_Ready,
 	}
-	ss.tools.DB.Create(voiceCreation)
+	err := ss.tools.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(voiceCreation).Error; err != nil {
+			return err
+		}
+		return ss.credits.ReserveRefTx(tx, ss.userID, partRef(JT_AvatarVoice, voiceCreation.ID), JT_AvatarVoice)
+	})
+	if err != nil {
+		return nil, err
+	}
 	ss.session.VoiceCreations = append(ss.session.VoiceCreations, voiceCreation)
 
+	ctx, done := ss.startPart("voice", voiceCreation.ID)
 	go func() {
+		defer close(voiceCreationChan)
+		defer done()
+		defer ss.refundUnlessCompleted(JT_AvatarVoice, voiceCreation.ID, &voiceCreation.Status)
+		fail := func(err error) {
+			voiceCreation.Status = failedStatus(ctx)
+			voiceCreation.FailedReason = err.Error()
+			ss.savePart(voiceCreation, &voiceCreation.Status)
+			voiceCreationChan <- *voiceCreation
+		}
+
 		voiceCreation.Status = models.AC_Processing
 		voiceCreationChan <- *voiceCreation
-		ss.tools.DB.Save(voiceCreation)
+		ss.savePart(voiceCreation, &voiceCreation.Status)
 
 		// 1. generate voice prompt
 		var prompt string
 		var err error
 		if editFlag {
-			prompt, err = ss.tools.PromptService.Use(AG_AvatarVoiceEdit, reqInputStr)
+			prompt, err = ss.tools.PromptService.Use(ctx, AG_AvatarVoiceEdit, reqInputStr)
 		} else {
-			prompt, err = ss.tools.PromptService.Use(AG_AvatarVoiceCreation, reqInputStr)
+			prompt, err = ss.tools.PromptService.Use(ctx, AG_AvatarVoiceCreation, reqInputStr)
 		}
 		if err != nil {
 			log.Println("Failed to create voice:", err)
-			voiceCreation.Status = models.AC_Failed
-			voiceCreation.FailedReason = err.Error()
-			ss.tools.DB.Save(voiceCreation)
-			voiceCreationChan <- *voiceCreation
+			fail(err)
 			return
 		}
 		voiceCreation.Prompt = prompt
 
 		// 2. generate voice
-		_, voiceId, err := ss.tools.VoiceActor.Create(voiceCreation.Prompt, models.Gender(gender), accentStrength, age, accent)
+		provider, voiceId, err := ss.tools.VoiceActor.Create(ctx, voiceCreation.Prompt, models.Gender(gender), accentStrength, age, accent)
 		if err != nil {
 			log.Println("Failed to create voice:", err)
-			voiceCreation.Status = models.AC_Failed
-			voiceCreation.FailedReason = err.Error()
-			ss.tools.DB.Save(voiceCreation)
-			voiceCreationChan <- *voiceCreation
+			fail(err)
 			return
 		}
 
 		// 3. create TTS and save to S3
-		introduction, err := ss.tools.PromptService.Use(AG_AvatarIntroduce, ss.session.GetBasicInfo())
+		introduction, err := ss.tools.PromptService.Use(ctx, AG_AvatarIntroduce, ss.session.GetBasicInfo())
 		if err != nil {
 			log.Println("Failed to create introduction:", err)
 			introduction = "Hello! I am your avatar. How are you?"
 		}
-		voiceBytes, err := ss.tools.VoiceActor.TTS(voiceId, introduction)
+		voiceBytes, err := ss.tools.VoiceActor.TTS(ctx, voiceId, introduction)
 		if err != nil {
 			log.Println("Failed to create voice:", err)
-			voiceCreation.Status = models.AC_Failed
-			voiceCreation.FailedReason = err.Error()
-			ss.tools.DB.Save(voiceCreation)
-			voiceCreationChan <- *voiceCreation
+			fail(err)
 			return
 		}
 		fileName := fmt.Sprintf("%s_voice_%d.%s", ss.session.ID, voiceCreation.ID, "mp3")
-		voiceURL, err := ss.tools.S3Service.UploadPublicFile(context.TODO(), fileName, voiceBytes, "audio/mpeg")
+		voiceURL, err := ss.tools.Storage.Upload(ctx, fileName, voiceBytes, "audio/mpeg")
 		if err != nil {
 			log.Println("Failed to upload voice to S3:", err)
-			voiceCreation.Status = models.AC_Failed
-			voiceCreation.FailedReason = err.Error()
-			ss.tools.DB.Save(voiceCreation)
-			voiceCreationChan <- *voiceCreation
+			fail(err)
+			return
 		}
 		voiceCreation.VoiceURL = voiceURL
+		voiceCreation.Provider = provider
 		voiceCreation.Status = models.AC_Completed
-		ss.tools.DB.Save(voiceCreation)
+		ss.savePart(voiceCreation, &voiceCreation.Status)
 		voiceCreationChan <- *voiceCreation
 	}()
 
 	return voiceCreationChan, nil
 }
 
+// partKey names an image, character or voice creation in AvatarCreateSession.parts
+func partKey(objectType string, id int) string {
+	return fmt.Sprintf("%s:%d", objectType, id)
+}
+
+// startPart gives the context of a creation started in the session, cancelled by cancelPart or with the session.
+// done must be called once the creation is over.
+func (ss *AvatarCreateSession) startPart(objectType string, id int) (ctx context.Context, done func()) {
+	ctx, cancel := context.WithCancel(ss.ctx)
+	key := partKey(objectType, id)
+	ss.mu.Lock()
+	ss.parts[key] = cancel
+	ss.mu.Unlock()
+	return ctx, func() {
+		ss.mu.Lock()
+		delete(ss.parts, key)
+		ss.mu.Unlock()
+		cancel()
+	}
+}
+
+func (ss *AvatarCreateSession) cancelPart(objectType string, id int) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if cancel, ok := ss.parts[partKey(objectType, id)]; ok {
+		cancel()
+	}
+}
+
+// partRef names the credit reservation of an image or voice creation of a chat session (see CreditService.ReserveRefTx)
+func partRef(jobType string, id int) string {
+	return fmt.Sprintf("%s:%d", jobType, id)
+}
+
+// refundUnlessCompleted refunds the creation once it ended failed or cancelled
+func (ss *AvatarCreateSession) refundUnlessCompleted(jobType string, id int, status *models.AvatarCreationStatus) {
+	if *status == models.AC_Failed || *status == models.AC_Cancelled {
+		ss.credits.RefundRef(partRef(jobType, id))
+	}
+}
+
+// savePart saves a creation of the session; if it was cancelled meanwhile, only its status is updated (to cancelled)
+func (ss *AvatarCreateSession) savePart(part interface{}, status *models.AvatarCreationStatus) {
+	saved, err := ss.tools.savePart(part)
+	if err != nil {
+		log.Println("Failed to save creation:", err)
+	} else if !saved {
+		*status = models.AC_Cancelled
+	}
+}
+
+// saveJobPart saves a creation made by a job, or stops the job if the creation was cancelled
+func (t *AvatarCreateTools) saveJobPart(part interface{}) error {
+	saved, err := t.savePart(part)
+	if err == nil && !saved {
+		return ErrJobCancelled
+	}
+	return err
+}
+
+// savePart saves an image, character or voice creation unless it was cancelled
+func (t *AvatarCreateTools) savePart(part interface{}) (bool, error) {
+	result := t.DB.Model(part).Omit(clause.Associations).Select("*").
+		Where("status <> ?", models.AC_Cancelled).
+		Updates(part)
+	return result.RowsAffected > 0, result.Error
+}
+
+// failedStatus is the status of a creation stopped by an error: cancelled if ctx was (by the user, or with the session)
+func failedStatus(ctx context.Context) models.AvatarCreationStatus {
+	if ctx.Err() != nil {
+		return models.AC_Cancelled
+	}
+	return models.AC_Failed
+}
+
+// CancelImage stops an image creation which is not done yet
+func (s *AvatarCreateService) CancelImage(userID string, creationID string, imageID int) error {
+	return s.cancelPart(userID, creationID, "image", &models.AvatarImageCreation{}, imageID, JT_AvatarImage)
+}
+
+// CancelCharacter stops a character creation which is not done yet
+func (s *AvatarCreateService) CancelCharacter(userID string, creationID string, characterID int) error {
+	return s.cancelPart(userID, creationID, "character", &models.AvatarCharacterCreation{}, characterID, "")
+}
+
+// CancelVoice stops a voice creation which is not done yet
+func (s *AvatarCreateService) CancelVoice(userID string, creationID string, voiceID int) error {
+	return s.cancelPart(userID, creationID, "voice", &models.AvatarVoiceCreation{}, voiceID, JT_AvatarVoice)
+}
+
+// cancelPart marks the creation cancelled, then stops its job (if made by request) or its goroutine (if made in a chat session)
+func (s *AvatarCreateService) cancelPart(userID string, creationID string, objectType string, model interface{}, partID int, jobType string) error {
+	var avatarCreation models.AvatarCreation
+	if err := s.tools.DB.First(&avatarCreation, "id=?", creationID).Error; err != nil {
+		return err
+	}
+	if avatarCreation.UserID != userID {
+		return errs.ErrNotFound
+	}
+
+	result := s.tools.DB.Model(model).
+		Where("id = ? AND avatar_creation_id = ? AND status IN ?", partID, creationID, []models.AvatarCreationStatus{models.AC_Ready, models.AC_Processing}).
+		Updates(map[string]interface{}{"status": models.AC_Cancelled, "failed_reason": "cancelled by the user"})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		// missing, or already over
+		if err := s.tools.DB.Where("id = ? AND avatar_creation_id = ?", partID, creationID).First(model).Error; err != nil {
+			return err
+		}
+		return errs.ErrCreationNotCancellable
+	}
+
+	if jobType != "" {
+		if _, err := s.jobs.Cancel(strconv.Itoa(partID), jobType); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	session, ok := s.sessions[creationID]
+	s.mu.Unlock()
+	if ok {
+		session.cancelPart(objectType, partID)
+	}
+	return nil
+}
+
+type imageJobPayload struct {
+	Prompt string `json:"prompt"` // user's request, before enhancing
+}
+
 func (s *AvatarCreateService) CreateImageByRequest(userID string, creationID string, userReq string) error {
 	var avatarCreation models.AvatarCreation
 	s.tools.DB.First(&avatarCreation, "id=?", creationID)
@@ -729,71 +919,82 @@ func (s *AvatarCreateService) CreateImageByRequest(userID string, creationID str
 		return errs.ErrNotFound
 	}
 	imageCreation := &models.AvatarImageCreation{
+		UserID:           userID,
 		AvatarCreationID: creationID,
 		AvatarCreation:   avatarCreation,
 		Prompt:           userReq,
 		Status:           models.AC_Ready,
 	}
-	s.tools.DB.Create(&imageCreation)
-
-	go func() {
-		imagePrompt := userReq
-		imagePrompt, err := s.tools.Painter.EnhancePrompt(imagePrompt)
+	return s.tools.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&imageCreation).Error; err != nil {
+			return err
+		}
+		job, err := s.jobs.EnqueueTx(tx, JT_AvatarImage, strconv.Itoa(imageCreation.ID), imageJobPayload{Prompt: userReq})
 		if err != nil {
-			log.Println("Failed to enhance image prompt:", err)
-			imageCreation.Status = models.AC_Failed
-			imageCreation.FailedReason = err.Error()
-			s.tools.DB.Save(&imageCreation)
-			return
+			return err
 		}
+		return s.credits.ReserveTx(tx, userID, job, JT_AvatarImage)
+	})
+}
 
-		imageCreation.Status = models.AC_Processing
-		imageCreation.Prompt = imagePrompt
-		s.tools.DB.Save(&imageCreation)
+func (s *AvatarCreateService) runImageJob(ctx context.Context, job *models.Job) error {
+	var payload imageJobPayload
+	if err := decodeJobPayload(job, &payload); err != nil {
+		return err
+	}
+	var imageCreation models.AvatarImageCreation
+	if err := loadJobRef(s.tools.DB, &imageCreation, job); err != nil {
+		return err
+	}
+	ctx, painter := tools.WithUsedProvider(s.tools.track(ctx, imageCreation.UserID, imageCreation.AvatarCreationID))
 
-		imageBytes, mimeType, err := s.tools.Painter.Paint(imagePrompt, "", 682, 1024)
-		if err != nil {
-			log.Println("Failed to paint image:", err)
-			imageCreation.Status = models.AC_Failed
-			imageCreation.FailedReason = err.Error()
-			s.tools.DB.Save(&imageCreation)
-			return
-		}
+	imagePrompt, err := s.tools.Painter.EnhancePrompt(ctx, payload.Prompt)
+	if err != nil {
+		return fmt.Errorf("failed to enhance image prompt: %w", err)
+	}
 
-		extension, err := utils.GetExtensionFromMimeType(mimeType)
-		if err != nil {
-			log.Println("Failed to get extension from mime type:", err)
-			imageCreation.Status = models.AC_Failed
-			imageCreation.FailedReason = err.Error()
-			s.tools.DB.Save(&imageCreation)
-			return
-		}
-		fileName := fmt.Sprintf("%s_image_%d%s", imageCreation.AvatarCreationID, imageCreation.ID, extension)
-		imageURL, err := s.tools.S3Service.UploadPublicFile(context.TODO(), fileName, imageBytes, mimeType)
-		if err != nil {
-			log.Println("Failed to upload image to S3:", err)
-			imageCreation.Status = models.AC_Failed
-			imageCreation.FailedReason = err.Error()
-			s.tools.DB.Save(&imageCreation)
-			return
-		}
+	imageCreation.Status = models.AC_Processing
+	imageCreation.Prompt = imagePrompt
+	if err := s.tools.saveJobPart(&imageCreation); err != nil {
+		return err
+	}
 
-		imageCreation.ImageURL = imageURL
-		imageCreation.Status = models.AC_Completed
-		s.tools.DB.Save(&imageCreation)
-	}()
+	imageBytes, mimeType, err := s.tools.Painter.Paint(ctx, imagePrompt, "", 682, 1024)
+	if err != nil {
+		return fmt.Errorf("failed to paint image: %w", err)
+	}
 
-	return nil
+	extension, err := utils.GetExtensionFromMimeType(mimeType)
+	if err != nil {
+		return Permanent(err)
+	}
+	fileName := fmt.Sprintf("%s_image_%d%s", imageCreation.AvatarCreationID, imageCreation.ID, extension)
+	imageURL, err := s.tools.Storage.Upload(ctx, fileName, imageBytes, mimeType)
+	if err != nil {
+		return fmt.Errorf("failed to upload image: %w", err)
+	}
+
+	imageCreation.ImageURL = imageURL
+	imageCreation.Provider = painter.Name()
+	imageCreation.Status = models.AC_Completed
+	return s.tools.saveJobPart(&imageCreation)
 }
 
-func (s *AvatarCreateService) CreateCharacterByRequest(userID string, creationID string, userReq string) error {
+func (s *AvatarCreateService) onImageJobFailed(job *models.Job, err error) {
+	if dbErr := s.tools.DB.Model(&models.AvatarImageCreation{}).Where("id = ? AND status <> ?", job.RefID, models.AC_Cancelled).
+		Updates(map[string]interface{}{"status": models.AC_Failed, "failed_reason": err.Error()}).Error; dbErr != nil {
+		log.Println("Failed to update image creation status:", dbErr)
+	}
+}
+
+func (s *AvatarCreateService) CreateCharacterByRequest(ctx context.Context, userID string, creationID string, userReq string) error {
 	var avatarCreation models.AvatarCreation
 	s.tools.DB.First(&avatarCreation, "id=?", creationID)
 	if avatarCreation.UserID != userID {
 		return errs.ErrNotFound
 	}
 
-	characterPrompt, err := s.tools.PromptService.Use(AG_AvatarCharacterCreation, userReq)
+	characterPrompt, err := s.tools.PromptService.Use(s.tools.track(ctx, userID, creationID), AG_AvatarCharacterCreation, userReq)
 	if err != nil {
 		return err
 	}
@@ -824,62 +1025,147 @@ func (s *AvatarCreateService) CreateVoiceByRequest(userID string, creationID str
 		AvatarCreation:   avatarCreation,
 		Status:           models.AC_Ready,
 	}
-
-	go func() {
-		voiceCreation.Status = models.AC_Processing
-		s.tools.DB.Save(voiceCreation)
-
-		// 1. generate voice prompt
-		var prompt string
-		var err error
-		prompt, err = s.tools.PromptService.Use(AG_AvatarVoiceCreation, req.Summary)
-		if err != nil {
-			log.Println("Failed to create voice:", err)
-			voiceCreation.Status = models.AC_Failed
-			voiceCreation.FailedReason = err.Error()
-			s.tools.DB.Save(voiceCreation)
-			return
+	return s.tools.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(voiceCreation).Error; err != nil {
+			return err
 		}
-		voiceCreation.Prompt = prompt
-
-		// 2. generate voice
-		_, voiceId, err := s.tools.VoiceActor.Create(voiceCreation.Prompt, models.Gender(req.Gender), req.AccentStrength, req.Age, req.Accent)
+		job, err := s.jobs.EnqueueTx(tx, JT_AvatarVoice, strconv.Itoa(voiceCreation.ID), req)
 		if err != nil {
-			log.Println("Failed to create voice:", err)
-			voiceCreation.Status = models.AC_Failed
-			voiceCreation.FailedReason = err.Error()
-			s.tools.DB.Save(voiceCreation)
-			return
+			return err
 		}
+		return s.credits.ReserveTx(tx, userID, job, JT_AvatarVoice)
+	})
+}
 
-		// 3. create TTS and save to S3
-		introduction, err := s.tools.PromptService.Use(AG_AvatarIntroduce, avatarCreation.GetBasicInfo())
-		if err != nil {
-			log.Println("Failed to create introduction:", err)
-			introduction = "Hello! I am your avatar. How are you?"
+func (s *AvatarCreateService) runVoiceJob(ctx context.Context, job *models.Job) error {
+	var req dto.AvatarVoiceCreationRequest
+	if err := decodeJobPayload(job, &req); err != nil {
+		return err
+	}
+	var voiceCreation models.AvatarVoiceCreation
+	if err := loadJobRef(s.tools.DB, &voiceCreation, job); err != nil {
+		return err
+	}
+	var avatarCreation models.AvatarCreation
+	if err := s.tools.DB.First(&avatarCreation, "id=?", voiceCreation.AvatarCreationID).Error; err != nil {
+		return err
+	}
+
+	voiceCreation.Status = models.AC_Processing
+	if err := s.tools.saveJobPart(&voiceCreation); err != nil {
+		return err
+	}
+
+	// 1. generate voice prompt
+	ctx = s.tools.track(ctx, voiceCreation.UserID, voiceCreation.AvatarCreationID)
+	prompt, err := s.tools.PromptService.Use(ctx, AG_AvatarVoiceCreation, req.Summary)
+	if err != nil {
+		return fmt.Errorf("failed to create voice prompt: %w", err)
+	}
+	voiceCreation.Prompt = prompt
+
+	// 2. generate voice
+	provider, voiceId, err := s.tools.VoiceActor.Create(ctx, voiceCreation.Prompt, req.Gender, req.AccentStrength, req.Age, req.Accent)
+	if err != nil {
+		return fmt.Errorf("failed to create voice: %w", err)
+	}
+
+	// 3. create TTS and save to S3
+	introduction, err := s.tools.PromptService.Use(ctx, AG_AvatarIntroduce, avatarCreation.GetBasicInfo())
+	if err != nil {
+		log.Println("Failed to create introduction:", err)
+		introduction = "Hello! I am your avatar. How are you?"
+	}
+	voiceBytes, err := s.tools.VoiceActor.TTS(ctx, voiceId, introduction)
+	if err != nil {
+		return fmt.Errorf("failed to create voice: %w", err)
+	}
+	fileName := fmt.Sprintf("%s_voice_%d.%s", voiceCreation.AvatarCreationID, voiceCreation.ID, "mp3")
+	voiceURL, err := s.tools.Storage.Upload(ctx, fileName, voiceBytes, "audio/mpeg")
+	if err != nil {
+		return fmt.Errorf("failed to upload voice: %w", err)
+	}
+	voiceCreation.VoiceURL = voiceURL
+	voiceCreation.Provider = provider
+	voiceCreation.Status = models.AC_Completed
+	return s.tools.saveJobPart(&voiceCreation)
+}
+
+func (s *AvatarCreateService) onVoiceJobFailed(job *models.Job, err error) {
+	if dbErr := s.tools.DB.Model(&models.AvatarVoiceCreation{}).Where("id = ? AND status <> ?", job.RefID, models.AC_Cancelled).
+		Updates(map[string]interface{}{"status": models.AC_Failed, "failed_reason": err.Error()}).Error; dbErr != nil {
+		log.Println("Failed to update voice creation status:", dbErr)
+	}
+}
+
+// renders the chat video of a newly created avatar
+func (s *AvatarCreateService) runChatVideoJob(ctx context.Context, job *models.Job) error {
+	var avatar models.Avatar
+	if err := loadJobRef(s.tools.DB, &avatar, job); err != nil {
+		return err
+	}
+
+	ctx = s.tools.Usage.Track(ctx, UsageOwner{UserID: avatar.UserID, AvatarID: avatar.ID, CreationType: UC_AvatarCreation, CreationID: avatar.AvatarCreationID})
+	video, err := s.tools.VideoProducer.Create(ctx, avatar.ProfileImageURL, string(AG_AvatarChatVideoPrompt))
+	if err != nil {
+		return err
+	}
+
+	fileName := fmt.Sprintf("%s_chat.mp4", avatar.ID)
+	videoURL, err := s.tools.Storage.Upload(ctx, fileName, video, "video/mp4")
+	if err != nil {
+		return err
+	}
+
+	return s.tools.DB.Model(&avatar).Update("avatar_video_url", videoURL).Error
+}
+
+// recoverStuckCreations fails the image, character and voice creations left ready or processing.
+// Those made in a chat session don't run as jobs, so they only count as stuck after abandonedCreationAge.
+func (s *AvatarCreateService) recoverStuckCreations() error {
+	checks := []struct {
+		model   interface{}
+		jobType string
+	}{
+		{&models.AvatarImageCreation{}, JT_AvatarImage},
+		{&models.AvatarCharacterCreation{}, ""},
+		{&models.AvatarVoiceCreation{}, JT_AvatarVoice},
+	}
+	pending := []models.AvatarCreationStatus{models.AC_Ready, models.AC_Processing}
+	for _, check := range checks {
+		var ids []int
+		if err := s.tools.DB.Model(check.model).
+			Where("status IN ? AND created_at < ?", pending, time.Now().Add(-abandonedCreationAge)).
+			Pluck("id", &ids).Error; err != nil {
+			return err
 		}
-		voiceBytes, err := s.tools.VoiceActor.TTS(voiceId, introduction)
-		if err != nil {
-			log.Println("Failed to create voice:", err)
-			voiceCreation.Status = models.AC_Failed
-			voiceCreation.FailedReason = err.Error()
-			s.tools.DB.Save(voiceCreation)
-			return
+		refIDs := make([]string, len(ids))
+		for i, id := range ids {
+			refIDs[i] = strconv.Itoa(id)
 		}
-		fileName := fmt.Sprintf("%s_voice_%d.%s", voiceCreation.AvatarCreationID, voiceCreation.ID, "mp3")
-		voiceURL, err := s.tools.S3Service.UploadPublicFile(context.TODO(), fileName, voiceBytes, "audio/mpeg")
-		if err != nil {
-			log.Println("Failed to upload voice to S3:", err)
-			voiceCreation.Status = models.AC_Failed
-			voiceCreation.FailedReason = err.Error()
-			s.tools.DB.Save(voiceCreation)
-			return
+		orphaned := refIDs
+		if check.jobType != "" {
+			var err error
+			if orphaned, err = s.jobs.Orphaned(check.jobType, refIDs); err != nil {
+				return err
+			}
 		}
-		voiceCreation.VoiceURL = voiceURL
-		voiceCreation.Status = models.AC_Completed
-		s.tools.DB.Save(voiceCreation)
-	}()
-
+		if len(orphaned) == 0 {
+			continue
+		}
+		log.Printf("Marking %d stuck %T row(s) as failed", len(orphaned), check.model)
+		if err := s.tools.DB.Model(check.model).
+			Where("id IN ? AND status IN ?", orphaned, pending).
+			Updates(map[string]interface{}{"status": models.AC_Failed, "failed_reason": "interrupted by a server restart"}).Error; err != nil {
+			return err
+		}
+		if check.jobType != "" {
+			// the chat session ones were paid for without a job (the job ones are refunded by the queue)
+			for _, id := range orphaned {
+				s.credits.RefundRef(check.jobType + ":" + id)
+			}
+		}
+	}
 	return nil
 }
 
@@ -895,36 +1181,52 @@ func (ss *AvatarCreateSession) EditVoice() error {
 	return nil
 }
 
-func (ss *AvatarCreateSession) ResponseAfterToolCalled(objectType string, message string, toolCallName string, toolCallArguments string, toolCallId string) (output chan string, done chan string, err chan error) {
-	go func() {
-		toolReq := models.AvatarCreationChat{
-			AvatarCreationID:  ss.session.ID,
-			Role:              "assistant",
-			ObjectType:        objectType,
-			Content:           "",
-			ToolCallId:        toolCallId,
-			ToolCallName:      toolCallName,
-			ToolCallArguments: toolCallArguments,
-		}
-		ss.tools.DB.Create(&toolReq)
-		toolChat := models.AvatarCreationChat{
-			AvatarCreationID: ss.session.ID,
-			Role:             "tool",
-			ObjectType:       objectType,
-			Content:          message,
-		}
-		ss.tools.DB.Create(&toolChat)
-	}()
+// ResponseAfterToolCalled saves the tool calls of the assistant with their results, and streams its next answer
+func (ss *AvatarCreateSession) ResponseAfterToolCalled(ctx context.Context, objectType string, results []tools.ToolResult) <-chan tools.AssistantEvent {
+	var assistant tools.Assistant
 	switch objectType {
 	case "image":
-		return ss.imageAssistant.HandleAsync(message, "tool", toolCallId, toolCallName, toolCallArguments)
+		assistant = ss.imageAssistant
 	case "character":
-		return ss.characterAssistant.HandleAsync(message, "tool", toolCallId, toolCallName, toolCallArguments)
+		assistant = ss.characterAssistant
 	case "voice":
-		return ss.voiceAssistant.HandleAsync(message, "tool", toolCallId, toolCallName, toolCallArguments)
+		assistant = ss.voiceAssistant
 	default:
-		return nil, nil, nil
+		return nil
+	}
+
+	// saved before the assistant answers, so the pairs stay in order in the history
+	createdObjectNumber, countErr := ss.tools.countCreations(ss.session.ID, objectType)
+	if countErr != nil {
+		log.Println("Failed to count creations:", countErr)
+	}
+	for _, result := range results {
+		toolReq := models.AvatarCreationChat{
+			AvatarCreationID:    ss.session.ID,
+			Role:                "assistant",
+			ObjectType:          objectType,
+			Content:             "",
+			CreatedObjectNumber: createdObjectNumber,
+			ToolCallId:          result.Call.ID,
+			ToolCallName:        result.Call.Name,
+			ToolCallArguments:   result.Call.Arguments,
+		}
+		if err := ss.tools.DB.Create(&toolReq).Error; err != nil {
+			log.Println("Failed to save tool call:", err)
+		}
+		toolChat := models.AvatarCreationChat{
+			AvatarCreationID:    ss.session.ID,
+			Role:                "tool",
+			ObjectType:          objectType,
+			Content:             result.Content,
+			CreatedObjectNumber: createdObjectNumber,
+			ToolCallId:          result.Call.ID,
+		}
+		if err := ss.tools.DB.Create(&toolChat).Error; err != nil {
+			log.Println("Failed to save tool result:", err)
+		}
 	}
+	return assistant.HandleToolResults(ss.track(ctx), results)
 }
 
 func (ss *AvatarCreateSession) Confirm() {
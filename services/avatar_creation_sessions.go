@@ -0,0 +1,197 @@
+package services
+
+import (
+	"avazon-api/controllers/errs"
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrSessionClosed is the close reason of the sockets of a session closed by the user
+var ErrSessionClosed = errors.New("session closed")
+
+// AvatarSessionConfig limits the in-memory avatar creation sessions.
+type AvatarSessionConfig struct {
+	IdleTTL            time.Duration // a session without chats for this long is evicted and its sockets closed
+	MaxSessionsPerUser int
+	MaxSocketsPerUser  int
+}
+
+// SessionSocket is a client connection (websocket) attached to an AvatarCreateSession.
+type SessionSocket interface {
+	// Close tells the client why (errs.ErrSessionExpired, ...) and closes the connection
+	Close(reason error)
+}
+
+// EnterSession attaches socket to the creation session of the user, loading the session if needed.
+// Fails with errs.ErrTooManySessions or errs.ErrTooManySockets when the user holds too many of them.
+func (s *AvatarCreateService) EnterSession(userID string, sessionID string, socket SessionSocket) (*AvatarCreateSession, error) {
+	s.mu.Lock()
+	session, ok := s.sessions[sessionID]
+	s.mu.Unlock()
+	// if not exists, create new room (outside the lock, it reads DB)
+	if !ok {
+		var err error
+		if session, err = s.openSession(userID, sessionID); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, loaded := s.sessions[sessionID]
+	if loaded {
+		if session != existing {
+			session.cancel() // may have been opened by another socket meanwhile
+		}
+		session = existing
+	}
+	if session.userID != userID {
+		return nil, errs.ErrForbidden
+	}
+	if s.countSockets(userID) >= s.sessionCfg.MaxSocketsPerUser {
+		if !loaded {
+			session.cancel()
+		}
+		return nil, errs.ErrTooManySockets
+	}
+	if !loaded {
+		if err := s.makeRoomFor(userID); err != nil {
+			session.cancel()
+			return nil, err
+		}
+		s.sessions[sessionID] = session
+	}
+	session.sockets[socket] = struct{}{}
+
+	session.mu.Lock()
+	session.enteredAt = time.Now()
+	session.updatedAt = session.enteredAt
+	session.mu.Unlock()
+	return session, nil
+}
+
+// LeaveSession detaches a disconnected socket. The session stays loaded until it gets idle.
+func (s *AvatarCreateService) LeaveSession(session *AvatarCreateSession, socket SessionSocket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(session.sockets, socket)
+}
+
+// CloseSession evicts the session, stops the creations running in it and closes every socket attached to it
+func (s *AvatarCreateService) CloseSession(userID string, sessionID string) {
+	s.mu.Lock()
+	session, ok := s.sessions[sessionID]
+	if !ok || session.userID != userID {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.sessions, sessionID)
+	session.cancel()
+	sockets := session.detachAll()
+	s.mu.Unlock()
+
+	for _, socket := range sockets {
+		socket.Close(ErrSessionClosed)
+	}
+}
+
+// StartReaper evicts the sessions idle for longer than IdleTTL, until ctx is done
+func (s *AvatarCreateService) StartReaper(ctx context.Context) {
+	interval := min(s.sessionCfg.IdleTTL/4, time.Minute)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				s.reapIdleSessions(now)
+			}
+		}
+	}()
+}
+
+func (s *AvatarCreateService) reapIdleSessions(now time.Time) {
+	var sockets []SessionSocket
+	s.mu.Lock()
+	for id, session := range s.sessions {
+		if now.Sub(session.lastActive()) < s.sessionCfg.IdleTTL {
+			continue
+		}
+		delete(s.sessions, id)
+		session.cancel()
+		sockets = append(sockets, session.detachAll()...)
+	}
+	s.mu.Unlock()
+
+	if len(sockets) > 0 {
+		log.Printf("Closing %d idle avatar creation socket(s)", len(sockets))
+	}
+	// closing writes to the network, so not under the lock
+	for _, socket := range sockets {
+		socket.Close(errs.ErrSessionExpired)
+	}
+}
+
+// makeRoomFor evicts the least recently used session of the user without any socket,
+// when the user already holds MaxSessionsPerUser sessions. (s.mu must be held)
+func (s *AvatarCreateService) makeRoomFor(userID string) error {
+	var count int
+	var evict string
+	var evictActive time.Time
+	for id, session := range s.sessions {
+		if session.userID != userID {
+			continue
+		}
+		count++
+		if len(session.sockets) == 0 && (evict == "" || session.lastActive().Before(evictActive)) {
+			evict, evictActive = id, session.lastActive()
+		}
+	}
+	if count < s.sessionCfg.MaxSessionsPerUser {
+		return nil
+	}
+	if count > s.sessionCfg.MaxSessionsPerUser || evict == "" {
+		return errs.ErrTooManySessions
+	}
+	s.sessions[evict].cancel()
+	delete(s.sessions, evict)
+	return nil
+}
+
+// countSockets counts the sockets of the user over all sessions (s.mu must be held)
+func (s *AvatarCreateService) countSockets(userID string) int {
+	count := 0
+	for _, session := range s.sessions {
+		if session.userID == userID {
+			count += len(session.sockets)
+		}
+	}
+	return count
+}
+
+// detachAll removes and returns every socket of the session (AvatarCreateService.mu must be held)
+func (ss *AvatarCreateSession) detachAll() []SessionSocket {
+	sockets := make([]SessionSocket, 0, len(ss.sockets))
+	for socket := range ss.sockets {
+		sockets = append(sockets, socket)
+	}
+	clear(ss.sockets)
+	return sockets
+}
+
+// Touch marks the session as active, delaying its expiry
+func (ss *AvatarCreateSession) Touch() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.updatedAt = time.Now()
+}
+
+func (ss *AvatarCreateSession) lastActive() time.Time {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.updatedAt
+}
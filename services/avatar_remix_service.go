@@ -8,31 +8,50 @@ import (
 	"avazon-api/utils"
 	"context"
 	"fmt"
+	"log"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type AvatarRemixService struct {
-	DB        *gorm.DB
-	S3Service *S3Service
-	Painter   tools.Painter
+	DB      *gorm.DB
+	Storage Storage
+	Painter tools.Painter
+	Jobs    *JobQueue
+	Events  *CreationEventBus
+	Usage   *UsageLedger
+	Credits *CreditService
 }
 
-func NewAvatarRemixService(db *gorm.DB, s3Service *S3Service, painter tools.Painter) *AvatarRemixService {
-	return &AvatarRemixService{DB: db, S3Service: s3Service, Painter: painter}
+func NewAvatarRemixService(db *gorm.DB, storage Storage, painter tools.Painter, jobs *JobQueue, events *CreationEventBus, usage *UsageLedger, credits *CreditService) *AvatarRemixService {
+	s := &AvatarRemixService{DB: db, Storage: storage, Painter: painter, Jobs: jobs, Events: events, Usage: usage, Credits: credits}
+	jobs.Register(JT_ImageRemix, s.runImageRemixJob, s.onImageRemixJobFailed)
+	jobs.OnRecover(s.recoverStuckRemixes)
+	return s
 }
 
 func (s *AvatarRemixService) onRemixImageFailed(avatarImageRemix *models.AvatarImageRemix, err error) {
 	avatarImageRemix.Status = models.AR_Failed
-	errorMessage := err.Error()
+	errorMessage := utils.TruncateString(err.Error(), 255)
 	avatarImageRemix.FailedReason = &errorMessage
-	s.DB.Save(avatarImageRemix)
+	result := s.DB.Model(avatarImageRemix).Where("status <> ?", models.AR_Cancelled).Updates(avatarImageRemix)
+	if result.Error != nil {
+		log.Printf("Error updating avatar image remix status: %v", result.Error)
+		return
+	}
+	if result.RowsAffected == 1 { // not cancelled meanwhile
+		s.Events.Publish(imageRemixEvent(avatarImageRemix))
+	}
 }
 
 func (s *AvatarRemixService) updateImageRemixStatus(avatarImageRemix *models.AvatarImageRemix, status models.AvatarRemixStatus) {
 	avatarImageRemix.Status = status
-	s.DB.Save(avatarImageRemix)
+	if err := s.DB.Save(avatarImageRemix).Error; err != nil {
+		log.Printf("Error updating avatar image remix status: %v", err)
+		return
+	}
+	s.Events.Publish(imageRemixEvent(avatarImageRemix))
 }
 
 func (s *AvatarRemixService) StartImageRemix(userID string, avatarID string, request dto.AvatarImageRemixRequest) (*models.AvatarImageRemix, error) {
@@ -49,44 +68,124 @@ func (s *AvatarRemixService) StartImageRemix(userID string, avatarID string, req
 		AvatarID:   avatarID,
 		Avatar:     avatar,
 		UserPrompt: request.Prompt,
-		Status:     models.AR_Yet,
+		Status:     models.AR_Progressing,
 	}
-	if err := s.DB.Create(&avatarImageRemix).Error; err != nil {
+	if err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&avatarImageRemix).Error; err != nil {
+			return err
+		}
+		job, err := s.Jobs.EnqueueTx(tx, JT_ImageRemix, avatarImageRemix.ID, nil)
+		if err != nil {
+			return err
+		}
+		return s.Credits.ReserveTx(tx, userID, job, JT_ImageRemix)
+	}); err != nil {
 		return nil, err
 	}
+	s.Events.Publish(imageRemixEvent(&avatarImageRemix))
 
-	go func() {
-		s.updateImageRemixStatus(&avatarImageRemix, models.AR_Progressing)
-		avatarImageBytes, contentType, err := utils.GetDataFromURL(avatar.ProfileImageURL)
-		if err != nil {
-			s.onRemixImageFailed(&avatarImageRemix, err)
-			return
-		}
+	return &avatarImageRemix, nil
+}
 
-		remixImageBytes, remixContentType, err := s.Painter.ChangeStyle(avatarImageBytes, contentType, request.Prompt)
-		if err != nil {
-			s.onRemixImageFailed(&avatarImageRemix, err)
-			return
-		}
+// restyles the avatar's profile image as the remix asks
+func (s *AvatarRemixService) runImageRemixJob(ctx context.Context, job *models.Job) error {
+	var avatarImageRemix models.AvatarImageRemix
+	if err := loadJobRef(s.DB, &avatarImageRemix, job); err != nil {
+		return err
+	}
+	var avatar models.Avatar
+	if err := s.DB.Where("id = ?", avatarImageRemix.AvatarID).First(&avatar).Error; err != nil {
+		return err
+	}
 
-		fileExtension, err := utils.GetExtensionFromMimeType(remixContentType)
-		if err != nil {
-			s.onRemixImageFailed(&avatarImageRemix, err)
-			return
-		}
-		filename := fmt.Sprintf("remix%s%s", avatarImageRemix.ID, fileExtension)
-		uploadedURL, err := s.S3Service.UploadPublicFile(context.TODO(), filename, remixImageBytes, remixContentType)
-		if err != nil {
-			s.onRemixImageFailed(&avatarImageRemix, err)
-			return
-		}
+	avatarImageBytes, contentType, err := ReadURL(ctx, s.Storage, avatar.ProfileImageURL)
+	if err != nil {
+		return err
+	}
 
-		avatarImageRemix.ImageURL = &uploadedURL
-		avatarImageRemix.Status = models.AR_Completed
-		s.DB.Save(&avatarImageRemix)
-	}()
+	ctx = s.Usage.Track(ctx, UsageOwner{UserID: avatarImageRemix.UserID, AvatarID: avatarImageRemix.AvatarID, CreationType: UC_Remix, CreationID: avatarImageRemix.ID})
+	ctx, painter := tools.WithUsedProvider(ctx)
+	remixImageBytes, remixContentType, err := s.Painter.ChangeStyle(ctx, avatarImageBytes, contentType, avatarImageRemix.UserPrompt)
+	if err != nil {
+		return err
+	}
 
-	return &avatarImageRemix, nil
+	fileExtension, err := utils.GetExtensionFromMimeType(remixContentType)
+	if err != nil {
+		return Permanent(err)
+	}
+	filename := fmt.Sprintf("remix%s%s", avatarImageRemix.ID, fileExtension)
+	uploadedURL, err := s.Storage.Upload(ctx, filename, remixImageBytes, remixContentType)
+	if err != nil {
+		return err
+	}
+
+	avatarImageRemix.ImageURL = &uploadedURL
+	avatarImageRemix.Provider = providerName(painter)
+	avatarImageRemix.Status = models.AR_Completed
+	if err := updateJobRef(s.DB, &avatarImageRemix, models.AR_Progressing); err != nil {
+		return err
+	}
+	s.Events.Publish(imageRemixEvent(&avatarImageRemix))
+	return nil
+}
+
+// CancelImageRemix stops a remix which is not done yet
+func (s *AvatarRemixService) CancelImageRemix(userID string, avatarID string, remixID string) (*models.AvatarImageRemix, error) {
+	avatarImageRemix, err := s.GetOneImageRemix(userID, avatarID, remixID)
+	if err != nil {
+		return nil, err
+	}
+	result := s.DB.Model(avatarImageRemix).
+		Where("status IN ?", []models.AvatarRemixStatus{models.AR_Yet, models.AR_Progressing}).
+		Update("status", models.AR_Cancelled)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errs.ErrCreationNotCancellable
+	}
+	if _, err := s.Jobs.Cancel(avatarImageRemix.ID, JT_ImageRemix); err != nil {
+		return nil, err
+	}
+	avatarImageRemix.Status = models.AR_Cancelled
+	s.Events.Publish(imageRemixEvent(avatarImageRemix))
+	return avatarImageRemix, nil
+}
+
+func (s *AvatarRemixService) onImageRemixJobFailed(job *models.Job, err error) {
+	var avatarImageRemix models.AvatarImageRemix
+	if dbErr := s.DB.Where("id = ?", job.RefID).First(&avatarImageRemix).Error; dbErr != nil {
+		log.Printf("Error fetching avatar image remix: %v", dbErr)
+		return
+	}
+	s.onRemixImageFailed(&avatarImageRemix, err)
+}
+
+// recoverStuckRemixes fails the remixes left progressing without a job
+func (s *AvatarRemixService) recoverStuckRemixes() error {
+	var ids []string
+	if err := s.DB.Model(&models.AvatarImageRemix{}).Where("status = ?", models.AR_Progressing).Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	orphaned, err := s.Jobs.Orphaned(JT_ImageRemix, ids)
+	if err != nil || len(orphaned) == 0 {
+		return err
+	}
+	log.Printf("Marking %d image remix(es) stuck in progressing as failed", len(orphaned))
+	if err := s.DB.Model(&models.AvatarImageRemix{}).
+		Where("id IN ? AND status = ?", orphaned, models.AR_Progressing).
+		Updates(map[string]interface{}{"status": models.AR_Failed, "failed_reason": "interrupted by a server restart"}).Error; err != nil {
+		return err
+	}
+	var remixes []*models.AvatarImageRemix
+	if err := s.DB.Where("id IN ?", orphaned).Find(&remixes).Error; err != nil {
+		return err
+	}
+	for _, remix := range remixes {
+		s.Events.Publish(imageRemixEvent(remix))
+	}
+	return nil
 }
 
 func (s *AvatarRemixService) GetOneImageRemix(userID string, avatarID string, remixID string) (*models.AvatarImageRemix, error) {
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"avazon-api/models"
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// kinds of CreationEvent
+const (
+	CK_Music      = "music"
+	CK_Video      = "video"
+	CK_ImageRemix = "image_remix"
+)
+
+// subscribers that don't read this many events in time are disconnected
+const creationEventBuffer = 32
+
+// CreationEvent is a status transition of a content creation (music, video) or an image remix.
+type CreationEvent struct {
+	Kind         string    `json:"kind"` // music, video, image_remix
+	CreationID   string    `json:"creation_id"`
+	AvatarID     string    `json:"avatar_id"`
+	UserID       string    `json:"user_id"`
+	Status       string    `json:"status"` // models.AvatarContentCreationStatus, or models.AvatarRemixStatus for image_remix
+	FailedReason *string   `json:"failed_reason,omitempty"`
+	At           time.Time `json:"at"`
+}
+
+func musicCreationEvent(mc *models.AvatarMusicContentCreation) CreationEvent {
+	return CreationEvent{Kind: CK_Music, CreationID: mc.ID, AvatarID: mc.AvatarID, UserID: mc.UserID, Status: string(mc.Status), FailedReason: mc.FailedReason}
+}
+
+func videoCreationEvent(vc *models.AvatarVideoContentCreation) CreationEvent {
+	return CreationEvent{Kind: CK_Video, CreationID: vc.ID, AvatarID: vc.AvatarID, UserID: vc.UserID, Status: string(vc.Status), FailedReason: vc.FailedReason}
+}
+
+func imageRemixEvent(remix *models.AvatarImageRemix) CreationEvent {
+	return CreationEvent{Kind: CK_ImageRemix, CreationID: remix.ID, AvatarID: remix.AvatarID, UserID: remix.UserID, Status: string(remix.Status), FailedReason: remix.FailedReason}
+}
+
+// CreationEventFilter narrows a subscription; empty fields match everything.
+type CreationEventFilter struct {
+	AvatarID   string
+	CreationID string
+}
+
+// CreationSubscription receives the events of one user.
+// C is closed on Unsubscribe, or when the subscriber falls behind.
+type CreationSubscription struct {
+	C      <-chan CreationEvent
+	c      chan CreationEvent
+	userID string
+	filter CreationEventFilter
+}
+
+func (sub *CreationSubscription) matches(event CreationEvent) bool {
+	return event.UserID == sub.userID &&
+		(sub.filter.AvatarID == "" || sub.filter.AvatarID == event.AvatarID) &&
+		(sub.filter.CreationID == "" || sub.filter.CreationID == event.CreationID)
+}
+
+// CreationEventBus delivers creation status transitions to the SSE/WebSocket subscribers.
+// With a redis client, events go through a redis channel, so a subscriber also sees
+// the events of jobs run by the other replicas.
+type CreationEventBus struct {
+	redis   *redis.Client // nil: in-process only
+	channel string
+	subs    map[*CreationSubscription]struct{}
+	mu      sync.Mutex
+}
+
+func NewCreationEventBus(redisClient *redis.Client) *CreationEventBus {
+	return &CreationEventBus{
+		redis:   redisClient,
+		channel: "creation_events",
+		subs:    make(map[*CreationSubscription]struct{}),
+	}
+}
+
+// Start relays the events of the redis channel to the local subscribers, until ctx is done.
+// Nothing to do without redis.
+func (b *CreationEventBus) Start(ctx context.Context) error {
+	if b.redis == nil {
+		return nil
+	}
+	pubsub := b.redis.Subscribe(ctx, b.channel)
+	if _, err := pubsub.Receive(ctx); err != nil { // wait for the subscription
+		pubsub.Close()
+		return err
+	}
+	go func() {
+		defer pubsub.Close()
+		for message := range pubsub.Channel() {
+			var event CreationEvent
+			if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+				log.Println("Invalid creation event:", err)
+				continue
+			}
+			b.deliver(event)
+		}
+	}()
+	return nil
+}
+
+// Publish sends a status transition to the subscribers. Call it after the transition is committed.
+func (b *CreationEventBus) Publish(event CreationEvent) {
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+	if b.redis == nil {
+		b.deliver(event)
+		return
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Println("Error marshalling creation event:", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := b.redis.Publish(ctx, b.channel, payload).Err(); err != nil {
+		log.Println("Error publishing creation event:", err)
+	}
+}
+
+func (b *CreationEventBus) deliver(event CreationEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		if !sub.matches(event) {
+			continue
+		}
+		select {
+		case sub.c <- event:
+		default:
+			// too slow: drop it, the client reconnects and reloads the creations
+			log.Println("Dropping a slow creation event subscriber of user:", sub.userID)
+			delete(b.subs, sub)
+			close(sub.c)
+		}
+	}
+}
+
+// Subscribe starts receiving the events of userID. Call Unsubscribe when done.
+func (b *CreationEventBus) Subscribe(userID string, filter CreationEventFilter) *CreationSubscription {
+	c := make(chan CreationEvent, creationEventBuffer)
+	sub := &CreationSubscription{C: c, c: c, userID: userID, filter: filter}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+func (b *CreationEventBus) Unsubscribe(sub *CreationSubscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.c)
+	}
+}
@@ -0,0 +1,262 @@
+package services
+
+import (
+	"avazon-api/controllers/errs"
+	"avazon-api/models"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CreditService keeps the credit balance of the users. Every paid job is reserved when it is enqueued
+// (in the same transaction, so a job only exists if it is paid for), and refunded once it ends failed or cancelled.
+type CreditService struct {
+	DB          *gorm.DB
+	Prices      map[string]int64 // by job type; the job types missing are free
+	SignupGrant int64
+}
+
+func NewCreditService(db *gorm.DB, jobs *JobQueue, prices map[string]int64, signupGrant int64) *CreditService {
+	s := &CreditService{DB: db, Prices: prices, SignupGrant: signupGrant}
+	jobs.OnFinished(s.refund)
+	return s
+}
+
+// Price of running the jobs of the given types
+func (s *CreditService) Price(jobTypes ...string) int64 {
+	var price int64
+	for _, jobType := range jobTypes {
+		price += s.Prices[jobType]
+	}
+	return price
+}
+
+// Check returns errs.ErrInsufficientCredits early, before calling a provider, if userID can't pay for the jobs.
+// ReserveTx still decides.
+func (s *CreditService) Check(userID string, jobTypes ...string) error {
+	price := s.Price(jobTypes...)
+	if price == 0 {
+		return nil
+	}
+	balance, err := s.GetBalance(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && balance < price) {
+		return errs.ErrInsufficientCredits
+	}
+	return err
+}
+
+// ReserveTx takes the price of the jobs from the balance of userID inside tx, the transaction enqueueing job.
+// Returns errs.ErrInsufficientCredits if the balance is too low.
+func (s *CreditService) ReserveTx(tx *gorm.DB, userID string, job *models.Job, jobTypes ...string) error {
+	price := s.Price(jobTypes...)
+	if price == 0 {
+		return nil
+	}
+	if err := s.takeTx(tx, userID, price); err != nil {
+		return err
+	}
+	operation := strings.Join(jobTypes, ",")
+	return s.logTx(tx, &models.CreditTransaction{
+		UserID:    userID,
+		Kind:      models.Credit_Reserve,
+		Amount:    -price,
+		JobID:     &job.ID,
+		Operation: &operation,
+	})
+}
+
+// ReserveRefTx is ReserveTx for work not run as a job (e.g. the creations of a chat session), named by ref.
+// Its owner gives the credits back with RefundRef if it fails.
+func (s *CreditService) ReserveRefTx(tx *gorm.DB, userID string, ref string, jobTypes ...string) error {
+	price := s.Price(jobTypes...)
+	if price == 0 {
+		return nil
+	}
+	if err := s.takeTx(tx, userID, price); err != nil {
+		return err
+	}
+	operation := strings.Join(jobTypes, ",")
+	return s.logTx(tx, &models.CreditTransaction{
+		UserID:    userID,
+		Kind:      models.Credit_Reserve,
+		Amount:    -price,
+		Ref:       &ref,
+		Operation: &operation,
+	})
+}
+
+// takeTx takes price from the balance of userID, or returns errs.ErrInsufficientCredits
+func (s *CreditService) takeTx(tx *gorm.DB, userID string, price int64) error {
+	// a conditional update, so concurrent reservations can't overdraw
+	result := tx.Model(&models.User{}).
+		Where("id = ? AND credits >= ?", userID, price).
+		Update("credits", gorm.Expr("credits - ?", price))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errs.ErrInsufficientCredits
+	}
+	return nil
+}
+
+// MoveTx hands the part of the reservation of a job paying for jobTypes over to the job it enqueued to run them
+// (e.g. the album image job starting the music job), so only that part is refunded if that one fails.
+// The rest stays with the job from, which delivered its work.
+func (s *CreditService) MoveTx(tx *gorm.DB, from *models.Job, to *models.Job, jobTypes ...string) error {
+	price := s.Price(jobTypes...)
+	if price == 0 {
+		return nil
+	}
+	var reservation models.CreditTransaction
+	err := tx.Where("job_id = ? AND kind = ? AND refunded_at IS NULL", from.ID, models.Credit_Reserve).First(&reservation).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil // free when it was reserved
+	} else if err != nil {
+		return err
+	}
+	price = min(price, -reservation.Amount) // the prices may have changed since
+	operation := strings.Join(jobTypes, ",")
+	if price == -reservation.Amount {
+		return tx.Model(&reservation).Updates(map[string]interface{}{"job_id": to.ID, "operation": operation}).Error
+	}
+	kept := []string{}
+	if reservation.Operation != nil {
+		kept = strings.Split(*reservation.Operation, ",")
+	}
+	for _, jobType := range jobTypes {
+		if i := slices.Index(kept, jobType); i >= 0 {
+			kept = slices.Delete(kept, i, i+1)
+		}
+	}
+	if err := tx.Model(&reservation).Updates(map[string]interface{}{
+		"amount":    reservation.Amount + price,
+		"operation": strings.Join(kept, ","),
+	}).Error; err != nil {
+		return err
+	}
+	return s.logTx(tx, &models.CreditTransaction{
+		UserID:    reservation.UserID,
+		Kind:      models.Credit_Reserve,
+		Amount:    -price,
+		JobID:     &to.ID,
+		Operation: &operation,
+	})
+}
+
+// refund gives back, once, the reservation of a job which failed or was cancelled
+func (s *CreditService) refund(job *models.Job) {
+	if job.Status != models.Job_Failed && job.Status != models.Job_Cancelled {
+		return
+	}
+	if err := s.refundWhere("job_id = ?", job.ID); err != nil {
+		log.Printf("Error refunding the credits of job %d (%s %s): %v", job.ID, job.Type, job.RefID, err)
+	}
+}
+
+// RefundRef gives back, once, the reservation of ReserveRefTx, e.g. when the creation failed or was cancelled
+func (s *CreditService) RefundRef(ref string) {
+	if err := s.refundWhere("ref = ?", ref); err != nil {
+		log.Printf("Error refunding the credits of %s: %v", ref, err)
+	}
+}
+
+// refundWhere refunds the reservation selected by the condition, unless it was refunded already
+func (s *CreditService) refundWhere(query string, arg interface{}) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		var reservation models.CreditTransaction
+		err := tx.Where(query, arg).Where("kind = ? AND refunded_at IS NULL", models.Credit_Reserve).First(&reservation).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil // free, moved to another job, or refunded already
+		} else if err != nil {
+			return err
+		}
+		result := tx.Model(&reservation).Where("refunded_at IS NULL").Update("refunded_at", time.Now())
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error // refunded meanwhile
+		}
+		if err := tx.Model(&models.User{}).Where("id = ?", reservation.UserID).
+			Update("credits", gorm.Expr("credits + ?", -reservation.Amount)).Error; err != nil {
+			return err
+		}
+		return s.logTx(tx, &models.CreditTransaction{
+			UserID:    reservation.UserID,
+			Kind:      models.Credit_Refund,
+			Amount:    -reservation.Amount,
+			JobID:     reservation.JobID,
+			Ref:       reservation.Ref,
+			Operation: reservation.Operation,
+		})
+	})
+}
+
+// CreateUser creates a new user with the signup grant
+func (s *CreditService) CreateUser(user *models.User) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		user.Credits = s.SignupGrant
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		if s.SignupGrant == 0 {
+			return nil
+		}
+		note := "signup"
+		return tx.Create(&models.CreditTransaction{
+			UserID:  user.ID,
+			Kind:    models.Credit_Grant,
+			Amount:  s.SignupGrant,
+			Balance: s.SignupGrant,
+			Note:    &note,
+		}).Error
+	})
+}
+
+// Grant adds amount credits to the balance of userID
+func (s *CreditService) Grant(userID string, amount int64, note string) (*models.CreditTransaction, error) {
+	grant := &models.CreditTransaction{UserID: userID, Kind: models.Credit_Grant, Amount: amount, Note: optional(note)}
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.User{}).Where("id = ?", userID).Update("credits", gorm.Expr("credits + ?", amount))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return s.logTx(tx, grant)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
+
+// logTx saves the transaction with the balance it left
+func (s *CreditService) logTx(tx *gorm.DB, transaction *models.CreditTransaction) error {
+	if err := tx.Model(&models.User{}).Where("id = ?", transaction.UserID).Pluck("credits", &transaction.Balance).Error; err != nil {
+		return err
+	}
+	return tx.Create(transaction).Error
+}
+
+func (s *CreditService) GetBalance(userID string) (int64, error) {
+	var user models.User
+	if err := s.DB.Select("credits").Where("id = ?", userID).First(&user).Error; err != nil {
+		return 0, err
+	}
+	return user.Credits, nil
+}
+
+// GetTransactions -> the latest first
+func (s *CreditService) GetTransactions(userID string, page int, limit int) ([]models.CreditTransaction, error) {
+	transactions := []models.CreditTransaction{}
+	err := s.DB.Where("user_id = ?", userID).
+		Order("id DESC").
+		Offset(page * limit).
+		Limit(limit).
+		Find(&transactions).Error
+	return transactions, err
+}
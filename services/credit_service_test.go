@@ -0,0 +1,193 @@
+package services
+
+import (
+	"avazon-api/controllers/errs"
+	"avazon-api/models"
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+const testCreditUser = "u1"
+
+var testPrices = map[string]int64{JT_MusicImage: 2, JT_Music: 5}
+
+// newTestCredits -> a credit service pricing the music jobs, and a user holding balance credits
+func newTestCredits(t *testing.T, balance int64) (*CreditService, *JobQueue) {
+	t.Helper()
+	q := NewJobQueue(newTestDB(t, &models.Job{}, &models.User{}, &models.CreditTransaction{}), JobQueueConfig{DefaultConcurrency: 1, MaxAttempts: 1})
+	q.Register(JT_MusicImage, nil, nil)
+	q.Register(JT_Music, nil, nil)
+	q.Register(testJobType, nil, nil) // free
+	credits := NewCreditService(q.DB, q, testPrices, 0)
+	user := &models.User{ID: testCreditUser, Email: "u1@example.com", Name: "u1", Role: models.UserRoleUser, Credits: balance}
+	if err := q.DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return credits, q
+}
+
+// enqueueReserved enqueues a job of jobType reserving the price of jobTypes
+func enqueueReserved(credits *CreditService, q *JobQueue, jobType string, jobTypes ...string) (*models.Job, error) {
+	var job *models.Job
+	err := q.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if job, err = q.EnqueueTx(tx, jobType, "m1", nil); err != nil {
+			return err
+		}
+		return credits.ReserveTx(tx, testCreditUser, job, jobTypes...)
+	})
+	return job, err
+}
+
+func moveReservation(t *testing.T, credits *CreditService, q *JobQueue, from *models.Job, jobTypes ...string) *models.Job {
+	t.Helper()
+	var to *models.Job
+	err := q.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if to, err = q.EnqueueTx(tx, JT_Music, "m1", nil); err != nil {
+			return err
+		}
+		return credits.MoveTx(tx, from, to, jobTypes...)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return to
+}
+
+func assertBalance(t *testing.T, credits *CreditService, want int64) {
+	t.Helper()
+	if balance, err := credits.GetBalance(testCreditUser); err != nil || balance != want {
+		t.Errorf("balance %d (%v), want %d", balance, err, want)
+	}
+}
+
+// the album image is delivered, then the music fails: only the music is refunded
+func TestCreditServiceMusicFailsAfterImage(t *testing.T) {
+	credits, q := newTestCredits(t, 10)
+	imageJob, err := enqueueReserved(credits, q, JT_MusicImage, JT_MusicImage, JT_Music)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, credits, 3)
+
+	musicJob := moveReservation(t, credits, q, imageJob, JT_Music)
+	q.finish(imageJob, models.Job_Succeeded)
+	q.finish(musicJob, models.Job_Failed)
+	assertBalance(t, credits, 8)
+
+	var reservations []models.CreditTransaction
+	q.DB.Where("kind = ?", models.Credit_Reserve).Order("id").Find(&reservations)
+	if len(reservations) != 2 ||
+		*reservations[0].JobID != imageJob.ID || reservations[0].Amount != -2 || *reservations[0].Operation != JT_MusicImage ||
+		*reservations[1].JobID != musicJob.ID || reservations[1].Amount != -5 || *reservations[1].Operation != JT_Music {
+		t.Errorf("reservations after the move: %+v", reservations)
+	}
+}
+
+func TestCreditServiceReserve(t *testing.T) {
+	tests := []struct {
+		name     string
+		balance  int64
+		jobTypes []string
+		wantErr  error
+		want     int64 // balance after
+	}{
+		{"one type", 10, []string{JT_Music}, nil, 5},
+		{"several types", 10, []string{JT_MusicImage, JT_Music}, nil, 3},
+		{"exact balance", 7, []string{JT_MusicImage, JT_Music}, nil, 0},
+		{"too few credits", 6, []string{JT_MusicImage, JT_Music}, errs.ErrInsufficientCredits, 6},
+		{"free", 0, []string{testJobType}, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			credits, q := newTestCredits(t, tt.balance)
+			if err := credits.Check(testCreditUser, tt.jobTypes...); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Check: got %v, want %v", err, tt.wantErr)
+			}
+			if _, err := enqueueReserved(credits, q, tt.jobTypes[0], tt.jobTypes...); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReserveTx: got %v, want %v", err, tt.wantErr)
+			}
+			assertBalance(t, credits, tt.want)
+
+			var jobs, reservations int64
+			q.DB.Model(&models.Job{}).Count(&jobs)
+			q.DB.Model(&models.CreditTransaction{}).Where("kind = ?", models.Credit_Reserve).Count(&reservations)
+			paid := tt.wantErr == nil && tt.want != tt.balance
+			if (jobs == 1) != (tt.wantErr == nil) || (reservations == 1) != paid {
+				t.Errorf("%d jobs and %d reservations left", jobs, reservations)
+			}
+		})
+	}
+}
+
+func TestCreditServiceRefund(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, credits *CreditService, q *JobQueue, job *models.Job)
+		want int64 // balance after, from 10 with the music reserved
+	}{
+		{"succeeded", func(t *testing.T, credits *CreditService, q *JobQueue, job *models.Job) {
+			q.finish(job, models.Job_Succeeded)
+		}, 5},
+		{"failed", func(t *testing.T, credits *CreditService, q *JobQueue, job *models.Job) {
+			q.finish(job, models.Job_Failed)
+		}, 10},
+		{"refunded twice", func(t *testing.T, credits *CreditService, q *JobQueue, job *models.Job) {
+			q.finish(job, models.Job_Failed)
+			q.finish(job, models.Job_Cancelled)
+		}, 10},
+		{"moved, then the new job failed", func(t *testing.T, credits *CreditService, q *JobQueue, job *models.Job) {
+			to := moveReservation(t, credits, q, job, JT_Music)
+			q.finish(job, models.Job_Failed) // nothing left to refund
+			q.finish(to, models.Job_Failed)
+			q.finish(to, models.Job_Failed)
+		}, 10},
+		{"moved, then the new job succeeded", func(t *testing.T, credits *CreditService, q *JobQueue, job *models.Job) {
+			to := moveReservation(t, credits, q, job, JT_Music)
+			q.finish(job, models.Job_Cancelled)
+			q.finish(to, models.Job_Succeeded)
+		}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			credits, q := newTestCredits(t, 10)
+			job, err := enqueueReserved(credits, q, JT_Music, JT_Music)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.run(t, credits, q, job)
+			assertBalance(t, credits, tt.want)
+
+			var refunds int64
+			q.DB.Model(&models.CreditTransaction{}).Where("kind = ?", models.Credit_Refund).Count(&refunds)
+			if wantRefunds := (tt.want - 5) / 5; refunds != wantRefunds {
+				t.Errorf("%d refunds, want %d", refunds, wantRefunds)
+			}
+		})
+	}
+}
+
+func TestCreditServiceRefundRef(t *testing.T) {
+	credits, q := newTestCredits(t, 10)
+	err := q.DB.Transaction(func(tx *gorm.DB) error {
+		return credits.ReserveRefTx(tx, testCreditUser, "music:m1", JT_Music)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, credits, 5)
+	credits.RefundRef("music:m1")
+	credits.RefundRef("music:m1")
+	credits.RefundRef("music:other")
+	assertBalance(t, credits, 10)
+
+	err = q.DB.Transaction(func(tx *gorm.DB) error {
+		return credits.ReserveRefTx(tx, testCreditUser, "music:m2", JT_MusicImage, JT_Music, JT_Music)
+	})
+	if !errors.Is(err, errs.ErrInsufficientCredits) {
+		t.Errorf("reserving 12 credits out of 10: %v", err)
+	}
+}
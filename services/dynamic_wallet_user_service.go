@@ -3,6 +3,7 @@ package services
 import (
 	"avazon-api/controllers/errs"
 	"avazon-api/models"
+	"avazon-api/utils"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -27,8 +28,7 @@ func (s *DynamicWalletUserService) GetDWUserByID(id string) (*models.User, error
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.APIKey))
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := utils.HTTPClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
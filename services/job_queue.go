@@ -0,0 +1,542 @@
+package services
+
+import (
+	"avazon-api/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// job types
+const (
+	JT_AvatarImage     = "avatar_image"      // AvatarImageCreation
+	JT_AvatarVoice     = "avatar_voice"      // AvatarVoiceCreation
+	JT_AvatarChatVideo = "avatar_chat_video" // Avatar
+	JT_VideoImage      = "video_image"       // AvatarVideoContentCreation
+	JT_Video           = "video"             // AvatarVideoContentCreation
+	JT_MusicImage      = "music_image"       // AvatarMusicContentCreation
+	JT_Music           = "music"             // AvatarMusicContentCreation
+	JT_ImageRemix      = "image_remix"       // AvatarImageRemix
+)
+
+const maxRetryBackoff = 10 * time.Minute
+
+var ErrUnknownJobType = errors.New("unknown job type")
+
+// ErrJobCancelled is returned by handlers that find their row cancelled by the user.
+// The job stops without being retried or failed.
+var ErrJobCancelled = errors.New("job cancelled")
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a job error that retrying won't fix. The job fails right away.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// JobHandler does the work of one job. Handlers may run more than once for the same job
+// (retries, restarts), so they must start over from what is stored in the DB.
+type JobHandler func(ctx context.Context, job *models.Job) error
+
+type JobQueueConfig struct {
+	Concurrency        map[string]int // per job type
+	DefaultConcurrency int
+	MaxAttempts        int
+	RetryBackoff       time.Duration // doubled on every attempt
+	PollInterval       time.Duration
+	StaleAfter         time.Duration // running jobs without heartbeat for this long are picked up again
+}
+
+type jobType struct {
+	handle   JobHandler
+	onFailed func(job *models.Job, err error)
+	limit    int
+	running  int
+}
+
+// JobQueue runs background jobs stored in the jobs table.
+//   - every job type has its own concurrency cap
+//   - failed attempts are retried with exponential backoff, up to MaxAttempts
+//   - running jobs send heartbeats; jobs of a dead server are picked up again (or failed) by the others, or after restart
+//   - Cancel stops a job: its context is cancelled, on whichever server runs it (noticed on the next heartbeat)
+//
+// Services register their handlers in their constructors, then Start is called once.
+type JobQueue struct {
+	DB         *gorm.DB
+	config     JobQueueConfig
+	types      map[string]*jobType
+	running    map[uint]context.CancelFunc // jobs running in this process
+	recoveries []func() error
+	finished   []func(job *models.Job)
+	wake       chan struct{}
+	mu         sync.Mutex
+}
+
+func NewJobQueue(db *gorm.DB, config JobQueueConfig) *JobQueue {
+	return &JobQueue{
+		DB:      db,
+		config:  config,
+		types:   make(map[string]*jobType),
+		running: make(map[uint]context.CancelFunc),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Register sets the handler of a job type. onFailed is called once the job has failed for good.
+func (q *JobQueue) Register(name string, handle JobHandler, onFailed func(job *models.Job, err error)) {
+	limit, ok := q.config.Concurrency[name]
+	if !ok {
+		limit = q.config.DefaultConcurrency
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.types[name] = &jobType{handle: handle, onFailed: onFailed, limit: max(limit, 1)}
+}
+
+// OnRecover adds a check run at startup and then periodically, after stale jobs are requeued.
+// Services use it to fail rows stuck in a progressing status without any active job.
+func (q *JobQueue) OnRecover(check func() error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.recoveries = append(q.recoveries, check)
+}
+
+// OnFinished adds a hook called once a job reached its final status (succeeded, failed or cancelled), set in job.Status
+func (q *JobQueue) OnFinished(hook func(job *models.Job)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.finished = append(q.finished, hook)
+}
+
+func (q *JobQueue) finish(job *models.Job, status models.JobStatus) {
+	job.Status = status
+	q.mu.Lock()
+	hooks := append([]func(job *models.Job){}, q.finished...)
+	q.mu.Unlock()
+	for _, hook := range hooks {
+		hook(job)
+	}
+}
+
+func (q *JobQueue) Enqueue(jobType string, refID string, payload interface{}) (*models.Job, error) {
+	return q.EnqueueTx(q.DB, jobType, refID, payload)
+}
+
+// EnqueueTx adds the job inside tx, so it only exists if tx commits.
+func (q *JobQueue) EnqueueTx(tx *gorm.DB, jobType string, refID string, payload interface{}) (*models.Job, error) {
+	q.mu.Lock()
+	_, ok := q.types[jobType]
+	q.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownJobType, jobType)
+	}
+
+	data := []byte("{}")
+	if payload != nil {
+		var err error
+		if data, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+	job := &models.Job{
+		Type:        jobType,
+		RefID:       refID,
+		Payload:     string(data),
+		Status:      models.Job_Queued,
+		MaxAttempts: q.config.MaxAttempts,
+		RunAt:       time.Now(),
+	}
+	if err := tx.Create(job).Error; err != nil {
+		return nil, err
+	}
+	q.notify()
+	return job, nil
+}
+
+// ActiveRefIDs returns the ref ids of the queued and running jobs of a type.
+func (q *JobQueue) ActiveRefIDs(jobType string) (map[string]bool, error) {
+	var refIDs []string
+	if err := q.DB.Model(&models.Job{}).
+		Where("type = ? AND status IN ?", jobType, []models.JobStatus{models.Job_Queued, models.Job_Running}).
+		Pluck("ref_id", &refIDs).Error; err != nil {
+		return nil, err
+	}
+	active := make(map[string]bool, len(refIDs))
+	for _, refID := range refIDs {
+		active[refID] = true
+	}
+	return active, nil
+}
+
+// Start recovers the jobs interrupted by the last shutdown and starts the workers.
+func (q *JobQueue) Start(ctx context.Context) error {
+	if err := q.recoverJobs(); err != nil {
+		return err
+	}
+	go q.loop(ctx)
+	return nil
+}
+
+func (q *JobQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *JobQueue) loop(ctx context.Context) {
+	poll := time.NewTicker(q.config.PollInterval)
+	defer poll.Stop()
+	heartbeat := time.NewTicker(q.config.StaleAfter / 4)
+	defer heartbeat.Stop()
+	recovery := time.NewTicker(q.config.StaleAfter)
+	defer recovery.Stop()
+
+	for {
+		q.dispatch(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+		case <-q.wake:
+		case <-heartbeat.C:
+			q.heartbeat()
+		case <-recovery.C:
+			if err := q.recoverJobs(); err != nil {
+				log.Printf("Error recovering jobs: %v", err)
+			}
+		}
+	}
+}
+
+// dispatch starts as many due jobs as the free slots of every type allow
+func (q *JobQueue) dispatch(ctx context.Context) {
+	q.mu.Lock()
+	free := make(map[string]int, len(q.types))
+	for name, t := range q.types {
+		if t.running < t.limit {
+			free[name] = t.limit - t.running
+		}
+	}
+	q.mu.Unlock()
+
+	for name, n := range free {
+		var jobs []*models.Job
+		if err := q.DB.
+			Where("type = ? AND status = ? AND run_at <= ?", name, models.Job_Queued, time.Now()).
+			Order("run_at").
+			Limit(n).
+			Find(&jobs).Error; err != nil {
+			log.Printf("Error fetching queued jobs: %v", err)
+			return
+		}
+		for _, job := range jobs {
+			if !q.claim(job) {
+				continue // taken by another server
+			}
+			jobCtx, cancel := context.WithCancel(ctx)
+			q.mu.Lock()
+			t := q.types[name]
+			t.running++
+			q.running[job.ID] = cancel
+			q.mu.Unlock()
+			go q.run(jobCtx, t, job)
+		}
+	}
+}
+
+func (q *JobQueue) claim(job *models.Job) bool {
+	now := time.Now()
+	result := q.DB.Model(&models.Job{}).
+		Where("id = ? AND status = ?", job.ID, models.Job_Queued).
+		Updates(map[string]interface{}{
+			"status":       models.Job_Running,
+			"attempts":     gorm.Expr("attempts + 1"),
+			"heartbeat_at": now,
+		})
+	if result.Error != nil {
+		log.Printf("Error claiming job %d: %v", job.ID, result.Error)
+		return false
+	}
+	if result.RowsAffected != 1 {
+		return false
+	}
+	job.Status = models.Job_Running
+	job.Attempts++
+	job.HeartbeatAt = &now
+	return true
+}
+
+func (q *JobQueue) run(ctx context.Context, t *jobType, job *models.Job) {
+	defer func() {
+		q.mu.Lock()
+		t.running--
+		if cancel, ok := q.running[job.ID]; ok {
+			cancel()
+			delete(q.running, job.ID)
+		}
+		q.mu.Unlock()
+		q.notify()
+	}()
+
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		return t.handle(ctx, job)
+	}()
+	// the status updates below are skipped if the job was cancelled meanwhile
+	if err == nil {
+		now := time.Now()
+		result := q.DB.Model(job).Where("status = ?", models.Job_Running).
+			Updates(map[string]interface{}{"status": models.Job_Succeeded, "finished_at": now})
+		if result.Error == nil && result.RowsAffected == 1 {
+			q.finish(job, models.Job_Succeeded)
+		}
+		return
+	}
+	if errors.Is(err, ErrJobCancelled) || q.isCancelled(job) {
+		log.Printf("Job %d (%s %s) was cancelled: %v", job.ID, job.Type, job.RefID, err)
+		// already finished if Cancel marked it
+		if result := q.markCancelled(q.DB.Where("id = ?", job.ID)); result.Error == nil && result.RowsAffected == 1 {
+			q.finish(job, models.Job_Cancelled)
+		}
+		return
+	}
+
+	var permanent permanentError
+	if job.Attempts < job.MaxAttempts && !errors.As(err, &permanent) {
+		delay := q.backoff(job.Attempts)
+		log.Printf("Job %d (%s %s) failed on attempt %d, retrying in %s: %v", job.ID, job.Type, job.RefID, job.Attempts, delay, err)
+		q.DB.Model(job).Where("status = ?", models.Job_Running).Updates(map[string]interface{}{
+			"status":     models.Job_Queued,
+			"run_at":     time.Now().Add(delay),
+			"last_error": err.Error(),
+		})
+		return
+	}
+	q.fail(t, job, err)
+}
+
+func (q *JobQueue) fail(t *jobType, job *models.Job, err error) {
+	log.Printf("Job %d (%s %s) failed after %d attempt(s): %v", job.ID, job.Type, job.RefID, job.Attempts, err)
+	now := time.Now()
+	result := q.DB.Model(job).Where("status = ?", models.Job_Running).Updates(map[string]interface{}{
+		"status":      models.Job_Failed,
+		"finished_at": now,
+		"last_error":  err.Error(),
+	})
+	if result.Error != nil {
+		log.Printf("Error updating job %d status to failed: %v", job.ID, result.Error)
+	} else if result.RowsAffected == 0 {
+		return // cancelled meanwhile
+	} else {
+		q.finish(job, models.Job_Failed)
+	}
+	if t != nil && t.onFailed != nil {
+		t.onFailed(job, err)
+	}
+}
+
+func (q *JobQueue) backoff(attempts int) time.Duration {
+	delay := q.config.RetryBackoff
+	for i := 1; i < attempts && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryBackoff)
+}
+
+func (q *JobQueue) heartbeat() {
+	q.mu.Lock()
+	ids := make([]uint, 0, len(q.running))
+	for id := range q.running {
+		ids = append(ids, id)
+	}
+	q.mu.Unlock()
+	if len(ids) == 0 {
+		return
+	}
+	if err := q.DB.Model(&models.Job{}).
+		Where("id IN ? AND status = ?", ids, models.Job_Running).
+		Update("heartbeat_at", time.Now()).Error; err != nil {
+		log.Printf("Error updating job heartbeats: %v", err)
+	}
+
+	// stop the jobs cancelled through another server
+	var cancelled []uint
+	if err := q.DB.Model(&models.Job{}).
+		Where("id IN ? AND status = ?", ids, models.Job_Cancelled).
+		Pluck("id", &cancelled).Error; err != nil {
+		log.Printf("Error checking cancelled jobs: %v", err)
+		return
+	}
+	q.cancelLocal(cancelled)
+}
+
+// Cancel cancels the queued and running jobs working on refID (of the given types, or of any type).
+// A queued job never starts; a running job gets its context cancelled, which frees its slot once
+// the handler returns. Returns whether there was any job to cancel.
+func (q *JobQueue) Cancel(refID string, jobTypes ...string) (bool, error) {
+	var ids []uint
+	query := q.DB.Model(&models.Job{}).
+		Where("ref_id = ? AND status IN ?", refID, []models.JobStatus{models.Job_Queued, models.Job_Running})
+	if len(jobTypes) > 0 {
+		query = query.Where("type IN ?", jobTypes)
+	}
+	if err := query.Pluck("id", &ids).Error; err != nil {
+		return false, err
+	}
+	if len(ids) == 0 {
+		return false, nil
+	}
+	result := q.markCancelled(q.DB.Where("id IN ?", ids))
+	if result.Error != nil {
+		return false, result.Error
+	}
+	q.cancelLocal(ids)
+
+	var cancelled []*models.Job
+	if err := q.DB.Where("id IN ? AND status = ?", ids, models.Job_Cancelled).Find(&cancelled).Error; err != nil {
+		log.Printf("Error loading the cancelled jobs of %s: %v", refID, err)
+	}
+	for _, job := range cancelled {
+		q.finish(job, models.Job_Cancelled)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// markCancelled sets the queued and running jobs of query as cancelled
+func (q *JobQueue) markCancelled(query *gorm.DB) *gorm.DB {
+	now := time.Now()
+	return query.Model(&models.Job{}).
+		Where("status IN ?", []models.JobStatus{models.Job_Queued, models.Job_Running}).
+		Updates(map[string]interface{}{"status": models.Job_Cancelled, "finished_at": now, "last_error": "cancelled"})
+}
+
+// cancelLocal cancels the context of the given jobs, if they run in this process
+func (q *JobQueue) cancelLocal(ids []uint) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, id := range ids {
+		if cancel, ok := q.running[id]; ok {
+			cancel()
+		}
+	}
+}
+
+func (q *JobQueue) isCancelled(job *models.Job) bool {
+	var status models.JobStatus
+	if err := q.DB.Model(&models.Job{}).Where("id = ?", job.ID).Pluck("status", &status).Error; err != nil {
+		return false
+	}
+	return status == models.Job_Cancelled
+}
+
+// recoverJobs requeues the running jobs whose server stopped sending heartbeats
+// (or fails them if they are out of attempts), then runs the registered recoveries.
+func (q *JobQueue) recoverJobs() error {
+	q.mu.Lock()
+	mine := make(map[uint]bool, len(q.running))
+	for id := range q.running {
+		mine[id] = true
+	}
+	q.mu.Unlock()
+
+	var stale []*models.Job
+	if err := q.DB.
+		Where("status = ? AND (heartbeat_at IS NULL OR heartbeat_at < ?)", models.Job_Running, time.Now().Add(-q.config.StaleAfter)).
+		Find(&stale).Error; err != nil {
+		return err
+	}
+	for _, job := range stale {
+		if mine[job.ID] {
+			continue
+		}
+		interrupted := errors.New("interrupted: the server running the job stopped")
+		if job.Attempts < job.MaxAttempts {
+			log.Printf("Job %d (%s %s) was interrupted, picking it up again", job.ID, job.Type, job.RefID)
+			q.DB.Model(&models.Job{}).
+				Where("id = ? AND status = ?", job.ID, models.Job_Running).
+				Updates(map[string]interface{}{"status": models.Job_Queued, "run_at": time.Now(), "last_error": interrupted.Error()})
+			continue
+		}
+		q.mu.Lock()
+		t := q.types[job.Type]
+		q.mu.Unlock()
+		q.fail(t, job, interrupted)
+	}
+
+	q.mu.Lock()
+	recoveries := append([]func() error{}, q.recoveries...)
+	q.mu.Unlock()
+	for _, check := range recoveries {
+		if err := check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadJobRef loads the row a job works on. A missing row fails the job without retrying.
+func loadJobRef(db *gorm.DB, dest interface{}, job *models.Job) error {
+	err := db.Where("id = ?", job.RefID).First(dest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Permanent(err)
+	}
+	return err
+}
+
+// updateJobRef saves the row a job works on, if it is still in status.
+// Otherwise (cancelled meanwhile) the job stops with ErrJobCancelled.
+func updateJobRef(db *gorm.DB, row interface{}, status interface{}) error {
+	result := db.Model(row).Omit(clause.Associations).Where("status = ?", status).Updates(row)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrJobCancelled
+	}
+	return nil
+}
+
+func decodeJobPayload(job *models.Job, dest interface{}) error {
+	if err := json.Unmarshal([]byte(job.Payload), dest); err != nil {
+		return Permanent(fmt.Errorf("invalid payload of job %d: %w", job.ID, err))
+	}
+	return nil
+}
+
+// Orphaned returns the ids that no queued or running job of jobType works on.
+func (q *JobQueue) Orphaned(jobType string, ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	active, err := q.ActiveRefIDs(jobType)
+	if err != nil {
+		return nil, err
+	}
+	var orphaned []string
+	for _, id := range ids {
+		if !active[id] {
+			orphaned = append(orphaned, id)
+		}
+	}
+	return orphaned, nil
+}
@@ -0,0 +1,280 @@
+package services
+
+import (
+	"avazon-api/models"
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB opens a fresh sqlite database with the tables of the given models
+func newTestDB(t *testing.T, tables ...interface{}) *gorm.DB {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "test.db") + "?_busy_timeout=5000"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+const testJobType = "test"
+
+// finishedJobs records the calls of the OnFinished hooks
+type finishedJobs struct {
+	statuses map[uint][]models.JobStatus
+	mu       sync.Mutex
+}
+
+func (f *finishedJobs) hook(job *models.Job) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.statuses[job.ID] = append(f.statuses[job.ID], job.Status)
+}
+
+func (f *finishedJobs) of(id uint) []models.JobStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.statuses[id]
+}
+
+func newTestQueue(t *testing.T, handle JobHandler, onFailed func(job *models.Job, err error)) (*JobQueue, *finishedJobs) {
+	t.Helper()
+	q := NewJobQueue(newTestDB(t, &models.Job{}, &models.User{}, &models.CreditTransaction{}), JobQueueConfig{
+		DefaultConcurrency: 1,
+		MaxAttempts:        3,
+		RetryBackoff:       time.Minute,
+		PollInterval:       time.Second,
+		StaleAfter:         time.Minute,
+	})
+	q.Register(testJobType, handle, onFailed)
+	finished := &finishedJobs{statuses: make(map[uint][]models.JobStatus)}
+	q.OnFinished(finished.hook)
+	return q, finished
+}
+
+// startJob claims and runs job like dispatch does -> closed once run returns
+func startJob(t *testing.T, q *JobQueue, job *models.Job) <-chan struct{} {
+	t.Helper()
+	if !q.claim(job) {
+		t.Fatalf("job %d not claimed", job.ID)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	q.mu.Lock()
+	jt := q.types[job.Type]
+	jt.running++
+	q.running[job.ID] = cancel
+	q.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.run(ctx, jt, job)
+	}()
+	return done
+}
+
+func waitJob(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job still running")
+	}
+}
+
+func loadJob(t *testing.T, q *JobQueue, id uint) models.Job {
+	t.Helper()
+	var job models.Job
+	if err := q.DB.First(&job, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestJobQueueClaim(t *testing.T) {
+	q, _ := newTestQueue(t, func(ctx context.Context, job *models.Job) error { return nil }, nil)
+	job, err := q.Enqueue(testJobType, "1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !q.claim(job) {
+		t.Fatal("first claim failed")
+	}
+	if q.claim(&models.Job{ID: job.ID}) {
+		t.Fatal("a running job was claimed again")
+	}
+	stored := loadJob(t, q, job.ID)
+	if stored.Status != models.Job_Running || stored.Attempts != 1 || stored.HeartbeatAt == nil {
+		t.Fatalf("claimed job: status %s, attempts %d, heartbeat %v", stored.Status, stored.Attempts, stored.HeartbeatAt)
+	}
+}
+
+func TestJobQueueEnqueueUnknownType(t *testing.T) {
+	q, _ := newTestQueue(t, nil, nil)
+	if _, err := q.Enqueue("missing", "1", nil); !errors.Is(err, ErrUnknownJobType) {
+		t.Fatalf("got %v, want ErrUnknownJobType", err)
+	}
+}
+
+func TestJobQueueRun(t *testing.T) {
+	errBoom := errors.New("boom")
+	tests := []struct {
+		name       string
+		err        error
+		attempts   int // before this run
+		wantStatus models.JobStatus
+		finished   []models.JobStatus
+		failed     bool // onFailed called
+	}{
+		{"success", nil, 0, models.Job_Succeeded, []models.JobStatus{models.Job_Succeeded}, false},
+		{"retried", errBoom, 0, models.Job_Queued, nil, false},
+		{"out of attempts", errBoom, 2, models.Job_Failed, []models.JobStatus{models.Job_Failed}, true},
+		{"permanent", Permanent(errBoom), 0, models.Job_Failed, []models.JobStatus{models.Job_Failed}, true},
+		{"cancelled by the handler", ErrJobCancelled, 0, models.Job_Cancelled, []models.JobStatus{models.Job_Cancelled}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failed := 0
+			q, finished := newTestQueue(t,
+				func(ctx context.Context, job *models.Job) error { return tt.err },
+				func(job *models.Job, err error) { failed++ },
+			)
+			job, err := q.Enqueue(testJobType, "1", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q.DB.Model(job).Update("attempts", tt.attempts)
+			job.Attempts = tt.attempts
+			before := time.Now()
+			waitJob(t, startJob(t, q, job))
+
+			stored := loadJob(t, q, job.ID)
+			if stored.Status != tt.wantStatus {
+				t.Errorf("status %s, want %s", stored.Status, tt.wantStatus)
+			}
+			if got := finished.of(job.ID); len(got) != len(tt.finished) || (len(got) == 1 && got[0] != tt.finished[0]) {
+				t.Errorf("OnFinished calls %v, want %v", got, tt.finished)
+			}
+			if (failed == 1) != tt.failed || failed > 1 {
+				t.Errorf("onFailed called %d times", failed)
+			}
+			if tt.wantStatus == models.Job_Queued {
+				// retried after the backoff of the first attempt
+				if stored.RunAt.Before(before.Add(q.config.RetryBackoff)) || stored.LastError == nil {
+					t.Errorf("retry: run_at %s (enqueued at %s), last_error %v", stored.RunAt, before, stored.LastError)
+				}
+			}
+		})
+	}
+}
+
+func TestJobQueueBackoff(t *testing.T) {
+	q := &JobQueue{config: JobQueueConfig{RetryBackoff: 10 * time.Second}}
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{10, maxRetryBackoff},
+		{100, maxRetryBackoff},
+	}
+	for _, tt := range tests {
+		if got := q.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+// a job cancelled while running has its context cancelled and is finished once, by Cancel
+func TestJobQueueCancelRunning(t *testing.T) {
+	started := make(chan struct{})
+	q, finished := newTestQueue(t, func(ctx context.Context, job *models.Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}, nil)
+	job, err := q.Enqueue(testJobType, "1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := startJob(t, q, job)
+	<-started
+
+	cancelled, err := q.Cancel("1", testJobType)
+	if err != nil || !cancelled {
+		t.Fatalf("Cancel: %v, %v", cancelled, err)
+	}
+	waitJob(t, done)
+
+	if stored := loadJob(t, q, job.ID); stored.Status != models.Job_Cancelled {
+		t.Errorf("status %s, want cancelled", stored.Status)
+	}
+	if got := finished.of(job.ID); len(got) != 1 || got[0] != models.Job_Cancelled {
+		t.Errorf("OnFinished calls %v, want one cancelled", got)
+	}
+	if again, err := q.Cancel("1"); err != nil || again {
+		t.Errorf("second Cancel: %v, %v", again, err)
+	}
+	if got := finished.of(job.ID); len(got) != 1 {
+		t.Errorf("OnFinished called again: %v", got)
+	}
+}
+
+// the reservation of a cancelled job is refunded once, whichever of Cancel and run reaches finish
+func TestJobQueueRefundOnce(t *testing.T) {
+	started := make(chan struct{})
+	q, _ := newTestQueue(t, func(ctx context.Context, job *models.Job) error {
+		close(started)
+		<-ctx.Done()
+		return ErrJobCancelled
+	}, nil)
+	credits := NewCreditService(q.DB, q, map[string]int64{testJobType: 3}, 0)
+	if err := q.DB.Create(&models.User{ID: "u1", Email: "u1@example.com", Name: "u1", Role: models.UserRoleUser, Credits: 5}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var job *models.Job
+	err := q.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if job, err = q.EnqueueTx(tx, testJobType, "1", nil); err != nil {
+			return err
+		}
+		return credits.ReserveTx(tx, "u1", job, testJobType)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance, _ := credits.GetBalance("u1"); balance != 2 {
+		t.Fatalf("balance after reservation %d, want 2", balance)
+	}
+
+	done := startJob(t, q, job)
+	<-started
+	if _, err := q.Cancel("1"); err != nil {
+		t.Fatal(err)
+	}
+	waitJob(t, done)
+	// a hook running twice must not refund twice
+	q.finish(job, models.Job_Cancelled)
+
+	if balance, _ := credits.GetBalance("u1"); balance != 5 {
+		t.Errorf("balance after cancel %d, want 5", balance)
+	}
+	var refunds int64
+	q.DB.Model(&models.CreditTransaction{}).Where("kind = ?", models.Credit_Refund).Count(&refunds)
+	if refunds != 1 {
+		t.Errorf("%d refunds, want 1", refunds)
+	}
+}
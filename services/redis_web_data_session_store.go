@@ -0,0 +1,112 @@
+package services
+
+import (
+	"avazon-api/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisWebDataSessionStore keeps every session as a JSON string whose redis TTL is the session's.
+type RedisWebDataSessionStore struct {
+	Client *redis.Client
+	Prefix string // e.g. "web_session:"
+}
+
+func NewRedisWebDataSessionStore(client *redis.Client) *RedisWebDataSessionStore {
+	return &RedisWebDataSessionStore{Client: client, Prefix: "web_session:"}
+}
+
+func (s *RedisWebDataSessionStore) tokenKey(tokenKey string) string {
+	return s.Prefix + "token:" + tokenKey
+}
+
+func (s *RedisWebDataSessionStore) dataKey(sessionID string) string {
+	return s.Prefix + "data:" + sessionID
+}
+
+func (s *RedisWebDataSessionStore) put(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return s.Client.Set(ctx, key, data, ttl).Err()
+}
+
+func (s *RedisWebDataSessionStore) delete(ctx context.Context, key string) error {
+	deleted, err := s.Client.Del(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrWebSessionNotFound
+	}
+	return nil
+}
+
+// decodeRedisSession turns a redis reply into value, mapping a missing key to ErrWebSessionNotFound
+func decodeRedisSession(data []byte, err error, value interface{}) error {
+	if errors.Is(err, redis.Nil) {
+		return ErrWebSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// the models hide these fields from the API, so they are stored with their own tags
+type redisTokenSession struct {
+	TokenKey      string    `json:"token_key"`
+	DataSessionID string    `json:"data_session_id"`
+	UserID        string    `json:"user_id"`
+	Token         string    `json:"token"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type redisDataSession struct {
+	SessionID  string    `json:"session_id"`
+	UserID     string    `json:"user_id"`
+	Content    string    `json:"content"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+func (s *RedisWebDataSessionStore) PutToken(ctx context.Context, session *models.WebTokenSession, ttl time.Duration) error {
+	return s.put(ctx, s.tokenKey(session.TokenKey), redisTokenSession(*session), ttl)
+}
+
+func (s *RedisWebDataSessionStore) TakeToken(ctx context.Context, tokenKey string) (*models.WebTokenSession, error) {
+	var session redisTokenSession
+	data, err := s.Client.GetDel(ctx, s.tokenKey(tokenKey)).Bytes()
+	if err := decodeRedisSession(data, err, &session); err != nil {
+		return nil, err
+	}
+	token := models.WebTokenSession(session)
+	return &token, nil
+}
+
+func (s *RedisWebDataSessionStore) DeleteToken(ctx context.Context, tokenKey string) error {
+	return s.delete(ctx, s.tokenKey(tokenKey))
+}
+
+func (s *RedisWebDataSessionStore) PutData(ctx context.Context, session *models.WebDataSession, ttl time.Duration) error {
+	return s.put(ctx, s.dataKey(session.SessionID), redisDataSession(*session), ttl)
+}
+
+func (s *RedisWebDataSessionStore) GetData(ctx context.Context, sessionID string) (*models.WebDataSession, error) {
+	var session redisDataSession
+	data, err := s.Client.Get(ctx, s.dataKey(sessionID)).Bytes()
+	if err := decodeRedisSession(data, err, &session); err != nil {
+		return nil, err
+	}
+	dataSession := models.WebDataSession(session)
+	return &dataSession, nil
+}
+
+func (s *RedisWebDataSessionStore) DeleteData(ctx context.Context, sessionID string) error {
+	return s.delete(ctx, s.dataKey(sessionID))
+}
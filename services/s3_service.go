@@ -3,12 +3,14 @@ package services
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"net/url"
+	"io"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 type S3Service struct {
@@ -33,8 +35,8 @@ func NewS3Service(bucketName string, region string) (*S3Service, error) {
 	}, nil
 }
 
-// UploadPublicFile uploads a file to S3 and returns the public URL.
-func (s *S3Service) UploadPublicFile(ctx context.Context, fileName string, fileData []byte, contentType string) (string, error) {
+// Upload uploads a file to S3 and returns the public URL.
+func (s *S3Service) Upload(ctx context.Context, fileName string, fileData []byte, contentType string) (string, error) {
 	// Upload file to S3
 	_, err := s.Client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket:      aws.String(s.BucketName),
@@ -46,8 +48,55 @@ func (s *S3Service) UploadPublicFile(ctx context.Context, fileName string, fileD
 		return "", fmt.Errorf("failed to upload file to S3: %w", err)
 	}
 
-	// Generate public URL
-	urlStr := fmt.Sprintf("https://%s.s3.amazonaws.com/%s", s.BucketName, url.PathEscape(fileName))
+	return s.URL(fileName), nil
+}
+
+func (s *S3Service) Download(ctx context.Context, fileName string) ([]byte, string, error) {
+	out, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.BucketName),
+		Key:    aws.String(fileName),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, "", ErrObjectNotFound
+	} else if err != nil {
+		return nil, "", fmt.Errorf("failed to download file from S3: %w", err)
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, aws.ToString(out.ContentType), nil
+}
+
+func (s *S3Service) Delete(ctx context.Context, fileName string) error {
+	_, err := s.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.BucketName),
+		Key:    aws.String(fileName),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete file from S3: %w", err)
+	}
+	return nil
+}
+
+func (s *S3Service) Exists(ctx context.Context, fileName string) (bool, error) {
+	_, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.BucketName),
+		Key:    aws.String(fileName),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
 
-	return urlStr, nil
+// URL returns the public URL of the object
+func (s *S3Service) URL(fileName string) string {
+	return fmt.Sprintf("https://%s.s3.amazonaws.com/%s", s.BucketName, escapeKey(fileName))
 }
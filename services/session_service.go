@@ -0,0 +1,196 @@
+package services
+
+import (
+	"avazon-api/controllers/errs"
+	"avazon-api/models"
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SessionService keeps the sessions started at POST /users/session, once the Dynamic wallet JWT is checked.
+// Their refresh tokens rotate: each one can be used once, and using one again (someone else holds it)
+// revokes the whole session. The revoked sessions are read from the database every poll,
+// so their access tokens stop working on every replica.
+type SessionService struct {
+	DB         *gorm.DB
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+	revoked    map[string]time.Time // session id -> revoked at, kept while its access tokens may be valid
+	prunedAt   time.Time
+	mu         sync.RWMutex
+}
+
+func NewSessionService(db *gorm.DB, accessTTL time.Duration, refreshTTL time.Duration) *SessionService {
+	return &SessionService{DB: db, AccessTTL: accessTTL, RefreshTTL: refreshTTL, revoked: make(map[string]time.Time)}
+}
+
+// Start loads the revoked sessions, then reads them again every pollInterval
+func (s *SessionService) Start(ctx context.Context, pollInterval time.Duration) error {
+	if err := s.poll(); err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.poll(); err != nil {
+					log.Printf("Error reading the revoked sessions: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// poll reads the sessions revoked within AccessTTL (the older ones have no valid access token left),
+// and deletes the expired sessions and refresh tokens once an hour
+func (s *SessionService) poll() error {
+	now := time.Now()
+	var sessions []models.UserSession
+	if err := s.DB.Select("id", "revoked_at").Where("revoked_at > ?", now.Add(-s.AccessTTL)).Find(&sessions).Error; err != nil {
+		return err
+	}
+	revoked := make(map[string]time.Time, len(sessions))
+	for _, session := range sessions {
+		revoked[session.ID] = *session.RevokedAt
+	}
+	s.mu.Lock()
+	s.revoked = revoked
+	s.mu.Unlock()
+
+	if now.Sub(s.prunedAt) < time.Hour {
+		return nil
+	}
+	s.prunedAt = now
+	// an expired refresh token is refused by its exp claim already
+	if err := s.DB.Where("expires_at < ?", now).Delete(&models.RefreshToken{}).Error; err != nil {
+		return err
+	}
+	return s.DB.Where("expires_at < ?", now.Add(-s.AccessTTL)).Delete(&models.UserSession{}).Error
+}
+
+// IsRevoked tells whether the access tokens of the session are refused
+func (s *SessionService) IsRevoked(sessionID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.revoked[sessionID]
+	return ok
+}
+
+// Create starts a session of userID -> the session and its first refresh token
+func (s *SessionService) Create(userID string) (*models.UserSession, *models.RefreshToken, error) {
+	expiresAt := time.Now().Add(s.RefreshTTL)
+	session := &models.UserSession{ID: uuid.New().String(), UserID: userID, ExpiresAt: expiresAt}
+	token := &models.RefreshToken{ID: uuid.New().String(), SessionID: session.ID, ExpiresAt: expiresAt}
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(session).Error; err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return session, token, nil
+}
+
+// Rotate uses the refresh token tokenID -> the session and the refresh token replacing it.
+// If it was used already, the session is revoked and errs.ErrRefreshTokenReused returned.
+func (s *SessionService) Rotate(tokenID string) (*models.UserSession, *models.RefreshToken, error) {
+	var session models.UserSession
+	next := &models.RefreshToken{ID: uuid.New().String()}
+	reused := false
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var token models.RefreshToken
+		if err := tx.Where("id = ?", tokenID).First(&token).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("id = ?", token.SessionID).First(&session).Error; err != nil {
+			return err
+		}
+		if session.RevokedAt != nil {
+			return errs.ErrSessionRevoked
+		}
+		now := time.Now()
+		if now.After(token.ExpiresAt) {
+			return errs.ErrJWTExpired
+		}
+		// a conditional update, so only one of concurrent refreshes wins
+		result := tx.Model(&token).Where("used_at IS NULL").Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			reused = true
+			return nil
+		}
+		next.SessionID = session.ID
+		next.ExpiresAt = now.Add(s.RefreshTTL)
+		if err := tx.Create(next).Error; err != nil {
+			return err
+		}
+		return tx.Model(&session).Update("expires_at", next.ExpiresAt).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, errs.ErrInvalidJWT
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if reused {
+		log.Printf("Refresh token %s of session %s (user %s) used again, revoking the session", tokenID, session.ID, session.UserID)
+		if err := s.Revoke(session.ID, models.SessionRevoked_Reuse); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, errs.ErrRefreshTokenReused
+	}
+	return &session, next, nil
+}
+
+// Revoke ends the session: its refresh tokens and access tokens are refused
+func (s *SessionService) Revoke(sessionID string, reason string) error {
+	now := time.Now()
+	err := s.DB.Model(&models.UserSession{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Updates(map[string]interface{}{"revoked_at": now, "revoked_reason": reason}).Error
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.revoked[sessionID] = now
+	s.mu.Unlock()
+	return nil
+}
+
+// RevokeUser ends every session of userID -> how many
+func (s *SessionService) RevokeUser(userID string, reason string) (int, error) {
+	now := time.Now()
+	var sessionIDs []string
+	err := s.DB.Model(&models.UserSession{}).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, now).
+		Pluck("id", &sessionIDs).Error
+	if err != nil || len(sessionIDs) == 0 {
+		return 0, err
+	}
+	err = s.DB.Model(&models.UserSession{}).
+		Where("id IN ? AND revoked_at IS NULL", sessionIDs).
+		Updates(map[string]interface{}{"revoked_at": now, "revoked_reason": reason}).Error
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	for _, sessionID := range sessionIDs {
+		s.revoked[sessionID] = now
+	}
+	s.mu.Unlock()
+	return len(sessionIDs), nil
+}
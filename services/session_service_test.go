@@ -0,0 +1,97 @@
+package services
+
+import (
+	"avazon-api/controllers/errs"
+	"avazon-api/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSessionServiceRotate(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(s *SessionService, session *models.UserSession, token *models.RefreshToken) string // -> the token to rotate
+		want    error
+		revoked bool // the session after the rotation
+	}{
+		{"rotated", func(s *SessionService, session *models.UserSession, token *models.RefreshToken) string {
+			return token.ID
+		}, nil, false},
+		{"unknown token", func(s *SessionService, session *models.UserSession, token *models.RefreshToken) string {
+			return "missing"
+		}, errs.ErrInvalidJWT, false},
+		{"reused", func(s *SessionService, session *models.UserSession, token *models.RefreshToken) string {
+			if _, _, err := s.Rotate(token.ID); err != nil {
+				t.Fatal(err)
+			}
+			return token.ID
+		}, errs.ErrRefreshTokenReused, true},
+		{"revoked session", func(s *SessionService, session *models.UserSession, token *models.RefreshToken) string {
+			if err := s.Revoke(session.ID, models.SessionRevoked_Logout); err != nil {
+				t.Fatal(err)
+			}
+			return token.ID
+		}, errs.ErrSessionRevoked, true},
+		{"expired token", func(s *SessionService, session *models.UserSession, token *models.RefreshToken) string {
+			s.DB.Model(token).Update("expires_at", time.Now().Add(-time.Minute))
+			return token.ID
+		}, errs.ErrJWTExpired, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSessionService(newTestDB(t, &models.UserSession{}, &models.RefreshToken{}), time.Minute, time.Hour)
+			session, token, err := s.Create("u1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			rotated, next, err := s.Rotate(tt.prepare(s, session, token))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Rotate: got %v, want %v", err, tt.want)
+			}
+			if err == nil {
+				if rotated.ID != session.ID || next.ID == token.ID || next.SessionID != session.ID {
+					t.Errorf("rotated to token %+v of session %s", next, rotated.ID)
+				}
+				// the new token can be used in turn
+				if _, _, err := s.Rotate(next.ID); err != nil {
+					t.Errorf("rotating the new token: %v", err)
+				}
+			}
+			if s.IsRevoked(session.ID) != tt.revoked {
+				t.Errorf("session revoked: %v, want %v", s.IsRevoked(session.ID), tt.revoked)
+			}
+			if tt.revoked {
+				// revoked on every replica too
+				other := NewSessionService(s.DB, time.Minute, time.Hour)
+				if err := other.poll(); err != nil || !other.IsRevoked(session.ID) {
+					t.Errorf("not revoked after poll: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestSessionServiceReuseRevokesNewTokens(t *testing.T) {
+	s := NewSessionService(newTestDB(t, &models.UserSession{}, &models.RefreshToken{}), time.Minute, time.Hour)
+	session, token, err := s.Create("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, next, err := s.Rotate(token.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Rotate(token.ID); !errors.Is(err, errs.ErrRefreshTokenReused) {
+		t.Fatalf("reusing the old token: %v", err)
+	}
+	var stored models.UserSession
+	s.DB.First(&stored, "id = ?", session.ID)
+	if stored.RevokedReason == nil || *stored.RevokedReason != models.SessionRevoked_Reuse {
+		t.Errorf("revoked reason %v, want %s", stored.RevokedReason, models.SessionRevoked_Reuse)
+	}
+	// whoever holds the token rotated in is signed out too
+	if _, _, err := s.Rotate(next.ID); !errors.Is(err, errs.ErrSessionRevoked) {
+		t.Errorf("rotating the new token: got %v, want ErrSessionRevoked", err)
+	}
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"avazon-api/utils"
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+var ErrObjectNotFound = errors.New("object not found")
+
+// Storage keeps the generated files (images, voices, music, videos) and hands out their public URLs.
+//   - S3Service: S3 bucket (production)
+//   - LocalStorage: directory on disk, served by the router
+//   - MemoryStorage: process memory, for tests
+type Storage interface {
+	// Upload stores data under key and returns its public URL
+	Upload(ctx context.Context, key string, data []byte, contentType string) (string, error)
+	// Download returns (data, content_type), or ErrObjectNotFound
+	Download(ctx context.Context, key string) ([]byte, string, error)
+	Delete(ctx context.Context, key string) error
+	Exists(ctx context.Context, key string) (bool, error)
+	// URL is the public URL of key. URL("") is the prefix of every URL of this storage.
+	URL(key string) string
+}
+
+// ReadURL fetches a file by its public URL, straight from storage if the URL belongs to it.
+// -> content, contentType(mime-type), error
+func ReadURL(ctx context.Context, storage Storage, fileURL string) ([]byte, string, error) {
+	if escaped, ok := strings.CutPrefix(fileURL, storage.URL("")); ok && escaped != "" {
+		if key, err := url.PathUnescape(escaped); err == nil {
+			return storage.Download(ctx, key)
+		}
+	}
+	return utils.GetDataFromURL(ctx, fileURL)
+}
+
+// escapeKey escapes every path segment of key for use in a URL
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+type memoryObject struct {
+	data        []byte
+	contentType string
+}
+
+type MemoryStorage struct {
+	BaseURL string
+	objects map[string]memoryObject
+	mu      sync.RWMutex
+}
+
+func NewMemoryStorage(baseURL string) *MemoryStorage {
+	return &MemoryStorage{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		objects: make(map[string]memoryObject),
+	}
+}
+
+func (s *MemoryStorage) Upload(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = memoryObject{data: append([]byte{}, data...), contentType: contentType}
+	return s.URL(key), nil
+}
+
+func (s *MemoryStorage) Download(ctx context.Context, key string) ([]byte, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	object, ok := s.objects[key]
+	if !ok {
+		return nil, "", ErrObjectNotFound
+	}
+	return append([]byte{}, object.data...), object.contentType, nil
+}
+
+func (s *MemoryStorage) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *MemoryStorage) Exists(ctx context.Context, key string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.objects[key]
+	return ok, nil
+}
+
+func (s *MemoryStorage) URL(key string) string {
+	return s.BaseURL + "/" + escapeKey(key)
+}
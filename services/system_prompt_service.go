@@ -3,6 +3,7 @@ package services
 import (
 	"avazon-api/models"
 	"avazon-api/tools"
+	"context"
 	"errors"
 	"fmt"
 
@@ -11,12 +12,12 @@ import (
 
 type SystemPromptService struct {
 	DB              *gorm.DB
-	CreateAssistant func() tools.Assistant
+	CreateAssistant func(agent string) tools.Assistant // vendor and model are configured per agent (AGENT_ASSISTANTS)
 }
 
 func NewSystemPromptService(
 	db *gorm.DB,
-	createAssistant func() tools.Assistant,
+	createAssistant func(agent string) tools.Assistant,
 ) *SystemPromptService {
 	return &SystemPromptService{
 		DB:              db,
@@ -51,16 +52,21 @@ func (s *SystemPromptService) GetSystemPrompt(promptAgent Agent) (string, error)
 	return promptUsage.Prompt.Prompt, nil
 }
 
+// NewAssistant creates the assistant configured for agent
+func (s *SystemPromptService) NewAssistant(agent Agent) tools.Assistant {
+	return s.CreateAssistant(string(agent))
+}
+
 // usually used by other service components
-func (s *SystemPromptService) Use(agent Agent, input string) (string, error) {
+func (s *SystemPromptService) Use(ctx context.Context, agent Agent, input string) (string, error) {
 	var systemPromptUsage models.SystemPromptUsage
 	result := s.DB.Where("agent_id = ?", agent).Preload("Prompt").First(&systemPromptUsage)
 	if result.Error != nil {
 		return "", fmt.Errorf("system prompt not found for agent: %s, error: %w", agent, result.Error)
 	}
-	assistant := s.CreateAssistant()
+	assistant := s.NewAssistant(agent)
 	assistant.SetSystemPrompt(systemPromptUsage.Prompt.Prompt)
-	return assistant.Handle(input)
+	return assistant.Handle(ctx, input)
 }
 
 // checks if a system prompt exists in the database and either creates or updates it accordingly.
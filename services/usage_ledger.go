@@ -0,0 +1,170 @@
+package services
+
+import (
+	"avazon-api/models"
+	"avazon-api/tools"
+	"context"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// what a provider call is made for
+const (
+	UC_AvatarCreation = "avatar_creation" // AvatarCreation: chats, images, characters, voices, and the chat video of the avatar
+	UC_Music          = "music"           // AvatarMusicContentCreation
+	UC_Video          = "video"           // AvatarVideoContentCreation
+	UC_Remix          = "remix"           // AvatarImageRemix
+)
+
+// UsageOwner is who, and which creation, the provider calls are made for
+type UsageOwner struct {
+	UserID       string
+	AvatarID     string // empty while the avatar is being created
+	CreationType string
+	CreationID   string
+}
+
+// UsagePrice is what a provider charges for an operation, in USD
+type UsagePrice struct {
+	PerUnit          float64 // image, request, character or second
+	PerMillionInput  float64 // chat tokens
+	PerMillionOutput float64
+}
+
+// DefaultUsagePrices are the list prices of the providers, keyed provider/operation. Override them with USAGE_PRICES.
+var DefaultUsagePrices = map[string]UsagePrice{
+	"openai/chat":             {PerMillionInput: 2.5, PerMillionOutput: 10}, // gpt-4o
+	"anthropic/chat":          {PerMillionInput: 3, PerMillionOutput: 15},   // claude sonnet
+	"openai/image":            {PerUnit: 0.08},                              // dall-e-3 and gpt-image-1, 1024x1792
+	"openart/image":           {PerUnit: 0.02},
+	"openart/prompt":          {PerUnit: 0},
+	"elevenlabs/tts":          {PerUnit: 0.0003},
+	"elevenlabs/voice_design": {PerUnit: 0.0003},
+	"runway/video":            {PerUnit: 0.05},
+	"jenai/music":             {PerUnit: 0.005},
+}
+
+// UsageLedger records the usage and cost of every provider call, attributed to its UsageOwner
+type UsageLedger struct {
+	DB     *gorm.DB
+	Prices map[string]UsagePrice
+}
+
+// NewUsageLedger prices the usage with DefaultUsagePrices, overridden by prices
+func NewUsageLedger(db *gorm.DB, prices map[string]UsagePrice) *UsageLedger {
+	merged := make(map[string]UsagePrice, len(DefaultUsagePrices)+len(prices))
+	for key, price := range DefaultUsagePrices {
+		merged[key] = price
+	}
+	for key, price := range prices {
+		merged[key] = price
+	}
+	return &UsageLedger{DB: db, Prices: merged}
+}
+
+// Track returns a context recording the provider calls made with it for owner
+func (l *UsageLedger) Track(ctx context.Context, owner UsageOwner) context.Context {
+	return tools.WithUsageRecorder(ctx, func(usage tools.Usage) {
+		l.record(owner, usage)
+	})
+}
+
+// cost of the usage; a provider without price (e.g. a self hosted assistant) costs nothing
+func (l *UsageLedger) cost(usage tools.Usage) float64 {
+	price := l.Prices[usage.Provider+"/"+string(usage.Operation)]
+	if usage.Unit == tools.Unit_Tokens {
+		return (float64(usage.InputTokens)*price.PerMillionInput + float64(usage.OutputTokens)*price.PerMillionOutput) / 1e6
+	}
+	return usage.Quantity * price.PerUnit
+}
+
+func (l *UsageLedger) record(owner UsageOwner, usage tools.Usage) {
+	record := models.UsageRecord{
+		UserID:       optional(owner.UserID),
+		AvatarID:     optional(owner.AvatarID),
+		CreationType: optional(owner.CreationType),
+		CreationID:   optional(owner.CreationID),
+		Provider:     usage.Provider,
+		Operation:    string(usage.Operation),
+		Model:        optional(usage.Model),
+		Unit:         string(usage.Unit),
+		Quantity:     usage.Quantity,
+		InputTokens:  usage.InputTokens,
+		OutputTokens: usage.OutputTokens,
+		Detail:       optional(usage.Detail),
+		Cost:         l.cost(usage),
+	}
+	// the call is made (and paid) whether or not its caller is still waiting, so the request context isn't used here
+	if err := l.DB.Create(&record).Error; err != nil {
+		log.Printf("Error recording %s %s usage of user %s: %v", usage.Provider, usage.Operation, owner.UserID, err)
+	}
+}
+
+func optional(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// UsageFilter selects the records of the aggregates. Zero values select everything.
+type UsageFilter struct {
+	From     time.Time // inclusive
+	To       time.Time // exclusive
+	UserID   string
+	Provider string
+}
+
+// UsageCost is one group of an aggregate. Only the fields of the grouping are set.
+type UsageCost struct {
+	UserID       *string `json:"user_id,omitempty"`
+	Provider     string  `json:"provider,omitempty"`
+	Operation    string  `json:"operation,omitempty"`
+	Unit         string  `json:"unit,omitempty"`
+	Day          string  `json:"day,omitempty"`      // YYYY-MM-DD
+	Quantity     float64 `json:"quantity,omitempty"` // by provider, where the unit is the same
+	InputTokens  int64   `json:"input_tokens"`
+	OutputTokens int64   `json:"output_tokens"`
+	Calls        int64   `json:"calls"`
+	Cost         float64 `json:"cost"`
+}
+
+const usageTotals = "COUNT(*) AS calls, SUM(input_tokens) AS input_tokens, SUM(output_tokens) AS output_tokens, SUM(cost) AS cost"
+
+func (l *UsageLedger) aggregate(filter UsageFilter, columns string, groupBy string, orderBy string) ([]UsageCost, error) {
+	query := l.DB.Model(&models.UsageRecord{}).Select(columns + ", " + usageTotals).Group(groupBy).Order(orderBy)
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	if filter.UserID != "" {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.Provider != "" {
+		query = query.Where("provider = ?", filter.Provider)
+	}
+	costs := []UsageCost{}
+	if err := query.Scan(&costs).Error; err != nil {
+		return nil, err
+	}
+	return costs, nil
+}
+
+// CostByUser -> the most expensive users first
+func (l *UsageLedger) CostByUser(filter UsageFilter) ([]UsageCost, error) {
+	return l.aggregate(filter, "user_id", "user_id", "cost DESC")
+}
+
+// CostByProvider -> by provider, operation and unit
+func (l *UsageLedger) CostByProvider(filter UsageFilter) ([]UsageCost, error) {
+	return l.aggregate(filter, "provider, operation, unit, SUM(quantity) AS quantity", "provider, operation, unit", "provider, operation, unit")
+}
+
+// CostByDay -> by day (UTC on sqlite, the database timezone on postgres)
+func (l *UsageLedger) CostByDay(filter UsageFilter) ([]UsageCost, error) {
+	return l.aggregate(filter, "CAST(DATE(created_at) AS TEXT) AS day", "day", "day")
+}
@@ -3,6 +3,7 @@ package services
 import (
 	"avazon-api/controllers/errs"
 	"avazon-api/models"
+	"avazon-api/utils"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -17,10 +18,11 @@ import (
 type UserService struct {
 	DB            *gorm.DB
 	DWUserService *DynamicWalletUserService
+	Credits       *CreditService
 }
 
-func NewUserService(db *gorm.DB, dwUserService *DynamicWalletUserService) *UserService {
-	return &UserService{DB: db, DWUserService: dwUserService}
+func NewUserService(db *gorm.DB, dwUserService *DynamicWalletUserService, credits *CreditService) *UserService {
+	return &UserService{DB: db, DWUserService: dwUserService, Credits: credits}
 }
 func (s *UserService) GetUserGoogleAccessTokenByAuthorizationCode(code string) (string, error) {
 	// Google OAuth2 token endpoint
@@ -34,9 +36,6 @@ func (s *UserService) GetUserGoogleAccessTokenByAuthorizationCode(code string) (
 		os.Getenv("GOOGLE_REDIRECT_URI"),
 	)
 
-	// Create HTTP client
-	client := &http.Client{}
-
 	// Create a new POST request
 	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(reqBody))
 	if err != nil {
@@ -45,7 +44,7 @@ func (s *UserService) GetUserGoogleAccessTokenByAuthorizationCode(code string) (
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	// Send the request
-	resp, err := client.Do(req)
+	resp, err := utils.HTTPClient.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -150,7 +149,9 @@ func (s *UserService) GetUserByIDCreateIfNotExists(userID string) (*models.User,
 			return nil, err
 		}
 		dwUser.Role = "user"
-		s.DB.Create(dwUser)
+		if err := s.Credits.CreateUser(dwUser); err != nil {
+			return nil, err
+		}
 		return dwUser, nil
 	} else if user != nil {
 		return user, nil
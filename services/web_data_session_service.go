@@ -3,6 +3,8 @@ package services
 import (
 	"avazon-api/controllers/errs"
 	"avazon-api/models"
+	"context"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,83 +12,109 @@ import (
 
 // web browser data transaction between different domains
 type WebDataSessionService struct {
-	data      map[string]*models.WebDataSession  // key: session_id, value: session
-	tokenData map[string]*models.WebTokenSession // key: session_id, value: session
+	store       WebDataSessionStore
+	TokenTTL    time.Duration // token sessions are fetched right after they are put
+	DataIdleTTL time.Duration // data sessions expire after this long without being read or written
 }
 
-func NewWebDataSessionService() *WebDataSessionService {
+func NewWebDataSessionService(store WebDataSessionStore, tokenTTL time.Duration, dataIdleTTL time.Duration) *WebDataSessionService {
 	return &WebDataSessionService{
-		data:      make(map[string]*models.WebDataSession),
-		tokenData: make(map[string]*models.WebTokenSession),
+		store:       store,
+		TokenTTL:    tokenTTL,
+		DataIdleTTL: dataIdleTTL,
 	}
 }
 
+// storeError maps a missing session to ErrNotFound
+func storeError(err error) error {
+	if errors.Is(err, ErrWebSessionNotFound) {
+		return errs.ErrNotFound
+	}
+	return err
+}
+
+// getData returns the user's data session and marks it as used
+func (s *WebDataSessionService) getData(ctx context.Context, userID string, sessionID string) (*models.WebDataSession, error) {
+	session, err := s.store.GetData(ctx, sessionID)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	if session.UserID != userID {
+		return nil, errs.ErrUnauthorized
+	}
+	session.LastUsedAt = time.Now()
+	return session, nil
+}
+
 // returns session_id
 func (s *WebDataSessionService) PutData(userID string, sessionID string, content string) error {
-	session, ok := s.data[sessionID]
-	if ok && session.UserID == userID {
-		session.Content = content
-		session.LastUsedAt = time.Now()
-		return nil
+	if len(content) > models.WebDataContentMaxBytes {
+		return errs.ErrWebDataTooLarge
+	}
+	ctx := context.TODO()
+	session, err := s.getData(ctx, userID, sessionID)
+	if err != nil {
+		return err
 	}
-	return errs.ErrNotFound
+	session.Content = content
+	return s.store.PutData(ctx, session, s.DataIdleTTL)
 }
 
 func (s *WebDataSessionService) GetData(sessionID string, userID string) (string, error) {
-	session, ok := s.data[sessionID]
-	if !ok {
-		return "", errs.ErrNotFound
+	ctx := context.TODO()
+	session, err := s.getData(ctx, userID, sessionID)
+	if err != nil {
+		return "", err
 	}
-	if session.UserID != userID {
-		return "", errs.ErrUnauthorized
+	if err := s.store.PutData(ctx, session, s.DataIdleTTL); err != nil {
+		return "", err
 	}
 	return session.Content, nil
 }
 
 func (s *WebDataSessionService) ClearData(sessionID string, userID string) error {
-	_, ok := s.data[sessionID]
-	if !ok {
-		return errs.ErrNotFound
+	ctx := context.TODO()
+	if _, err := s.getData(ctx, userID, sessionID); err != nil {
+		return err
 	}
-	delete(s.data, sessionID)
-	return nil
+	return storeError(s.store.DeleteData(ctx, sessionID))
 }
 
 func (s *WebDataSessionService) PutToken(userID string, token string) (models.WebTokenSession, error) {
+	ctx := context.TODO()
+	now := time.Now()
 	session := &models.WebTokenSession{
 		TokenKey:      uuid.New().String(),
 		DataSessionID: uuid.New().String(),
 		UserID:        userID,
 		Token:         token,
-		CreatedAt:     time.Now(),
+		CreatedAt:     now,
 	}
 
-	s.tokenData[session.TokenKey] = session
-	s.data[session.DataSessionID] = &models.WebDataSession{
-		SessionID: session.DataSessionID,
-		UserID:    userID,
-		CreatedAt: time.Now(),
-		Content:   "",
+	if err := s.store.PutData(ctx, &models.WebDataSession{
+		SessionID:  session.DataSessionID,
+		UserID:     userID,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		Content:    "",
+	}, s.DataIdleTTL); err != nil {
+		return models.WebTokenSession{}, err
+	}
+	if err := s.store.PutToken(ctx, session, s.TokenTTL); err != nil {
+		return models.WebTokenSession{}, err
 	}
 
 	return *session, nil
 }
 
 func (s *WebDataSessionService) GetToken(tokenKey string) (models.WebTokenSession, error) {
-	session, ok := s.tokenData[tokenKey]
-	if !ok {
-		return models.WebTokenSession{}, errs.ErrNotFound
+	session, err := s.store.TakeToken(context.TODO(), tokenKey)
+	if err != nil {
+		return models.WebTokenSession{}, storeError(err)
 	}
-	sessionCopy := *session
-	delete(s.tokenData, tokenKey)
-	return sessionCopy, nil
+	return *session, nil
 }
 
 func (s *WebDataSessionService) ClearToken(tokenKey string) error {
-	_, ok := s.tokenData[tokenKey]
-	if !ok {
-		return errs.ErrNotFound
-	}
-	delete(s.tokenData, tokenKey)
-	return nil
+	return storeError(s.store.DeleteToken(context.TODO(), tokenKey))
 }
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"avazon-api/models"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrWebSessionNotFound = errors.New("web session not found")
+
+// WebDataSessionStore keeps the sessions of WebDataSessionService until their TTL runs out.
+//   - MemoryWebDataSessionStore: process memory (single server)
+//   - RedisWebDataSessionStore: redis, shared by every replica and kept across restarts
+type WebDataSessionStore interface {
+	PutToken(ctx context.Context, session *models.WebTokenSession, ttl time.Duration) error
+	// TakeToken returns the token session and deletes it, or ErrWebSessionNotFound
+	TakeToken(ctx context.Context, tokenKey string) (*models.WebTokenSession, error)
+	DeleteToken(ctx context.Context, tokenKey string) error
+	// PutData saves the data session, which expires ttl from now
+	PutData(ctx context.Context, session *models.WebDataSession, ttl time.Duration) error
+	// GetData returns the data session, or ErrWebSessionNotFound
+	GetData(ctx context.Context, sessionID string) (*models.WebDataSession, error)
+	DeleteData(ctx context.Context, sessionID string) error
+}
+
+type memoryTokenSession struct {
+	session   models.WebTokenSession
+	expiresAt time.Time
+}
+
+type memoryDataSession struct {
+	session   models.WebDataSession
+	expiresAt time.Time
+}
+
+// MemoryWebDataSessionStore keeps the sessions in maps. Expired sessions are never returned,
+// and a sweeper removes them every sweepInterval.
+type MemoryWebDataSessionStore struct {
+	tokens map[string]memoryTokenSession // key: token_key
+	data   map[string]memoryDataSession  // key: session_id
+	mu     sync.Mutex
+}
+
+func NewMemoryWebDataSessionStore(sweepInterval time.Duration) *MemoryWebDataSessionStore {
+	s := &MemoryWebDataSessionStore{
+		tokens: make(map[string]memoryTokenSession),
+		data:   make(map[string]memoryDataSession),
+	}
+	go s.sweep(sweepInterval)
+	return s
+}
+
+func (s *MemoryWebDataSessionStore) sweep(interval time.Duration) {
+	for range time.Tick(interval) {
+		now := time.Now()
+		s.mu.Lock()
+		for key, token := range s.tokens {
+			if now.After(token.expiresAt) {
+				delete(s.tokens, key)
+			}
+		}
+		for id, data := range s.data {
+			if now.After(data.expiresAt) {
+				delete(s.data, id)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *MemoryWebDataSessionStore) PutToken(ctx context.Context, session *models.WebTokenSession, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[session.TokenKey] = memoryTokenSession{session: *session, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryWebDataSessionStore) TakeToken(ctx context.Context, tokenKey string) (*models.WebTokenSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[tokenKey]
+	delete(s.tokens, tokenKey)
+	if !ok || time.Now().After(token.expiresAt) {
+		return nil, ErrWebSessionNotFound
+	}
+	return &token.session, nil
+}
+
+func (s *MemoryWebDataSessionStore) DeleteToken(ctx context.Context, tokenKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[tokenKey]
+	delete(s.tokens, tokenKey)
+	if !ok || time.Now().After(token.expiresAt) {
+		return ErrWebSessionNotFound
+	}
+	return nil
+}
+
+func (s *MemoryWebDataSessionStore) PutData(ctx context.Context, session *models.WebDataSession, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[session.SessionID] = memoryDataSession{session: *session, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryWebDataSessionStore) GetData(ctx context.Context, sessionID string) (*models.WebDataSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.data[sessionID]
+	if !ok || time.Now().After(data.expiresAt) {
+		return nil, ErrWebSessionNotFound
+	}
+	return &data.session, nil
+}
+
+func (s *MemoryWebDataSessionStore) DeleteData(ctx context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.data[sessionID]
+	delete(s.data, sessionID)
+	if !ok || time.Now().After(data.expiresAt) {
+		return ErrWebSessionNotFound
+	}
+	return nil
+}
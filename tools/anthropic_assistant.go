@@ -0,0 +1,316 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// AnthropicBaseURL is the API of Anthropic itself
+const AnthropicBaseURL = "https://api.anthropic.com"
+
+const anthropicVersion = "2023-06-01"
+
+// AnthropicAssistant is an Assistant of the Anthropic Messages API (Claude)
+type AnthropicAssistant struct {
+	messages  []Message
+	tools     []Tool
+	baseURL   string
+	apiKey    string
+	model     string
+	maxTokens int
+}
+
+func NewAnthropicAssistant(baseURL string, apiKey string, model string, maxTokens int) *AnthropicAssistant {
+	return &AnthropicAssistant{
+		messages:  []Message{},
+		tools:     []Tool{},
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		apiKey:    apiKey,
+		model:     model,
+		maxTokens: maxTokens,
+	}
+}
+
+type anthropicRequest struct {
+	Model     string             `json:"model"`
+	MaxTokens int                `json:"max_tokens"`
+	System    string             `json:"system,omitempty"`
+	Messages  []anthropicMessage `json:"messages"`
+	Tools     []anthropicTool    `json:"tools,omitempty"`
+	Stream    bool               `json:"stream"`
+}
+
+type anthropicTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+}
+
+type anthropicMessage struct {
+	Role    string                  `json:"role"` // user or assistant
+	Content []anthropicContentBlock `json:"content"`
+}
+
+type anthropicContentBlock struct {
+	Type      string          `json:"type"`                  // text, tool_use or tool_result
+	Text      string          `json:"text,omitempty"`        // text
+	ID        string          `json:"id,omitempty"`          // tool_use
+	Name      string          `json:"name,omitempty"`        // tool_use
+	Input     json.RawMessage `json:"input,omitempty"`       // tool_use
+	ToolUseID string          `json:"tool_use_id,omitempty"` // tool_result
+	Content   string          `json:"content,omitempty"`     // tool_result
+}
+
+type anthropicResponse struct {
+	Content []anthropicContentBlock `json:"content"`
+	Usage   anthropicUsage          `json:"usage"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// one event of the stream
+type anthropicEvent struct {
+	Type         string                `json:"type"`
+	Index        int                   `json:"index"`
+	ContentBlock anthropicContentBlock `json:"content_block"` // content_block_start
+	Message      anthropicResponse     `json:"message"`       // message_start: the input tokens
+	Usage        anthropicUsage        `json:"usage"`         // message_delta: the output tokens so far
+	Delta        struct {
+		Type        string `json:"type"`         // text_delta or input_json_delta
+		Text        string `json:"text"`         // text_delta
+		PartialJSON string `json:"partial_json"` // input_json_delta
+		StopReason  string `json:"stop_reason"`  // message_delta
+	} `json:"delta"` // content_block_delta, message_delta
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"` // error
+}
+
+// request converts the messages: the system prompt goes apart, tool results are user messages,
+// and consecutive messages of the same role are merged as the API expects the roles to alternate.
+func (a *AnthropicAssistant) request(stream bool) anthropicRequest {
+	request := anthropicRequest{Model: a.model, MaxTokens: a.maxTokens, Stream: stream}
+	for _, m := range answerToolCalls(a.messages) {
+		role := m.Role
+		var blocks []anthropicContentBlock
+		switch m.Role {
+		case "system":
+			request.System = m.Content
+			continue
+		case "tool":
+			role = "user"
+			blocks = append(blocks, anthropicContentBlock{Type: "tool_result", ToolUseID: m.ToolCallID, Content: m.Content})
+		default:
+			if m.Content != "" {
+				blocks = append(blocks, anthropicContentBlock{Type: "text", Text: m.Content})
+			}
+			for _, call := range m.ToolCalls {
+				input := json.RawMessage(call.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, anthropicContentBlock{Type: "tool_use", ID: call.ID, Name: call.Name, Input: input})
+			}
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+		if last := len(request.Messages) - 1; last >= 0 && request.Messages[last].Role == role {
+			request.Messages[last].Content = append(request.Messages[last].Content, blocks...)
+			continue
+		}
+		request.Messages = append(request.Messages, anthropicMessage{Role: role, Content: blocks})
+	}
+	for _, tool := range a.tools {
+		request.Tools = append(request.Tools, anthropicTool{Name: tool.Name, Description: tool.Description, InputSchema: tool.Parameters.schema()})
+	}
+	return request
+}
+
+func (a *AnthropicAssistant) post(ctx context.Context, request anthropicRequest) (*http.Response, error) {
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", a.baseURL+"/v1/messages", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", a.apiKey)
+	req.Header.Set("anthropic-version", anthropicVersion)
+
+	resp, err := anthropicHTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, statusError(resp)
+	}
+	return resp, nil
+}
+
+func (a *AnthropicAssistant) SetSystemPrompt(prompt string) {
+	a.messages = setSystemPrompt(a.messages, prompt)
+}
+
+func (a *AnthropicAssistant) Handle(ctx context.Context, userInput string) (string, error) {
+	a.messages = append(a.messages, Message{Role: "user", Content: userInput})
+
+	resp, err := a.post(ctx, a.request(false))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var anthropicResp anthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&anthropicResp); err != nil {
+		return "", err
+	}
+	recordTokens(ctx, anthropicHTTP.breaker.provider, a.model, anthropicResp.Usage.InputTokens, anthropicResp.Usage.OutputTokens)
+	respMessage := ""
+	for _, block := range anthropicResp.Content {
+		if block.Type == "text" {
+			respMessage += block.Text
+		}
+	}
+	if respMessage == "" {
+		return "", fmt.Errorf("no response from Anthropic")
+	}
+	a.messages = append(a.messages, Message{Role: "assistant", Content: respMessage})
+	return respMessage, nil
+}
+
+func (a *AnthropicAssistant) HandleAsync(ctx context.Context, userInput string) <-chan AssistantEvent {
+	if userInput != "" {
+		a.messages = append(a.messages, Message{Role: "user", Content: userInput})
+	}
+	return a.stream(ctx)
+}
+
+func (a *AnthropicAssistant) HandleToolResults(ctx context.Context, results []ToolResult) <-chan AssistantEvent {
+	a.messages = appendToolResults(a.messages, results)
+	return a.stream(ctx)
+}
+
+// stream requests the next answer. Each tool call is a content block, complete when the block stops.
+func (a *AnthropicAssistant) stream(ctx context.Context) <-chan AssistantEvent {
+	stream := newEventStream(ctx)
+
+	go func() {
+		defer close(stream.events)
+
+		resp, err := a.post(ctx, a.request(true))
+		if err != nil {
+			stream.fail(err)
+			return
+		}
+		defer resp.Body.Close()
+
+		// the tokens are billed even when the stream is cut
+		var usage anthropicUsage
+		defer func() {
+			recordTokens(ctx, anthropicHTTP.breaker.provider, a.model, usage.InputTokens, usage.OutputTokens)
+		}()
+
+		respContent := ""
+		stopReason := ""
+		var toolCalls []ToolCall
+		calls := map[int]int{} // content block index -> tool call index
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue // event names, blank lines
+			}
+			var event anthropicEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				stream.fail(fmt.Errorf("error parsing JSON: %v", err))
+				return
+			}
+
+			switch event.Type {
+			case "message_start":
+				usage = event.Message.Usage
+			case "content_block_start":
+				if event.ContentBlock.Type == "tool_use" {
+					index := len(toolCalls)
+					calls[event.Index] = index
+					toolCalls = append(toolCalls, ToolCall{ID: event.ContentBlock.ID, Name: event.ContentBlock.Name})
+					if !stream.send(AssistantEvent{Type: AE_ToolCallStart, Index: index, ToolCall: toolCalls[index]}) {
+						return
+					}
+				}
+			case "content_block_delta":
+				switch event.Delta.Type {
+				case "text_delta":
+					if event.Delta.Text != "" {
+						if !stream.send(AssistantEvent{Type: AE_TextDelta, Text: event.Delta.Text}) {
+							return
+						}
+						respContent += event.Delta.Text
+					}
+				case "input_json_delta":
+					index, ok := calls[event.Index]
+					if !ok || event.Delta.PartialJSON == "" {
+						continue
+					}
+					toolCalls[index].Arguments += event.Delta.PartialJSON
+					if !stream.send(AssistantEvent{Type: AE_ToolArgumentDelta, Index: index, Text: event.Delta.PartialJSON}) {
+						return
+					}
+				}
+			case "content_block_stop":
+				if index, ok := calls[event.Index]; ok {
+					if toolCalls[index].Arguments == "" {
+						toolCalls[index].Arguments = "{}" // a tool without parameters
+					}
+					if !stream.send(AssistantEvent{Type: AE_ToolCallComplete, Index: index, ToolCall: toolCalls[index]}) {
+						return
+					}
+				}
+			case "message_delta":
+				if event.Usage.OutputTokens > 0 {
+					usage.OutputTokens = event.Usage.OutputTokens
+				}
+				if event.Delta.StopReason != "" {
+					stopReason = event.Delta.StopReason
+				}
+			case "error":
+				stream.fail(fmt.Errorf("anthropic stream error: %s: %s", event.Error.Type, event.Error.Message))
+				return
+			case "message_stop":
+				a.messages = append(a.messages, Message{Role: "assistant", Content: respContent, ToolCalls: toolCalls})
+				stream.send(AssistantEvent{Type: AE_Finish, Content: respContent, ToolCalls: toolCalls, FinishReason: stopReason})
+				return
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			stream.fail(fmt.Errorf("error reading stream: %v", err))
+			return
+		}
+		stream.fail(fmt.Errorf("anthropic stream ended before message_stop"))
+	}()
+
+	return stream.events
+}
+
+func (a *AnthropicAssistant) SetTools(tools []Tool) {
+	a.tools = tools
+}
+
+func (a *AnthropicAssistant) Init(messages []Message) {
+	a.messages = messages
+}
@@ -3,11 +3,15 @@ package tools
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
 )
 
 // Simple assistant like ChatGPT
@@ -15,152 +19,259 @@ type Assistant interface {
 	// Set base system prompt
 	SetSystemPrompt(string)
 	// Handle user input and return response
-	Handle(userInput string) (string, error)
-	// Handle user input asynchronously and return response
-	HandleAsync(userInput string, args ...string) (output chan string, done chan string, err chan error)
-	SetTools(tools []OpenAITool)
+	Handle(ctx context.Context, userInput string) (string, error)
+	// Handle user input asynchronously and stream the answer (see AssistantEvent). The channel is closed after the
+	// finish or error event. When ctx is done, the request is aborted and the channel is closed.
+	HandleAsync(ctx context.Context, userInput string) <-chan AssistantEvent
+	// Answer the tool calls of the previous answer, and stream the next answer like HandleAsync
+	HandleToolResults(ctx context.Context, results []ToolResult) <-chan AssistantEvent
+	SetTools(tools []Tool)
 	Init(messages []Message)
 }
 
+// ======================================================================================================================
+// Provider-neutral model, converted to the wire format of each vendor
+// ======================================================================================================================
+
+// Tool is a function the assistant may call
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  ToolParameters
+}
+
+// ToolParameters describes the arguments of a tool: a JSON object without additional properties
+type ToolParameters struct {
+	Properties map[string]ToolParameter
+	Required   []string
+}
+
+type ToolParameter struct {
+	Type        string // string, number, integer or boolean
+	Description string
+	Enum        []string
+}
+
+// schema is the JSON schema of the arguments
+func (p ToolParameters) schema() map[string]interface{} {
+	properties := map[string]interface{}{}
+	for name, param := range p.Properties {
+		property := map[string]interface{}{"type": param.Type, "description": param.Description}
+		if len(param.Enum) > 0 {
+			property["enum"] = param.Enum
+		}
+		properties[name] = property
+	}
+	required := p.Required
+	if required == nil {
+		required = []string{}
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+type Message struct {
+	Role       string // system, user, assistant or tool
+	Content    string
+	ToolCalls  []ToolCall // calls made by an assistant message
+	ToolCallID string     // call answered by a tool message
+}
+
+type ToolCall struct {
+	ID        string
+	Name      string
+	Arguments string // JSON object
+}
+
+// ======================================================================================================================
+// OpenAI (and OpenAI compatible servers: vLLM, Ollama, ...)
+// ======================================================================================================================
+
+// OpenAIBaseURL is the API of OpenAI itself
+const OpenAIBaseURL = "https://api.openai.com/v1"
+
 // OpenAIAssistant struct definition
 type OpenAIAssistant struct {
 	messages []Message
-	tools    []OpenAITool
+	tools    []Tool
+	baseURL  string
 	apiKey   string
 	model    string
+	http     *providerClient
 }
 
-// Define struct for API request
-type OpenAIRequest struct {
-	Model    string       `json:"model"`
-	Messages []Message    `json:"messages"`
-	Stream   bool         `json:"stream"`
-	Tools    []OpenAITool `json:"tools,omitempty"`
+type openAIRequest struct {
+	Model         string               `json:"model"`
+	Messages      []openAIMessage      `json:"messages"`
+	Stream        bool                 `json:"stream"`
+	StreamOptions *openAIStreamOptions `json:"stream_options,omitempty"`
+	Tools         []openAITool         `json:"tools,omitempty"`
 }
 
-type OpenAITool struct {
-	Type     string             `json:"type"`
-	Function OpenAIToolFunction `json:"function"`
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"` // a last chunk, without choices, tells the tokens of the answer
 }
 
-type OpenAIToolFunction struct {
-	Name        string                   `json:"name"`
-	Description string                   `json:"description"`
-	Parameters  OpenAIFunctionParameters `json:"parameters"`
+type openAITool struct {
+	Type     string             `json:"type"` // function
+	Function openAIToolFunction `json:"function"`
 }
 
-type OpenAIFunctionParameters struct {
-	Type                 string                     `json:"type"` // object
-	Properties           map[string]OpenAIParameter `json:"properties"`
-	Required             []string                   `json:"required"`
-	AdditionalProperties bool                       `json:"additionalProperties"` // maybe false
+type openAIToolFunction struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Parameters  map[string]interface{} `json:"parameters"`
 }
 
-type OpenAIParameter struct {
-	Type        string   `json:"type"`
-	Description string   `json:"description"`
-	Enum        []string `json:"enum,omitempty"`
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"` // if Role is tool
 }
 
-type Message struct {
-	Role       string        `json:"role"`
-	Content    string        `json:"content"`
-	ToolCalls  []ToolCallRes `json:"tool_calls,omitempty"`
-	ToolCallId string        `json:"tool_call_id,omitempty"` // if Role is tool
+type openAIToolCall struct {
+	ID       string                 `json:"id"`
+	Type     string                 `json:"type"`
+	Function openAIToolCallFunction `json:"function"`
 }
 
-// Define struct for API response
-type OpenAIResponse struct {
-	ID      string   `json:"id"`
-	Object  string   `json:"object"`
-	Created int      `json:"created"`
-	Choices []Choice `json:"choices"`
+type openAIToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
 }
 
-// call from assistant
-type ToolCallRes struct {
-	ID       string            `json:"id"`
-	Type     string            `json:"type"`
-	Function OpenAIFunctionRes `json:"function"`
+type openAIResponse struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int            `json:"created"`
+	Choices []openAIChoice `json:"choices"`
+	Usage   *openAIUsage   `json:"usage"`
 }
 
-type OpenAIFunctionRes struct {
-	Name      string `json:"name"`
-	Arguments string `json:"arguments"`
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
 }
 
-// Delta struct represents partial message delta in streaming response
-type Delta struct {
-	Type      string        `json:"type,omitempty"`
-	Role      string        `json:"role,omitempty"`
-	Content   string        `json:"content,omitempty"`
-	ToolCalls []ToolCallRes `json:"tool_calls,omitempty"`
+type openAIChoice struct {
+	Message      openAIMessage `json:"message"`
+	Delta        openAIDelta   `json:"delta"` // streaming
+	FinishReason string        `json:"finish_reason"`
+	Index        int           `json:"index"`
 }
 
-type Choice struct {
-	Message      Message `json:"message"`
-	Delta        Delta   `json:"delta"`
-	FinishReason string  `json:"finish_reason"`
-	Index        int     `json:"index"`
+type openAIDelta struct {
+	Role      string                `json:"role,omitempty"`
+	Content   string                `json:"content,omitempty"`
+	ToolCalls []openAIToolCallDelta `json:"tool_calls,omitempty"`
 }
 
-// NewOpenAIAssistant creates a new OpenAIAssistant
-func NewOpenAIAssistant(apiKey string, model string) *OpenAIAssistant {
-	return &OpenAIAssistant{
-		messages: []Message{},
-		tools:    []OpenAITool{},
-		apiKey:   apiKey,
-		model:    model,
-	}
+// part of a tool call, by index
+type openAIToolCallDelta struct {
+	Index int `json:"index"`
+	openAIToolCall
+}
+
+// NewOpenAIAssistant creates an assistant of the chat completions API at baseURL (OpenAIBaseURL, or any compatible server)
+func NewOpenAIAssistant(baseURL string, apiKey string, model string) *OpenAIAssistant {
+	return NewOpenAIAssistantWithMessages(baseURL, apiKey, model, []Message{})
 }
 
-func NewOpenAIAssistantWithMessages(apiKey string, model string, messages []Message) *OpenAIAssistant {
+func NewOpenAIAssistantWithMessages(baseURL string, apiKey string, model string, messages []Message) *OpenAIAssistant {
 	return &OpenAIAssistant{
 		messages: messages,
-		tools:    []OpenAITool{},
+		tools:    []Tool{},
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
 		apiKey:   apiKey,
 		model:    model,
+		http:     openAICompatibleClient(baseURL),
 	}
 }
 
-// SetSystemPrompt sets the system prompt
-func (a *OpenAIAssistant) SetSystemPrompt(prompt string) {
-	if len(a.messages) == 0 {
-		a.messages = append(a.messages, Message{Role: "system", Content: prompt})
-	} else {
-		if a.messages[0].Role == "system" {
-			a.messages[0].Content = prompt
-		} else {
-			a.messages = append([]Message{{Role: "system", Content: prompt}}, a.messages...)
-		}
+// openAICompatibleClient gives other servers than OpenAI a circuit breaker of their own, named after their host
+func openAICompatibleClient(baseURL string) *providerClient {
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Host == "" || u.Host == "api.openai.com" {
+		return openAIHTTP
 	}
+	return newProviderClient(u.Host)
 }
 
-// Handle handles user input synchronously and returns response
-func (a *OpenAIAssistant) Handle(userInput string) (string, error) {
-	a.messages = append(a.messages, Message{Role: "user", Content: userInput})
-
-	requestBody := OpenAIRequest{
-		Model:    a.model,
-		Messages: a.messages,
-		Stream:   false,
+func (a *OpenAIAssistant) request(messages []Message, stream bool) openAIRequest {
+	request := openAIRequest{Model: a.model, Stream: stream}
+	if stream {
+		request.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
 	}
+	for _, m := range answerToolCalls(messages) {
+		message := openAIMessage{Role: m.Role, Content: m.Content, ToolCallID: m.ToolCallID}
+		for _, call := range m.ToolCalls {
+			message.ToolCalls = append(message.ToolCalls, openAIToolCall{
+				ID:       call.ID,
+				Type:     "function",
+				Function: openAIToolCallFunction{Name: call.Name, Arguments: call.Arguments},
+			})
+		}
+		request.Messages = append(request.Messages, message)
+	}
+	for _, tool := range a.tools {
+		request.Tools = append(request.Tools, openAITool{
+			Type:     "function",
+			Function: openAIToolFunction{Name: tool.Name, Description: tool.Description, Parameters: tool.Parameters.schema()},
+		})
+	}
+	return request
+}
 
-	jsonData, err := json.Marshal(requestBody)
+func (a *OpenAIAssistant) post(ctx context.Context, request openAIRequest) (*http.Response, error) {
+	jsonData, err := json.Marshal(request)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", a.baseURL+"/chat/completions", bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+a.apiKey)
+	if a.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+a.apiKey)
+	}
+
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, statusError(resp)
+	}
+	return resp, nil
+}
+
+// SetSystemPrompt sets the system prompt
+func (a *OpenAIAssistant) SetSystemPrompt(prompt string) {
+	a.messages = setSystemPrompt(a.messages, prompt)
+}
+
+func setSystemPrompt(messages []Message, prompt string) []Message {
+	if len(messages) > 0 && messages[0].Role == "system" {
+		messages[0].Content = prompt
+		return messages
+	}
+	return append([]Message{{Role: "system", Content: prompt}}, messages...)
+}
+
+// Handle handles user input synchronously and returns response
+func (a *OpenAIAssistant) Handle(ctx context.Context, userInput string) (string, error) {
+	a.messages = append(a.messages, Message{Role: "user", Content: userInput})
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := a.post(ctx, a.request(a.messages, false))
 	if err != nil {
 		return "", err
 	}
@@ -172,98 +283,54 @@ func (a *OpenAIAssistant) Handle(userInput string) (string, error) {
 		return "", err
 	}
 
-	var openAIResp OpenAIResponse
+	var openAIResp openAIResponse
 	err = json.Unmarshal(body, &openAIResp)
 	if err != nil {
 		return "", err
 	}
 
+	a.recordUsage(ctx, openAIResp.Usage)
+	if len(openAIResp.Choices) == 0 {
+		return "", fmt.Errorf("no response from OpenAI")
+	}
 	respMessage := openAIResp.Choices[0].Message.Content
 	a.messages = append(a.messages, Message{Role: "assistant", Content: respMessage})
+	return respMessage, nil
+}
 
-	if len(openAIResp.Choices) > 0 {
-		return respMessage, nil
+// HandleAsync handles user input asynchronously and streams the answer
+func (a *OpenAIAssistant) HandleAsync(ctx context.Context, userInput string) <-chan AssistantEvent {
+	if userInput != "" {
+		a.messages = append(a.messages, Message{Role: "user", Content: userInput})
 	}
+	return a.stream(ctx)
+}
 
-	return "", fmt.Errorf("no response from OpenAI")
+func (a *OpenAIAssistant) HandleToolResults(ctx context.Context, results []ToolResult) <-chan AssistantEvent {
+	a.messages = appendToolResults(a.messages, results)
+	return a.stream(ctx)
 }
 
-// HandleAsync handles user input asynchronously and returns streaming response
-//   - outputChan: function call format -> "function:function_name" (no arguments for now)
-//   - args[0]: user role (if not given, "user" will be used)
-//   - args[1]: tool call id (if not given, no tool call will be handled)
-//   - args[2]: tool call name (if not given, no tool call will be handled)
-//   - args[3]: tool call arguments (if not given, no tool call will be handled)
-func (a *OpenAIAssistant) HandleAsync(userInput string, args ...string) (output chan string, done chan string, err chan error) {
-	outputChan := make(chan string)
-	doneChan := make(chan string)
-	errChan := make(chan error)
+// stream requests the next answer. Tool calls are streamed by index: the first chunk of a call has its id and name,
+// the next ones parts of its arguments. The calls are complete when the answer is.
+func (a *OpenAIAssistant) stream(ctx context.Context) <-chan AssistantEvent {
+	stream := newEventStream(ctx)
 
 	go func() {
-		defer close(outputChan)
-		defer close(doneChan)
-		defer close(errChan)
-
-		userRole := "user"
-		if len(args) > 0 {
-			userRole = args[0]
-		}
-		toolCallId := ""
-		if len(args) > 1 {
-			toolCallId = args[1]
-		}
-		toolCallName := ""
-		if len(args) > 2 {
-			toolCallName = args[2]
-		}
-		toolCallArguments := ""
-		if len(args) > 3 {
-			toolCallArguments = args[3]
-		}
-
-		if userRole == "tool" {
-			a.messages = append(a.messages, Message{
-				Role:      "assistant",
-				Content:   userInput,
-				ToolCalls: []ToolCallRes{{ID: toolCallId, Type: "function", Function: OpenAIFunctionRes{Name: toolCallName, Arguments: toolCallArguments}}},
-			})
-			a.messages = append(a.messages, Message{Role: userRole, Content: userInput, ToolCallId: toolCallId})
-		} else if userInput != "" {
-			a.messages = append(a.messages, Message{Role: userRole, Content: userInput})
-		}
+		defer close(stream.events)
 
-		requestBody := OpenAIRequest{
-			Model:    a.model,
-			Messages: a.messages,
-			Stream:   true,
-			Tools:    a.tools,
-		}
-
-		jsonData, err := json.Marshal(requestBody)
-		if err != nil {
-			errChan <- err
-			return
-		}
-
-		req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
-		if err != nil {
-			errChan <- err
-			return
-		}
-
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", "Bearer "+a.apiKey)
-
-		client := &http.Client{}
-		resp, err := client.Do(req)
+		resp, err := a.post(ctx, a.request(a.messages, true))
 		if err != nil {
-			errChan <- err
+			stream.fail(err)
 			return
 		}
 		defer resp.Body.Close()
 
 		respContent := ""
+		finishReason := ""
+		calls := map[int]*ToolCall{}
 		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
 		for scanner.Scan() {
 			line := scanner.Text()
 
@@ -280,57 +347,80 @@ func (a *OpenAIAssistant) HandleAsync(userInput string, args ...string) (output
 				continue
 			}
 
-			var openAIResp OpenAIResponse
+			var openAIResp openAIResponse
 			err := json.Unmarshal([]byte(line), &openAIResp)
 			if err != nil {
-				errChan <- fmt.Errorf("error parsing JSON: %v", err)
 				log.Printf("Error parsing JSON: %v, data: %s\n", err, line)
-				for scanner.Scan() {
-					line := scanner.Text()
-					log.Println(line)
-				}
+				stream.fail(fmt.Errorf("error parsing JSON: %v", err))
 				return
 			}
 
-			// Extract content from delta and send to output
-			if len(openAIResp.Choices) > 0 {
-				for _, choice := range openAIResp.Choices {
-					// function call
-					if len(choice.Delta.ToolCalls) > 0 {
-						for _, toolCall := range choice.Delta.ToolCalls {
-							if toolCall.Type == "function" {
-								fName := toolCall.Function.Name
-								cId := toolCall.ID
-								outputChan <- "function:" + "{\"id\":\"" + cId + "\",\"name\":\"" + fName + "\"}" // must be handled by the caller
-							} else {
-								// after function call
-								// argument will be given as string, in doneChan
-								respContent += toolCall.Function.Arguments // must be handled by the caller
-							}
+			a.recordUsage(ctx, openAIResp.Usage) // the last chunk
+			for _, choice := range openAIResp.Choices {
+				for _, delta := range choice.Delta.ToolCalls {
+					call, ok := calls[delta.Index]
+					if !ok {
+						call = &ToolCall{ID: delta.ID, Name: delta.Function.Name}
+						calls[delta.Index] = call
+						if !stream.send(AssistantEvent{Type: AE_ToolCallStart, Index: delta.Index, ToolCall: *call}) {
+							return
 						}
 					}
-					// chunked response message
-					content := choice.Delta.Content
-					if content != "" {
-						outputChan <- content
-						respContent += content
+					if delta.Function.Arguments != "" {
+						call.Arguments += delta.Function.Arguments
+						if !stream.send(AssistantEvent{Type: AE_ToolArgumentDelta, Index: delta.Index, Text: delta.Function.Arguments}) {
+							return
+						}
+					}
+				}
+				// chunked response message
+				if content := choice.Delta.Content; content != "" {
+					if !stream.send(AssistantEvent{Type: AE_TextDelta, Text: content}) {
+						return
 					}
+					respContent += content
+				}
+				if choice.FinishReason != "" {
+					finishReason = choice.FinishReason
 				}
 			}
 		}
 
 		if err := scanner.Err(); err != nil {
-			errChan <- fmt.Errorf("error reading stream: %v", err)
+			stream.fail(fmt.Errorf("error reading stream: %v", err))
 			return
 		}
 
-		doneChan <- respContent
+		indexes := make([]int, 0, len(calls))
+		for index := range calls {
+			indexes = append(indexes, index)
+		}
+		sort.Ints(indexes)
+		toolCalls := make([]ToolCall, 0, len(calls))
+		for _, index := range indexes {
+			call := *calls[index]
+			if call.Arguments == "" {
+				call.Arguments = "{}"
+			}
+			toolCalls = append(toolCalls, call)
+			if !stream.send(AssistantEvent{Type: AE_ToolCallComplete, Index: index, ToolCall: call}) {
+				return
+			}
+		}
+		a.messages = append(a.messages, Message{Role: "assistant", Content: respContent, ToolCalls: toolCalls})
+		stream.send(AssistantEvent{Type: AE_Finish, Content: respContent, ToolCalls: toolCalls, FinishReason: finishReason})
 	}()
 
-	return outputChan, doneChan, errChan
+	return stream.events
+}
+
+func (a *OpenAIAssistant) recordUsage(ctx context.Context, usage *openAIUsage) {
+	if usage != nil {
+		recordTokens(ctx, a.http.breaker.provider, a.model, usage.PromptTokens, usage.CompletionTokens)
+	}
 }
 
-func (a *OpenAIAssistant) SetTools(tools []OpenAITool) {
+func (a *OpenAIAssistant) SetTools(tools []Tool) {
 	a.tools = tools
 }
 
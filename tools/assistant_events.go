@@ -0,0 +1,92 @@
+package tools
+
+import "context"
+
+type AssistantEventType string
+
+// an answer streams text deltas and tool calls (start, argument deltas, complete), then ends with finish or error
+const (
+	AE_TextDelta         AssistantEventType = "text_delta"
+	AE_ToolCallStart     AssistantEventType = "tool_call_start"
+	AE_ToolArgumentDelta AssistantEventType = "tool_argument_delta"
+	AE_ToolCallComplete  AssistantEventType = "tool_call_complete"
+	AE_Finish            AssistantEventType = "finish"
+	AE_Error             AssistantEventType = "error"
+)
+
+// AssistantEvent is one event of the answer streamed by Assistant.HandleAsync
+type AssistantEvent struct {
+	Type AssistantEventType
+	Text string // text_delta: the new text; tool_argument_delta: the new part of the arguments
+	// tool call events: position of the call in the answer, as the calls may be streamed in parallel
+	Index int
+	// tool_call_start: ID and Name; tool_call_complete: with the whole Arguments
+	ToolCall ToolCall
+	// finish: the whole text and the completed calls of the answer
+	Content      string
+	ToolCalls    []ToolCall
+	FinishReason string // as given by the vendor (stop, tool_calls, end_turn, tool_use, ...)
+	Err          error  // error
+}
+
+// ToolResult answers one tool call of the previous answer
+type ToolResult struct {
+	Call    ToolCall
+	Content string
+}
+
+// eventStream sends the events of one answer; sending fails once ctx is done, as the caller may stop reading
+type eventStream struct {
+	ctx    context.Context
+	events chan AssistantEvent
+}
+
+func newEventStream(ctx context.Context) eventStream {
+	return eventStream{ctx: ctx, events: make(chan AssistantEvent)}
+}
+
+func (s eventStream) send(event AssistantEvent) bool {
+	select {
+	case s.events <- event:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+func (s eventStream) fail(err error) {
+	s.send(AssistantEvent{Type: AE_Error, Err: err})
+}
+
+// answerToolCalls gives the tool calls left unanswered (not run by the caller, or cut by a closed socket) a result,
+// as the vendors reject a conversation with unanswered calls
+func answerToolCalls(messages []Message) []Message {
+	answered := make([]Message, 0, len(messages))
+	for i := 0; i < len(messages); i++ {
+		answered = append(answered, messages[i])
+		calls := messages[i].ToolCalls
+		if len(calls) == 0 {
+			continue
+		}
+		results := map[string]bool{}
+		for i+1 < len(messages) && messages[i+1].Role == "tool" {
+			i++
+			results[messages[i].ToolCallID] = true
+			answered = append(answered, messages[i])
+		}
+		for _, call := range calls {
+			if !results[call.ID] {
+				answered = append(answered, Message{Role: "tool", Content: "not run", ToolCallID: call.ID})
+			}
+		}
+	}
+	return answered
+}
+
+// appendToolResults adds the results after the assistant message which made the calls
+func appendToolResults(messages []Message, results []ToolResult) []Message {
+	for _, result := range results {
+		messages = append(messages, Message{Role: "tool", Content: result.Content, ToolCallID: result.Call.ID})
+	}
+	return messages
+}
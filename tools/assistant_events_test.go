@@ -0,0 +1,43 @@
+package tools
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnswerToolCalls(t *testing.T) {
+	user := Message{Role: "user", Content: "paint me"}
+	calls := func(ids ...string) Message {
+		m := Message{Role: "assistant"}
+		for _, id := range ids {
+			m.ToolCalls = append(m.ToolCalls, ToolCall{ID: id, Name: "paint", Arguments: "{}"})
+		}
+		return m
+	}
+	result := func(id string) Message { return Message{Role: "tool", Content: "done", ToolCallID: id} }
+	notRun := func(id string) Message { return Message{Role: "tool", Content: "not run", ToolCallID: id} }
+
+	tests := []struct {
+		name     string
+		messages []Message
+		want     []Message
+	}{
+		{"no calls", []Message{user, {Role: "assistant", Content: "hi"}}, []Message{user, {Role: "assistant", Content: "hi"}}},
+		{"answered", []Message{user, calls("a", "b"), result("a"), result("b")}, []Message{user, calls("a", "b"), result("a"), result("b")}},
+		{"unanswered at the end", []Message{user, calls("a")}, []Message{user, calls("a"), notRun("a")}},
+		{"partly answered", []Message{user, calls("a", "b"), result("b")}, []Message{user, calls("a", "b"), result("b"), notRun("a")}},
+		{
+			"unanswered before a user message",
+			[]Message{user, calls("a"), user, calls("b"), result("b")},
+			[]Message{user, calls("a"), notRun("a"), user, calls("b"), result("b")},
+		},
+		{"empty", []Message{}, []Message{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := answerToolCalls(tt.messages); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answerToolCalls() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package tools
+
+import (
+	"avazon-api/utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FakeAssistant is a scripted, offline Assistant (PROVIDERS=fake).
+//   - A user message containing ToolCallKeyword makes it call its first tool,
+//     with arguments filled from the tool's parameter schema.
+//   - Any other message (and every tool result) gets a canned text reply.
+//
+// Replies are streamed word by word like the real one.
+type FakeAssistant struct {
+	messages        []Message
+	tools           []Tool
+	ToolCallKeyword string
+	ChunkDelay      time.Duration
+}
+
+func NewFakeAssistant() *FakeAssistant {
+	return &FakeAssistant{
+		messages:        []Message{},
+		tools:           []Tool{},
+		ToolCallKeyword: "create",
+		ChunkDelay:      30 * time.Millisecond,
+	}
+}
+
+func (a *FakeAssistant) SetSystemPrompt(prompt string) {
+	a.messages = setSystemPrompt(a.messages, prompt)
+}
+
+// Handle returns a deterministic answer derived from the input (used for prompt generation)
+func (a *FakeAssistant) Handle(ctx context.Context, userInput string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	a.messages = append(a.messages, Message{Role: "user", Content: userInput})
+	reply := "[fake] " + firstLine(userInput, 200)
+	a.messages = append(a.messages, Message{Role: "assistant", Content: reply})
+	return reply, nil
+}
+
+// HandleAsync streams the same events as the real assistants
+func (a *FakeAssistant) HandleAsync(ctx context.Context, userInput string) <-chan AssistantEvent {
+	if userInput != "" {
+		a.messages = append(a.messages, Message{Role: "user", Content: userInput})
+	}
+	stream := newEventStream(ctx)
+
+	go func() {
+		defer close(stream.events)
+
+		if len(a.tools) > 0 && a.ToolCallKeyword != "" && strings.Contains(strings.ToLower(userInput), a.ToolCallKeyword) {
+			tool := a.tools[0]
+			call := ToolCall{ID: "call_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:24], Name: tool.Name}
+			arguments, err := fakeToolArguments(tool, userInput)
+			if err != nil {
+				stream.fail(err)
+				return
+			}
+			if !stream.send(AssistantEvent{Type: AE_ToolCallStart, ToolCall: call}) {
+				return
+			}
+			call.Arguments = arguments
+			if !stream.send(AssistantEvent{Type: AE_ToolArgumentDelta, Text: arguments}) ||
+				!stream.send(AssistantEvent{Type: AE_ToolCallComplete, ToolCall: call}) {
+				return
+			}
+			a.reply(stream, "", []ToolCall{call})
+			return
+		}
+
+		a.reply(stream, fmt.Sprintf("You said: %q. Tell me when you want me to %s it.", firstLine(userInput, 100), a.ToolCallKeyword), nil)
+	}()
+
+	return stream.events
+}
+
+func (a *FakeAssistant) HandleToolResults(ctx context.Context, results []ToolResult) <-chan AssistantEvent {
+	a.messages = appendToolResults(a.messages, results)
+	stream := newEventStream(ctx)
+
+	go func() {
+		defer close(stream.events)
+		a.reply(stream, "Got it, I've started working on that for you. Let me know if you'd like any changes!", nil)
+	}()
+
+	return stream.events
+}
+
+// reply streams text, then finishes with it and the tool calls. Stops silently when ctx is done.
+func (a *FakeAssistant) reply(stream eventStream, text string, toolCalls []ToolCall) {
+	for i, word := range strings.SplitAfter(text, " ") {
+		if i > 0 {
+			if utils.Sleep(stream.ctx, a.ChunkDelay) != nil {
+				return
+			}
+		}
+		if word != "" && !stream.send(AssistantEvent{Type: AE_TextDelta, Text: word}) {
+			return
+		}
+	}
+	a.messages = append(a.messages, Message{Role: "assistant", Content: text, ToolCalls: toolCalls})
+	finishReason := "stop"
+	if len(toolCalls) > 0 {
+		finishReason = "tool_calls"
+	}
+	stream.send(AssistantEvent{Type: AE_Finish, Content: text, ToolCalls: toolCalls, FinishReason: finishReason})
+}
+
+func (a *FakeAssistant) SetTools(tools []Tool) {
+	a.tools = tools
+}
+
+func (a *FakeAssistant) Init(messages []Message) {
+	a.messages = messages
+}
+
+// fakeToolArguments fills every parameter with a valid value: the first enum value,
+// 1 for numbers, or a summary of the user's message for strings.
+func fakeToolArguments(tool Tool, userInput string) (string, error) {
+	arguments := map[string]interface{}{}
+	for name, param := range tool.Parameters.Properties {
+		switch {
+		case len(param.Enum) > 0:
+			arguments[name] = param.Enum[0]
+		case param.Type == "number" || param.Type == "integer":
+			arguments[name] = 1
+		case param.Type == "boolean":
+			arguments[name] = true
+		default:
+			arguments[name] = "fake " + name + ": " + firstLine(userInput, 200)
+		}
+	}
+	bytes, err := json.Marshal(arguments)
+	return string(bytes), err
+}
+
+func firstLine(s string, maxLen int) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	return utils.TruncateString(s, maxLen)
+}
@@ -0,0 +1,232 @@
+package tools
+
+import (
+	"avazon-api/models"
+	"avazon-api/utils"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Offline implementations of the media tools (PROVIDERS=fake).
+// Output is deterministic for the same input; Delay simulates provider latency.
+
+// FakePainter renders a flat placeholder PNG whose color is derived from the prompt.
+type FakePainter struct {
+	Delay time.Duration
+}
+
+func NewFakePainter(delay time.Duration) *FakePainter {
+	return &FakePainter{Delay: delay}
+}
+
+func (p *FakePainter) Paint(ctx context.Context, prompt string, negative string, width int, height int) ([]byte, string, error) {
+	if err := utils.Sleep(ctx, p.Delay); err != nil {
+		return nil, "", err
+	}
+	imageBytes, err := PlaceholderPNG(prompt, width, height)
+	return imageBytes, "image/png", err
+}
+
+func (p *FakePainter) PaintFromReference(
+	ctx context.Context, refImageBytes []byte, refContentType string, prompt string, width int, height int,
+) ([]byte, string, error) {
+	return p.Paint(ctx, prompt, "", width, height)
+}
+
+func (p *FakePainter) EnhancePrompt(ctx context.Context, prompt string) (string, error) {
+	return prompt, ctx.Err()
+}
+
+func (p *FakePainter) ChangeStyle(ctx context.Context, imageBytes []byte, contentType string, prompt string) ([]byte, string, error) {
+	width, height := 1024, 1024
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(imageBytes)); err == nil {
+		width, height = cfg.Width, cfg.Height
+	}
+	return p.Paint(ctx, prompt, "", width, height)
+}
+
+// PlaceholderPNG returns a width x height PNG filled with a color picked from seed.
+func PlaceholderPNG(seed string, width int, height int) ([]byte, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid image size: %dx%d", width, height)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(seed))
+	sum := h.Sum32()
+	fill := color.RGBA{R: uint8(sum >> 16), G: uint8(sum >> 8), B: uint8(sum), A: 255}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = fill.R, fill.G, fill.B, fill.A
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// FakeVoiceActor returns silent MP3s, roughly as long as the text would take to read.
+type FakeVoiceActor struct {
+	Delay time.Duration
+}
+
+func NewFakeVoiceActor(delay time.Duration) *FakeVoiceActor {
+	return &FakeVoiceActor{Delay: delay}
+}
+
+func (va *FakeVoiceActor) Create(ctx context.Context, prompt string, gender models.Gender, args ...string) (string, string, error) {
+	if err := utils.Sleep(ctx, va.Delay); err != nil {
+		return "", "", err
+	}
+	h := fnv.New32a()
+	h.Write([]byte(string(gender) + prompt))
+	return "fake", fmt.Sprintf("fake-voice-%08x", h.Sum32()), nil
+}
+
+func (va *FakeVoiceActor) TTS(ctx context.Context, voiceId string, text string) ([]byte, error) {
+	if err := utils.Sleep(ctx, va.Delay); err != nil {
+		return nil, err
+	}
+	// ~15 characters per second of speech
+	seconds := min(max(len(text)/15, 1), 30)
+	return SilentMP3(time.Duration(seconds) * time.Second), nil
+}
+
+func (va *FakeVoiceActor) TTSStream(ctx context.Context, voiceId string, text string) (io.ReadCloser, error) {
+	voice, err := va.TTS(ctx, voiceId, text)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(voice)), nil
+}
+
+// SilentMP3 builds an MPEG-1 Layer III stream (128kbps, 44.1kHz, mono) of empty frames.
+// A frame whose side info and main data are all zero decodes to silence.
+func SilentMP3(duration time.Duration) []byte {
+	const (
+		frameSize    = 144 * 128000 / 44100 // 417 bytes, no padding
+		frameSamples = 1152
+	)
+	frames := max(int(duration.Seconds()*44100/frameSamples), 1)
+	frame := make([]byte, frameSize)
+	copy(frame, []byte{0xFF, 0xFB, 0x90, 0xC0}) // sync, MPEG-1 L3 no CRC, 128kbps 44.1kHz, mono
+	return bytes.Repeat(frame, frames)
+}
+
+// FakeVideoProducer returns the same fixture bytes for every request.
+type FakeVideoProducer struct {
+	Fixture []byte
+	Delay   time.Duration
+	tasks   []*VideoGenTask
+	mu      sync.Mutex
+}
+
+// NewFakeVideoProducer uses PlaceholderMP4 when fixture is empty.
+func NewFakeVideoProducer(fixture []byte, delay time.Duration) *FakeVideoProducer {
+	if len(fixture) == 0 {
+		fixture = PlaceholderMP4()
+	}
+	return &FakeVideoProducer{Fixture: fixture, Delay: delay}
+}
+
+func (vp *FakeVideoProducer) Create(ctx context.Context, imageURL string, prompt string) ([]byte, error) {
+	if err := utils.Sleep(ctx, vp.Delay); err != nil {
+		return nil, err
+	}
+	return vp.Fixture, nil
+}
+
+func (vp *FakeVideoProducer) CreateAsync(ctx context.Context, imageURL string, prompt string) <-chan *VideoGenTask {
+	task := &VideoGenTask{
+		ID:        uuid.New().String(),
+		ImageURL:  imageURL,
+		Prompt:    prompt,
+		CreatedAt: time.Now(),
+	}
+	vp.mu.Lock()
+	vp.tasks = append(vp.tasks, task)
+	vp.mu.Unlock()
+
+	taskCh := make(chan *VideoGenTask, 1)
+	go func() {
+		err := utils.Sleep(ctx, vp.Delay)
+		vp.mu.Lock()
+		if err != nil {
+			task.FailReason = err.Error()
+		} else {
+			task.VideoURL = "data:video/mp4;base64," + base64.StdEncoding.EncodeToString(vp.Fixture)
+		}
+		vp.mu.Unlock()
+		taskCh <- task
+		close(taskCh)
+	}()
+	return taskCh
+}
+
+func (vp *FakeVideoProducer) SaveImageAsset(ctx context.Context, image []byte, imageType string) (string, error) {
+	return "data:" + imageType + ";base64," + base64.StdEncoding.EncodeToString(image), nil
+}
+
+func (vp *FakeVideoProducer) GetTasks() []*VideoGenTask {
+	vp.mu.Lock()
+	defer vp.mu.Unlock()
+	return append([]*VideoGenTask{}, vp.tasks...)
+}
+
+// PlaceholderMP4 is a structurally valid, empty MP4 (ftyp + moov/mvhd, no tracks).
+// Set FAKE_VIDEO_FIXTURE to a real clip if the frontend needs something playable.
+func PlaceholderMP4() []byte {
+	box := func(kind string, payload []byte) []byte {
+		b := make([]byte, 8, 8+len(payload))
+		binary.BigEndian.PutUint32(b, uint32(8+len(payload)))
+		copy(b[4:], kind)
+		return append(b, payload...)
+	}
+
+	ftyp := box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41"))
+
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)       // timescale
+	binary.BigEndian.PutUint32(mvhd[20:], 0x00010000) // rate 1.0
+	binary.BigEndian.PutUint16(mvhd[24:], 0x0100)     // volume 1.0
+	for i, v := range []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		binary.BigEndian.PutUint32(mvhd[36+4*i:], v) // identity matrix
+	}
+	binary.BigEndian.PutUint32(mvhd[96:], 1) // next track id
+
+	return append(ftyp, box("moov", box("mvhd", mvhd))...)
+}
+
+// FakeMusicProducer returns the same fixture bytes for every request.
+type FakeMusicProducer struct {
+	Fixture []byte
+	Delay   time.Duration
+}
+
+// NewFakeMusicProducer uses 10 seconds of silence when fixture is empty.
+func NewFakeMusicProducer(fixture []byte, delay time.Duration) *FakeMusicProducer {
+	if len(fixture) == 0 {
+		fixture = SilentMP3(10 * time.Second)
+	}
+	return &FakeMusicProducer{Fixture: fixture, Delay: delay}
+}
+
+func (mp *FakeMusicProducer) Produce(ctx context.Context, title string, style string, description string) ([]byte, error) {
+	if err := utils.Sleep(ctx, mp.Delay); err != nil {
+		return nil, err
+	}
+	return mp.Fixture, nil
+}
@@ -2,7 +2,9 @@ package tools
 
 import (
 	"avazon-api/controllers/errs"
+	"avazon-api/utils"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,9 +13,12 @@ import (
 )
 
 type MusicProducer interface {
-	Produce(title string, style string, description string) ([]byte, error) // returns .mp3
+	Produce(ctx context.Context, title string, style string, description string) ([]byte, error) // returns .mp3
 }
 
+// gives up on a track still generating after this long
+const jenaiGenerationTimeout = 15 * time.Minute
+
 type JENAIProducer struct {
 	ApiKey string
 }
@@ -36,7 +41,7 @@ func NewJENAIProducer(apiKey string) *JENAIProducer {
 }
 
 // Produce 메서드: 음악 생성
-func (mp *JENAIProducer) Produce(title string, style string, description string) ([]byte, error) {
+func (mp *JENAIProducer) Produce(ctx context.Context, title string, style string, description string) ([]byte, error) {
 	// 1. Generate Music
 	generateURL := "https://app.jenmusic.ai/api/v1/public/track/generate"
 
@@ -55,18 +60,21 @@ func (mp *JENAIProducer) Produce(title string, style string, description string)
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest("POST", generateURL, bytes.NewBuffer(generateReqDataJSON))
+	req, err := http.NewRequestWithContext(ctx, "POST", generateURL, bytes.NewBuffer(generateReqDataJSON))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+mp.ApiKey)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := jenaiHTTP.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status: %w", statusError(resp))
+	}
 
 	// find json["data"][0]["id"]
 	var genResp struct {
@@ -83,76 +91,87 @@ func (mp *JENAIProducer) Produce(title string, style string, description string)
 
 	genMusicID := genResp.Data[0].ID
 	genTask := &JENAIGeneratingTask{ID: genMusicID}
-	taskCh := make(chan *JENAIGeneratingTask)
-
-	go func(task *JENAIGeneratingTask) {
-		for {
-			time.Sleep(5 * time.Second)
-			// GET https://app.jenmusic.ai/api/v1/public/generation_status/{{objectId}}
-			statusURL := "https://app.jenmusic.ai/api/v1/public/generation_status/" + genMusicID
-			statusReq, err := http.NewRequest("GET", statusURL, nil)
-			if err != nil {
-				genTask.FailReason = err.Error()
-				taskCh <- genTask
-				break
-			}
-			statusReq.Header.Add("Authorization", "Bearer "+mp.ApiKey)
-			client := &http.Client{}
-			resp, err := client.Do(statusReq)
-			if err != nil {
-				genTask.FailReason = err.Error()
-				taskCh <- genTask
-				break
-			}
-			defer resp.Body.Close()
-			// find json["data"]["status"] (should be in "generating", "validating", "validated")
-			var statusResp struct {
-				Data struct {
-					Status string `json:"status"`
-					URL    string `json:"url"`
-				} `json:"data"`
-			}
-			if err := json.NewDecoder(resp.Body).Decode(&statusResp); err != nil {
-				genTask.FailReason = err.Error()
-				taskCh <- genTask
-				break
-			}
-			if statusResp.Data.Status == "generating" || statusResp.Data.Status == "validating" {
-				continue
-			} else if statusResp.Data.Status == "validated" {
-				genTask.URL = statusResp.Data.URL
-				taskCh <- genTask
-				break
-			} else {
-				genTask.FailReason = "unexpected status: " + statusResp.Data.Status
-				taskCh <- genTask
-				break
-			}
+	if err := mp.waitForTrack(ctx, genTask); err != nil {
+		fmt.Printf("failed to generate music: %v", err)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
-	}(genTask)
-	t := <-taskCh
-	if t.FailReason != "" {
-		fmt.Printf("failed to generate music: %v", t.FailReason)
+		return nil, errs.ErrInternalServerError
+	}
+	if genTask.FailReason != "" {
+		fmt.Printf("failed to generate music: %v", genTask.FailReason)
 		return nil, errs.ErrInternalServerError
 	}
 
-	// GET t.URL
-	resp, err = client.Get(t.URL)
+	// GET genTask.URL
+	musicReq, err := http.NewRequestWithContext(ctx, "GET", genTask.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	musicResp, err := jenaiHTTP.Do(musicReq)
 	if err != nil {
 		fmt.Printf("failed to get generated music: %v", err)
 		return nil, errs.ErrInternalServerError
 	}
-	defer resp.Body.Close()
+	defer musicResp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("unexpected response status: %v", resp.Status)
-		return nil, errs.ErrInternalServerError
+	if musicResp.StatusCode != http.StatusOK {
+		err := statusError(musicResp)
+		fmt.Printf("unexpected response status: %v", err)
+		return nil, fmt.Errorf("failed to get generated music: %w", err)
 	}
 
-	musicBytes, err := io.ReadAll(resp.Body)
+	musicBytes, err := io.ReadAll(musicResp.Body)
 	if err != nil {
 		fmt.Printf("failed to read music data: %v", err)
 		return nil, errs.ErrInternalServerError
 	}
+	recordUsage(ctx, Usage{Provider: "jenai", Operation: Op_Music, Quantity: float64(generateReqData.Duration), Unit: Unit_Seconds, Detail: generateReqData.Format})
 	return musicBytes, nil
 }
+
+// waitForTrack polls the generation status every 5 seconds, until the track is validated
+// (task.URL is set) or fails (task.FailReason is set). Returns an error when ctx is done or
+// the generation takes longer than jenaiGenerationTimeout.
+func (mp *JENAIProducer) waitForTrack(ctx context.Context, task *JENAIGeneratingTask) error {
+	ctx, cancel := context.WithTimeout(ctx, jenaiGenerationTimeout)
+	defer cancel()
+	for {
+		if err := utils.Sleep(ctx, 5*time.Second); err != nil {
+			return err
+		}
+		// GET https://app.jenmusic.ai/api/v1/public/generation_status/{{objectId}}
+		statusURL := "https://app.jenmusic.ai/api/v1/public/generation_status/" + task.ID
+		statusReq, err := http.NewRequestWithContext(ctx, "GET", statusURL, nil)
+		if err != nil {
+			return err
+		}
+		statusReq.Header.Add("Authorization", "Bearer "+mp.ApiKey)
+		resp, err := jenaiHTTP.Do(statusReq)
+		if err != nil {
+			return err
+		}
+		// find json["data"]["status"] (should be in "generating", "validating", "validated")
+		var statusResp struct {
+			Data struct {
+				Status string `json:"status"`
+				URL    string `json:"url"`
+			} `json:"data"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&statusResp)
+		resp.Body.Close()
+		if err != nil {
+			task.FailReason = err.Error()
+			return nil
+		}
+		switch statusResp.Data.Status {
+		case "generating", "validating":
+			continue
+		case "validated":
+			task.URL = statusResp.Data.URL
+		default:
+			task.FailReason = "unexpected status: " + statusResp.Data.Status
+		}
+		return nil
+	}
+}
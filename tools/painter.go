@@ -3,28 +3,33 @@ package tools
 import (
 	"avazon-api/utils"
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
+	"math"
 	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"strings"
 	"time"
 )
 
 type Painter interface {
 	// returns (image_bytes, mime_type, error)
-	Paint(prompt string, negative string, width int, height int) ([]byte, string, error)
+	Paint(ctx context.Context, prompt string, negative string, width int, height int) ([]byte, string, error)
 	// paint from reference image
 	// :param refImageBytes: reference image bytes (e.g. face image)
 	// :param refContentType: reference image MIME type (e.g. image/jpeg)
 	PaintFromReference(
-		refImageBytes []byte, refContentType string, prompt string, width int, height int,
+		ctx context.Context, refImageBytes []byte, refContentType string, prompt string, width int, height int,
 	) ([]byte, string, error)
 	// enhance prompt
-	EnhancePrompt(prompt string) (string, error)
+	EnhancePrompt(ctx context.Context, prompt string) (string, error)
 	// Change style of the image
-	ChangeStyle(imageBytes []byte, contentType string, prompt string) ([]byte, string, error)
+	ChangeStyle(ctx context.Context, imageBytes []byte, contentType string, prompt string) ([]byte, string, error)
 }
 
 type OpenArtRequest struct {
@@ -80,40 +85,88 @@ type ImagePlaceholderResponse struct {
 	Images []ImageItem `json:"images"`
 }
 
+// gives up on an image still pending after this long
+const openArtGenerationTimeout = 10 * time.Minute
+
 type OpenArtPainter struct {
 	ApiKey string
 }
 
 type OpenAIPainter struct {
-	APIKey string
+	APIKey      string
+	ChatBaseURL string // chat completions API enhancing the prompts (OpenAI or a compatible server)
+	ChatModel   string // enhances the prompts
 }
 
 func NewOpenArtPainter(apiKey string) *OpenArtPainter {
 	return &OpenArtPainter{ApiKey: apiKey}
 }
 
-func NewOpenAIPainter(apiKey string) *OpenAIPainter {
-	return &OpenAIPainter{APIKey: apiKey}
+func NewOpenAIPainter(apiKey string, chatBaseURL string, chatModel string) *OpenAIPainter {
+	return &OpenAIPainter{APIKey: apiKey, ChatBaseURL: chatBaseURL, ChatModel: chatModel}
 }
 
 // ======================================================================================================================
 // OpenAIArtist
 // ======================================================================================================================
 
+// image sizes the OpenAI models paint, square first
+var (
+	dallE3Sizes    = [][2]int{{1024, 1024}, {1792, 1024}, {1024, 1792}}
+	gptImage1Sizes = [][2]int{{1024, 1024}, {1536, 1024}, {1024, 1536}}
+)
+
+// model of the image edits: DALL-E 2 only edits the transparent parts of an image
+const openAIEditModel = "gpt-image-1"
+
+// openAIImageSize picks the size to ask a model for: width x height when the model paints it, otherwise the
+// supported size of the closest aspect ratio (682x1024 -> 1024x1792 with DALL-E 3)
+func openAIImageSize(sizes [][2]int, width int, height int) (string, error) {
+	if width <= 0 || height <= 0 {
+		return "", fmt.Errorf("invalid image size %dx%d", width, height)
+	}
+	best := sizes[0]
+	ratio := float64(width) / float64(height)
+	for _, size := range sizes {
+		if size[0] == width && size[1] == height {
+			return fmt.Sprintf("%dx%d", width, height), nil
+		}
+		if math.Abs(math.Log(float64(size[0])/float64(size[1])/ratio)) < math.Abs(math.Log(float64(best[0])/float64(best[1])/ratio)) {
+			best = size
+		}
+	}
+	log.Printf("OpenAI doesn't paint %dx%d images, asking for %dx%d", width, height, best[0], best[1])
+	return fmt.Sprintf("%dx%d", best[0], best[1]), nil
+}
+
+// PaintFromReference paints the character of the reference image as the prompt describes, through the image edit endpoint
 func (a *OpenAIPainter) PaintFromReference(
-	refImageBytes []byte, refContentType string, prompt string, width int, height int,
+	ctx context.Context, refImageBytes []byte, refContentType string, prompt string, width int, height int,
 ) ([]byte, string, error) {
-	return nil, "", fmt.Errorf("not implemented")
+	size, err := openAIImageSize(gptImage1Sizes, width, height)
+	if err != nil {
+		return nil, "", err
+	}
+	return a.editImage(ctx, refImageBytes, refContentType, map[string]string{
+		"prompt":         "Paint a new image of the character in this reference image, keeping their face and look: " + prompt,
+		"size":           size,
+		"input_fidelity": "high", // keeps the face
+	})
 }
 
 // DALL-E-3
-func (a *OpenAIPainter) Paint(prompt string, _ string, width int, height int) ([]byte, string, error) {
+func (a *OpenAIPainter) Paint(ctx context.Context, prompt string, _ string, width int, height int) ([]byte, string, error) {
+	size, err := openAIImageSize(dallE3Sizes, width, height)
+	if err != nil {
+		return nil, "", err
+	}
+
 	// Prepare request data
 	requestData := map[string]interface{}{
 		"model":  "dall-e-3",
 		"prompt": prompt,
 		"n":      1,
-		"size":   fmt.Sprintf("%dx%d", width, height),
+		"size":   size,
 	}
 
 	// Encode request data in JSON format
@@ -123,7 +176,7 @@ func (a *OpenAIPainter) Paint(prompt string, _ string, width int, height int) ([
 	}
 
 	// Create HTTP request
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/images/generations", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/images/generations", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to create request: %w", err)
 	}
@@ -132,9 +185,7 @@ func (a *OpenAIPainter) Paint(prompt string, _ string, width int, height int) ([
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.APIKey))
 
-	// Send request using HTTP client
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := openAIHTTP.Do(req)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to send request: %w", err)
 	}
@@ -142,8 +193,7 @@ func (a *OpenAIPainter) Paint(prompt string, _ string, width int, height int) ([
 
 	// Check response status code
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, "", fmt.Errorf("received non-200 response code: %d, body: %s", resp.StatusCode, string(body))
+		return nil, "", fmt.Errorf("received non-200 response code: %w", statusError(resp))
 	}
 
 	// Read response body
@@ -170,26 +220,111 @@ func (a *OpenAIPainter) Paint(prompt string, _ string, width int, height int) ([
 	imageURL := responseData.Data[0].URL
 
 	// Download the image data and return as byte array
-	imageResp, err := http.Get(imageURL)
+	imageData, _, err := fetchImageFromURL(ctx, openAIHTTP, imageURL)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to download image: %w", err)
 	}
-	defer imageResp.Body.Close()
 
-	imageData, err := io.ReadAll(imageResp.Body)
+	recordImage(ctx, "openai", "dall-e-3", size)
+	return imageData, "image/png", nil
+}
+
+const openAIEnhancePromptSystemPrompt = `You write prompts for an image generation model.
+Rewrite the user's image description into one vivid prompt: graphic style first, then the subject, then the surrounding environment.
+Keep every detail the user gave. Answer with the prompt only, shorter than 300 characters.`
+
+// EnhancePrompt rewrites prompt through the chat Assistant, the way OpenArt's prompt assistant does
+func (a *OpenAIPainter) EnhancePrompt(ctx context.Context, prompt string) (string, error) {
+	assistant := NewOpenAIAssistant(a.ChatBaseURL, a.APIKey, a.ChatModel)
+	assistant.SetSystemPrompt(openAIEnhancePromptSystemPrompt)
+	enhanced, err := assistant.Handle(ctx, prompt)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to read image data: %w", err)
+		return "", fmt.Errorf("failed to enhance prompt: %w", err)
 	}
-
-	return imageData, "image/png", nil
+	enhanced = strings.Trim(strings.TrimSpace(enhanced), `"`)
+	if enhanced == "" {
+		return "", fmt.Errorf("no enhanced prompt found in response")
+	}
+	return enhanced, nil
 }
 
-func (a *OpenAIPainter) EnhancePrompt(prompt string) (string, error) {
-	return "", fmt.Errorf("OpenAIArtist.EnhancePrompt method not implemented")
+// ChangeStyle redraws the image in the style the prompt describes, through the image edit endpoint
+func (a *OpenAIPainter) ChangeStyle(ctx context.Context, imageBytes []byte, contentType string, prompt string) ([]byte, string, error) {
+	return a.editImage(ctx, imageBytes, contentType, map[string]string{
+		"prompt": "Redraw this image keeping its subject and composition, with this change of style: " + prompt,
+		"size":   "auto",
+	})
 }
 
-func (a *OpenAIPainter) ChangeStyle(imageBytes []byte, contentType string, prompt string) ([]byte, string, error) {
-	return nil, "", fmt.Errorf("OpenAIArtist.ChangeStyle method not implemented")
+// editImage posts the image and fields to POST /v1/images/edits -> (image bytes, MIME type, error)
+func (a *OpenAIPainter) editImage(ctx context.Context, imageBytes []byte, contentType string, fields map[string]string) ([]byte, string, error) {
+	switch contentType {
+	case "image/png", "image/jpeg", "image/webp":
+	default:
+		return nil, "", fmt.Errorf("OpenAI can't edit %s images", contentType)
+	}
+	fileExtension, err := utils.GetExtensionFromMimeType(contentType)
+	if err != nil {
+		return nil, "", err
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	partHeader := make(textproto.MIMEHeader)
+	partHeader.Set("Content-Disposition", `form-data; name="image"; filename="image`+fileExtension+`"`)
+	partHeader.Set("Content-Type", contentType) // OpenAI rejects application/octet-stream
+	part, err := writer.CreatePart(partHeader)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create form file: %w", err)
+	}
+	if _, err := part.Write(imageBytes); err != nil {
+		return nil, "", fmt.Errorf("failed to write image: %w", err)
+	}
+	fields["model"] = openAIEditModel
+	fields["n"] = "1"
+	for name, value := range fields {
+		if err := writer.WriteField(name, value); err != nil {
+			return nil, "", fmt.Errorf("failed to write field %s: %w", name, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, "", fmt.Errorf("failed to close multipart writer: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/images/edits", body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.APIKey))
+
+	resp, err := openAIHTTP.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("received non-200 response code: %w", statusError(resp))
+	}
+
+	// gpt-image-1 always answers base64 PNGs
+	var responseData struct {
+		Data []struct {
+			B64JSON string `json:"b64_json"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&responseData); err != nil {
+		return nil, "", fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if len(responseData.Data) == 0 || responseData.Data[0].B64JSON == "" {
+		return nil, "", fmt.Errorf("no image data received")
+	}
+	imageData, err := base64.StdEncoding.DecodeString(responseData.Data[0].B64JSON)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	recordImage(ctx, "openai", openAIEditModel, fields["size"])
+	return imageData, "image/png", nil
 }
 
 // ======================================================================================================================
@@ -197,7 +332,7 @@ func (a *OpenAIPainter) ChangeStyle(imageBytes []byte, contentType string, promp
 // ======================================================================================================================
 
 // Stable Diffusion
-func (a *OpenArtPainter) Paint_old(prompt string, negative string, width int, height int) ([]byte, string, error) {
+func (a *OpenArtPainter) Paint_old(ctx context.Context, prompt string, negative string, width int, height int) ([]byte, string, error) {
 	// currentModel := "Merjic/majicMIX-realistic"
 	currentModel := "DynamicWang/AWPortrait"
 	// 1. Request image generation from OpenArt API
@@ -226,7 +361,7 @@ func (a *OpenArtPainter) Paint_old(prompt string, negative string, width int, he
 		return nil, "", fmt.Errorf("failed to create request payload: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", "https://openart.ai/api/apps/create_model_hub", bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://openart.ai/api/apps/create_model_hub", bytes.NewBuffer(requestBody))
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to create HTTP request: %v", err)
 	}
@@ -235,15 +370,14 @@ func (a *OpenArtPainter) Paint_old(prompt string, negative string, width int, he
 	req.Header.Set("Cookie", "__Secure-next-auth.session-token="+a.ApiKey)
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := openArtHTTP.Do(req)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to make API request: %v", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("unexpected response status: %v", resp.Status)
+		return nil, "", fmt.Errorf("unexpected response status: %w", statusError(resp))
 	}
 
 	var openArtResp OpenArtResponse
@@ -252,63 +386,20 @@ func (a *OpenArtPainter) Paint_old(prompt string, negative string, width int, he
 	}
 
 	// 2. Periodically check the status of the generated image
-	var finalImageURL string
-	for {
-		// Request to check the status of the image
-		placeholderURL := fmt.Sprintf("https://openart.ai/api/create/image_placeholder?generation_history_id=%s", openArtResp.GenerationHistoryID)
-		statusReq, err := http.NewRequest("GET", placeholderURL, nil)
-		if err != nil {
-			return nil, "", fmt.Errorf("failed to create status request: %v", err)
-		}
-		statusReq.Header.Set("Cookie", "__Secure-next-auth.session-token="+a.ApiKey)
-
-		statusResp, err := client.Do(statusReq)
-		if err != nil {
-			return nil, "", fmt.Errorf("failed to check image status: %v", err)
-		}
-		defer statusResp.Body.Close()
-
-		if statusResp.StatusCode != http.StatusOK {
-			return nil, "", fmt.Errorf("unexpected status response: %v", statusResp.Status)
-		}
-
-		var imagePlaceholderResp ImagePlaceholderResponse
-		if err := json.NewDecoder(statusResp.Body).Decode(&imagePlaceholderResp); err != nil {
-			return nil, "", fmt.Errorf("failed to decode status response: %v", err)
-		}
-
-		// Check image status
-		allCompleted := true
-		for _, img := range imagePlaceholderResp.Images {
-			if img.Status == "completed" {
-				finalImageURL = img.URL // Save the URL of the first completed image
-				break
-			} else if img.Status == "pending" {
-				allCompleted = false
-			}
-		}
-
-		if allCompleted {
-			break
-		}
-
-		// Wait briefly before checking status again
-		time.Sleep(2 * time.Second)
-	}
-
-	if finalImageURL == "" {
-		return nil, "", fmt.Errorf("no completed images found")
+	finalImageURL, err := a.waitForImage(ctx, openArtResp.GenerationHistoryID)
+	if err != nil {
+		return nil, "", err
 	}
 
 	// Change to the original quality image URL
 	finalImageURL = strings.Replace(finalImageURL, "_512.webp", "_raw.jpg", 1)
 
 	println("finalImageURL:", finalImageURL)
-	return fetchImageFromURL(finalImageURL)
+	return fetchImageFromURL(ctx, openArtHTTP, finalImageURL)
 }
 
 // Stable Diffusion
-func (a *OpenArtPainter) Paint(prompt string, negative string, width int, height int) ([]byte, string, error) {
+func (a *OpenArtPainter) Paint(ctx context.Context, prompt string, negative string, width int, height int) ([]byte, string, error) {
 	currentModel := "Flux_dev"
 	// 1. Request image generation from OpenArt API
 	requestData := OpenArtRequest{
@@ -333,7 +424,7 @@ func (a *OpenArtPainter) Paint(prompt string, negative string, width int, height
 		return nil, "", fmt.Errorf("failed to create request payload: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", "https://openart.ai/api/create/flux", bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://openart.ai/api/create/flux", bytes.NewBuffer(requestBody))
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to create HTTP request: %v", err)
 	}
@@ -342,15 +433,14 @@ func (a *OpenArtPainter) Paint(prompt string, negative string, width int, height
 	req.Header.Set("Cookie", "__Secure-next-auth.session-token="+a.ApiKey)
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := openArtHTTP.Do(req)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to make API request: %v", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("unexpected response status: %v", resp.Status)
+		return nil, "", fmt.Errorf("unexpected response status: %w", statusError(resp))
 	}
 
 	var openArtResp OpenArtFluxResponse
@@ -363,66 +453,28 @@ func (a *OpenArtPainter) Paint(prompt string, negative string, width int, height
 	}
 
 	// 2. Periodically check the status of the generated image
-	var finalImageURL string
-	for {
-		// Request to check the status of the image
-		placeholderURL := fmt.Sprintf("https://openart.ai/api/create/image_placeholder?generation_history_id=%s", openArtResp.GenerationHistoryIDs[0])
-		statusReq, err := http.NewRequest("GET", placeholderURL, nil)
-		if err != nil {
-			return nil, "", fmt.Errorf("failed to create status request: %v", err)
-		}
-		statusReq.Header.Set("Cookie", "__Secure-next-auth.session-token="+a.ApiKey)
-
-		statusResp, err := client.Do(statusReq)
-		if err != nil {
-			return nil, "", fmt.Errorf("failed to check image status: %v", err)
-		}
-		defer statusResp.Body.Close()
-
-		if statusResp.StatusCode != http.StatusOK {
-			return nil, "", fmt.Errorf("unexpected status response: %v", statusResp.Status)
-		}
-
-		var imagePlaceholderResp ImagePlaceholderResponse
-		if err := json.NewDecoder(statusResp.Body).Decode(&imagePlaceholderResp); err != nil {
-			return nil, "", fmt.Errorf("failed to decode status response: %v", err)
-		}
-
-		// Check image status
-		allCompleted := true
-		for _, img := range imagePlaceholderResp.Images {
-			if img.Status == "completed" {
-				finalImageURL = img.URL // Save the URL of the first completed image
-				break
-			} else if img.Status == "pending" {
-				allCompleted = false
-			}
-		}
-
-		if allCompleted {
-			break
-		}
-
-		// Wait briefly before checking status again
-		time.Sleep(2 * time.Second)
-	}
-
-	if finalImageURL == "" {
-		return nil, "", fmt.Errorf("no completed images found")
+	finalImageURL, err := a.waitForImage(ctx, openArtResp.GenerationHistoryIDs[0])
+	if err != nil {
+		return nil, "", err
 	}
 
 	// Change to the original quality image URL
 	finalImageURL = strings.Replace(finalImageURL, "_512.webp", "_raw.jpg", 1)
 
 	println("finalImageURL:", finalImageURL)
-	return fetchImageFromURL(finalImageURL)
+	imageBytes, mimeType, err := fetchImageFromURL(ctx, openArtHTTP, finalImageURL)
+	if err != nil {
+		return nil, "", err
+	}
+	recordImage(ctx, "openart", currentModel, fmt.Sprintf("%dx%d", width, height))
+	return imageBytes, mimeType, nil
 }
 
 func (a *OpenArtPainter) PaintFromReference(
-	refImageBytes []byte, refContentType string, prompt string, width int, height int,
+	ctx context.Context, refImageBytes []byte, refContentType string, prompt string, width int, height int,
 ) ([]byte, string, error) {
 	// 1. Upload image to OpenArt
-	uploadImageURL, err := a.uploadImage(refImageBytes, refContentType)
+	uploadImageURL, err := a.uploadImage(ctx, refImageBytes, refContentType)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to upload image: %v", err)
 	}
@@ -453,7 +505,7 @@ func (a *OpenArtPainter) PaintFromReference(
 		return nil, "", fmt.Errorf("failed to create request payload: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", "https://openart.ai/api/create/flux", bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://openart.ai/api/create/flux", bytes.NewBuffer(requestBody))
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to create HTTP request: %v", err)
 	}
@@ -462,15 +514,14 @@ func (a *OpenArtPainter) PaintFromReference(
 	req.Header.Set("Cookie", "__Secure-next-auth.session-token="+a.ApiKey)
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := openArtHTTP.Do(req)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to make API request: %v", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("unexpected response status: %v", resp.Status)
+		return nil, "", fmt.Errorf("unexpected response status: %w", statusError(resp))
 	}
 
 	var openArtResp OpenArtFluxResponse
@@ -483,71 +534,37 @@ func (a *OpenArtPainter) PaintFromReference(
 	}
 
 	// 2. Periodically check the status of the generated image
-	var finalImageURL string
-	for {
-		// Request to check the status of the image
-		placeholderURL := fmt.Sprintf("https://openart.ai/api/create/image_placeholder?generation_history_id=%s", openArtResp.GenerationHistoryIDs[0])
-		statusReq, err := http.NewRequest("GET", placeholderURL, nil)
-		if err != nil {
-			return nil, "", fmt.Errorf("failed to create status request: %v", err)
-		}
-		statusReq.Header.Set("Cookie", "__Secure-next-auth.session-token="+a.ApiKey)
-
-		statusResp, err := client.Do(statusReq)
-		if err != nil {
-			return nil, "", fmt.Errorf("failed to check image status: %v", err)
-		}
-		defer statusResp.Body.Close()
-
-		if statusResp.StatusCode != http.StatusOK {
-			return nil, "", fmt.Errorf("unexpected status response: %v", statusResp.Status)
-		}
-
-		var imagePlaceholderResp ImagePlaceholderResponse
-		if err := json.NewDecoder(statusResp.Body).Decode(&imagePlaceholderResp); err != nil {
-			return nil, "", fmt.Errorf("failed to decode status response: %v", err)
-		}
-
-		// Check image status
-		allCompleted := true
-		for _, img := range imagePlaceholderResp.Images {
-			if img.Status == "completed" {
-				finalImageURL = img.URL // Save the URL of the first completed image
-				break
-			} else if img.Status == "pending" {
-				allCompleted = false
-			}
-		}
-
-		if allCompleted {
-			break
-		}
-
-		// Wait briefly before checking status again
-		time.Sleep(2 * time.Second)
-	}
-
-	if finalImageURL == "" {
-		return nil, "", fmt.Errorf("no completed images found")
+	finalImageURL, err := a.waitForImage(ctx, openArtResp.GenerationHistoryIDs[0])
+	if err != nil {
+		return nil, "", err
 	}
 
 	// Change to the original quality image URL
 	finalImageURL = strings.Replace(finalImageURL, "_512.webp", "_raw.jpg", 1)
 
 	println("finalImageURL:", finalImageURL)
-	return fetchImageFromURL(finalImageURL)
+	imageBytes, mimeType, err := fetchImageFromURL(ctx, openArtHTTP, finalImageURL)
+	if err != nil {
+		return nil, "", err
+	}
+	recordImage(ctx, "openart", currentModel, fmt.Sprintf("%dx%d", width, height))
+	return imageBytes, mimeType, nil
 }
 
 // Function to download and return the image from the URL -> (image bytes, MIME type, error)
-func fetchImageFromURL(url string) ([]byte, string, error) {
-	resp, err := http.Get(url)
+func fetchImageFromURL(ctx context.Context, client *providerClient, url string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create request: %v", err)
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to fetch image: %v", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("failed to fetch image: %v", resp.Status)
+		return nil, "", fmt.Errorf("failed to fetch image: %w", statusError(resp))
 	}
 
 	// Load the entire response body into memory
@@ -563,7 +580,64 @@ func fetchImageFromURL(url string) ([]byte, string, error) {
 	return body, mimeType, nil
 }
 
-func (a *OpenArtPainter) uploadImage(imageBytes []byte, mimeType string) (string, error) {
+// waitForImage polls the generation every 2 seconds until an image is completed -> its URL.
+// Gives up when ctx is done, or after openArtGenerationTimeout.
+func (a *OpenArtPainter) waitForImage(ctx context.Context, generationHistoryID string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, openArtGenerationTimeout)
+	defer cancel()
+	for {
+		imagePlaceholderResp, err := a.imagePlaceholder(ctx, generationHistoryID)
+		if err != nil {
+			return "", err
+		}
+
+		// Check image status
+		allCompleted := true
+		for _, img := range imagePlaceholderResp.Images {
+			if img.Status == "completed" {
+				return img.URL, nil // the first completed image
+			} else if img.Status == "pending" {
+				allCompleted = false
+			}
+		}
+		if allCompleted {
+			return "", fmt.Errorf("no completed images found")
+		}
+
+		// Wait briefly before checking status again
+		if err := utils.Sleep(ctx, 2*time.Second); err != nil {
+			return "", err
+		}
+	}
+}
+
+// imagePlaceholder requests the status of the images of a generation
+func (a *OpenArtPainter) imagePlaceholder(ctx context.Context, generationHistoryID string) (*ImagePlaceholderResponse, error) {
+	placeholderURL := fmt.Sprintf("https://openart.ai/api/create/image_placeholder?generation_history_id=%s", generationHistoryID)
+	statusReq, err := http.NewRequestWithContext(ctx, "GET", placeholderURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create status request: %v", err)
+	}
+	statusReq.Header.Set("Cookie", "__Secure-next-auth.session-token="+a.ApiKey)
+
+	statusResp, err := openArtHTTP.Do(statusReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check image status: %v", err)
+	}
+	defer statusResp.Body.Close()
+
+	if statusResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status response: %w", statusError(statusResp))
+	}
+
+	var imagePlaceholderResp ImagePlaceholderResponse
+	if err := json.NewDecoder(statusResp.Body).Decode(&imagePlaceholderResp); err != nil {
+		return nil, fmt.Errorf("failed to decode status response: %v", err)
+	}
+	return &imagePlaceholderResp, nil
+}
+
+func (a *OpenArtPainter) uploadImage(ctx context.Context, imageBytes []byte, mimeType string) (string, error) {
 	// 1. Upload image to OpenArt
 	// POST https://openart.ai/api/media/upload_image
 	// form-data key: file, value: image bytes
@@ -590,7 +664,7 @@ func (a *OpenArtPainter) uploadImage(imageBytes []byte, mimeType string) (string
 		return "", fmt.Errorf("failed to close writer: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", "https://openart.ai/api/media/upload_image", body)
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://openart.ai/api/media/upload_image", body)
 	if err != nil {
 		return "", fmt.Errorf("failed to create HTTP request: %v", err)
 	}
@@ -598,15 +672,14 @@ func (a *OpenArtPainter) uploadImage(imageBytes []byte, mimeType string) (string
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 	req.Header.Set("Cookie", "__Secure-next-auth.session-token="+a.ApiKey)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := openArtHTTP.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("failed to make API request: %v", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected response status: %v", resp.Status)
+		return "", fmt.Errorf("unexpected response status: %w", statusError(resp))
 	}
 
 	var uploadResponse struct {
@@ -623,7 +696,7 @@ func (a *OpenArtPainter) uploadImage(imageBytes []byte, mimeType string) (string
 // recommend to specify graphic style in first. and then specify the surrounding environment.
 // must be shorter than 300 characters!
 // ex) "(realistic) beautiful portrait of a woman, white background"
-func (a *OpenArtPainter) EnhancePrompt(prompt string) (string, error) {
+func (a *OpenArtPainter) EnhancePrompt(ctx context.Context, prompt string) (string, error) {
 	// Prepare request data for prompt generation
 	requestData := map[string]interface{}{
 		// "colorScheme":          "",
@@ -640,7 +713,7 @@ func (a *OpenArtPainter) EnhancePrompt(prompt string) (string, error) {
 	}
 
 	// Create HTTP request
-	req, err := http.NewRequest("POST", "https://openart.ai/api/common/prompt", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://openart.ai/api/common/prompt", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return "", fmt.Errorf("failed to create prompt request: %w", err)
 	}
@@ -649,9 +722,7 @@ func (a *OpenArtPainter) EnhancePrompt(prompt string) (string, error) {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.ApiKey))
 
-	// Send request using HTTP client
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := openArtHTTP.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("failed to send prompt request: %w", err)
 	}
@@ -659,8 +730,7 @@ func (a *OpenArtPainter) EnhancePrompt(prompt string) (string, error) {
 
 	// Check response status code
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("received non-200 response code: %d, body: %s", resp.StatusCode, string(body))
+		return "", fmt.Errorf("received non-200 response code: %w", statusError(resp))
 	}
 
 	// Read response body
@@ -680,15 +750,16 @@ func (a *OpenArtPainter) EnhancePrompt(prompt string) (string, error) {
 
 	// Return the enhanced prompt from the first item in the response
 	if len(responseData) > 0 {
+		recordUsage(ctx, Usage{Provider: "openart", Operation: Op_Prompt, Model: "gpt-4o-mini", Quantity: 1, Unit: Unit_Requests})
 		return responseData[0].Prompt, nil
 	}
 
 	return "", fmt.Errorf("no enhanced prompt found in response")
 }
 
-func (a *OpenArtPainter) ChangeStyle(imageBytes []byte, contentType string, prompt string) ([]byte, string, error) {
+func (a *OpenArtPainter) ChangeStyle(ctx context.Context, imageBytes []byte, contentType string, prompt string) ([]byte, string, error) {
 	// 1. Upload image to OpenArt
-	uploadImageURL, err := a.uploadImage(imageBytes, contentType)
+	uploadImageURL, err := a.uploadImage(ctx, imageBytes, contentType)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to upload image: %v", err)
 	}
@@ -709,7 +780,7 @@ func (a *OpenArtPainter) ChangeStyle(imageBytes []byte, contentType string, prom
 		return nil, "", fmt.Errorf("failed to marshal request payload: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", "https://openart.ai/api/apps/create", bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://openart.ai/api/apps/create", bytes.NewBuffer(payloadBytes))
 
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to create request: %w", err)
@@ -718,16 +789,14 @@ func (a *OpenArtPainter) ChangeStyle(imageBytes []byte, contentType string, prom
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.ApiKey))
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := openArtHTTP.Do(req)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to send style change request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, "", fmt.Errorf("received non-200 response code: %d, body: %s", resp.StatusCode, string(body))
+		return nil, "", fmt.Errorf("received non-200 response code: %w", statusError(resp))
 	}
 
 	var responseData struct {
@@ -739,30 +808,14 @@ func (a *OpenArtPainter) ChangeStyle(imageBytes []byte, contentType string, prom
 	}
 
 	// 3. Periodically check the status of the generated image
+	ctx, cancel := context.WithTimeout(ctx, openArtGenerationTimeout)
+	defer cancel()
 	var finalImageURL string
 	refetchCount := 0
 	for {
-		// Request to check the status of the image
-		placeholderURL := fmt.Sprintf("https://openart.ai/api/create/image_placeholder?generation_history_id=%s", responseData.GenerationHistoryID)
-		statusReq, err := http.NewRequest("GET", placeholderURL, nil)
-		if err != nil {
-			return nil, "", fmt.Errorf("failed to create status request: %v", err)
-		}
-		statusReq.Header.Set("Cookie", "__Secure-next-auth.session-token="+a.ApiKey)
-
-		statusResp, err := client.Do(statusReq)
+		imagePlaceholderResp, err := a.imagePlaceholder(ctx, responseData.GenerationHistoryID)
 		if err != nil {
-			return nil, "", fmt.Errorf("failed to check image status: %v", err)
-		}
-		defer statusResp.Body.Close()
-
-		if statusResp.StatusCode != http.StatusOK {
-			return nil, "", fmt.Errorf("unexpected status response: %v", statusResp.Status)
-		}
-
-		var imagePlaceholderResp ImagePlaceholderResponse
-		if err := json.NewDecoder(statusResp.Body).Decode(&imagePlaceholderResp); err != nil {
-			return nil, "", fmt.Errorf("failed to decode status response: %v", err)
+			return nil, "", err
 		}
 
 		// Check image status
@@ -786,13 +839,16 @@ func (a *OpenArtPainter) ChangeStyle(imageBytes []byte, contentType string, prom
 		}
 
 		// Wait briefly before checking status again
-		time.Sleep(2 * time.Second)
+		if err := utils.Sleep(ctx, 2*time.Second); err != nil {
+			return nil, "", err
+		}
 	}
 
-	imageBytes, mimeType, err := fetchImageFromURL(finalImageURL)
+	imageBytes, mimeType, err := fetchImageFromURL(ctx, openArtHTTP, finalImageURL)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to fetch image from URL: %v", err)
 	}
 
+	recordImage(ctx, "openart", "creative-variations", "")
 	return imageBytes, mimeType, nil
 }
@@ -0,0 +1,32 @@
+package tools
+
+import "testing"
+
+func TestOpenAIImageSize(t *testing.T) {
+	tests := []struct {
+		name          string
+		sizes         [][2]int
+		width, height int
+		want          string
+		wantErr       bool
+	}{
+		{"supported", dallE3Sizes, 1792, 1024, "1792x1024", false},
+		{"square", gptImage1Sizes, 512, 512, "1024x1024", false},
+		{"portrait", dallE3Sizes, 682, 1024, "1024x1792", false},
+		{"landscape", gptImage1Sizes, 1920, 1080, "1536x1024", false},
+		{"nearly square", dallE3Sizes, 1000, 1100, "1024x1024", false},
+		{"zero width", dallE3Sizes, 0, 1024, "", true},
+		{"negative height", gptImage1Sizes, 1024, -1, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := openAIImageSize(tt.sizes, tt.width, tt.height)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error: %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("openAIImageSize(%d, %d) = %q, want %q", tt.width, tt.height, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package tools
+
+import (
+	"avazon-api/models"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ErrNotImplemented is returned by the provider methods that don't exist (yet). Provider chains skip them.
+var ErrNotImplemented = errors.New("not implemented")
+
+// StatusError is an unexpected HTTP response of a provider
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return e.Status
+	}
+	return fmt.Sprintf("%s, body: %s", e.Status, e.Body)
+}
+
+// statusError reads resp (and at most 1KB of its body) into a StatusError
+func statusError(resp *http.Response) *StatusError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(body)}
+}
+
+// Retryable tells whether the next provider of a chain is worth trying after err.
+// It isn't when ctx is done, or when the request itself was rejected (400, 413, 415, 422): the others would reject it too.
+// Anything else (network errors, timeouts, expired keys or sessions, rate limits, 5xx, ...) is retryable.
+func Retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity:
+			return false
+		}
+	}
+	return true
+}
+
+type usedProviderKey struct{}
+
+// UsedProvider gets the name of the provider that produced the last result of a chain called with its context.
+type UsedProvider struct {
+	name string
+	mu   sync.Mutex
+}
+
+// WithUsedProvider returns a context through which the chains report the provider they used
+func WithUsedProvider(ctx context.Context) (context.Context, *UsedProvider) {
+	used := &UsedProvider{}
+	return context.WithValue(ctx, usedProviderKey{}, used), used
+}
+
+// Name is empty until a chain succeeded
+func (u *UsedProvider) Name() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.name
+}
+
+func recordProvider(ctx context.Context, name string) {
+	if used, ok := ctx.Value(usedProviderKey{}).(*UsedProvider); ok {
+		used.mu.Lock()
+		used.name = name
+		used.mu.Unlock()
+	}
+}
+
+// Provider is one entry of a provider chain
+type Provider[T any] struct {
+	Name string
+	Tool T
+}
+
+// tryProviders calls the providers in order until one succeeds or fails with a fatal error (see Retryable).
+// Providers answering ErrNotImplemented are skipped.
+func tryProviders[T any](ctx context.Context, providers []Provider[T], call func(p Provider[T]) error) error {
+	var errs []error
+	for _, p := range providers {
+		err := call(p)
+		if err == nil {
+			recordProvider(ctx, p.Name)
+			return nil
+		}
+		if errors.Is(err, ErrNotImplemented) {
+			continue
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", p.Name, err))
+		if !Retryable(ctx, err) {
+			break
+		}
+		log.Printf("Provider %s failed: %v", p.Name, err)
+	}
+	if len(errs) == 0 {
+		return ErrNotImplemented
+	}
+	return errors.Join(errs...)
+}
+
+// ======================================================================================================================
+// Painter
+// ======================================================================================================================
+
+// PainterChain is a Painter trying an ordered list of painters
+type PainterChain struct {
+	providers []Provider[Painter]
+}
+
+func NewPainterChain(providers ...Provider[Painter]) *PainterChain {
+	return &PainterChain{providers: providers}
+}
+
+func (c *PainterChain) Paint(ctx context.Context, prompt string, negative string, width int, height int) ([]byte, string, error) {
+	var image []byte
+	var mimeType string
+	err := tryProviders(ctx, c.providers, func(p Provider[Painter]) (err error) {
+		image, mimeType, err = p.Tool.Paint(ctx, prompt, negative, width, height)
+		return err
+	})
+	return image, mimeType, err
+}
+
+func (c *PainterChain) PaintFromReference(
+	ctx context.Context, refImageBytes []byte, refContentType string, prompt string, width int, height int,
+) ([]byte, string, error) {
+	var image []byte
+	var mimeType string
+	err := tryProviders(ctx, c.providers, func(p Provider[Painter]) (err error) {
+		image, mimeType, err = p.Tool.PaintFromReference(ctx, refImageBytes, refContentType, prompt, width, height)
+		return err
+	})
+	return image, mimeType, err
+}
+
+func (c *PainterChain) EnhancePrompt(ctx context.Context, prompt string) (string, error) {
+	var enhanced string
+	err := tryProviders(ctx, c.providers, func(p Provider[Painter]) (err error) {
+		enhanced, err = p.Tool.EnhancePrompt(ctx, prompt)
+		return err
+	})
+	return enhanced, err
+}
+
+func (c *PainterChain) ChangeStyle(ctx context.Context, imageBytes []byte, contentType string, prompt string) ([]byte, string, error) {
+	var image []byte
+	var mimeType string
+	err := tryProviders(ctx, c.providers, func(p Provider[Painter]) (err error) {
+		image, mimeType, err = p.Tool.ChangeStyle(ctx, imageBytes, contentType, prompt)
+		return err
+	})
+	return image, mimeType, err
+}
+
+// ======================================================================================================================
+// VoiceActor
+// ======================================================================================================================
+
+// VoiceActorChain is a VoiceActor trying an ordered list of voice actors.
+// Its voice ids are prefixed with the provider that created them ("elevenlabs:abc"), so TTS goes to that provider.
+type VoiceActorChain struct {
+	providers []Provider[VoiceActor]
+}
+
+func NewVoiceActorChain(providers ...Provider[VoiceActor]) *VoiceActorChain {
+	return &VoiceActorChain{providers: providers}
+}
+
+func (c *VoiceActorChain) Create(ctx context.Context, prompt string, gender models.Gender, args ...string) (string, string, error) {
+	var provider, voiceID string
+	err := tryProviders(ctx, c.providers, func(p Provider[VoiceActor]) (err error) {
+		_, voiceID, err = p.Tool.Create(ctx, prompt, gender, args...)
+		provider = p.Name
+		return err
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return provider, provider + ":" + voiceID, nil
+}
+
+func (c *VoiceActorChain) TTS(ctx context.Context, voiceId string, text string) ([]byte, error) {
+	var voice []byte
+	err := tryProviders(ctx, c.route(voiceId), func(p Provider[VoiceActor]) (err error) {
+		voice, err = p.Tool.TTS(ctx, unqualified(voiceId), text)
+		return err
+	})
+	return voice, err
+}
+
+func (c *VoiceActorChain) TTSStream(ctx context.Context, voiceId string, text string) (io.ReadCloser, error) {
+	var stream io.ReadCloser
+	err := tryProviders(ctx, c.route(voiceId), func(p Provider[VoiceActor]) (err error) {
+		stream, err = p.Tool.TTSStream(ctx, unqualified(voiceId), text)
+		return err
+	})
+	return stream, err
+}
+
+// route returns the provider of a prefixed voice id, or every provider for a bare one
+func (c *VoiceActorChain) route(voiceID string) []Provider[VoiceActor] {
+	name, _, ok := strings.Cut(voiceID, ":")
+	if !ok {
+		return c.providers
+	}
+	for _, p := range c.providers {
+		if p.Name == name {
+			return []Provider[VoiceActor]{p}
+		}
+	}
+	return c.providers
+}
+
+func unqualified(voiceID string) string {
+	if _, id, ok := strings.Cut(voiceID, ":"); ok {
+		return id
+	}
+	return voiceID
+}
+
+// ======================================================================================================================
+// MusicProducer
+// ======================================================================================================================
+
+// MusicProducerChain is a MusicProducer trying an ordered list of music producers
+type MusicProducerChain struct {
+	providers []Provider[MusicProducer]
+}
+
+func NewMusicProducerChain(providers ...Provider[MusicProducer]) *MusicProducerChain {
+	return &MusicProducerChain{providers: providers}
+}
+
+func (c *MusicProducerChain) Produce(ctx context.Context, title string, style string, description string) ([]byte, error) {
+	var music []byte
+	err := tryProviders(ctx, c.providers, func(p Provider[MusicProducer]) (err error) {
+		music, err = p.Tool.Produce(ctx, title, style, description)
+		return err
+	})
+	return music, err
+}
@@ -0,0 +1,291 @@
+package tools
+
+import (
+	"avazon-api/utils"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling a provider whose circuit breaker is open. Provider chains move on to the next one.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// ResilienceConfig tunes the retries and circuit breakers of the provider calls
+type ResilienceConfig struct {
+	MaxRetries       int           // retries of a call answering 429, 5xx or (idempotent calls only) a network error
+	RetryBackoff     time.Duration // delay before the first retry, doubled on every retry, with jitter
+	MaxRetryWait     time.Duration // longest wait before a retry: a longer Retry-After gives up at once
+	BreakerThreshold int           // consecutive failures (5xx, network errors) opening the breaker
+	BreakerCooldown  time.Duration // an open breaker lets one trial call through after this long
+}
+
+var resilience = ResilienceConfig{
+	MaxRetries:       3,
+	RetryBackoff:     500 * time.Millisecond,
+	MaxRetryWait:     30 * time.Second,
+	BreakerThreshold: 5,
+	BreakerCooldown:  30 * time.Second,
+}
+
+// ConfigureResilience replaces the default settings. Call it at startup, before any provider is called.
+func ConfigureResilience(config ResilienceConfig) {
+	resilience = config
+}
+
+// ======================================================================================================================
+// Circuit breaker
+// ======================================================================================================================
+
+type BreakerState string
+
+// closed -> open after BreakerThreshold consecutive failures
+// open -> half_open after BreakerCooldown, letting one trial call through
+// half_open -> closed if the trial succeeds, open again otherwise
+const (
+	Breaker_Closed   BreakerState = "closed"
+	Breaker_Open     BreakerState = "open"
+	Breaker_HalfOpen BreakerState = "half_open"
+)
+
+type circuitBreaker struct {
+	provider string
+	state    BreakerState
+	failures int // consecutive
+	openedAt time.Time
+	probing  bool // a half open trial call is running
+	mu       sync.Mutex
+}
+
+// allow tells whether a call may go out, and takes the trial slot of a half open breaker
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == Breaker_Open && time.Since(b.openedAt) >= resilience.BreakerCooldown {
+		b.state = Breaker_HalfOpen
+	}
+	switch b.state {
+	case Breaker_Open:
+		return false
+	case Breaker_HalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+// record reports the outcome of an allowed call: true for success, false for failure, nil when it tells nothing
+// about the provider's health (rate limited, cancelled by the caller)
+func (b *circuitBreaker) record(success *bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	switch {
+	case success == nil:
+	case *success:
+		if b.state != Breaker_Closed {
+			log.Printf("Circuit breaker of %s closed", b.provider)
+		}
+		b.state = Breaker_Closed
+		b.failures = 0
+	default:
+		b.failures++
+		if b.state == Breaker_HalfOpen || (b.state == Breaker_Closed && b.failures >= resilience.BreakerThreshold) {
+			log.Printf("Circuit breaker of %s opened after %d failure(s)", b.provider, b.failures)
+			b.state = Breaker_Open
+			b.openedAt = time.Now()
+		}
+	}
+}
+
+// ProviderHealth is the circuit breaker state of one provider
+type ProviderHealth struct {
+	Provider string       `json:"provider"`
+	State    BreakerState `json:"state"`
+	Failures int          `json:"failures"`            // consecutive
+	OpenedAt *time.Time   `json:"opened_at,omitempty"` // while not closed
+	RetryAt  *time.Time   `json:"retry_at,omitempty"`  // next trial call, while open
+}
+
+func (b *circuitBreaker) health() ProviderHealth {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	health := ProviderHealth{Provider: b.provider, State: b.state, Failures: b.failures}
+	if b.state == Breaker_Open && time.Since(b.openedAt) >= resilience.BreakerCooldown {
+		health.State = Breaker_HalfOpen
+	}
+	if health.State != Breaker_Closed {
+		openedAt := b.openedAt
+		health.OpenedAt = &openedAt
+	}
+	if health.State == Breaker_Open {
+		retryAt := b.openedAt.Add(resilience.BreakerCooldown)
+		health.RetryAt = &retryAt
+	}
+	return health
+}
+
+var (
+	breakers   = map[string]*circuitBreaker{}
+	breakersMu sync.Mutex
+)
+
+func breakerOf(provider string) *circuitBreaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b, ok := breakers[provider]
+	if !ok {
+		b = &circuitBreaker{provider: provider, state: Breaker_Closed}
+		breakers[provider] = b
+	}
+	return b
+}
+
+// ProvidersHealth returns the circuit breaker states of the providers, by name
+func ProvidersHealth() []ProviderHealth {
+	breakersMu.Lock()
+	all := make([]*circuitBreaker, 0, len(breakers))
+	for _, b := range breakers {
+		all = append(all, b)
+	}
+	breakersMu.Unlock()
+
+	healths := make([]ProviderHealth, 0, len(all))
+	for _, b := range all {
+		healths = append(healths, b.health())
+	}
+	sort.Slice(healths, func(i, j int) bool { return healths[i].Provider < healths[j].Provider })
+	return healths
+}
+
+// ======================================================================================================================
+// HTTP client
+// ======================================================================================================================
+
+// providerClient sends the requests of one provider through utils.HTTPClient, with retries and a circuit breaker
+type providerClient struct {
+	breaker *circuitBreaker
+}
+
+var (
+	openAIHTTP     = newProviderClient("openai")
+	openArtHTTP    = newProviderClient("openart")
+	elevenLabsHTTP = newProviderClient("elevenlabs")
+	runwayHTTP     = newProviderClient("runway")
+	jenaiHTTP      = newProviderClient("jenai")
+	anthropicHTTP  = newProviderClient("anthropic")
+)
+
+func newProviderClient(provider string) *providerClient {
+	return &providerClient{breaker: breakerOf(provider)}
+}
+
+// Do sends req, retrying 429 and 5xx answers (and network errors of idempotent requests) with a jittered exponential
+// backoff, or after the Retry-After the provider asked for. The last response is returned once retries are exhausted.
+// Fails fast with ErrCircuitOpen while the provider is down.
+func (c *providerClient) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		if !c.breaker.allow() {
+			return nil, fmt.Errorf("%s: %w", c.breaker.provider, ErrCircuitOpen)
+		}
+		attemptReq := req
+		if attempt > 0 && req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				c.breaker.record(nil)
+				return nil, err
+			}
+			attemptReq = req.Clone(ctx)
+			attemptReq.Body = body
+		}
+
+		resp, err := utils.HTTPClient.Do(attemptReq)
+		c.breaker.record(healthy(resp, err, ctx.Err()))
+
+		retry := false
+		if err != nil {
+			retry = ctx.Err() == nil && idempotent(req.Method)
+		} else {
+			retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		}
+		if !retry || attempt >= resilience.MaxRetries || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+
+		wait := backoff(attempt)
+		if resp != nil {
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				if retryAfter > resilience.MaxRetryWait {
+					return resp, nil
+				}
+				wait = retryAfter
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024)) // lets the connection be reused
+			resp.Body.Close()
+			log.Printf("%s answered %s, retrying in %s", c.breaker.provider, resp.Status, wait)
+		} else {
+			log.Printf("%s request failed (%v), retrying in %s", c.breaker.provider, err, wait)
+		}
+		if err := utils.Sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// healthy classifies a call for the circuit breaker: network errors and 5xx are failures, 429 and calls cancelled
+// by the caller tell nothing
+func healthy(resp *http.Response, err error, ctxErr error) *bool {
+	var ok bool
+	switch {
+	case ctxErr != nil:
+		return nil
+	case err != nil:
+		ok = false
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return nil
+	default:
+		ok = resp.StatusCode < 500
+	}
+	return &ok
+}
+
+// a network error may come after the request was processed, so only requests safe to send twice are retried
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// backoff is RetryBackoff doubled on every retry, capped at MaxRetryWait, with up to half of it taken off at random
+func backoff(attempt int) time.Duration {
+	wait := min(resilience.RetryBackoff<<min(attempt, 16), resilience.MaxRetryWait)
+	if wait <= 0 {
+		return 0
+	}
+	return wait - rand.N(wait/2+1)
+}
+
+// parseRetryAfter reads a Retry-After header, either in seconds or an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,73 @@
+package tools
+
+import "context"
+
+type UsageOperation string
+
+const (
+	Op_Chat        UsageOperation = "chat"
+	Op_Image       UsageOperation = "image"
+	Op_Prompt      UsageOperation = "prompt" // prompt enhancement of a painter
+	Op_TTS         UsageOperation = "tts"
+	Op_VoiceDesign UsageOperation = "voice_design"
+	Op_Video       UsageOperation = "video"
+	Op_Music       UsageOperation = "music"
+)
+
+type UsageUnit string
+
+const (
+	Unit_Tokens     UsageUnit = "tokens"
+	Unit_Images     UsageUnit = "images"
+	Unit_Requests   UsageUnit = "requests"
+	Unit_Characters UsageUnit = "characters"
+	Unit_Seconds    UsageUnit = "seconds"
+)
+
+// Usage is what one successful provider call consumed
+type Usage struct {
+	Provider     string // openai, anthropic, openart, elevenlabs, runway, jenai, or the host of an OpenAI compatible server
+	Operation    UsageOperation
+	Model        string
+	Quantity     float64 // in Unit; chat: input + output tokens
+	Unit         UsageUnit
+	InputTokens  int    // chat
+	OutputTokens int    // chat
+	Detail       string // e.g. the image size
+}
+
+// UsageRecorder receives the usage of the provider calls made with its context. It may be called from any goroutine.
+type UsageRecorder func(usage Usage)
+
+type usageRecorderKey struct{}
+
+// WithUsageRecorder returns a context through which the providers report their usage to record
+func WithUsageRecorder(ctx context.Context, record UsageRecorder) context.Context {
+	return context.WithValue(ctx, usageRecorderKey{}, record)
+}
+
+func recordUsage(ctx context.Context, usage Usage) {
+	if record, ok := ctx.Value(usageRecorderKey{}).(UsageRecorder); ok {
+		record(usage)
+	}
+}
+
+// recordTokens reports the tokens of a chat answer, if the vendor told them
+func recordTokens(ctx context.Context, provider string, model string, inputTokens int, outputTokens int) {
+	if inputTokens+outputTokens == 0 {
+		return
+	}
+	recordUsage(ctx, Usage{
+		Provider:     provider,
+		Operation:    Op_Chat,
+		Model:        model,
+		Quantity:     float64(inputTokens + outputTokens),
+		Unit:         Unit_Tokens,
+		InputTokens:  inputTokens,
+		OutputTokens: outputTokens,
+	})
+}
+
+func recordImage(ctx context.Context, provider string, model string, size string) {
+	recordUsage(ctx, Usage{Provider: provider, Operation: Op_Image, Model: model, Quantity: 1, Unit: Unit_Images, Detail: size})
+}
@@ -3,6 +3,7 @@ package tools
 import (
 	"avazon-api/utils"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -15,21 +16,24 @@ import (
 
 type VideoProducer interface {
 	// Create creates a video from the image and returns the video as bytes
-	Create(imageURL string, prompt string) ([]byte, error)
-	// CreateAsync -> (doneCh, failCh)
-	CreateAsync(imageURL string, prompt string) <-chan *VideoGenTask
+	Create(ctx context.Context, imageURL string, prompt string) ([]byte, error)
+	// CreateAsync -> the task, sent once done (VideoURL set) or failed (FailReason set)
+	CreateAsync(ctx context.Context, imageURL string, prompt string) <-chan *VideoGenTask
 	// SaveImageAsset saves the image as an asset and returns the URL
-	SaveImageAsset(image []byte, imageType string) (string, error)
+	SaveImageAsset(ctx context.Context, image []byte, imageType string) (string, error)
 	GetTasks() []*VideoGenTask
 }
 
+// gives up on a video not rendered after this long (waiting for a free slot included)
+const runwayGenerationTimeout = 30 * time.Minute
+
+// length of the generated videos
+const runwayVideoSeconds = 10
+
 type RunwayVideoProducer struct {
 	ApiKey          string
-	accessToken     string             // Short token. Renewed with each request
-	ReadyTaskCh     chan *VideoGenTask // Channel for preparing tasks -> starts when canStart is true
-	ProcessingTasks []*VideoGenTask    // Tasks in progress -> moved to DoneQueue upon completion
-	DoneCh          chan *VideoGenTask // Channel to notify task completion
-	FailCh          chan *VideoGenTask // Channel to notify task failure
+	accessToken     string          // Short token. Renewed with each request
+	ProcessingTasks []*VideoGenTask // Tasks started and not finished yet
 	mu              sync.Mutex
 }
 
@@ -44,19 +48,14 @@ type VideoGenTask struct {
 }
 
 func NewRunwayVideoProducer(apiKey string) *RunwayVideoProducer {
-	ret := &RunwayVideoProducer{
+	return &RunwayVideoProducer{
 		ApiKey:          apiKey,
-		ReadyTaskCh:     make(chan *VideoGenTask),
 		ProcessingTasks: make([]*VideoGenTask, 0),
-		DoneCh:          make(chan *VideoGenTask),
-		FailCh:          make(chan *VideoGenTask),
 	}
-	go ret.taskLoop()
-	return ret
 }
 
-func (va *RunwayVideoProducer) Create(imageURL string, prompt string) ([]byte, error) {
-	imageBytes, mimeType, err := utils.GetDataFromURL(imageURL)
+func (va *RunwayVideoProducer) Create(ctx context.Context, imageURL string, prompt string) ([]byte, error) {
+	imageBytes, mimeType, err := utils.GetDataFromURL(ctx, imageURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get image bytes: %v", err)
 	}
@@ -66,7 +65,7 @@ func (va *RunwayVideoProducer) Create(imageURL string, prompt string) ([]byte, e
 		return nil, fmt.Errorf("failed to get file extension from MIME type: %v", err)
 	}
 	assetFileName := fmt.Sprintf("%d.%s", time.Now().Unix(), assetFilmExtension)
-	assetURL, err := va.uploadImageAsset(assetFileName, imageBytes)
+	assetURL, err := va.uploadImageAsset(ctx, assetFileName, imageBytes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to upload image asset: %v", err)
 	}
@@ -77,71 +76,49 @@ func (va *RunwayVideoProducer) Create(imageURL string, prompt string) ([]byte, e
 		Prompt:    prompt,
 		CreatedAt: time.Now(),
 	}
-	va.ReadyTaskCh <- task
-	for {
-		select {
-		case t := <-va.FailCh:
-			if task.ID == t.ID {
-				return nil, fmt.Errorf("failed to create video: %s", t.FailReason)
-			} else {
-				va.FailCh <- t
-			}
-		case t := <-va.DoneCh:
-			if task.ID == t.ID {
-				// Fetch from t.VideoURL
-				resp, err := http.Get(t.VideoURL)
-				if err != nil {
-					return nil, fmt.Errorf("failed to fetch video: %v", err)
-				}
-				defer resp.Body.Close()
-				if resp.StatusCode != http.StatusOK {
-					return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-				}
-				return io.ReadAll(resp.Body)
-			} else {
-				va.DoneCh <- t
-			}
-		default:
-			// HTTP Polling
-			time.Sleep(10 * time.Second)
-		}
+	if err := va.generate(ctx, task); err != nil {
+		return nil, err
+	}
+	if task.FailReason != "" {
+		return nil, fmt.Errorf("failed to create video: %s", task.FailReason)
+	}
+
+	// Fetch from task.VideoURL
+	req, err := http.NewRequestWithContext(ctx, "GET", task.VideoURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
+	resp, err := runwayHTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch video: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
 }
 
-func (va *RunwayVideoProducer) CreateAsync(imageURL string, prompt string) <-chan *VideoGenTask {
+func (va *RunwayVideoProducer) CreateAsync(ctx context.Context, imageURL string, prompt string) <-chan *VideoGenTask {
 	task := &VideoGenTask{
 		ImageURL:  imageURL,
 		Prompt:    prompt,
 		CreatedAt: time.Now(),
 	}
-	va.ReadyTaskCh <- task
-	taskCh := make(chan *VideoGenTask)
+	taskCh := make(chan *VideoGenTask, 1)
 	go func() {
-		for {
-			select {
-			case t := <-va.FailCh:
-				if task.ID == t.ID {
-					taskCh <- t
-				} else {
-					va.FailCh <- t
-				}
-			case t := <-va.DoneCh:
-				if task.ID == t.ID {
-					taskCh <- t
-				} else {
-					va.DoneCh <- t
-				}
-			default:
-				time.Sleep(10 * time.Second)
-			}
+		defer close(taskCh)
+		if err := va.generate(ctx, task); err != nil {
+			task.FailReason = err.Error()
 		}
+		taskCh <- task
 	}()
 	return taskCh
 }
 
-func (va *RunwayVideoProducer) SaveImageAsset(image []byte, imageType string) (string, error) {
+func (va *RunwayVideoProducer) SaveImageAsset(ctx context.Context, image []byte, imageType string) (string, error) {
 	// First of all, issue a new token
-	_, err := va.issueToken()
+	_, err := va.issueToken(ctx)
 	if err != nil {
 		return "", fmt.Errorf("failed to issue token: %v", err)
 	}
@@ -162,16 +139,16 @@ func (va *RunwayVideoProducer) SaveImageAsset(image []byte, imageType string) (s
 	}
 	// Generate file name
 	filename := fmt.Sprintf("%d%s", timeline, ext)
-	assetID, err := va.uploadImageAssetFile(filename, image, "DATASET")
+	assetID, err := va.uploadImageAssetFile(ctx, filename, image, "DATASET")
 	if err != nil {
 		return "", fmt.Errorf("failed to upload image asset: %v", err)
 	}
-	previewID, err := va.uploadImageAssetFile(filename, image, "DATASET_PREVIEW")
+	previewID, err := va.uploadImageAssetFile(ctx, filename, image, "DATASET_PREVIEW")
 	if err != nil {
 		return "", fmt.Errorf("failed to upload preview image asset: %v", err)
 	}
 	// Find json["dataset"]["url"]
-	resp, err := va.post("https://api.runwayml.com/v1/datasets", map[string]interface{}{
+	resp, err := va.post(ctx, "https://api.runwayml.com/v1/datasets", map[string]interface{}{
 		"fileCount":        1,
 		"name":             filename,
 		"uploadId":         assetID,
@@ -205,12 +182,14 @@ func (va *RunwayVideoProducer) SaveImageAsset(image []byte, imageType string) (s
 }
 
 func (va *RunwayVideoProducer) GetTasks() []*VideoGenTask {
-	return va.ProcessingTasks
+	va.mu.Lock()
+	defer va.mu.Unlock()
+	return append([]*VideoGenTask{}, va.ProcessingTasks...)
 }
 
-func (va *RunwayVideoProducer) get(url string) (*http.Response, error) {
+func (va *RunwayVideoProducer) get(ctx context.Context, url string) (*http.Response, error) {
 	// Create GET request
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
@@ -218,9 +197,7 @@ func (va *RunwayVideoProducer) get(url string) (*http.Response, error) {
 	// Set Authorization header
 	req.Header.Set("Authorization", "Bearer "+va.ApiKey)
 
-	// Create HTTP client and execute request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := runwayHTTP.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make request: %v", err)
 	}
@@ -228,7 +205,7 @@ func (va *RunwayVideoProducer) get(url string) (*http.Response, error) {
 	return resp, nil
 }
 
-func (va *RunwayVideoProducer) post(url string, body map[string]interface{}) (*http.Response, error) {
+func (va *RunwayVideoProducer) post(ctx context.Context, url string, body map[string]interface{}) (*http.Response, error) {
 	// Create request body
 	reqBody, err := json.Marshal(body)
 	if err != nil {
@@ -236,7 +213,7 @@ func (va *RunwayVideoProducer) post(url string, body map[string]interface{}) (*h
 	}
 
 	// Create POST request
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(reqBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
@@ -245,9 +222,7 @@ func (va *RunwayVideoProducer) post(url string, body map[string]interface{}) (*h
 	req.Header.Set("Authorization", "Bearer "+va.ApiKey)
 	req.Header.Set("Content-Type", "application/json")
 
-	// Create HTTP client and execute request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := runwayHTTP.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make request: %v", err)
 	}
@@ -255,11 +230,11 @@ func (va *RunwayVideoProducer) post(url string, body map[string]interface{}) (*h
 	return resp, nil
 }
 
-func (va *RunwayVideoProducer) issueToken() (string, error) {
+func (va *RunwayVideoProducer) issueToken(ctx context.Context) (string, error) {
 	// Token issuance URL for Runway API
 	tokenURL := "https://api.runwayml.com/v1/short_jwt"
 	// Issue token via POST request
-	resp, err := va.post(tokenURL, map[string]interface{}{})
+	resp, err := va.post(ctx, tokenURL, map[string]interface{}{})
 	if err != nil {
 		return "", fmt.Errorf("failed to make request: %v", err)
 	}
@@ -286,9 +261,9 @@ func (va *RunwayVideoProducer) issueToken() (string, error) {
 
 // Returns (object_id, error)
 // dataType: "DATASET" or "DATASET_PREVIEW"
-func (va *RunwayVideoProducer) uploadImageAssetFile(filename string, image []byte, dataType string) (string, error) {
+func (va *RunwayVideoProducer) uploadImageAssetFile(ctx context.Context, filename string, image []byte, dataType string) (string, error) {
 	// 1. Start image upload
-	resp1, err := va.post("https://api.runwayml.com/v1/uploads", map[string]interface{}{
+	resp1, err := va.post(ctx, "https://api.runwayml.com/v1/uploads", map[string]interface{}{
 		"filename":      filename,
 		"numberOfParts": 1,
 		"type":          dataType,
@@ -313,7 +288,7 @@ func (va *RunwayVideoProducer) uploadImageAssetFile(filename string, image []byt
 	}
 
 	// 2. Upload image to S3
-	putReq, err := http.NewRequest("PUT", resp1Data.UploadUrls[0], bytes.NewBuffer(image))
+	putReq, err := http.NewRequestWithContext(ctx, "PUT", resp1Data.UploadUrls[0], bytes.NewBuffer(image))
 	if err != nil {
 		return "", fmt.Errorf("failed to create PUT request: %v", err)
 	}
@@ -321,8 +296,7 @@ func (va *RunwayVideoProducer) uploadImageAssetFile(filename string, image []byt
 	// Set Content-Type header
 	putReq.Header.Set("Content-Type", resp1Data.UploadHeaders.ContentType)
 
-	client := &http.Client{}
-	putResp, err := client.Do(putReq)
+	putResp, err := runwayHTTP.Do(putReq)
 	if err != nil {
 		return "", fmt.Errorf("failed to upload image: %v", err)
 	}
@@ -342,7 +316,7 @@ func (va *RunwayVideoProducer) uploadImageAssetFile(filename string, image []byt
 
 	// 3. Notify upload completion
 	completeURL := fmt.Sprintf("https://api.runwayml.com/v1/uploads/%s/complete", resp1Data.ID)
-	resp3, err := va.post(completeURL, map[string]interface{}{
+	resp3, err := va.post(ctx, completeURL, map[string]interface{}{
 		"parts": []map[string]interface{}{
 			{
 				"PartNumber": 1,
@@ -365,13 +339,13 @@ func (va *RunwayVideoProducer) uploadImageAssetFile(filename string, image []byt
 }
 
 // returns url only
-func (va *RunwayVideoProducer) uploadImageAsset(filename string, imageBytes []byte) (string, error) {
-	datasetID, err := va.uploadImageAssetFile(filename, imageBytes, "DATASET")
+func (va *RunwayVideoProducer) uploadImageAsset(ctx context.Context, filename string, imageBytes []byte) (string, error) {
+	datasetID, err := va.uploadImageAssetFile(ctx, filename, imageBytes, "DATASET")
 	if err != nil {
 		return "", fmt.Errorf("failed to upload image asset: %v", err)
 	}
 
-	datasetPreviewID, err := va.uploadImageAssetFile(filename, imageBytes, "DATASET_PREVIEW")
+	datasetPreviewID, err := va.uploadImageAssetFile(ctx, filename, imageBytes, "DATASET_PREVIEW")
 	if err != nil {
 		return "", fmt.Errorf("failed to upload image asset: %v", err)
 	}
@@ -396,7 +370,7 @@ func (va *RunwayVideoProducer) uploadImageAsset(filename string, imageBytes []by
 
 	// Send the request to create the dataset
 	datasetURL := "https://api.runwayml.com/v1/datasets"
-	resp, err := va.post(datasetURL, payload)
+	resp, err := va.post(ctx, datasetURL, payload)
 	if err != nil {
 		return "", fmt.Errorf("failed to create dataset: %v", err)
 	}
@@ -421,14 +395,14 @@ func (va *RunwayVideoProducer) uploadImageAsset(filename string, imageBytes []by
 	return datasetResponse.Dataset.URL, nil
 }
 
-func (va *RunwayVideoProducer) checkCanStartNewTask() (bool, error) {
-	_, err := va.issueToken()
+func (va *RunwayVideoProducer) checkCanStartNewTask(ctx context.Context) (bool, error) {
+	_, err := va.issueToken(ctx)
 	if err != nil {
 		return false, err
 	}
 	checkURL := "https://api.runwayml.com/v1/tasks/can_start?mode=explore"
 	// Check if json["canStartNewTask"]["canStartNewTask"] is true
-	resp, err := va.get(checkURL)
+	resp, err := va.get(ctx, checkURL)
 	if err != nil {
 		return false, err
 	}
@@ -447,40 +421,68 @@ func (va *RunwayVideoProducer) checkCanStartNewTask() (bool, error) {
 	return respData.CanStartNewTask.CanStartNewTask, nil
 }
 
-// taskLoop is a goroutine that manages tasks which are all about generating videos
-func (va *RunwayVideoProducer) taskLoop() {
+// generate waits for a free slot, starts the task and polls it every 10 seconds until it is done
+// (task.VideoURL is set) or failed (task.FailReason is set).
+// Returns an error when ctx is done or after runwayGenerationTimeout; the started task is then cancelled.
+func (va *RunwayVideoProducer) generate(ctx context.Context, task *VideoGenTask) error {
+	ctx, cancel := context.WithTimeout(ctx, runwayGenerationTimeout)
+	defer cancel()
+
+	for {
+		canStart, err := va.checkCanStartNewTask(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to check if new task can start: %v", err)
+		}
+		if canStart {
+			break
+		}
+		if err := utils.Sleep(ctx, 10*time.Second); err != nil {
+			return err
+		}
+	}
+
+	if err := va.startTask(ctx, task); err != nil {
+		return err
+	}
+	if task.FailReason != "" {
+		return nil
+	}
+	va.mu.Lock()
+	va.ProcessingTasks = append(va.ProcessingTasks, task)
+	va.mu.Unlock()
+	defer va.untrack(task)
+
 	for {
-		select {
-		// Check if new task can start
-		case t := <-va.ReadyTaskCh:
-			if canStart, err := va.checkCanStartNewTask(); err != nil {
-				fmt.Println("failed to check if new task can start:", err)
-				continue
-			} else if !canStart {
-				fmt.Println("cannot start new task")
-				continue
+		if err := utils.Sleep(ctx, 10*time.Second); err != nil {
+			va.cancelTask(task)
+			return err
+		}
+		done, err := va.checkTask(ctx, task)
+		if err != nil {
+			if ctx.Err() != nil {
+				va.cancelTask(task)
+				return ctx.Err()
 			}
-			go va.startTask(t)
-		case <-time.After(10 * time.Second):
-			va.mu.Lock()
-			// Check if any task is done
-			if len(va.ProcessingTasks) > 0 {
-				va.checkProcessingTaskStatus()
+			return err
+		}
+		if done {
+			if task.FailReason == "" {
+				recordUsage(ctx, Usage{Provider: "runway", Operation: Op_Video, Model: "gen3a_turbo", Quantity: runwayVideoSeconds, Unit: Unit_Seconds, Detail: "720p"})
 			}
-			va.mu.Unlock()
+			return nil
 		}
 	}
 }
 
-// In here, ProcessingCh <- task will be executed and managed in taskLoop()
-func (va *RunwayVideoProducer) startTask(task *VideoGenTask) {
-	resp, err := va.post("https://api.runwayml.com/v1/tasks", map[string]interface{}{
+// startTask starts the generation -> task.ID, or task.FailReason
+func (va *RunwayVideoProducer) startTask(ctx context.Context, task *VideoGenTask) error {
+	resp, err := va.post(ctx, "https://api.runwayml.com/v1/tasks", map[string]interface{}{
 		"taskType": "gen3a_turbo",
 		"internal": false,
 		"asTeamId": 18904146,
 		"options": map[string]interface{}{
 			"name":           fmt.Sprintf("Gen-3 Alpha Turbo %d", time.Now().Unix()),
-			"seconds":        10,
+			"seconds":        runwayVideoSeconds,
 			"text_prompt":    task.Prompt,
 			"flip":           true, // portrait
 			"exploreMode":    true,
@@ -498,15 +500,12 @@ func (va *RunwayVideoProducer) startTask(task *VideoGenTask) {
 		},
 	})
 	if err != nil {
-		task.FailReason = fmt.Sprintf("failed to start task: %v", err)
-		va.FailCh <- task
-		return
+		return fmt.Errorf("failed to start task: %v", err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		task.FailReason = fmt.Sprintf("unexpected status code: %d", resp.StatusCode)
-		va.FailCh <- task
-		return
+		return nil
 	}
 	// Use json["task"]["id"] and json["task"]["status"]
 	var respData struct {
@@ -516,90 +515,25 @@ func (va *RunwayVideoProducer) startTask(task *VideoGenTask) {
 		} `json:"task"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
-		task.FailReason = fmt.Sprintf("failed to decode response body: %v", err)
-		va.FailCh <- task
-		return
+		return fmt.Errorf("failed to decode response body: %v", err)
 	}
 	task.ID = respData.Task.ID
 	if respData.Task.Status != "PENDING" {
 		task.FailReason = fmt.Sprintf("unexpected status: %s", respData.Task.Status)
-		va.FailCh <- task
-		return
 	}
-	va.mu.Lock()
-	va.ProcessingTasks = append(va.ProcessingTasks, task)
-	va.mu.Unlock()
+	return nil
 }
 
-// Must be called within mutex lock
-func (va *RunwayVideoProducer) checkProcessingTaskStatus() {
-	_, err := va.issueToken()
-	if err != nil {
-		va.onSystemError(fmt.Sprintf("failed to issue token: %v", err))
-		return
-	}
-
-	// GET https://api.runwayml.com/v1/assets_pending?asTeamId=18904146&privateInTeam=true
-	resp, err := va.get("https://api.runwayml.com/v1/assets_pending?asTeamId=18904146&privateInTeam=true")
-	if err != nil {
-		// Formatting error message
-		va.onSystemError(fmt.Sprintf("failed to get pending assets: %v", err))
-		return
-	}
-	// Hope to get json["pendingAssets"] as array
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		va.onSystemError(fmt.Sprintf("unexpected status code: %d", resp.StatusCode))
-		return
-	}
-	var respData struct {
-		PendingAssets []struct {
-			ID string `json:"id"`
-		} `json:"pendingAssets"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
-		va.onSystemError(fmt.Sprintf("failed to decode response body: %v", err))
-		return
-	}
-
-	// If task's ID is not in pendingAssets, check and move it to DoneCh
-	isOnPendingList := make(map[string]bool)
-	for _, tID := range respData.PendingAssets {
-		isOnPendingList[tID.ID] = true
-	}
-	for i := len(va.ProcessingTasks) - 1; i >= 0; i-- {
-		task := va.ProcessingTasks[i]
-		if _, exists := isOnPendingList[task.ID]; !exists {
-			va.onProcessingTaskOut(task)
-			va.ProcessingTasks = append(va.ProcessingTasks[:i], va.ProcessingTasks[i+1:]...)
-		}
-	}
-}
-
-// Must be called within mutex lock
-func (va *RunwayVideoProducer) onSystemError(failReason string) {
-	fmt.Println("system error:", failReason)
-	for _, task := range va.ProcessingTasks {
-		task.FailReason = failReason
-		va.FailCh <- task
-	}
-	va.ProcessingTasks = make([]*VideoGenTask, 0)
-}
-
-// When checked pending list and task is not in pending list
-func (va *RunwayVideoProducer) onProcessingTaskOut(task *VideoGenTask) {
+// checkTask -> true once the task succeeded (task.VideoURL) or failed (task.FailReason)
+func (va *RunwayVideoProducer) checkTask(ctx context.Context, task *VideoGenTask) (bool, error) {
 	// GET https://api.runwayml.com/v1/tasks/{task_id}
-	resp, err := va.get("https://api.runwayml.com/v1/tasks/" + task.ID)
+	resp, err := va.get(ctx, "https://api.runwayml.com/v1/tasks/"+task.ID)
 	if err != nil {
-		task.FailReason = fmt.Sprintf("failed to get task status: %v", err)
-		va.FailCh <- task
-		return
+		return false, fmt.Errorf("failed to get task status: %v", err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		task.FailReason = fmt.Sprintf("unexpected status code: %d", resp.StatusCode)
-		va.FailCh <- task
-		return
+		return false, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 	// Hope to get json["task"]["status"] is "SUCCEEDED", and get json["task"]["artifacts"][0]["url"]
 	var respData struct {
@@ -611,15 +545,48 @@ func (va *RunwayVideoProducer) onProcessingTaskOut(task *VideoGenTask) {
 		} `json:"task"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
-		task.FailReason = fmt.Sprintf("failed to decode response body: %v", err)
-		va.FailCh <- task
-		return
+		return false, fmt.Errorf("failed to decode response body: %v", err)
 	}
-	if respData.Task.Status != "SUCCEEDED" {
+	switch respData.Task.Status {
+	case "SUCCEEDED":
+		if len(respData.Task.Artifacts) == 0 {
+			task.FailReason = "no artifact in the succeeded task"
+			return true, nil
+		}
+		task.VideoURL = respData.Task.Artifacts[0].URL
+		return true, nil
+	case "FAILED", "CANCELLED", "ABORTED":
 		task.FailReason = fmt.Sprintf("unexpected status: %s", respData.Task.Status)
-		va.FailCh <- task
+		return true, nil
+	default: // PENDING, RUNNING, THROTTLED
+		return false, nil
+	}
+}
+
+// cancelTask asks Runway to stop a task we are no longer waiting for (best effort)
+func (va *RunwayVideoProducer) cancelTask(task *VideoGenTask) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "DELETE", "https://api.runwayml.com/v1/tasks/"+task.ID, nil)
+	if err != nil {
 		return
 	}
-	task.VideoURL = respData.Task.Artifacts[0].URL
-	va.DoneCh <- task
+	req.Header.Set("Authorization", "Bearer "+va.ApiKey)
+	resp, err := runwayHTTP.Do(req)
+	if err != nil {
+		fmt.Println("failed to cancel task:", task.ID, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+func (va *RunwayVideoProducer) untrack(task *VideoGenTask) {
+	va.mu.Lock()
+	defer va.mu.Unlock()
+	for i, t := range va.ProcessingTasks {
+		if t == task {
+			va.ProcessingTasks = append(va.ProcessingTasks[:i], va.ProcessingTasks[i+1:]...)
+			return
+		}
+	}
 }
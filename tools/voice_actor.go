@@ -3,21 +3,23 @@ package tools
 import (
 	"avazon-api/models"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"unicode/utf8"
 )
 
 type VoiceActor interface {
 	// Generate voice -> Return the model's provider and voice_id in order
 	// returns (provider, voice_id, error)
-	Create(prompt string, gender models.Gender, args ...string) (string, string, error)
+	Create(ctx context.Context, prompt string, gender models.Gender, args ...string) (string, string, error)
 	// Create voice
-	TTS(voiceId string, text string) ([]byte, error)
+	TTS(ctx context.Context, voiceId string, text string) ([]byte, error)
 	// Create TTS stream using voiceId
-	TTSStream(voiceId string, text string) (io.ReadCloser, error)
+	TTSStream(ctx context.Context, voiceId string, text string) (io.ReadCloser, error)
 }
 
 type ElevenLabsVoiceActor struct {
@@ -50,7 +52,7 @@ func NewElevenLabsVoiceActor(apiKey string) *ElevenLabsVoiceActor {
 
 // Create method: Generate and save voice
 // args[0]: accent_strength, args[1]: age, args[2]: accent
-func (va *ElevenLabsVoiceActor) Create(prompt string, gender models.Gender, args ...string) (string, string, error) {
+func (va *ElevenLabsVoiceActor) Create(ctx context.Context, prompt string, gender models.Gender, args ...string) (string, string, error) {
 	// 1. Generate Voice
 	generateURL := "https://api.elevenlabs.io/v1/voice-generation/generate-voice"
 
@@ -81,7 +83,7 @@ func (va *ElevenLabsVoiceActor) Create(prompt string, gender models.Gender, args
 		return "", "", fmt.Errorf("failed to marshal generate request data: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", generateURL, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", generateURL, bytes.NewBuffer(jsonData))
 	if err != nil {
 		fmt.Printf("failed to create generate HTTP request: %v", err)
 		return "", "", fmt.Errorf("failed to create generate HTTP request: %v", err)
@@ -89,8 +91,7 @@ func (va *ElevenLabsVoiceActor) Create(prompt string, gender models.Gender, args
 	req.Header.Set("XI-API-KEY", va.ApiKey)
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := elevenLabsHTTP.Do(req)
 	if err != nil {
 		fmt.Printf("failed to make generate API request: %v", err)
 		return "", "", fmt.Errorf("failed to make generate API request: %v", err)
@@ -98,9 +99,9 @@ func (va *ElevenLabsVoiceActor) Create(prompt string, gender models.Gender, args
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		fmt.Printf("unexpected response status: %v %s", resp.Status, body)
-		return "", "", fmt.Errorf("unexpected response status: %v, body: %s", resp.Status, string(body))
+		err := statusError(resp)
+		fmt.Printf("unexpected response status: %v", err)
+		return "", "", fmt.Errorf("unexpected response status: %w", err)
 	}
 
 	// Retrieve generated_voice_id from response headers
@@ -125,7 +126,7 @@ func (va *ElevenLabsVoiceActor) Create(prompt string, gender models.Gender, args
 		return "", "", fmt.Errorf("failed to marshal save request data: %v", err)
 	}
 
-	saveReq, err := http.NewRequest("POST", createURL, bytes.NewBuffer(jsonSaveData))
+	saveReq, err := http.NewRequestWithContext(ctx, "POST", createURL, bytes.NewBuffer(jsonSaveData))
 	if err != nil {
 		fmt.Printf("failed to create save HTTP request: %v", err)
 		return "", "", fmt.Errorf("failed to create save HTTP request: %v", err)
@@ -134,7 +135,7 @@ func (va *ElevenLabsVoiceActor) Create(prompt string, gender models.Gender, args
 	saveReq.Header.Set("XI-API-KEY", va.ApiKey)
 	saveReq.Header.Set("Content-Type", "application/json")
 
-	saveResp, err := client.Do(saveReq)
+	saveResp, err := elevenLabsHTTP.Do(saveReq)
 	if err != nil {
 		fmt.Printf("failed to make save API request: %v", err)
 		return "", "", fmt.Errorf("failed to make save API request: %v", err)
@@ -142,9 +143,9 @@ func (va *ElevenLabsVoiceActor) Create(prompt string, gender models.Gender, args
 	defer saveResp.Body.Close()
 
 	if saveResp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(saveResp.Body)
-		fmt.Printf("unexpected response status: %v %s", saveResp.Status, body)
-		return "", "", fmt.Errorf("unexpected response status: %v, body: %s", saveResp.Status, string(body))
+		err := statusError(saveResp)
+		fmt.Printf("unexpected response status: %v", err)
+		return "", "", fmt.Errorf("unexpected response status: %w", err)
 	}
 
 	var saveRes ElevenLabsVoiceCreateRes
@@ -153,11 +154,12 @@ func (va *ElevenLabsVoiceActor) Create(prompt string, gender models.Gender, args
 		return "", "", fmt.Errorf("failed to decode save response: %v", err)
 	}
 
+	recordUsage(ctx, Usage{Provider: "elevenlabs", Operation: Op_VoiceDesign, Quantity: float64(utf8.RuneCountInString(prompt)), Unit: Unit_Characters})
 	return "elevenlabs", saveRes.VoiceID, nil
 }
 
 // TTS method: Convert text to speech and return binary data in MP3 format
-func (va *ElevenLabsVoiceActor) TTS(voiceId string, text string) ([]byte, error) {
+func (va *ElevenLabsVoiceActor) TTS(ctx context.Context, voiceId string, text string) ([]byte, error) {
 	ttsURL := fmt.Sprintf("https://api.elevenlabs.io/v1/text-to-speech/%s", voiceId)
 
 	// Create TTS request
@@ -171,7 +173,7 @@ func (va *ElevenLabsVoiceActor) TTS(voiceId string, text string) ([]byte, error)
 		return nil, fmt.Errorf("failed to marshal TTS request data: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", ttsURL, bytes.NewBuffer(jsonTTSData))
+	req, err := http.NewRequestWithContext(ctx, "POST", ttsURL, bytes.NewBuffer(jsonTTSData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create TTS HTTP request: %v", err)
 	}
@@ -179,17 +181,16 @@ func (va *ElevenLabsVoiceActor) TTS(voiceId string, text string) ([]byte, error)
 	req.Header.Set("XI-API-KEY", va.ApiKey)
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := elevenLabsHTTP.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make TTS API request: %v", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		fmt.Printf("unexpected response status: %v %s", resp.Status, body)
-		return nil, fmt.Errorf("unexpected response status: %v, body: %s", resp.Status, string(body))
+		err := statusError(resp)
+		fmt.Printf("unexpected response status: %v", err)
+		return nil, fmt.Errorf("unexpected response status: %w", err)
 	}
 
 	// Read voice data (binary data in MP3 format)
@@ -198,12 +199,13 @@ func (va *ElevenLabsVoiceActor) TTS(voiceId string, text string) ([]byte, error)
 		return nil, fmt.Errorf("failed to read TTS response body: %v", err)
 	}
 
+	recordTTS(ctx, text)
 	// Return MP3 data to the caller
 	return body, nil
 }
 
 // TTSStream method: Create TTS stream
-func (va *ElevenLabsVoiceActor) TTSStream(voiceID string, text string) (io.ReadCloser, error) {
+func (va *ElevenLabsVoiceActor) TTSStream(ctx context.Context, voiceID string, text string) (io.ReadCloser, error) {
 	ttsURL := fmt.Sprintf("https://api.elevenlabs.io/v1/text-to-speech/%s/stream", voiceID)
 
 	// Create TTS request
@@ -217,7 +219,7 @@ func (va *ElevenLabsVoiceActor) TTSStream(voiceID string, text string) (io.ReadC
 		return nil, fmt.Errorf("failed to marshal TTS request data: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", ttsURL, bytes.NewBuffer(jsonTTSData))
+	req, err := http.NewRequestWithContext(ctx, "POST", ttsURL, bytes.NewBuffer(jsonTTSData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create TTS HTTP request: %v", err)
 	}
@@ -225,17 +227,23 @@ func (va *ElevenLabsVoiceActor) TTSStream(voiceID string, text string) (io.ReadC
 	req.Header.Set("XI-API-KEY", va.ApiKey)
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := elevenLabsHTTP.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make TTS API request: %v", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		fmt.Printf("unexpected response status: %v %s", resp.Status, body)
-		return nil, fmt.Errorf("unexpected response status: %v, body: %s", resp.Status, string(body))
+		err := statusError(resp)
+		resp.Body.Close()
+		fmt.Printf("unexpected response status: %v", err)
+		return nil, fmt.Errorf("unexpected response status: %w", err)
 	}
 
+	recordTTS(ctx, text)
 	return resp.Body, nil
 }
+
+// ElevenLabs bills the characters of the text
+func recordTTS(ctx context.Context, text string) {
+	recordUsage(ctx, Usage{Provider: "elevenlabs", Operation: Op_TTS, Model: "eleven_multilingual_v2", Quantity: float64(utf8.RuneCountInString(text)), Unit: Unit_Characters})
+}
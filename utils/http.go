@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// HTTPClient is shared by every outgoing call (OpenAI, OpenArt, ElevenLabs, Runway, ...), so
+// connections are reused. There is no overall timeout as responses may be long streams:
+// bound each call with the deadline of its context instead.
+var HTTPClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 3 * time.Minute, // image generation answers late
+		ExpectContinueTimeout: time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		ForceAttemptHTTP2:     true,
+	},
+}
+
+// Sleep waits for d, or returns ctx.Err() as soon as ctx is done. Used between polls.
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"mime"
@@ -8,6 +9,7 @@ import (
 	"os"
 	"strconv"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -57,18 +59,20 @@ func GetExtensionFromMimeType(mimeType string) (string, error) {
 
 // Fetches a remote file using an HTTP GET request
 // -> content, contentType(mime-type), error
-func GetDataFromURL(url string) ([]byte, string, error) {
-	req, err := http.NewRequest("GET", url, nil)
+func GetDataFromURL(ctx context.Context, url string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, "", err
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := HTTPClient.Do(req)
 	if err != nil {
 		return nil, "", err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to fetch %s: status %d", url, resp.StatusCode)
+	}
 
 	contentType := resp.Header.Get("Content-Type")
 	if contentType == "" {
@@ -144,31 +148,15 @@ func GenUUID(prefix string) (string, error) {
 	return uuidStr, nil
 }
 
-// Separate non-JSON and JSON parts
-func SeparateTextAndJSON(text string) (string, []string) {
-	jsonParts := []string{}
-	nonJsonPart := ""
-	start := 0
-	braceCount := 0
-
-	for i, char := range text {
-		if char == '{' {
-			if braceCount == 0 {
-				nonJsonPart += text[start:i]
-			}
-			start = i
-			braceCount++
-		} else if char == '}' {
-			braceCount--
-			if braceCount == 0 && start != -1 {
-				jsonParts = append(jsonParts, text[start:i+1])
-				start = i + 1
-			}
-		}
-	}
-
-	if len(jsonParts) == 0 {
-		return text, []string{}
-	}
-	return nonJsonPart, jsonParts
+// TruncateString cuts s down to at most maxLen bytes without splitting a UTF-8 character.
+// Used before writing free-form text (e.g. upstream error messages) into length-limited columns,
+// which PostgreSQL enforces and SQLite silently ignores.
+func TruncateString(s string, maxLen int) string {
+	if len(s) <= maxLen {
+		return s
+	}
+	for maxLen > 0 && !utf8.RuneStart(s[maxLen]) {
+		maxLen--
+	}
+	return s[:maxLen]
 }